@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"eurozulu/miniSQL/stringutil"
+)
+
+// ConnectCommands connects to a server and runs the commands from the std input on that server, as RunCommands runs them locally.
+// Query results are written by the client, in its own output format.
+func (c *CLI) ConnectCommands(ctx context.Context, addr string, out io.Writer, done chan bool) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s  %w", addr, err)
+	}
+	cl := &client{cli: c, conn: conn, frames: bufio.NewScanner(conn)}
+	cl.frames.Buffer(make([]byte, 0, 4096), maxStatementSize)
+	c.Prompt = addr + ">"
+	go func() {
+		defer conn.Close()
+		c.runCommands(ctx, out, done, cl.runCommand)
+	}()
+	return nil
+}
+
+// client runs the commands of a command line on a server.
+type client struct {
+	cli    *CLI
+	conn   net.Conn
+	frames *bufio.Scanner
+}
+
+// runCommand sends a line of commands to the server and writes its response.
+// Commands which change the state of the command line are run locally.
+func (c *client) runCommand(ctx context.Context, out io.Writer, line string) error {
+	line = strings.TrimSpace(line)
+	if isEmptyCommand(line) {
+		return nil
+	}
+	cmd, args := stringutil.FirstWord(line)
+	switch strings.ToUpper(strings.TrimSuffix(cmd, ";")) {
+	case "EXIT", "X", "QUIT":
+		return exitError
+	case "FORMAT":
+		return c.cli.FormatCommand(commandArgument(args), out)
+	}
+	// the statement is sent as a frame, so it may span more than one line
+	if err := json.NewEncoder(c.conn).Encode(&frame{Type: frameStatement, Text: line}); err != nil {
+		return fmt.Errorf("failed to send to server  %w", err)
+	}
+	return c.readResponse(out)
+}
+
+// readResponse reads the frames of a response from the server, until the done frame, writing any results or text.
+func (c *client) readResponse(out io.Writer) error {
+	var tables []*resultTable
+	var text bool
+	var cmdErr error
+	for c.frames.Scan() {
+		var f frame
+		if err := json.Unmarshal(c.frames.Bytes(), &f); err != nil {
+			return fmt.Errorf("invalid response from server  %w", err)
+		}
+		switch f.Type {
+		case frameColumns:
+			tables = append(tables, &resultTable{name: f.Table, columns: f.Columns})
+		case frameRow:
+			if len(tables) == 0 {
+				return fmt.Errorf("invalid response from server, row before any columns")
+			}
+			t := tables[len(tables)-1]
+			t.rows = append(t.rows, f.Values)
+		case frameText:
+			text = true
+			if _, err := io.WriteString(out, f.Text); err != nil {
+				return err
+			}
+		case frameError:
+			cmdErr = fmt.Errorf("%s", f.Error)
+		case frameDone:
+			// results found before an error are written, as they are by the command line
+			if len(tables) > 0 || !text && cmdErr == nil {
+				if err := c.cli.writeQueryResults(out, tables); err != nil {
+					return err
+				}
+			}
+			return cmdErr
+		default:
+			return fmt.Errorf("invalid response from server, unknown frame %q", f.Type)
+		}
+	}
+	if err := c.frames.Err(); err != nil {
+		return fmt.Errorf("failed to read from server  %w", err)
+	}
+	return fmt.Errorf("server closed the connection")
+}
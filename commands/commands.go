@@ -10,48 +10,131 @@ import (
 	"strings"
 
 	"eurozulu/miniSQL/minisql"
+	// registers the parser of the queries run on the database
+	_ "eurozulu/miniSQL/queries"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/stringutil"
 	"github.com/eurozulu/commandline"
 )
 
 const historyLocation = "$HOME/.minisql_history"
 
-var Database *minisql.MiniDB
-var Prompt = ">"
+// maxCommandLength is the longest line, or command continued over lines, which can be read.
+const maxCommandLength = 64 * 1024 * 1024
+
 var exitError = fmt.Errorf("exiting")
 
 var exitHelp = "use EXIT to close the program\n" +
 	"\tAlso works with QUIT and X"
 
+// CLI is the command line of a database.  Each CLI has its own database, session, prompt and output format,
+// so any number of them may be used at once.
+type CLI struct {
+	// Prompt is shown before each command typed.
+	Prompt string
+	// FileDir is the directory of the files clients of the server may use in commands, such as DUMP and IMPORT.
+	// When empty, clients can not use files.  It does not limit the files used by the command line.
+	FileDir string
+	db      *minisql.MiniDB
+	// session is the command line's session on the database, holding any transaction in progress.
+	session *minisql.Session
+	// prepared are the queries prepared on the session, by name.
+	prepared map[string]*minisql.Stmt
+	// format is the format query results are written in.
+	format string
+	// nullText, when not nil, is the text written for NULL values, by all but the JSON formats, which always write null.
+	// When nil, each format writes its own default, NULL in tables and markdown, empty in CSV and TSV.
+	nullText *string
+}
+
+// NewCLI creates a command line of the database.  The database is nil for a command line which only connects to a server.
+func NewCLI(db *minisql.MiniDB) *CLI {
+	c := &CLI{Prompt: ">", db: db, format: FormatTable, prepared: map[string]*minisql.Stmt{}}
+	if db != nil {
+		c.session = db.NewSession()
+	}
+	return c
+}
+
+// Database gets the database of the command line.
+func (c *CLI) Database() *minisql.MiniDB {
+	return c.db
+}
+
 // RunCommands reads the available commands from the std input and executes them.
 // If stdinput already contains data (pipped in from cmdline) that is executed first, then
 // the applications own command line is started.  Commands can then be entered into this command line until "EXIT" is entered
-func RunCommands(ctx context.Context, out io.Writer, done chan bool) {
+func (c *CLI) RunCommands(ctx context.Context, out io.Writer, done chan bool) {
+	c.runCommands(ctx, out, done, c.parseCommand)
+}
+
+// commandRunner runs a single line of commands.
+type commandRunner func(ctx context.Context, out io.Writer, line string) error
+
+func (c *CLI) runCommands(ctx context.Context, out io.Writer, done chan bool, run commandRunner) {
 	defer close(done)
-	err := readStdInput(ctx, out)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
+	if !stdInIsTerminal() {
+		if err := readStdInput(ctx, out, run); err != nil && err != exitError {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
 		return
 	}
-	err = readCommandLine(ctx, out)
+	if err := c.readCommandLine(ctx, out, run); err != nil && err != exitError {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
 }
 
-// readStdInput flushes any available data in stdin and parse it as lines of commands
-func readStdInput(ctx context.Context, out io.Writer) error {
-	for stdInSize() > 0 {
-		s := bufio.NewScanner(os.Stdin)
-		for s.Scan() {
-			if err := parseCommand(ctx, out, strings.Split(s.Text(), " ")...); err != nil {
-				if err != nil {
-					return err
-				}
-			}
+// readStdInput reads all the data in stdin and parse it as lines of commands
+func readStdInput(ctx context.Context, out io.Writer, run commandRunner) error {
+	_, err := readCommands(os.Stdin, func(cmd string) error {
+		return run(ctx, out, cmd)
+	})
+	return err
+}
+
+// readCommands reads the lines of commands, running each one in turn, until one fails.
+// A command continues on the following line when its line ends inside a quoted string, or with a comma.
+// Returns the number of the line the last command read began on.
+func readCommands(r io.Reader, run func(cmd string) error) (int, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxCommandLength)
+	var lines []string
+	var line, start int
+	for s.Scan() {
+		line++
+		if len(lines) == 0 {
+			start = line
+		}
+		lines = append(lines, s.Text())
+		cmd := strings.Join(lines, "\n")
+		if continues(cmd) {
+			continue
+		}
+		lines = nil
+		if err := run(cmd); err != nil {
+			return start, err
 		}
 	}
-	return nil
+	if err := s.Err(); err != nil {
+		return start, err
+	}
+	if len(lines) > 0 {
+		return start, run(strings.Join(lines, "\n"))
+	}
+	return start, nil
+}
+
+// continues checks if the command is incomplete, ending inside a quoted string, or with a comma.
+func continues(cmd string) bool {
+	tokens, err := lexer.Tokenize(cmd)
+	if err != nil {
+		return lexer.IsIncomplete(err)
+	}
+	return len(tokens) > 1 && tokens[len(tokens)-2].IsSymbol(",")
 }
 
 // readCommandLine awaits user input and parses each line as a command
-func readCommandLine(ctx context.Context, out io.Writer) error {
+func (c *CLI) readCommandLine(ctx context.Context, out io.Writer, run commandRunner) error {
 	cli := commandline.NewCommandLine()
 	if err := cli.LoadHistory(historyLocation); err != nil {
 		return fmt.Errorf("cli history load failed  %w", err)
@@ -63,13 +146,13 @@ func readCommandLine(ctx context.Context, out io.Writer) error {
 	}(cli)
 
 	for {
-		_, _ = out.Write([]byte(Prompt))
+		_, _ = out.Write([]byte(c.Prompt))
 		ln, err := cli.ReadCommand()
 		if err != nil {
 			return fmt.Errorf("failed to read command line %w", err)
 		}
 		fmt.Println()
-		err = parseCommand(ctx, out, strings.Split(ln, " ")...)
+		err = run(ctx, out, ln)
 		if err != nil {
 			if err == exitError {
 				return err
@@ -81,60 +164,112 @@ func readCommandLine(ctx context.Context, out io.Writer) error {
 	}
 }
 
-func parseCommand(ctx context.Context, out io.Writer, args ...string) error {
-	if len(args) == 0 {
-		args = []string{""}
+// parseCommand executes the given line as a command.
+// The first word of the line is the command, followed by any arguments.
+func (c *CLI) parseCommand(ctx context.Context, out io.Writer, line string) error {
+	line = strings.TrimSpace(line)
+	if isEmptyCommand(line) {
+		return nil
 	}
+	cmd, rest := stringutil.FirstWord(line)
+	args := commandArgument(rest)
+
 	var err error
-	switch strings.ToUpper(args[0]) {
-	case "":
-		err = nil //nop
+	switch strings.ToUpper(strings.TrimSuffix(cmd, ";")) {
 	case "EXIT", "X", "QUIT":
 		return exitError
-	case "SELECT", "INSERT", "DELETE", "UPDATE":
-		err = queryCommand(ctx, strings.Join(args, " "), out)
-	case "CREATE":
-		err = createCommand(strings.Join(args[1:], " "), out)
-
-	case "DROP":
-		err = dropCommand(strings.Join(args[1:], " "), out)
+	case "SELECT", "INSERT", "DELETE", "UPDATE",
+		"BEGIN", "START", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE":
+		err = c.queryCommand(ctx, line, out)
+	case "CREATE", "DROP":
+		err = c.structureCommand(ctx, line, out)
+	case "PREPARE":
+		err = c.PrepareCommand(ctx, line, out)
+	case "EXECUTE":
+		err = c.ExecuteCommand(ctx, line, out)
+	case "DEALLOCATE":
+		err = c.DeallocateCommand(ctx, line, out)
 
 	case "RESTORE":
-		err = RestoreCommand(strings.Join(args[1:], ""), out)
+		err = c.RestoreCommand(args, out)
 
 	case "DUMP":
-		err = DumpCommand(strings.Join(args[1:], ""), out)
+		err = c.DumpCommand(rest, out)
+
+	case "SOURCE":
+		err = c.SourceCommand(ctx, args, out)
+
+	case "IMPORT":
+		err = c.ImportCommand(ctx, line, out)
+
+	case "EXPORT":
+		err = c.ExportCommand(ctx, line, out)
+
+	case "CHECKPOINT":
+		err = c.CheckpointCommand(args, out)
 
 	case "DESC", "DESCRIBE":
-		err = DescribeCommand(strings.Join(args[1:], ""), out)
+		err = c.DescribeCommand(args, out)
+
+	case "FORMAT":
+		err = c.FormatCommand(args, out)
+
+	case "COMPAT":
+		err = c.CompatCommand(ctx, args, out)
 
 	case "TABLES":
-		err = TablesCommand("", out)
+		err = c.TablesCommand("", out)
 
 	case "HELP":
-		err = HelpCommand(strings.Join(args, " "), out)
+		err = HelpCommand(args, out)
 	default:
-		err = fmt.Errorf("%q is an unknown command", args[0])
+		err = fmt.Errorf("%q is an unknown command", cmd)
 	}
 	return err
 }
 
-func stdInSize() int64 {
+// currentSession gets the session on the database, of the client connection running the command, or of the command line.
+func (c *CLI) currentSession(ctx context.Context) *minisql.Session {
+	if conn := connectionOf(ctx); conn != nil {
+		return conn.session
+	}
+	return c.session
+}
+
+// isEmptyCommand checks if the given line is empty, or contains only comments.
+func isEmptyCommand(line string) bool {
+	tokens, err := lexer.Tokenize(line)
+	return err == nil && len(tokens) == 1
+}
+
+// commandArgument cleans up the argument of a non query command, removing any trailing semicolon and enclosing quotes.
+func commandArgument(s string) string {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), ";"))
+	return stringutil.Unquote(s)
+}
+
+func stdInIsTerminal() bool {
 	fi, err := os.Stdin.Stat()
 	if err != nil {
 		if _, err := fmt.Fprintf(os.Stderr, "%v", err); err != nil {
 			log.Fatalln(err)
 		}
-		return 0
+		return false
 	}
-	return fi.Size()
+	return fi.Mode()&os.ModeCharDevice != 0
 }
 
 func HelpCommand(_ string, out io.Writer) error {
 	_, _ = fmt.Fprintln(out, queryHelp)
 	_, _ = fmt.Fprintln(out, structueHelp)
+	_, _ = fmt.Fprintln(out, transactionHelp)
+	_, _ = fmt.Fprintln(out, prepareHelp)
 	_, _ = fmt.Fprintln(out, metadataHelp)
 	_, _ = fmt.Fprintln(out, dumpHelp)
+	_, _ = fmt.Fprintln(out, csvHelp)
+	_, _ = fmt.Fprintln(out, formatHelp)
+	_, _ = fmt.Fprintln(out, compatHelp)
+	_, _ = fmt.Fprintln(out, serverHelp+httpHelp)
 	_, _ = fmt.Fprintln(out, exitHelp)
 	return nil
 }
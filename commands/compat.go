@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var compatHelp = "Change how WHERE conditions are read with COMPAT\n" +
+	"\tCOMPAT\tshows if compat mode is on\n" +
+	"\tCOMPAT ON|OFF\tturns on the legacy rules of earlier versions, where AND and OR are read from left to right and NULL equals NULL\n" +
+	"\t\tor turns them off, to follow SQL, where NOT is applied before AND, AND before OR, and comparing with NULL matches nothing\n"
+
+// CompatCommand turns on, off or shows, the compat mode of the queries parsed after it, by the current session.
+// COMPAT [ON|OFF]
+func (c *CLI) CompatCommand(ctx context.Context, cmd string, out io.Writer) error {
+	session := c.currentSession(ctx)
+	switch strings.ToUpper(cmd) {
+	case "":
+	case "ON":
+		session.SetCompat(true)
+	case "OFF":
+		session.SetCompat(false)
+	default:
+		return fmt.Errorf("expected ON or OFF after COMPAT, found %q", cmd)
+	}
+	mode := "off"
+	if session.Compat() {
+		mode = "on"
+	}
+	_, err := fmt.Fprintf(out, "compat %s\n", mode)
+	return err
+}
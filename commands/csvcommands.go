@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var csvHelp = "Move the rows of tables to and from CSV files with IMPORT and EXPORT\n" +
+	"\tIMPORT CSV '<file>' INTO <table> [HEADER] [DELIMITER '<char>'] [NULL '<text>']\n" +
+	"\t\tinserts the rows of the file.  HEADER reads the column names from the first line, creating the table when it doesn't exist\n" +
+	"\t\tWithout HEADER, the values are the columns of the table, in the order DESC lists them\n" +
+	"\tEXPORT <table> | (<select query>) TO '<file>' [HEADER] [DELIMITER '<char>'] [NULL '<text>']\n" +
+	"\t\twrites the rows of the table, or the results of the query, to the file. HEADER writes the column names as the first line\n" +
+	"\t\tDELIMITER separates the values, a comma by default.  Use '\\t' for tabs\n" +
+	"\t\tNULL is the text of NULL values, empty by default.  Empty strings are written quoted, \"\", so are not read as NULL\n"
+
+// ImportCommand inserts the rows of a CSV file into a table.  The file is read one line at a time, so may be of any size.
+func (c *CLI) ImportCommand(ctx context.Context, cmd string, out io.Writer) error {
+	ts, err := lexer.NewTokenStream(cmd)
+	if err != nil {
+		return err
+	}
+	ts.Next()
+	if err = ts.ExpectKeyword("CSV"); err != nil {
+		return err
+	}
+	filename, err := expectString(ts, "file name")
+	if err != nil {
+		return err
+	}
+	if err = ts.ExpectKeyword("INTO"); err != nil {
+		return err
+	}
+	table, err := ts.ExpectName("table name after INTO")
+	if err != nil {
+		return err
+	}
+	opts, err := readCSVOptions(ts)
+	if err != nil {
+		return err
+	}
+	if filename, err = c.filePath(ctx, filename); err != nil {
+		return err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func(f io.Closer) {
+		if err := f.Close(); err != nil {
+			log.Println(err)
+		}
+	}(f)
+	n, err := minisql.ImportCSV(c.currentSession(ctx), table, f, opts)
+	if err != nil {
+		return fmt.Errorf("failed to import %s  %w", filename, err)
+	}
+	_, err = fmt.Fprintf(out, "imported %d %s into %s\n", n, plural(n, "row"), table)
+	return err
+}
+
+// ExportCommand writes the rows of a table, or the results of a select query, to a CSV file, as they are read.
+func (c *CLI) ExportCommand(ctx context.Context, cmd string, out io.Writer) error {
+	ts, err := lexer.NewTokenStream(cmd)
+	if err != nil {
+		return err
+	}
+	ts.Next()
+	var query string
+	if ts.Peek().IsSymbol("(") {
+		if query, err = readBracketedQuery(cmd, ts); err != nil {
+			return err
+		}
+	} else {
+		table, err := ts.ExpectName("table name or bracketed SELECT query")
+		if err != nil {
+			return err
+		}
+		t, err := c.currentSession(ctx).Table(table)
+		if err != nil {
+			return err
+		}
+		cols := t.ColumnNames()
+		for i, col := range cols {
+			cols[i] = minisql.QuoteName(col)
+		}
+		query = fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), minisql.QuoteName(table))
+	}
+	if err = ts.ExpectKeyword("TO"); err != nil {
+		return err
+	}
+	filename, err := expectString(ts, "file name after TO")
+	if err != nil {
+		return err
+	}
+	opts, err := readCSVOptions(ts)
+	if err != nil {
+		return err
+	}
+	if filename, err = c.filePath(ctx, filename); err != nil {
+		return err
+	}
+
+	rows, err := c.currentSession(ctx).Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	n, err := minisql.ExportCSV(f, rows, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export to %s  %w", filename, err)
+	}
+	_, err = fmt.Fprintf(out, "exported %d %s to %s\n", n, plural(n, "row"), filename)
+	return err
+}
+
+// readCSVOptions reads the optional HEADER, DELIMITER and NULL of a CSV file, in any order, up to the end of the command.
+func readCSVOptions(ts *lexer.TokenStream) (minisql.CSVOptions, error) {
+	var opts minisql.CSVOptions
+	for !ts.AtEnd() {
+		t := ts.Next()
+		switch {
+		case t.IsKeyword("CSV"):
+		case t.IsKeyword("HEADER"):
+			opts.Header = true
+		case t.IsKeyword("DELIMITER"):
+			d, err := expectString(ts, "delimiter")
+			if err != nil {
+				return opts, err
+			}
+			if d == `\t` {
+				d = "\t"
+			}
+			if utf8.RuneCountInString(d) != 1 {
+				return opts, fmt.Errorf("delimiter must be a single character, found %q", d)
+			}
+			opts.Delimiter, _ = utf8.DecodeRuneInString(d)
+		case t.IsKeyword("NULL"):
+			n, err := expectString(ts, "text of NULL values")
+			if err != nil {
+				return opts, err
+			}
+			opts.Null = n
+		default:
+			return opts, ts.Errorf(t, "unexpected %s, expected HEADER, DELIMITER or NULL", t)
+		}
+	}
+	return opts, nil
+}
+
+// readBracketedQuery reads a SELECT query, enclosed in brackets, returning the query inside the brackets.
+func readBracketedQuery(cmd string, ts *lexer.TokenStream) (string, error) {
+	if err := ts.ExpectSymbol("("); err != nil {
+		return "", err
+	}
+	first := ts.Peek()
+	if !first.IsKeyword("SELECT") {
+		return "", ts.Errorf(first, "expected SELECT query, found %s", first)
+	}
+	end := first
+	for depth := 1; ; {
+		t := ts.Next()
+		switch {
+		case t.Type == lexer.EOF:
+			return "", ts.Errorf(t, "missing ')' after query")
+		case t.IsSymbol("("):
+			depth++
+		case t.IsSymbol(")"):
+			depth--
+		}
+		if depth == 0 {
+			return cmd[first.Offset:end.End], nil
+		}
+		end = t
+	}
+}
+
+// expectString reads a quoted string.
+func expectString(ts *lexer.TokenStream, what string) (string, error) {
+	t := ts.Next()
+	if t.Type != lexer.STRING {
+		return "", ts.Errorf(t, "expected quoted %s, found %s", what, t)
+	}
+	return t.Text, nil
+}
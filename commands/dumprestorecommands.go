@@ -1,58 +1,176 @@
 package commands
 
 import (
+	"context"
 	"eurozulu/miniSQL/minisql"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path"
 	"strings"
 )
 
 var dumpHelp = "Dump and restore the whole database with DUMP and RESTORE\n" +
-	"\tDUMP <filename to write to>\n" +
-	"\tRESTORE <filename to read from\n"
+	"\tDUMP <filename to write to> [AS SQL | BINARY [COMPRESSED]]\n" +
+	"\t\tAS SQL writes a script of the CREATE and INSERT queries which rebuild the database, to run with SOURCE\n" +
+	"\t\tAS BINARY writes a compact binary dump, which is faster to restore.  COMPRESSED compresses it with gzip\n" +
+	"\tRESTORE <filename to read from>\trestores a JSON or binary dump, finding its format from the file\n" +
+	"\tSOURCE <filename to read from>\truns the commands in the file, such as a dump written AS SQL\n" +
+	"\tCHECKPOINT\twrites a new snapshot of a durable database, started with -data-dir, and empties its log\n"
 
-func DumpCommand(cmd string, out io.Writer) error {
+// dumpFormat is a format a dump is written in, as named following the AS of a DUMP command.
+type dumpFormat string
+
+const (
+	dumpJSON       dumpFormat = "JSON"
+	dumpSQL        dumpFormat = "SQL"
+	dumpBinary     dumpFormat = "BINARY"
+	dumpCompressed dumpFormat = "BINARY COMPRESSED"
+)
+
+// dumpWriters are the extension added to the file name of each format, when it has none, and the function writing it.
+var dumpWriters = map[dumpFormat]struct {
+	ext  string
+	dump func(filename string, db *minisql.MiniDB) error
+}{
+	dumpJSON: {ext: "json", dump: minisql.Dump},
+	dumpSQL:  {ext: "sql", dump: minisql.DumpSQL},
+	dumpBinary: {ext: "db", dump: func(filename string, db *minisql.MiniDB) error {
+		return minisql.DumpBinary(filename, db, false)
+	}},
+	dumpCompressed: {ext: "db", dump: func(filename string, db *minisql.MiniDB) error {
+		return minisql.DumpBinary(filename, db, true)
+	}},
+}
+
+func (c *CLI) DumpCommand(cmd string, out io.Writer) error {
+	filename, format, err := dumpArguments(cmd)
+	if err != nil {
+		return err
+	}
+	if filename, err = dumpDatabase(c.db, filename, format, out); err != nil {
+		return err
+	}
+	c.Prompt = dbName(filename) + ">"
+	return nil
+}
+
+// dumpArguments gets the file name of a DUMP command, and the format following any AS.
+func dumpArguments(args string) (string, dumpFormat, error) {
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(args), ";"))
+	for i := len(fields) - 2; i > 0; i-- {
+		if !strings.EqualFold(fields[i], "AS") {
+			continue
+		}
+		format := dumpFormat(strings.ToUpper(strings.Join(fields[i+1:], " ")))
+		if _, ok := dumpWriters[format]; !ok {
+			return "", "", fmt.Errorf("%q is not a known dump format, expected SQL, BINARY or BINARY COMPRESSED", strings.Join(fields[i+1:], " "))
+		}
+		return commandArgument(strings.Join(fields[:i], " ")), format, nil
+	}
+	return commandArgument(args), dumpJSON, nil
+}
+
+// dumpDatabase dumps the database to the named file, in the given format, adding the extension of the format when the name has none.
+// Returns the name of the file written.
+func dumpDatabase(db *minisql.MiniDB, cmd string, format dumpFormat, out io.Writer) (string, error) {
 	if cmd == "" {
-		return fmt.Errorf("must specifiy the file path to write to")
+		return "", fmt.Errorf("must specifiy the file path to write to")
 	}
+	w := dumpWriters[format]
 	if path.Ext(cmd) == "" {
-		cmd = strings.Join([]string{cmd, "json"}, ".")
+		cmd = strings.Join([]string{cmd, w.ext}, ".")
+	}
+	if err := w.dump(cmd, db); err != nil {
+		return "", err
 	}
-	if err := minisql.Dump(cmd, Database); err != nil {
+	_, err := fmt.Fprintf(out, "dumped %d tables to %s\n", len(db.TableNames()), cmd)
+	return cmd, err
+}
+
+func (c *CLI) RestoreCommand(cmd string, out io.Writer) error {
+	if err := restoreDatabase(c.db, cmd, out); err != nil {
 		return err
 	}
-	Prompt = dbName(cmd) + ">"
-	_, err := fmt.Fprintf(out, "dumped %d tables to %s\n", len(Database.TableNames()), cmd)
-	return err
+	c.Prompt = dbName(cmd) + ">"
+	return nil
 }
 
-func RestoreCommand(cmd string, out io.Writer) error {
+// restoreDatabase restores the tables in the named file into the database, trying the name with a json, then db, extension when it has none.
+func restoreDatabase(db *minisql.MiniDB, cmd string, out io.Writer) error {
 	if cmd == "" {
 		return fmt.Errorf("must specifiy the file path to restore from")
 	}
 
-	tc := len(Database.TableNames())
-	if err := minisql.Restore(cmd, Database); err != nil {
+	tc := len(db.TableNames())
+	if err := minisql.Restore(cmd, db); err != nil {
 		if path.Ext(cmd) == "" && os.IsNotExist(err) {
-			// not exists without extentions, try again with json extension
-			err = minisql.Restore(strings.Join([]string{cmd, "json"}, "."), Database)
+			// not exists without extentions, try again with json extension, then the binary extension
+			err = minisql.Restore(strings.Join([]string{cmd, "json"}, "."), db)
+			if os.IsNotExist(err) {
+				err = minisql.Restore(strings.Join([]string{cmd, dumpWriters[dumpBinary].ext}, "."), db)
+			}
 		}
 		if err != nil {
 			return err
 		}
 	}
-	tc = len(Database.TableNames()) - tc
+	tc = len(db.TableNames()) - tc
 	var ts string
 	if tc != 1 {
 		ts = "s"
 	}
-	Prompt = dbName(cmd) + ">"
 	_, err := fmt.Fprintf(out, "restored %d new table%s from %s\n", tc, ts, cmd)
 	return err
 }
 
+// SourceCommand runs the commands in the named file, in turn, stopping at the first to fail.
+func (c *CLI) SourceCommand(ctx context.Context, cmd string, out io.Writer) error {
+	if cmd == "" {
+		return fmt.Errorf("must specifiy the file path to read from")
+	}
+	cmd, err := c.filePath(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(cmd)
+	if err != nil {
+		return err
+	}
+	// the commands of a client are limited to those it may send to the server
+	run := c.parseCommand
+	if connectionOf(ctx) != nil {
+		run = c.serverCommand
+	}
+	defer func(f io.Closer) {
+		if err := f.Close(); err != nil {
+			log.Println(err)
+		}
+	}(f)
+	var count int
+	line, err := readCommands(f, func(command string) error {
+		if isEmptyCommand(command) {
+			return nil
+		}
+		count++
+		return run(ctx, out, command)
+	})
+	if err != nil {
+		return fmt.Errorf("%s line %d  %w", cmd, line, err)
+	}
+	_, err = fmt.Fprintf(out, "ran %d %s from %s\n", count, plural(count, "command"), cmd)
+	return err
+}
+
+func (c *CLI) CheckpointCommand(_ string, out io.Writer) error {
+	if err := c.db.Checkpoint(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "checkpoint of %d tables complete\n", len(c.db.TableNames()))
+	return err
+}
+
 func dbName(s string) string {
 	n := path.Base(s)
 	return n[:len(n)-len(path.Ext(s))]
@@ -0,0 +1,368 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The output formats of query results
+const (
+	FormatTable    = "table"
+	FormatCSV      = "csv"
+	FormatTSV      = "tsv"
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+	FormatMarkdown = "markdown"
+)
+
+var formatHelp = "Change how query results are written with FORMAT\n" +
+	"\tFORMAT\tshows the current format\n" +
+	"\tFORMAT table|csv|tsv|json|jsonl|markdown [NULL <text>]\tsets the format and optionally the text written for NULL values\n"
+
+// resultTable is the rows of results from a single table, with the same columns.
+type resultTable struct {
+	name    string
+	columns []string
+	rows    [][]*string
+}
+
+// resultWriter writes query results in a format.
+type resultWriter interface {
+	// Write writes the results, of one or more tables.  null is the text written for NULL values.
+	Write(out io.Writer, tables []*resultTable, null string) error
+	// NullText gets the default text written for NULL values.
+	NullText() string
+}
+
+var resultWriters = map[string]resultWriter{
+	FormatTable:    tableWriter{},
+	FormatCSV:      delimitedWriter{comma: ','},
+	FormatTSV:      delimitedWriter{comma: '\t'},
+	FormatJSON:     jsonWriter{},
+	FormatJSONL:    jsonWriter{lines: true},
+	FormatMarkdown: markdownWriter{},
+}
+
+// formatNames gets the names of the output formats, in name order.
+func formatNames() []string {
+	var names []string
+	for n := range resultWriters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetFormat sets the format query results are written in.
+func (c *CLI) SetFormat(format string) error {
+	f := strings.ToLower(format)
+	if _, ok := resultWriters[f]; !ok {
+		return fmt.Errorf("%q is not a known format.  Use one of %s", format, strings.Join(formatNames(), ", "))
+	}
+	c.format = f
+	return nil
+}
+
+// Format gets the format query results are written in.
+func (c *CLI) Format() string {
+	return c.format
+}
+
+// SetNullText sets the text written for NULL values, by all but the JSON formats, which always write null.
+func (c *CLI) SetNullText(text string) {
+	c.nullText = &text
+}
+
+// null gets the text written for NULL values in the current format.
+func (c *CLI) null() string {
+	if c.nullText != nil {
+		return *c.nullText
+	}
+	return resultWriters[c.format].NullText()
+}
+
+// FormatCommand sets, or shows, the format query results are written in.
+// FORMAT <format> [NULL <text>]
+func (c *CLI) FormatCommand(cmd string, out io.Writer) error {
+	if cmd == "" {
+		_, err := fmt.Fprintf(out, "format %s, NULL as %q\n", c.format, c.null())
+		return err
+	}
+	format, rest := cmd, ""
+	if i := strings.IndexAny(cmd, " \t"); i >= 0 {
+		format, rest = cmd[:i], strings.TrimSpace(cmd[i:])
+	}
+	var null *string
+	if rest != "" {
+		kw, text := rest, ""
+		if i := strings.IndexAny(rest, " \t"); i >= 0 {
+			kw, text = rest[:i], strings.TrimSpace(rest[i:])
+		}
+		if !strings.EqualFold(kw, "NULL") {
+			return fmt.Errorf("expected NULL after the format, found %q", kw)
+		}
+		text = commandArgument(text)
+		null = &text
+	}
+	if err := c.SetFormat(format); err != nil {
+		return err
+	}
+	if null != nil {
+		c.nullText = null
+	}
+	_, err := fmt.Fprintf(out, "format %s\n", c.format)
+	return err
+}
+
+// writeResults writes the results in the current output format.
+func (c *CLI) writeResults(out io.Writer, tables []*resultTable) error {
+	return resultWriters[c.format].Write(out, tables, c.null())
+}
+
+func valueText(v *string, null string) string {
+	if v == nil {
+		return null
+	}
+	return *v
+}
+
+var tableEscaper = strings.NewReplacer("\t", `\t`, "\r", `\r`, "\n", `\n`)
+
+// tableWriter writes the results of each table as a table of aligned columns, headed with the table name.
+type tableWriter struct{}
+
+func (w tableWriter) NullText() string {
+	return "NULL"
+}
+
+func (w tableWriter) Write(out io.Writer, tables []*resultTable, null string) error {
+	for _, t := range tables {
+		widths := make([]int, len(t.columns))
+		cells := make([][]string, len(t.rows))
+		for i, c := range t.columns {
+			widths[i] = utf8.RuneCountInString(c)
+		}
+		for r, row := range t.rows {
+			cells[r] = make([]string, len(row))
+			for i, v := range row {
+				// tabs and line ends would break the alignment of the columns
+				s := tableEscaper.Replace(valueText(v, null))
+				cells[r][i] = s
+				if n := utf8.RuneCountInString(s); n > widths[i] {
+					widths[i] = n
+				}
+			}
+		}
+		buf := &strings.Builder{}
+		fmt.Fprintf(buf, "Table: %s\n", t.name)
+		writeAligned(buf, t.columns, widths, " | ")
+		dashes := make([]string, len(widths))
+		for i, n := range widths {
+			dashes[i] = strings.Repeat("-", n)
+		}
+		writeAligned(buf, dashes, widths, "-+-")
+		for _, row := range cells {
+			writeAligned(buf, row, widths, " | ")
+		}
+		fmt.Fprintf(buf, "(%d %s)\n", len(t.rows), plural(len(t.rows), "row"))
+		if _, err := io.WriteString(out, buf.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAligned(buf *strings.Builder, cells []string, widths []int, sep string) {
+	for i, c := range cells {
+		if i > 0 {
+			buf.WriteString(sep)
+		}
+		buf.WriteString(c)
+		// the last column is not padded, so lines have no trailing space
+		if i < len(cells)-1 {
+			buf.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c)))
+		}
+	}
+	buf.WriteString("\n")
+}
+
+func plural(n int, s string) string {
+	if n == 1 {
+		return s
+	}
+	return s + "s"
+}
+
+// delimitedWriter writes the results as delimited values, with a header line of the column names.
+// Commas are written as CSV, quoting values containing the delimiter, quotes or line ends.
+// Tabs are written as TSV, escaping tabs, line ends and backslashes in values with a backslash.
+type delimitedWriter struct {
+	comma rune
+}
+
+func (w delimitedWriter) NullText() string {
+	return ""
+}
+
+func (w delimitedWriter) Write(out io.Writer, tables []*resultTable, null string) error {
+	if w.comma == '\t' {
+		return w.writeTSV(out, tables, null)
+	}
+	cw := csv.NewWriter(out)
+	cw.Comma = w.comma
+	for _, t := range tables {
+		if err := cw.Write(t.columns); err != nil {
+			return err
+		}
+		for _, row := range t.rows {
+			rec := make([]string, len(row))
+			for i, v := range row {
+				rec[i] = valueText(v, null)
+			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (w delimitedWriter) writeTSV(out io.Writer, tables []*resultTable, null string) error {
+	buf := &strings.Builder{}
+	line := func(vals []string) {
+		for i, v := range vals {
+			if i > 0 {
+				buf.WriteString("\t")
+			}
+			buf.WriteString(tsvEscaper.Replace(v))
+		}
+		buf.WriteString("\n")
+	}
+	for _, t := range tables {
+		line(t.columns)
+		for _, row := range t.rows {
+			vals := make([]string, len(row))
+			for i, v := range row {
+				vals[i] = valueText(v, null)
+			}
+			line(vals)
+		}
+	}
+	_, err := io.WriteString(out, buf.String())
+	return err
+}
+
+// jsonWriter writes each result as a JSON object, with the selected names in order and NULL values as null.
+// The objects are written in a single array or, as JSON lines, one object per line.
+type jsonWriter struct {
+	lines bool
+}
+
+func (w jsonWriter) NullText() string {
+	return "null"
+}
+
+func (w jsonWriter) Write(out io.Writer, tables []*resultTable, _ string) error {
+	buf := &strings.Builder{}
+	if !w.lines {
+		buf.WriteString("[")
+	}
+	var count int
+	for _, t := range tables {
+		for _, row := range t.rows {
+			if !w.lines {
+				if count > 0 {
+					buf.WriteString(",")
+				}
+				buf.WriteString("\n  ")
+			}
+			if err := writeJSONObject(buf, t.columns, row); err != nil {
+				return err
+			}
+			if w.lines {
+				buf.WriteString("\n")
+			}
+			count++
+		}
+	}
+	if !w.lines {
+		if count > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("]\n")
+	}
+	_, err := io.WriteString(out, buf.String())
+	return err
+}
+
+// writeJSONObject writes the values as a JSON object, keyed by the columns in order.
+func writeJSONObject(buf *strings.Builder, columns []string, values []*string) error {
+	buf.WriteString("{")
+	for i, c := range columns {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		by, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		buf.Write(by)
+		buf.WriteString(":")
+		if by, err = json.Marshal(values[i]); err != nil {
+			return err
+		}
+		buf.Write(by)
+	}
+	buf.WriteString("}")
+	return nil
+}
+
+// markdownWriter writes the results of each table as a markdown table.
+type markdownWriter struct{}
+
+func (w markdownWriter) NullText() string {
+	return "NULL"
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+func (w markdownWriter) Write(out io.Writer, tables []*resultTable, null string) error {
+	buf := &strings.Builder{}
+	line := func(vals []string) {
+		buf.WriteString("|")
+		for _, v := range vals {
+			buf.WriteString(" ")
+			buf.WriteString(markdownEscaper.Replace(v))
+			buf.WriteString(" |")
+		}
+		buf.WriteString("\n")
+	}
+	for i, t := range tables {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		line(t.columns)
+		dashes := make([]string, len(t.columns))
+		for i := range dashes {
+			dashes[i] = "---"
+		}
+		line(dashes)
+		for _, row := range t.rows {
+			vals := make([]string, len(row))
+			for i, v := range row {
+				vals[i] = valueText(v, null)
+			}
+			line(vals)
+		}
+	}
+	_, err := io.WriteString(out, buf.String())
+	return err
+}
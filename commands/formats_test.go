@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func formatTestTables() []*resultTable {
+	one, two := "one", "a,\"b\"\tc|d\ne"
+	return []*resultTable{{
+		name:    "t",
+		columns: []string{"z", "a"},
+		rows:    [][]*string{{&one, nil}, {nil, &two}},
+	}}
+}
+
+func TestResultWriters(t *testing.T) {
+	tests := map[string]string{
+		FormatTable: "Table: t\n" +
+			"z    | a\n" +
+			"-----+--------------\n" +
+			"one  | NULL\n" +
+			"NULL | a,\"b\"\\tc|d\\ne\n" +
+			"(2 rows)\n",
+		FormatCSV:      "z,a\none,\n,\"a,\"\"b\"\"\tc|d\ne\"\n",
+		FormatTSV:      "z\ta\none\t\n\ta,\"b\"\\tc|d\\ne\n",
+		FormatJSON:     "[\n  {\"z\":\"one\",\"a\":null},\n  {\"z\":null,\"a\":\"a,\\\"b\\\"\\tc|d\\ne\"}\n]\n",
+		FormatJSONL:    "{\"z\":\"one\",\"a\":null}\n{\"z\":null,\"a\":\"a,\\\"b\\\"\\tc|d\\ne\"}\n",
+		FormatMarkdown: "| z | a |\n| --- | --- |\n| one | NULL |\n| NULL | a,\"b\"\tc\\|d<br>e |\n",
+	}
+	for format, expect := range tests {
+		w := resultWriters[format]
+		buf := &bytes.Buffer{}
+		if err := w.Write(buf, formatTestTables(), w.NullText()); err != nil {
+			t.Fatalf("failed to write %s  %s", format, err)
+		}
+		if buf.String() != expect {
+			t.Fatalf("unexpected %s output, expected\n%q\nfound\n%q", format, expect, buf.String())
+		}
+	}
+}
+
+func TestResultWriters_JSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := (jsonWriter{}).Write(buf, formatTestTables(), ""); err != nil {
+		t.Fatalf("failed to write json  %s", err)
+	}
+	var rows []map[string]*string
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("json output is invalid  %s", err)
+	}
+	if len(rows) != 2 || rows[0]["a"] != nil || *rows[1]["a"] != "a,\"b\"\tc|d\ne" {
+		t.Fatalf("unexpected json rows %v", rows)
+	}
+	buf.Reset()
+	if err := (jsonWriter{}).Write(buf, nil, ""); err != nil || buf.String() != "[]\n" {
+		t.Fatalf("expected empty array without results, found %q  %v", buf.String(), err)
+	}
+}
+
+func TestFormatCommand(t *testing.T) {
+	c := NewCLI(nil)
+	buf := &bytes.Buffer{}
+	if err := c.FormatCommand("CSV NULL '-'", buf); err != nil {
+		t.Fatalf("failed to set format  %s", err)
+	}
+	if c.Format() != FormatCSV || c.null() != "-" {
+		t.Fatalf("expected csv format with NULL as '-', found %s %q", c.Format(), c.null())
+	}
+	for _, cmd := range []string{"xml", "csv EMPTY x"} {
+		if err := c.FormatCommand(cmd, buf); err == nil {
+			t.Fatalf("expected error setting format %q", cmd)
+		}
+	}
+	if other := NewCLI(nil); other.Format() != FormatTable || other.null() != "NULL" {
+		t.Fatalf("expected format of another command line to be unchanged, found %s %q", other.Format(), other.null())
+	}
+}
@@ -0,0 +1,307 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/stringutil"
+)
+
+var httpHelp = "\tminisql -http <address>\tserves the database as a JSON API over HTTP, on POST /query, GET /tables, GET /tables/<table>, POST /dump and POST /restore\n" +
+	"\t\t/dump and /restore only use the files in the -file-dir, named relative to it\n"
+
+// maxRequestSize is the largest request body accepted by the HTTP API.
+const maxRequestSize = 1024 * 1024
+
+// queryRequest is the body of a POST /query request.
+type queryRequest struct {
+	SQL    string        `json:"sql"`
+	Params []interface{} `json:"params"`
+}
+
+// fileRequest is the body of a POST /dump or POST /restore request.
+type fileRequest struct {
+	File string `json:"file"`
+}
+
+// tableDescription is the response to GET /tables/<table>.
+type tableDescription struct {
+	Table   string              `json:"table"`
+	Columns []columnDescription `json:"columns"`
+	Indexes []minisql.IndexDef  `json:"indexes,omitempty"`
+}
+
+type columnDescription struct {
+	Name string             `json:"name"`
+	Type minisql.ColumnType `json:"type"`
+	// Constraints are the constraints of the column, as written in CREATE TABLE
+	Constraints string `json:"constraints,omitempty"`
+}
+
+// httpError is an error with the HTTP status it is reported with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e httpError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(err error) error {
+	return httpError{status: http.StatusBadRequest, err: err}
+}
+
+// errorStatus gets the HTTP status an error is reported with.
+// Errors in the request are a bad request, unless they refer to a table, or file, which does not exist.
+func errorStatus(err error) int {
+	var he httpError
+	var ute minisql.UnknownTableError
+	switch {
+	case errors.As(err, &ute), errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.As(err, &he):
+		return he.status
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// ListenAndServeHTTP serves the database as a JSON API over HTTP, on the given address, until the context is done.
+// Dumps and restores may only use the files in the given directory.
+func ListenAndServeHTTP(ctx context.Context, db *minisql.MiniDB, addr, fileDir string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving http on %s", l.Addr())
+	srv := &http.Server{Handler: NewHTTPHandler(db, fileDir)}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err = srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// NewHTTPHandler creates the handler of the HTTP API of the database.
+//
+//	POST /query {"sql": "...", "params": [...]}  runs a query, with any '?' placeholders replaced by the params
+//	GET /tables  lists the table names
+//	GET /tables/<table>  describes the columns and indexes of the table
+//	POST /dump {"file": "..."}  dumps the database to the file
+//	POST /restore {"file": "..."}  restores the tables in the file
+//
+// The files dumped and restored are named by their path relative to the given directory, and may not be outside of it.
+// When the directory is empty, dumps and restores are refused.
+func NewHTTPHandler(db *minisql.MiniDB, fileDir string) http.Handler {
+	h := &httpHandler{db: db, fileDir: fileDir}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", allowMethod(http.MethodPost, h.query))
+	mux.HandleFunc("/tables", allowMethod(http.MethodGet, h.tables))
+	mux.HandleFunc("/tables/", allowMethod(http.MethodGet, h.describe))
+	mux.HandleFunc("/dump", allowMethod(http.MethodPost, h.fileHandler(h.dump)))
+	mux.HandleFunc("/restore", allowMethod(http.MethodPost, h.fileHandler(h.restore)))
+	return mux
+}
+
+// httpHandler handles the requests of the HTTP API of a database.
+type httpHandler struct {
+	db *minisql.MiniDB
+	// fileDir is the directory of the files which may be dumped and restored.
+	fileDir string
+}
+
+// allowMethod refuses any request without the given method, before it reaches the handler.
+func allowMethod(method string, h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeHTTPError(w, httpError{status: http.StatusMethodNotAllowed, err: fmt.Errorf("%s requires %s", r.URL.Path, method)})
+			return
+		}
+		if err := h(w, r); err != nil {
+			writeHTTPError(w, err)
+		}
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// readRequest reads the JSON body of a request.
+func readRequest(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return badRequest(fmt.Errorf("invalid request body  %w", err))
+	}
+	return nil
+}
+
+// query runs a query, streaming its results as they are found.
+// The response is a JSON object of the first table and columns of the results, the results and their count:
+//
+//	{"table": "t", "columns": ["a", "b"], "rows": [{"a": "1", "b": null}], "count": 1}
+//
+// An error found before any results is reported with the status of the error.  An error found after results have been
+// written is added to the object as "error".
+func (h *httpHandler) query(w http.ResponseWriter, r *http.Request) error {
+	var req queryRequest
+	if err := readRequest(r, &req); err != nil {
+		return err
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		return badRequest(fmt.Errorf("request has no sql"))
+	}
+	if isTransactionCommand(req.SQL) {
+		return badRequest(fmt.Errorf("transactions are not supported over http, each query is run on its own"))
+	}
+	rows, err := h.db.Query(r.Context(), req.SQL, req.Params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	buf := &strings.Builder{}
+	var count int
+	head := struct {
+		Table   string   `json:"table"`
+		Columns []string `json:"columns"`
+	}{Table: rows.TableName(), Columns: rows.Columns()}
+	if head.Columns == nil {
+		head.Columns = []string{}
+	}
+	by, err := json.Marshal(&head)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	// the closing brace of the head is left off, to add the rows
+	buf.Write(by[:len(by)-1])
+	buf.WriteString(`,"rows":[`)
+	for rows.Next() {
+		if count > 0 {
+			buf.WriteString(",")
+		}
+		count++
+		if err := writeJSONObject(buf, rows.Columns(), rows.Values()); err != nil {
+			log.Println(err)
+		}
+		if buf.Len() >= 4096 {
+			if _, err := io.WriteString(w, buf.String()); err != nil {
+				return nil
+			}
+			buf.Reset()
+		}
+	}
+	fmt.Fprintf(buf, `],"count":%d`, count)
+	if rows.Err() != nil {
+		by, _ = json.Marshal(rows.Err().Error())
+		fmt.Fprintf(buf, `,"error":%s`, by)
+	}
+	buf.WriteString("}\n")
+	_, _ = io.WriteString(w, buf.String())
+	return nil
+}
+
+func (h *httpHandler) tables(w http.ResponseWriter, _ *http.Request) error {
+	names := h.db.TableNames()
+	if names == nil {
+		names = []string{}
+	}
+	writeJSON(w, http.StatusOK, names)
+	return nil
+}
+
+func (h *httpHandler) describe(w http.ResponseWriter, r *http.Request) error {
+	name := strings.TrimPrefix(r.URL.Path, "/tables/")
+	t, err := h.db.Table(name)
+	if err != nil {
+		return err
+	}
+	desc := tableDescription{Table: name}
+	for _, c := range t.ColumnNames() {
+		cd, err := t.ColumnDef(c)
+		if err != nil {
+			return err
+		}
+		desc.Columns = append(desc.Columns, columnDescription{Name: c, Type: cd.Type, Constraints: cd.Constraints()})
+	}
+	if ix, ok := t.(minisql.Indexer); ok {
+		desc.Indexes = ix.Indexes()
+	}
+	writeJSON(w, http.StatusOK, desc)
+	return nil
+}
+
+func (h *httpHandler) dump(file string, out io.Writer) error {
+	_, err := dumpDatabase(h.db, file, dumpJSON, out)
+	return err
+}
+
+func (h *httpHandler) restore(file string, out io.Writer) error {
+	return restoreDatabase(h.db, file, out)
+}
+
+// fileHandler reads the file of a request and performs the given command on it, responding with the output of the command.
+func (h *httpHandler) fileHandler(command func(file string, out io.Writer) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var req fileRequest
+		if err := readRequest(r, &req); err != nil {
+			return err
+		}
+		if req.File == "" {
+			return badRequest(fmt.Errorf("request has no file"))
+		}
+		file, err := fileInDir(h.fileDir, req.File)
+		if err != nil {
+			return httpError{status: http.StatusForbidden, err: err}
+		}
+		out := &bytes.Buffer{}
+		if err := command(file, out); err != nil {
+			if errorStatus(err) == http.StatusNotFound {
+				return err
+			}
+			return httpError{status: http.StatusInternalServerError, err: err}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": strings.TrimSpace(out.String())})
+		return nil
+	}
+}
+
+// isTransactionCommand checks if the line is a command which begins or ends a transaction, or a savepoint in one.
+func isTransactionCommand(line string) bool {
+	cmd, _ := stringutil.FirstWord(strings.TrimSpace(line))
+	switch strings.ToUpper(strings.TrimSuffix(cmd, ";")) {
+	case "BEGIN", "START", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eurozulu/miniSQL/minisql"
+)
+
+// httpRequest makes the request of the HTTP API, returning the status and body of the response.
+func httpRequest(t *testing.T, db *minisql.MiniDB, method, path, body string) (int, string) {
+	return httpFileRequest(t, db, "", method, path, body)
+}
+
+// httpFileRequest makes the request of the HTTP API, with the directory of the files it may dump and restore.
+func httpFileRequest(t *testing.T, db *minisql.MiniDB, fileDir, method, path, body string) (int, string) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	NewHTTPHandler(db, fileDir).ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s: expected json content, found %q", method, path, ct)
+	}
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+func TestHTTPHandler_Query(t *testing.T) {
+	db := minisql.NewDatabase(nil)
+	for _, sql := range []string{
+		`{"sql": "CREATE TABLE t (a INTEGER, b)"}`,
+		`{"sql": "INSERT INTO t (a, b) VALUES (?, ?)", "params": [1, "it's"]}`,
+		`{"sql": "INSERT INTO t (a, b) VALUES (?, ?)", "params": [2, null]}`,
+	} {
+		if status, body := httpRequest(t, db, http.MethodPost, "/query", sql); status != http.StatusOK {
+			t.Fatalf("unexpected status %d for %s  %s", status, sql, body)
+		}
+	}
+
+	tests := []struct {
+		body   string
+		status int
+		expect string
+	}{
+		{`{"sql": "SELECT a, b FROM t WHERE a > ? ORDER BY a DESC LIMIT ?", "params": [0, 5]}`, http.StatusOK,
+			`{"table":"t","columns":["a","b"],"rows":[{"a":"2","b":null},{"a":"1","b":"it's"}],"count":2}`},
+		{`{"sql": "SELECT a FROM t WHERE a = 3"}`, http.StatusOK, `{"table":"","columns":[],"rows":[],"count":0}`},
+		{`{"sql": "SELECT zz FROM t"}`, http.StatusBadRequest, `{"error":"zz is not a known column in table t"}`},
+		{`{"sql": "SELECT a FROM nope"}`, http.StatusNotFound, `{"error":"\"nope\" is not a known table"}`},
+		{`{"sql": "DELETE FROM nope"}`, http.StatusNotFound, `{"error":"\"nope\" is not a known table"}`},
+		{`{"sql": "SELEC a FROM t"}`, http.StatusBadRequest, `{"error":"line 1, column 1: unrecognised query \"SELEC\""}`},
+		{`{"sql": "SELECT a FROM t WHERE a = ?"}`, http.StatusBadRequest, `{"error":"query has 1 parameters, but 0 values were given"}`},
+		{`{"sql": "BEGIN"}`, http.StatusBadRequest, `{"error":"transactions are not supported over http, each query is run on its own"}`},
+		{`{"params": [1]}`, http.StatusBadRequest, `{"error":"request has no sql"}`},
+	}
+	for _, test := range tests {
+		status, body := httpRequest(t, db, http.MethodPost, "/query", test.body)
+		if status != test.status {
+			t.Fatalf("expected status %d for %s, found %d  %s", test.status, test.body, status, body)
+		}
+		if body != test.expect {
+			t.Fatalf("unexpected response to %s, expected\n%s\nfound\n%s", test.body, test.expect, body)
+		}
+	}
+
+	if status, _ := httpRequest(t, db, http.MethodGet, "/query", ""); status != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET /query to be refused, found status %d", status)
+	}
+}
+
+func TestHTTPHandler_Tables(t *testing.T) {
+	db := minisql.NewDatabase(minisql.Schema{"t": {"a": {Type: minisql.INTEGER}, "b": {}}})
+	if status, body := httpRequest(t, db, http.MethodGet, "/tables", ""); status != http.StatusOK || body != `["t"]` {
+		t.Fatalf("unexpected tables %d %s", status, body)
+	}
+
+	status, body := httpRequest(t, db, http.MethodGet, "/tables/t", "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d  %s", status, body)
+	}
+	var desc tableDescription
+	if err := json.Unmarshal([]byte(body), &desc); err != nil {
+		t.Fatalf("invalid description  %s", err)
+	}
+	if desc.Table != "t" || len(desc.Columns) != 2 {
+		t.Fatalf("unexpected description %s", body)
+	}
+	for _, c := range desc.Columns {
+		if c.Name == "a" && c.Type != minisql.INTEGER || c.Name == "b" && c.Type != minisql.TEXT {
+			t.Fatalf("unexpected column %v", c)
+		}
+	}
+
+	if status, _ = httpRequest(t, db, http.MethodGet, "/tables/nope", ""); status != http.StatusNotFound {
+		t.Fatalf("expected unknown table to be not found, found status %d", status)
+	}
+}
+
+func TestHTTPHandler_DumpRestore(t *testing.T) {
+	db := minisql.NewDatabase(minisql.Schema{"t": {"a": {}}})
+	dir := t.TempDir()
+	file := filepath.Join(dir, "db.json")
+	req := `{"file": "db.json"}`
+	if status, body := httpFileRequest(t, db, dir, http.MethodPost, "/dump", req); status != http.StatusOK {
+		t.Fatalf("unexpected dump status %d  %s", status, body)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("expected dump file  %s", err)
+	}
+
+	db = minisql.NewDatabase(nil)
+	status, body := httpFileRequest(t, db, dir, http.MethodPost, "/restore", req)
+	if status != http.StatusOK || body != `{"message":"restored 1 new table from `+file+`"}` {
+		t.Fatalf("unexpected restore %d  %s", status, body)
+	}
+	if !db.ContainsTable("t") {
+		t.Fatalf("expected table to be restored")
+	}
+	if status, _ = httpFileRequest(t, db, dir, http.MethodPost, "/restore", `{"file": "db.json.missing"}`); status != http.StatusNotFound {
+		t.Fatalf("expected missing file to be not found, found status %d", status)
+	}
+	if status, _ = httpFileRequest(t, db, dir, http.MethodPost, "/dump", `{}`); status != http.StatusBadRequest {
+		t.Fatalf("expected dump without a file to be a bad request, found status %d", status)
+	}
+	for _, f := range []string{file, "../db.json", "a/../../db.json"} {
+		if status, _ = httpFileRequest(t, db, dir, http.MethodPost, "/restore", `{"file": "`+f+`"}`); status != http.StatusForbidden {
+			t.Fatalf("expected file %q outside of the file directory to be forbidden, found status %d", f, status)
+		}
+	}
+	if status, _ = httpRequest(t, db, http.MethodPost, "/dump", req); status != http.StatusForbidden {
+		t.Fatalf("expected dump without a file directory to be forbidden, found status %d", status)
+	}
+}
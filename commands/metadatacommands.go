@@ -1,26 +1,43 @@
 package commands
 
 import (
+	"eurozulu/miniSQL/minisql"
 	"fmt"
 	"io"
 	"strings"
 )
 
 var metadataHelp = "Metadata about the database, DESCRIBE (DESC) and TABLES\n" +
-	"\tDESC <table>  describes the columns in that table\n" +
+	"\tDESC <table>  describes the columns, and their types, and the indexes of that table\n" +
 	"\tTABLES    Lists all the table names in the database\n"
 
-func DescribeCommand(cmd string, out io.Writer) error {
-	desc, err := Database.Describe(cmd)
+func (c *CLI) DescribeCommand(cmd string, out io.Writer) error {
+	cols, err := c.db.Describe(cmd)
 	if err != nil {
 		return err
 	}
-	desc = append([]string{fmt.Sprintf("Table: %s", cmd)}, desc...)
+	t, err := c.db.Table(cmd)
+	if err != nil {
+		return err
+	}
+	desc := []string{fmt.Sprintf("Table: %s", cmd)}
+	for _, c := range cols {
+		cd, err := t.ColumnDef(c)
+		if err != nil {
+			return err
+		}
+		desc = append(desc, fmt.Sprintf("%s\t%s", c, cd))
+	}
+	if ix, ok := t.(minisql.Indexer); ok {
+		for _, def := range ix.Indexes() {
+			desc = append(desc, fmt.Sprintf("INDEX\t%s", def))
+		}
+	}
 	_, err = fmt.Fprintln(out, strings.Join(desc, "\n"))
 	return err
 }
 
-func TablesCommand(cmd string, out io.Writer) error {
-	_, err := fmt.Fprintln(out, strings.Join(Database.TableNames(), "\n"))
+func (c *CLI) TablesCommand(cmd string, out io.Writer) error {
+	_, err := fmt.Fprintln(out, strings.Join(c.db.TableNames(), "\n"))
 	return err
 }
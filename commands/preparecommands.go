@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/queries/whereclause"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var prepareHelp = "Parse a query once, to run any number of times, with PREPARE and EXECUTE\n" +
+	"\tPREPARE <name> AS <query>\tprepares the query, with '?' or '$1', '$2'... placeholders in place of its values\n" +
+	"\t\te.g. PREPARE byqty AS SELECT name FROM mytable WHERE qty > $1 LIMIT $2\n" +
+	"\tEXECUTE <name> [(<value> [,<value>...])]\truns the prepared query, with the values in place of its placeholders\n" +
+	"\t\te.g. EXECUTE byqty (10, 5)\n" +
+	"\tDEALLOCATE [PREPARE] <name> | ALL\tremoves the prepared query, or all of them\n"
+
+// PrepareCommand parses a query, to run with EXECUTE.  Prepared queries belong to the session preparing them.
+func (c *CLI) PrepareCommand(ctx context.Context, cmd string, out io.Writer) error {
+	ts, err := lexer.NewTokenStream(cmd)
+	if err != nil {
+		return err
+	}
+	ts.Next()
+	name, err := ts.ExpectName("prepared query name")
+	if err != nil {
+		return err
+	}
+	if err = ts.ExpectKeyword("AS"); err != nil {
+		return err
+	}
+	prepared := c.preparedStatements(ctx)
+	if _, ok := prepared[name]; ok {
+		return fmt.Errorf("prepared query %q already exists", name)
+	}
+	st, err := c.currentSession(ctx).Prepare(ts.Rest())
+	if err != nil {
+		return err
+	}
+	prepared[name] = st
+	_, err = fmt.Fprintf(out, "prepared %s with %d %s\n", name, st.NumParameters(), plural(st.NumParameters(), "parameter"))
+	return err
+}
+
+// ExecuteCommand runs a prepared query, with the given values of its parameters, writing the results as queryCommand.
+func (c *CLI) ExecuteCommand(ctx context.Context, cmd string, out io.Writer) error {
+	ts, err := lexer.NewTokenStream(cmd)
+	if err != nil {
+		return err
+	}
+	ts.Next()
+	name, err := ts.ExpectName("prepared query name")
+	if err != nil {
+		return err
+	}
+	st, ok := c.preparedStatements(ctx)[name]
+	if !ok {
+		return fmt.Errorf("%q is not a prepared query", name)
+	}
+	var args []interface{}
+	if ts.AcceptSymbol("(") {
+		for {
+			v, err := whereclause.ReadValue(ts)
+			if err != nil {
+				return err
+			}
+			args = append(args, v)
+			if !ts.AcceptSymbol(",") {
+				break
+			}
+		}
+		if err = ts.ExpectSymbol(")"); err != nil {
+			return err
+		}
+	}
+	if err = ts.ExpectEnd(); err != nil {
+		return err
+	}
+	rows, err := st.Query(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return c.writeRows(ctx, rows, out)
+}
+
+// DeallocateCommand removes a prepared query, or all of them.
+func (c *CLI) DeallocateCommand(ctx context.Context, cmd string, out io.Writer) error {
+	ts, err := lexer.NewTokenStream(cmd)
+	if err != nil {
+		return err
+	}
+	ts.Next()
+	ts.AcceptKeyword("PREPARE")
+	prepared := c.preparedStatements(ctx)
+	var names []string
+	if ts.AcceptKeyword("ALL") {
+		for name := range prepared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		name, err := ts.ExpectName("prepared query name")
+		if err != nil {
+			return err
+		}
+		if _, ok := prepared[name]; !ok {
+			return fmt.Errorf("%q is not a prepared query", name)
+		}
+		names = append(names, name)
+	}
+	if err = ts.ExpectEnd(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		delete(prepared, name)
+	}
+	_, err = fmt.Fprintf(out, "deallocated %d prepared %s\n", len(names), plural(len(names), "statement"))
+	return err
+}
+
+// preparedStatements gets the queries prepared by the client connection running a command, or by the command line.
+func (c *CLI) preparedStatements(ctx context.Context) map[string]*minisql.Stmt {
+	if conn := connectionOf(ctx); conn != nil {
+		return conn.prepared
+	}
+	return c.prepared
+}
@@ -3,11 +3,8 @@ package commands
 import (
 	"context"
 	"eurozulu/miniSQL/minisql"
-	"eurozulu/miniSQL/queries"
-	"eurozulu/miniSQL/queries/whereclause"
 	"fmt"
 	"io"
-	"sort"
 	"strings"
 )
 
@@ -18,73 +15,81 @@ var queryHelp = "Query commands: SELECT, INSERT, UPDATE and DELETE.\n" +
 	"\t\t\tIf the INTO table exists, must have matching column names from the result.\n" +
 	"\t\t\tIf the INTO table doesn't exists, it is created with the columns of the result\n" +
 	"\t\tFROM must be followed by one or more, comma deliminated column names from the named table.\n" +
-	"\t\tWHERE optional whereclause clause to filter result.  Current only supports AND and equality\n" +
+	"\t\t\tcolumns may be expressions, named with AS <name>, or by their text e.g. SELECT price * qty AS total, 'const' AS tag FROM t\n" +
+	"\t\tWHERE optional whereclause clause to filter result, conditions joined with AND and OR, NOT applied before AND, AND before OR\n" +
 	"\t\t\te.g. WHERE col1=1 AND col2=thatthing\n" +
-	"\t\t\tcolumn can also be tested for NULL using the 'NULL' keyword\n" +
+	"\t\t\tvalues may be expressions of columns, with + - * / % and || e.g. WHERE qty * price > 100 OR updated > created\n" +
+	"\t\t\tcolumn can also be tested for NULL using IS NULL or IS NOT NULL, as comparing with NULL matches nothing\n" +
+	"\t\t\tvalues may call functions, UPPER LOWER LENGTH SUBSTR TRIM REPLACE INSTR CONCAT ABS ROUND FLOOR CEIL MOD\n" +
+	"\t\t\tCOALESCE NULLIF IFNULL NOW DATE STRFTIME DATE_ADD and CAST(<value> AS <type>) e.g. WHERE UPPER(name) = 'BOB'\n" +
+	"\t\tFROM <table> [<alias>] [INNER|LEFT|RIGHT|FULL JOIN <table> [<alias>] ON <table>.<column> = <table>.<column> [AND ...]]...\n" +
+	"\t\t\tjoins the rows of other tables, columns of joined tables are named <table>.<column> or <alias>.<column>\n" +
+	"\t\t\tCROSS JOIN <table> [<alias>] joins every row of each table, without ON\n" +
+	"\t\tGROUP BY <column>[,<column>...] [HAVING <condition>] groups the rows with the same values into a single result\n" +
+	"\t\t\tselect COUNT(*), COUNT([DISTINCT] <column>), SUM(<column>), AVG(<column>), MIN(<column>) and MAX(<column>) of each group\n" +
+	"\t\tORDER BY <column>[,<column>...] [ASC|DESC] sorts the results, by columns or by expressions e.g. ORDER BY LOWER(name)\n" +
+	"\t\tLIMIT <count> [OFFSET <count>] returns at most count results, skipping the first offset results\n" +
 	"\tINSERT INTO <table> (<column> [,<column>...]) VALUES (<value> [,<value>...])\n" +
 	"\tUPDATE <table> SET <column>=<value>|NULL [,<column>=<value>|NULL...][ WHERE <column>=<value>|NULL [AND <column>=<value>|NULL]...]\n" +
+	"\t\tvalues may be expressions of the row's columns e.g. SET qty = qty + 1, name = UPPER(name)\n" +
 	"\tDELETE FROM <table> [ WHERE <column>=<value>|NULL [AND <column>=<value>|NULL]...]\n"
 
-func queryCommand(ctx context.Context, cmd string, out io.Writer) error {
-	q, err := queries.ParseQuery(cmd)
-	if err != nil {
-		return err
-	}
-	rCh, err := q.Execute(ctx, Database)
+var transactionHelp = "Group changes into transactions with BEGIN, COMMIT and ROLLBACK\n" +
+	"\tBEGIN [TRANSACTION]\tstarts a transaction\n" +
+	"\tCOMMIT [TRANSACTION]\tends the transaction, keeping all its changes\n" +
+	"\tROLLBACK [TRANSACTION]\tends the transaction, undoing all its changes, including CREATE and DROP\n" +
+	"\tSAVEPOINT <name>\tmarks a point in the transaction to rollback to\n" +
+	"\tROLLBACK TO [SAVEPOINT] <name>\tundoes the changes made since the savepoint\n" +
+	"\tRELEASE [SAVEPOINT] <name>\tremoves the savepoint, keeping the changes\n"
+
+// queryCommand runs the query on the current session, and writes its results in the current output format.
+// When run for a client connection, the results are sent to the client.
+// Any results found before an error are written before the error is returned.
+func (c *CLI) queryCommand(ctx context.Context, cmd string, out io.Writer) error {
+	rows, err := c.currentSession(ctx).Query(ctx, cmd)
 	if err != nil {
 		return err
 	}
-	result := map[string][]minisql.Values{}
-	for r := range rCh {
-		result[r.TableName()] = append(result[r.TableName()], r.Values())
-	}
-	if len(result) == 0 {
-		if _, err := fmt.Fprintf(out, "no results\n"); err != nil {
-			return err
-		}
-		return nil
-	}
+	return c.writeRows(ctx, rows, out)
+}
 
-	for t, v := range result {
-		if _, err := fmt.Fprintf(out, "Table: %s\n", t); err != nil {
-			return err
-		}
-		cols := orderedColumnNames(v[0])
-		if _, err := fmt.Fprintf(out, "%s\n", strings.Join(cols, "\t")); err != nil {
+// writeRows writes all the rows of a query in the current output format, or sends them to the client connection running the query.
+func (c *CLI) writeRows(ctx context.Context, rows *minisql.Rows, out io.Writer) error {
+	defer rows.Close()
+	tables := collectResults(rows)
+	if conn := connectionOf(ctx); conn != nil {
+		if err := conn.results(tables); err != nil {
 			return err
 		}
-		orderedColumnValues(cols, out, v)
+	} else if err := c.writeQueryResults(out, tables); err != nil {
+		return err
 	}
-	return nil
+	return rows.Err()
 }
 
-func orderedColumnNames(values minisql.Values) []string {
-	keys := make([]string, len(values))
-	var i int
-	for k := range values {
-		keys[i] = k
-		i++
+// writeQueryResults writes the results of a query in the current output format.
+func (c *CLI) writeQueryResults(out io.Writer, tables []*resultTable) error {
+	if len(tables) == 0 && (c.format == FormatTable || c.format == FormatMarkdown) {
+		_, err := fmt.Fprintf(out, "no results\n")
+		return err
 	}
-	sort.Slice(keys, func(i, j int) bool {
-		s := []string{keys[i], keys[j]}
-		sort.Strings(s)
-		return s[0] == keys[i]
-	})
-	return keys
+	return c.writeResults(out, tables)
 }
 
-func orderedColumnValues(cols []string, out io.Writer, values []minisql.Values) {
-	for _, v := range values {
-		for _, c := range cols {
-			fmt.Fprintf(out, "%s\t", valueString(v[c]))
+// collectResults reads all the rows, into a table for each table name and set of columns, in the order they are first found.
+func collectResults(rows *minisql.Rows) []*resultTable {
+	var tables []*resultTable
+	byKey := map[string]*resultTable{}
+	for rows.Next() {
+		cols := rows.Columns()
+		key := strings.Join(append([]string{rows.TableName()}, cols...), "\x00")
+		t, ok := byKey[key]
+		if !ok {
+			t = &resultTable{name: rows.TableName(), columns: cols}
+			byKey[key] = t
+			tables = append(tables, t)
 		}
-		fmt.Fprintln(out)
-	}
-}
-
-func valueString(v *string) string {
-	if v != nil {
-		return *v
+		t.rows = append(t.rows, rows.Values())
 	}
-	return whereclause.NULL
+	return tables
 }
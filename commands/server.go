@@ -0,0 +1,243 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/stringutil"
+)
+
+// DefaultListen is the address the server listens on, when no other is given.
+const DefaultListen = "localhost:7654"
+
+var serverHelp = "Share the database with other processes by starting minisql as a server\n" +
+	"\tminisql [-file-dir <directory>] serve [-listen <address>]\tserves the database over TCP, on " + DefaultListen + " by default\n" +
+	"\t\tclients may only DUMP, RESTORE, SOURCE, IMPORT and EXPORT the files in the -file-dir, named relative to it\n" +
+	"\tminisql connect <host>:<port>\truns the command line on a server\n"
+
+// maxStatementSize is the longest line, or statement frame, a client may send.
+const maxStatementSize = 1024 * 1024
+
+// The types of frame sent between the server and a client.
+const (
+	// frameStatement is a statement sent by a client, which may span any number of lines
+	frameStatement = "statement"
+	// frameColumns begins the results of a table, naming the table and its columns
+	frameColumns = "columns"
+	// frameRow is a row of values of the columns of the last columns frame
+	frameRow = "row"
+	// frameText is the output of a command which is not a query. e.g. DESC
+	frameText = "text"
+	// frameError is an error running the statement
+	frameError = "error"
+	// frameDone completes the response to a statement, with the count of rows sent
+	frameDone = "done"
+)
+
+// frame is a part of the response to a statement, sent to the client as a single line of JSON.
+// A client sends a statement as a single line of text, or a statement frame, and reads frames until a done frame.
+type frame struct {
+	Type    string    `json:"type"`
+	Table   string    `json:"table,omitempty"`
+	Columns []string  `json:"columns,omitempty"`
+	Values  []*string `json:"values,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Rows    int       `json:"rows,omitempty"`
+}
+
+// connection is the state of a client connected to the server.
+// Commands run for the client use its own session, and send query results to the client rather than writing them.
+type connection struct {
+	session *minisql.Session
+	// prepared are the queries prepared on the session, by name.
+	prepared map[string]*minisql.Stmt
+	// results sends the results of a query to the client
+	results func(tables []*resultTable) error
+}
+
+type connectionKey struct{}
+
+func withConnection(ctx context.Context, c *connection) context.Context {
+	return context.WithValue(ctx, connectionKey{}, c)
+}
+
+// connectionOf gets the client connection running a command, or nil when the command is run by the command line.
+func connectionOf(ctx context.Context) *connection {
+	c, _ := ctx.Value(connectionKey{}).(*connection)
+	return c
+}
+
+// ListenAndServe listens on the given address, serving the database to any number of clients, until the context is done.
+func (c *CLI) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving on %s", l.Addr())
+	return c.Serve(ctx, l)
+}
+
+// Serve accepts client connections from the listener, until the context is done.
+// Each client has its own session on the database, so may use transactions without affecting other clients.
+func (c *CLI) Serve(ctx context.Context, l net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			c.serveConnection(ctx, conn)
+		}(conn)
+	}
+}
+
+// serveConnection runs each statement sent by the client, sending back the frames of its response.
+// Any transaction left open when the client disconnects is rolled back.
+func (c *CLI) serveConnection(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	log.Printf("client %s connected", conn.RemoteAddr())
+	defer log.Printf("client %s disconnected", conn.RemoteAddr())
+
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	var rows int
+	client := &connection{
+		session:  c.db.NewSession(),
+		prepared: map[string]*minisql.Stmt{},
+		results: func(tables []*resultTable) error {
+			for _, t := range tables {
+				if err := enc.Encode(&frame{Type: frameColumns, Table: t.name, Columns: t.columns}); err != nil {
+					return err
+				}
+				for _, row := range t.rows {
+					if err := enc.Encode(&frame{Type: frameRow, Values: row}); err != nil {
+						return err
+					}
+				}
+				rows += len(t.rows)
+			}
+			return nil
+		},
+	}
+	defer func() {
+		if client.session.InTransaction() {
+			_ = client.session.Rollback()
+		}
+	}()
+	cctx := withConnection(ctx, client)
+
+	s := bufio.NewScanner(conn)
+	s.Buffer(make([]byte, 0, 4096), maxStatementSize)
+	for s.Scan() {
+		rows = 0
+		out := &bytes.Buffer{}
+		statement, err := statementOf(s.Bytes())
+		if err == nil {
+			err = c.serverCommand(cctx, out, statement)
+		}
+		if err == exitError {
+			return
+		}
+		if out.Len() > 0 {
+			_ = enc.Encode(&frame{Type: frameText, Text: out.String()})
+		}
+		if err != nil {
+			_ = enc.Encode(&frame{Type: frameError, Error: err.Error()})
+		}
+		_ = enc.Encode(&frame{Type: frameDone, Rows: rows})
+		if err = w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// statementOf gets the statement sent by a client, as the line of text or the text of a statement frame.
+func statementOf(line []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("{")) {
+		return string(line), nil
+	}
+	var f frame
+	if err := json.Unmarshal(line, &f); err != nil {
+		return "", fmt.Errorf("invalid statement frame  %w", err)
+	}
+	if f.Type != frameStatement {
+		return "", fmt.Errorf("expected a %s frame, found %q", frameStatement, f.Type)
+	}
+	return f.Text, nil
+}
+
+// serverCommand runs a command sent by a client.  Commands which change the state of the command line are refused,
+// and the command line prompt is left unchanged.
+func (c *CLI) serverCommand(ctx context.Context, out io.Writer, line string) error {
+	cmd, args := stringutil.FirstWord(strings.TrimSpace(line))
+	switch strings.ToUpper(strings.TrimSuffix(cmd, ";")) {
+	case "FORMAT":
+		return fmt.Errorf("FORMAT is set by the client")
+	case "DUMP":
+		filename, format, err := dumpArguments(args)
+		if err != nil {
+			return err
+		}
+		if filename, err = c.filePath(ctx, filename); err != nil {
+			return err
+		}
+		_, err = dumpDatabase(c.db, filename, format, out)
+		return err
+	case "RESTORE":
+		filename, err := c.filePath(ctx, commandArgument(args))
+		if err != nil {
+			return err
+		}
+		return restoreDatabase(c.db, filename, out)
+	}
+	return c.parseCommand(ctx, out, line)
+}
+
+// filePath gets the path of a file named in a command.  The command line may use any file, so its names are used as they are.
+// Clients of the server may only use the files in the FileDir, named by their path relative to it.
+func (c *CLI) filePath(ctx context.Context, name string) (string, error) {
+	if connectionOf(ctx) == nil || name == "" {
+		return name, nil
+	}
+	return fileInDir(c.FileDir, name)
+}
+
+// fileInDir gets the path of the named file in the directory, refusing any name outside of the directory.
+// When the directory is empty, no file may be used.
+func fileInDir(dir, name string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("files can not be used by clients, unless the server is started with -file-dir")
+	}
+	clean := filepath.Clean(name)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is not in the file directory of the server", name)
+	}
+	return filepath.Join(dir, clean), nil
+}
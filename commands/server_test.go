@@ -0,0 +1,394 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"eurozulu/miniSQL/minisql"
+)
+
+// testServer serves a new database on a local port, until the test ends.  Clients may use the files in a temporary directory.
+func testServer(t *testing.T) (*CLI, string) {
+	return testFileServer(t, t.TempDir())
+}
+
+// testFileServer serves a new database on a local port, until the test ends.  Clients may use the files in the given directory.
+func testFileServer(t *testing.T, fileDir string) (*CLI, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen  %s", err)
+	}
+	c := NewCLI(minisql.NewDatabase(nil))
+	c.FileDir = fileDir
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- c.Serve(ctx, l)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serve failed  %s", err)
+		}
+	})
+	return c, l.Addr().String()
+}
+
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	frames *bufio.Scanner
+}
+
+func dialTestServer(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect  %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testConn{t: t, conn: conn, frames: bufio.NewScanner(conn)}
+}
+
+// send sends the statement and reads the frames of the response, up to and including the done frame.
+func (c *testConn) send(statement string) []frame {
+	if _, err := io.WriteString(c.conn, statement+"\n"); err != nil {
+		c.t.Fatalf("failed to send %q  %s", statement, err)
+	}
+	var frames []frame
+	for c.frames.Scan() {
+		var f frame
+		if err := json.Unmarshal(c.frames.Bytes(), &f); err != nil {
+			c.t.Fatalf("invalid frame %q  %s", c.frames.Text(), err)
+		}
+		frames = append(frames, f)
+		if f.Type == frameDone {
+			return frames
+		}
+	}
+	c.t.Fatalf("connection closed before done frame of %q", statement)
+	return nil
+}
+
+// rows gets the values of the row frames, NULL as "NULL" and joined with ",".
+func rows(frames []frame) []string {
+	var found []string
+	for _, f := range frames {
+		if f.Type != frameRow {
+			continue
+		}
+		vals := make([]string, len(f.Values))
+		for i, v := range f.Values {
+			vals[i] = valueText(v, "NULL")
+		}
+		found = append(found, strings.Join(vals, ","))
+	}
+	return found
+}
+
+func TestServe(t *testing.T) {
+	cli, addr := testServer(t)
+	c := dialTestServer(t, addr)
+	for _, s := range []string{
+		"CREATE TABLE t (a INTEGER, b)",
+		"INSERT INTO t (a, b) VALUES (1, 'one')",
+		"INSERT INTO t (a) VALUES (2)",
+	} {
+		fs := c.send(s)
+		if last := fs[len(fs)-1]; last.Type != frameDone || last.Rows != 1 {
+			t.Fatalf("unexpected response to %q  %v", s, fs)
+		}
+	}
+
+	fs := c.send("SELECT a, b FROM t ORDER BY a")
+	if len(fs) != 4 {
+		t.Fatalf("expected 4 frames, found %d  %v", len(fs), fs)
+	}
+	if fs[0].Type != frameColumns || fs[0].Table != "t" || strings.Join(fs[0].Columns, ",") != "a,b" {
+		t.Fatalf("unexpected columns frame  %v", fs[0])
+	}
+	if found := strings.Join(rows(fs), ";"); found != "1,one;2,NULL" {
+		t.Fatalf("unexpected rows, expected %q, found %q", "1,one;2,NULL", found)
+	}
+	if fs[3].Rows != 2 {
+		t.Fatalf("expected done with 2 rows, found %d", fs[3].Rows)
+	}
+
+	fs = c.send("DESC t")
+	if fs[0].Type != frameText || !strings.Contains(fs[0].Text, "INTEGER") {
+		t.Fatalf("expected text frame describing the table, found %v", fs)
+	}
+
+	fs = c.send("SELECT zz FROM t")
+	if len(fs) != 2 || fs[0].Type != frameError || fs[0].Error == "" || fs[1].Type != frameDone {
+		t.Fatalf("expected error frame then done, found %v", fs)
+	}
+
+	fs = c.send("FORMAT csv")
+	if fs[0].Type != frameError {
+		t.Fatalf("expected FORMAT to be refused, found %v", fs)
+	}
+	if cli.Format() != FormatTable {
+		t.Fatalf("expected format to be unchanged, found %s", cli.Format())
+	}
+}
+
+func TestServe_Sessions(t *testing.T) {
+	_, addr := testServer(t)
+	c1 := dialTestServer(t, addr)
+	c2 := dialTestServer(t, addr)
+	c1.send("CREATE TABLE t (a INTEGER)")
+	c1.send("INSERT INTO t (a) VALUES (1)")
+
+	c1.send("BEGIN")
+	c1.send("INSERT INTO t (a) VALUES (2)")
+	if found := strings.Join(rows(c1.send("SELECT a FROM t ORDER BY a")), ";"); found != "1;2" {
+		t.Fatalf("expected transaction to see its insert, found %q", found)
+	}
+	// the transaction is only in the session of the client which began it
+	if fs := c2.send("COMMIT"); fs[0].Type != frameError {
+		t.Fatalf("expected COMMIT without a transaction to fail, found %v", fs)
+	}
+
+	// disconnecting rolls back the open transaction
+	_ = c1.conn.Close()
+	c3 := dialTestServer(t, addr)
+	c3.send("BEGIN")
+	c3.send("INSERT INTO t (a) VALUES (3)")
+	c3.send("COMMIT")
+	// the rollback happens once the server sees the disconnect
+	var found string
+	for i := 0; i < 100; i++ {
+		if found = strings.Join(rows(c2.send("SELECT a FROM t ORDER BY a")), ";"); found == "1;3" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected rolled back and committed inserts, found %q", found)
+}
+
+func TestServe_Prepared(t *testing.T) {
+	_, addr := testServer(t)
+	c1 := dialTestServer(t, addr)
+	c2 := dialTestServer(t, addr)
+	c1.send("CREATE TABLE t (a INTEGER, b)")
+	if fs := c1.send("PREPARE ins AS INSERT INTO t (a, b) VALUES (?, ?)"); fs[0].Type != frameText || fs[0].Text != "prepared ins with 2 parameters\n" {
+		t.Fatalf("unexpected response to PREPARE  %v", fs)
+	}
+	for _, s := range []string{"EXECUTE ins (1, 'it''s')", "EXECUTE ins (2, NULL)", "EXECUTE ins (3, three)"} {
+		if fs := c1.send(s); fs[len(fs)-1].Rows != 1 {
+			t.Fatalf("unexpected response to %q  %v", s, fs)
+		}
+	}
+	c1.send("PREPARE sel AS SELECT a, b FROM t WHERE a >= $1 ORDER BY a LIMIT $2")
+	if found := strings.Join(rows(c1.send("EXECUTE sel (2, 5)")), ";"); found != "2,NULL;3,three" {
+		t.Fatalf("unexpected results of prepared select, found %q", found)
+	}
+	if found := strings.Join(rows(c1.send("EXECUTE sel (0, 1)")), ";"); found != "1,it's" {
+		t.Fatalf("unexpected results of prepared select, found %q", found)
+	}
+
+	for _, s := range []string{
+		"EXECUTE sel (1)",
+		"EXECUTE nosuch (1)",
+		"PREPARE sel AS SELECT a FROM t",
+		"PREPARE bad AS SELECT FROM t WHERE a = ?",
+	} {
+		if fs := c1.send(s); fs[0].Type != frameError {
+			t.Fatalf("expected error from %q, found %v", s, fs)
+		}
+	}
+	// prepared queries belong to the connection which prepared them
+	if fs := c2.send("EXECUTE sel (0, 1)"); fs[0].Type != frameError {
+		t.Fatalf("expected other connection not to find the prepared query, found %v", fs)
+	}
+	if fs := c1.send("DEALLOCATE PREPARE sel"); fs[0].Type != frameText {
+		t.Fatalf("unexpected response to DEALLOCATE  %v", fs)
+	}
+	if fs := c1.send("EXECUTE sel (0, 1)"); fs[0].Type != frameError {
+		t.Fatalf("expected deallocated query to be unknown, found %v", fs)
+	}
+	if fs := c1.send("DEALLOCATE ALL"); fs[0].Type != frameText || fs[0].Text != "deallocated 1 prepared statement\n" {
+		t.Fatalf("unexpected response to DEALLOCATE ALL  %v", fs)
+	}
+}
+
+func TestServe_ImportExport(t *testing.T) {
+	cli, addr := testServer(t)
+	c := dialTestServer(t, addr)
+	src := "in.csv"
+	if err := os.WriteFile(filepath.Join(cli.FileDir, src), []byte("name,qty\n\"Smith, J\",3\nnone,\nempty,\"\"\n"), 0644); err != nil {
+		t.Fatalf("failed to write csv  %s", err)
+	}
+	if fs := c.send(fmt.Sprintf("IMPORT CSV '%s' INTO people HEADER", src)); fs[0].Type != frameText || fs[0].Text != "imported 3 rows into people\n" {
+		t.Fatalf("unexpected response to IMPORT  %v", fs)
+	}
+	if found := strings.Join(rows(c.send("SELECT name, qty FROM people ORDER BY name")), ";"); found != "Smith, J,3;empty,;none,NULL" {
+		t.Fatalf("unexpected imported rows %q", found)
+	}
+
+	dst := "out.csv"
+	exported := filepath.Join(cli.FileDir, dst)
+	if fs := c.send(fmt.Sprintf("EXPORT people TO '%s' HEADER DELIMITER ';' NULL 'NULL'", dst)); fs[0].Type != frameText || fs[0].Text != "exported 3 rows to "+exported+"\n" {
+		t.Fatalf("unexpected response to EXPORT  %v", fs)
+	}
+	c.send("CREATE TABLE copy (name, qty)")
+	if fs := c.send(fmt.Sprintf("IMPORT CSV '%s' INTO copy HEADER DELIMITER ';' NULL 'NULL'", dst)); fs[0].Type != frameText {
+		t.Fatalf("unexpected response to IMPORT of export  %v", fs)
+	}
+	if found := strings.Join(rows(c.send("SELECT name, qty FROM copy ORDER BY name")), ";"); found != "Smith, J,3;empty,;none,NULL" {
+		t.Fatalf("unexpected rows imported from export %q", found)
+	}
+
+	if fs := c.send(fmt.Sprintf("EXPORT (SELECT name FROM people WHERE (qty = 3)) TO '%s'", dst)); fs[0].Type != frameText || fs[0].Text != "exported 1 row to "+exported+"\n" {
+		t.Fatalf("unexpected response to EXPORT of query  %v", fs)
+	}
+	if b, err := os.ReadFile(exported); err != nil || string(b) != "\"Smith, J\"\n" {
+		t.Fatalf("unexpected export of query %q  %v", b, err)
+	}
+
+	for _, s := range []string{
+		"IMPORT CSV 'nosuch.csv' INTO people",
+		fmt.Sprintf("IMPORT CSV '%s' INTO nosuch", dst),
+		fmt.Sprintf("IMPORT CSV '%s' INTO people DELIMITER ';;'", src),
+		fmt.Sprintf("EXPORT nosuch TO '%s'", dst),
+		fmt.Sprintf("EXPORT (DELETE FROM people) TO '%s'", dst),
+		fmt.Sprintf("IMPORT CSV '%s' INTO people", filepath.Join(cli.FileDir, src)),
+		"EXPORT people TO '../out.csv'",
+	} {
+		if fs := c.send(s); fs[0].Type != frameError {
+			t.Fatalf("expected error from %q, found %v", s, fs)
+		}
+	}
+}
+
+func TestServe_DumpSQL(t *testing.T) {
+	cli, addr := testServer(t)
+	c := dialTestServer(t, addr)
+	c.send("CREATE TABLE t (a INTEGER, `b c`)")
+	c.send("INSERT INTO t (a, `b c`) VALUES (1, 'it''s'), (2, NULL), (3, 'x, -- y;')")
+	c.send("INSERT INTO t (a, `b c`) VALUES (6, '\"quoted\"'), (7, '\"abc'), (8, '''x''')")
+	c.send("UPDATE t SET `b c` = 'z\"' WHERE a = 7")
+	c.send("CREATE INDEX bya ON t (a)")
+	dump := filepath.Join(cli.FileDir, "db.sql")
+	if fs := c.send("DUMP 'db.sql' AS SQL"); fs[0].Type != frameText || fs[0].Text != "dumped 1 tables to "+dump+"\n" {
+		t.Fatalf("unexpected response to DUMP AS SQL  %v", fs)
+	}
+	// values may continue over lines
+	f, err := os.OpenFile(dump, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open dump  %s", err)
+	}
+	_, err = f.WriteString("INSERT INTO t (a, `b c`) VALUES (4, 'two\nlines'),\n(5, '');\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatalf("failed to write to dump  %s", err)
+	}
+
+	_, addr = testFileServer(t, cli.FileDir)
+	c = dialTestServer(t, addr)
+	if fs := c.send("SOURCE db.sql"); fs[len(fs)-2].Type != frameText || fs[len(fs)-2].Text != "ran 4 commands from "+dump+"\n" {
+		t.Fatalf("unexpected response to SOURCE  %v", fs)
+	}
+	if found := strings.Join(rows(c.send("SELECT a, `b c` FROM t ORDER BY a")), ";"); found != "1,it's;2,NULL;3,x, -- y;;4,two\nlines;5,;6,\"quoted\";7,z\";8,'x'" {
+		t.Fatalf("unexpected rows from SOURCE of dump %q", found)
+	}
+	if fs := c.send("DESC t"); !strings.Contains(fmt.Sprint(fs), "bya") {
+		t.Fatalf("expected index to be created by dump, found %v", fs)
+	}
+	if fs := c.send("SOURCE db.sql"); fs[0].Type != frameError || !strings.Contains(fs[0].Error, "line 3") {
+		t.Fatalf("expected error on line 3 sourcing dump into existing table, found %v", fs)
+	}
+}
+
+func TestServe_DumpBinary(t *testing.T) {
+	cli, addr := testServer(t)
+	c := dialTestServer(t, addr)
+	c.send("CREATE TABLE t (a INTEGER, b)")
+	c.send("INSERT INTO t (a, b) VALUES (1, 'one'), (2, NULL)")
+	for _, format := range []string{"BINARY", "binary compressed"} {
+		name := strings.ReplaceAll(format, " ", "_")
+		dump := filepath.Join(cli.FileDir, name)
+		if fs := c.send(fmt.Sprintf("DUMP %s AS %s;", name, format)); fs[0].Type != frameText || fs[0].Text != "dumped 1 tables to "+dump+".db\n" {
+			t.Fatalf("unexpected response to DUMP AS %s  %v", format, fs)
+		}
+		_, addr := testFileServer(t, cli.FileDir)
+		rc := dialTestServer(t, addr)
+		if fs := rc.send("RESTORE " + name); fs[0].Type != frameText || fs[0].Text != "restored 1 new table from "+dump+"\n" {
+			t.Fatalf("unexpected response to RESTORE of %s dump  %v", format, fs)
+		}
+		if found := strings.Join(rows(rc.send("SELECT a, b FROM t ORDER BY a")), ";"); found != "1,one;2,NULL" {
+			t.Fatalf("unexpected rows restored from %s dump %q", format, found)
+		}
+	}
+	if fs := c.send("DUMP db AS XML"); fs[0].Type != frameError {
+		t.Fatalf("expected error dumping as unknown format, found %v", fs)
+	}
+}
+
+func TestServe_NoFileDir(t *testing.T) {
+	_, addr := testFileServer(t, "")
+	c := dialTestServer(t, addr)
+	c.send("CREATE TABLE t (a)")
+	for _, s := range []string{
+		"DUMP db",
+		"RESTORE db",
+		"SOURCE db.sql",
+		"IMPORT CSV 'in.csv' INTO t",
+		"EXPORT t TO 'out.csv'",
+	} {
+		if fs := c.send(s); fs[0].Type != frameError || !strings.Contains(fs[0].Error, "-file-dir") {
+			t.Fatalf("expected %q to be refused without a file directory, found %v", s, fs)
+		}
+	}
+}
+
+func TestConnect_MultiLine(t *testing.T) {
+	_, addr := testServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect  %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	cl := &client{cli: NewCLI(minisql.NewDatabase(nil)), conn: conn, frames: bufio.NewScanner(conn)}
+	ctx := context.Background()
+	for _, s := range []string{
+		"CREATE TABLE t (a,\n b INTEGER)",
+		"INSERT INTO t (a, b)\nVALUES ('x\ny', 1)",
+	} {
+		if err := cl.runCommand(ctx, io.Discard, s); err != nil {
+			t.Fatalf("failed to run %q  %s", s, err)
+		}
+	}
+	out := &strings.Builder{}
+	if err := cl.runCommand(ctx, out, "SELECT b\nFROM t\r\nWHERE a = 'x\ny'"); err != nil {
+		t.Fatalf("failed to select  %s", err)
+	}
+	if !strings.Contains(out.String(), "1") {
+		t.Fatalf("expected the selected row, found %q", out.String())
+	}
+
+	// a plain line is still a statement, while a frame must be a statement frame
+	c := dialTestServer(t, addr)
+	if found := strings.Join(rows(c.send(`{"type":"statement","text":"SELECT a\nFROM t"}`)), ";"); found != "x\ny" {
+		t.Fatalf("expected the row of the statement frame, found %q", found)
+	}
+	if found := strings.Join(rows(c.send("SELECT b FROM t")), ";"); found != "1" {
+		t.Fatalf("expected the row of the line, found %q", found)
+	}
+	for _, s := range []string{`{"type":"row","text":"SELECT a FROM t"}`, `{"type":`} {
+		if fs := c.send(s); fs[0].Type != frameError {
+			t.Fatalf("expected %q to be refused, found %v", s, fs)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package driver
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+
+	"eurozulu/miniSQL/minisql"
+)
+
+// conn is a connection to a database, with its own session.
+type conn struct {
+	db      *minisql.MiniDB
+	session *minisql.Session
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext parses the query once, for the statement to run any number of times.
+func (c *conn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	st, err := c.session.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{st: st, session: c.session}, nil
+}
+
+// Close closes the connection, rolling back any transaction left open.
+func (c *conn) Close() error {
+	if c.session.InTransaction() {
+		return c.session.Rollback()
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation != driver.IsolationLevel(0) {
+		return nil, fmt.Errorf("isolation levels are not supported")
+	}
+	if opts.ReadOnly {
+		return nil, fmt.Errorf("read only transactions are not supported")
+	}
+	if err := c.session.Begin(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Commit commits the transaction begun on the connection.
+func (c *conn) Commit() error {
+	return c.session.Commit()
+}
+
+// Rollback rolls back the transaction begun on the connection.
+func (c *conn) Rollback() error {
+	return c.session.Rollback()
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	st, err := c.session.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return (&stmt{st: st, session: c.session}).ExecContext(ctx, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	st, err := c.session.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return (&stmt{st: st, session: c.session}).QueryContext(ctx, args)
+}
+
+// parameters gets the values of the arguments, refusing named arguments.
+func parameters(args []driver.NamedValue) ([]interface{}, error) {
+	params := make([]interface{}, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, fmt.Errorf("named parameters are not supported, use '?' or '$n'")
+		}
+		params[i] = a.Value
+	}
+	return params, nil
+}
+
+// stmt is a query prepared in the session of a connection.
+type stmt struct {
+	st      *minisql.Stmt
+	session *minisql.Session
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.st.NumParameters()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	params, err := parameters(args)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.st.ExecChanges(ctx, params...)
+	if err != nil {
+		return nil, err
+	}
+	return &result{changes: c}, nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	params, err := parameters(args)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := s.st.Query(ctx, params...)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(s.session, rs), nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nvs
+}
+
+// result is the result of an Exec.
+type result struct {
+	changes minisql.Changes
+}
+
+// LastInsertId gets the _id of the last row inserted by an INSERT.
+func (r *result) LastInsertId() (int64, error) {
+	if r.changes.LastID < 0 {
+		return 0, fmt.Errorf("no row was inserted")
+	}
+	return r.changes.LastID, nil
+}
+
+func (r *result) RowsAffected() (int64, error) {
+	return int64(r.changes.Rows), nil
+}
@@ -0,0 +1,134 @@
+// Package driver is the database/sql driver of miniSQL, registered as "minisql".
+//
+//	db, err := sql.Open("minisql", "file:/path/db.json?mode=memory")
+//
+// The data source name is the location of the database and how it is opened:
+//
+//	""  or ":memory:"  a new, empty database, held in memory
+//	"file:<dump file>?mode=memory"  a database restored from a dump file, held in memory.  Changes are not written to the file.
+//	"file:<directory>?mode=durable"  a durable database, in a data directory, logging every change.
+//
+// The "file:" prefix is optional, and the mode defaults to memory.  Adding "compat=true" parses the queries of every
+// connection with the legacy rules of earlier versions, e.g. "file:<dump file>?mode=memory&compat=true".
+// The database is opened once by sql.Open, and shared by all of its connections.  Each connection has its own session,
+// so a transaction is only on the connection which began it.  Closing the sql.DB closes a durable database.
+//
+// Queries may use '?' placeholders, which are replaced by the arguments, in order.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"eurozulu/miniSQL/minisql"
+	// registers the parser of the queries run on the database
+	_ "eurozulu/miniSQL/queries"
+)
+
+// The modes a database can be opened in.
+const (
+	ModeMemory  = "memory"
+	ModeDurable = "durable"
+)
+
+// DriverName is the name the driver is registered with database/sql.
+const DriverName = "minisql"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver opens miniSQL databases.
+type Driver struct{}
+
+// Open opens a new database and a connection to it.
+// As each call opens a new database, database/sql uses OpenConnector, to share a database between its connections.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector opens the database of the data source name, to connect to.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	opts, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := minisql.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s  %w", dsn, err)
+	}
+	return &connector{driver: d, db: db}, nil
+}
+
+// parseDSN gets the options to open the database of the data source name with.
+func parseDSN(dsn string) (minisql.Options, error) {
+	var opts minisql.Options
+	if dsn == "" || dsn == ":memory:" {
+		return opts, nil
+	}
+	dsn = strings.TrimPrefix(dsn, "file:")
+	path, query := dsn, ""
+	if i := strings.LastIndex(dsn, "?"); i >= 0 {
+		path, query = dsn[:i], dsn[i+1:]
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return opts, fmt.Errorf("invalid data source name %q  %w", dsn, err)
+	}
+	mode := ModeMemory
+	for k, v := range params {
+		switch k {
+		case "mode":
+			mode = strings.ToLower(v[len(v)-1])
+			if mode != ModeMemory && mode != ModeDurable {
+				return opts, fmt.Errorf("%q is not a known mode, use %s or %s", mode, ModeMemory, ModeDurable)
+			}
+		case "compat":
+			if opts.Compat, err = strconv.ParseBool(v[len(v)-1]); err != nil {
+				return opts, fmt.Errorf("%q is not a valid compat, use true or false", v[len(v)-1])
+			}
+		default:
+			return opts, fmt.Errorf("%q is not a known data source parameter", k)
+		}
+	}
+	if path == ":memory:" {
+		path = ""
+	}
+	if mode == ModeDurable {
+		if path == "" {
+			return opts, fmt.Errorf("a durable database requires the path of its directory")
+		}
+		opts.Dir = path
+	} else {
+		opts.Restore = path
+	}
+	return opts, nil
+}
+
+// connector connects to a single open database.
+type connector struct {
+	driver *Driver
+	db     *minisql.MiniDB
+}
+
+func (c *connector) Connect(_ context.Context) (driver.Conn, error) {
+	return &conn{db: c.db, session: c.db.NewSession()}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the database, writing a new snapshot of a durable database.
+func (c *connector) Close() error {
+	return c.db.Close()
+}
@@ -0,0 +1,221 @@
+package driver_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eurozulu/miniSQL/driver"
+	"eurozulu/miniSQL/minisql"
+)
+
+func openTestDB(t *testing.T, dsn string) *sql.DB {
+	db, err := sql.Open(driver.DriverName, dsn)
+	if err != nil {
+		t.Fatalf("failed to open %q  %s", dsn, err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...interface{}) sql.Result {
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("failed to exec %q  %s", query, err)
+	}
+	return res
+}
+
+func TestDriver_ExecQuery(t *testing.T) {
+	db := openTestDB(t, ":memory:")
+	mustExec(t, db, "CREATE TABLE t (name, qty INTEGER, price REAL, ok BOOLEAN, at TIMESTAMP)")
+	at := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	res := mustExec(t, db, "INSERT INTO t (name, qty, price, ok, at) VALUES (?, ?, ?, ?, ?)", "it's", 3, 1.5, true, at)
+	if id, err := res.LastInsertId(); err != nil || id != 0 {
+		t.Fatalf("unexpected last insert id %d  %v", id, err)
+	}
+	res = mustExec(t, db, "INSERT INTO t (name, qty) VALUES (?, ?)", "two", 5)
+	if id, _ := res.LastInsertId(); id != 1 {
+		t.Fatalf("expected last insert id 1, found %d", id)
+	}
+
+	var name string
+	var qty int
+	var price float64
+	var ok bool
+	var found time.Time
+	err := db.QueryRow("SELECT name, qty, price, ok, at FROM t WHERE qty = ?", 3).Scan(&name, &qty, &price, &ok, &found)
+	if err != nil {
+		t.Fatalf("failed to scan row  %s", err)
+	}
+	if name != "it's" || qty != 3 || price != 1.5 || !ok || !found.Equal(at) {
+		t.Fatalf("unexpected row %q %d %v %v %v", name, qty, price, ok, found)
+	}
+
+	rows, err := db.Query("SELECT name, price FROM t ORDER BY qty")
+	if err != nil {
+		t.Fatalf("query failed  %s", err)
+	}
+	if cols, _ := rows.Columns(); len(cols) != 2 || cols[0] != "name" || cols[1] != "price" {
+		t.Fatalf("unexpected columns %v", cols)
+	}
+	var names []string
+	var prices []sql.NullFloat64
+	for rows.Next() {
+		var p sql.NullFloat64
+		if err = rows.Scan(&name, &p); err != nil {
+			t.Fatalf("failed to scan  %s", err)
+		}
+		names = append(names, name)
+		prices = append(prices, p)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("rows failed  %s", err)
+	}
+	if len(names) != 2 || names[0] != "it's" || names[1] != "two" || !prices[0].Valid || prices[1].Valid {
+		t.Fatalf("unexpected rows %v %v", names, prices)
+	}
+
+	res = mustExec(t, db, "UPDATE t SET qty = ? WHERE name = ?", 7, "two")
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatalf("expected 1 row updated, found %d", n)
+	}
+	res = mustExec(t, db, "DELETE FROM t")
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("expected 2 rows deleted, found %d", n)
+	}
+	if err = db.QueryRow("SELECT name FROM t").Scan(&name); err != sql.ErrNoRows {
+		t.Fatalf("expected no rows, found %v", err)
+	}
+
+	if _, err = db.Exec("SELECT zz FROM t"); err == nil {
+		t.Fatalf("expected error selecting unknown column")
+	}
+	if _, err = db.Exec("INSERT INTO t (qty) VALUES (?)", "abc"); err == nil {
+		t.Fatalf("expected error inserting invalid integer")
+	}
+	if _, err = db.Exec("SELECT name FROM t WHERE qty = ?"); err == nil {
+		t.Fatalf("expected error without parameter")
+	}
+	if _, err = db.Exec("SELECT name FROM t WHERE qty = ?", sql.Named("qty", 1)); err == nil {
+		t.Fatalf("expected error with named parameter")
+	}
+}
+
+func TestDriver_Prepare(t *testing.T) {
+	db := openTestDB(t, ":memory:")
+	mustExec(t, db, "CREATE TABLE t (name, qty INTEGER)")
+	ins, err := db.Prepare("INSERT INTO t (name, qty) VALUES ($2, $1)")
+	if err != nil {
+		t.Fatalf("failed to prepare  %s", err)
+	}
+	defer ins.Close()
+	for i, name := range []string{"a", "b", "c"} {
+		if _, err = ins.Exec(i, name); err != nil {
+			t.Fatalf("failed to exec prepared insert  %s", err)
+		}
+	}
+	// the number of parameters is known when prepared, so the wrong number of arguments is refused
+	if _, err = ins.Exec(1); err == nil {
+		t.Fatalf("expected error executing with too few arguments")
+	}
+	sel, err := db.Prepare("SELECT name FROM t WHERE qty > ? ORDER BY qty")
+	if err != nil {
+		t.Fatalf("failed to prepare  %s", err)
+	}
+	defer sel.Close()
+	var name string
+	if err = sel.QueryRow(0).Scan(&name); err != nil || name != "b" {
+		t.Fatalf("expected b, found %q  %v", name, err)
+	}
+	if err = sel.QueryRow(1).Scan(&name); err != nil || name != "c" {
+		t.Fatalf("expected c, found %q  %v", name, err)
+	}
+	if _, err = db.Prepare("SELECT name FROM t WHERE"); err == nil {
+		t.Fatalf("expected error preparing an invalid query")
+	}
+}
+
+func TestDriver_Transactions(t *testing.T) {
+	db := openTestDB(t, "")
+	mustExec(t, db, "CREATE TABLE t (a INTEGER)")
+	count := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+			t.Fatalf("failed to count  %s", err)
+		}
+		return n
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin  %s", err)
+	}
+	if _, err = tx.Exec("INSERT INTO t (a) VALUES (?)", 1); err != nil {
+		t.Fatalf("failed to insert  %s", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("failed to rollback  %s", err)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("expected rollback to remove the insert, found %d rows", n)
+	}
+
+	tx, _ = db.Begin()
+	_, _ = tx.Exec("INSERT INTO t (a) VALUES (?)", 2)
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("failed to commit  %s", err)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expected commit to keep the insert, found %d rows", n)
+	}
+}
+
+func TestDriver_DSN(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "db.json")
+	mdb := minisql.NewDatabase(minisql.Schema{"t": {"a": {}}})
+	if err := minisql.Dump(dump, mdb); err != nil {
+		t.Fatalf("failed to dump  %s", err)
+	}
+	db := openTestDB(t, "file:"+dump+"?mode=memory")
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("expected restored empty table, found %d  %v", n, err)
+	}
+
+	data := filepath.Join(dir, "data")
+	db = openTestDB(t, "file:"+data+"?mode=durable")
+	mustExec(t, db, "CREATE TABLE d (a)")
+	mustExec(t, db, "INSERT INTO d (a) VALUES (?)", "kept")
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close  %s", err)
+	}
+	db = openTestDB(t, "file:"+data+"?mode=durable")
+	var a string
+	if err := db.QueryRow("SELECT a FROM d").Scan(&a); err != nil || a != "kept" {
+		t.Fatalf("expected durable row, found %q  %v", a, err)
+	}
+
+	// NULL equals NULL only in compat mode
+	for dsn, expect := range map[string]int64{"file:" + dump: 0, "file:" + dump + "?compat=true": 1} {
+		db = openTestDB(t, dsn)
+		mustExec(t, db, "INSERT INTO t (a) VALUES (NULL)")
+		if n, _ := mustExec(t, db, "UPDATE t SET a = 'x' WHERE a = NULL").RowsAffected(); n != expect {
+			t.Fatalf("expected %d rows updated with %q, found %d", expect, dsn, n)
+		}
+	}
+
+	for _, dsn := range []string{"file:" + dump + "?mode=other", "file:" + dump + "?cache=shared", "file:" + dump + "?compat=maybe",
+		filepath.Join(dir, "missing.json")} {
+		db, err := sql.Open(driver.DriverName, dsn)
+		if err == nil {
+			err = db.Ping()
+			_ = db.Close()
+		}
+		if err == nil {
+			t.Fatalf("expected error opening %q", dsn)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+
+	"eurozulu/miniSQL/minisql"
+)
+
+// rows reads the results of a query, as they are found.
+// The columns are those of the first result.  Values are converted to the Go type of the column in its table,
+// int64, float64, bool, time.Time or string.  Columns not in the table, such as aggregates, are strings.
+type rows struct {
+	rows    *minisql.Rows
+	columns []string
+	types   []minisql.ColumnType
+}
+
+func newRows(db minisql.Database, rs *minisql.Rows) *rows {
+	r := &rows{rows: rs, columns: rs.Columns()}
+	if r.columns == nil {
+		r.columns = []string{}
+	}
+	r.types = make([]minisql.ColumnType, len(r.columns))
+	t, err := db.Table(rs.TableName())
+	for i, c := range r.columns {
+		r.types[i] = minisql.TEXT
+		if c == minisql.IDColumn {
+			r.types[i] = minisql.INTEGER
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if cd, err := t.ColumnDef(c); err == nil {
+			r.types[i] = cd.Type
+		}
+	}
+	return r
+}
+
+func (rs *rows) Columns() []string {
+	return rs.columns
+}
+
+// ColumnTypeDatabaseTypeName gets the type of the column in its table, or TEXT when it is not a column of the table.
+func (rs *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return string(rs.types[index])
+}
+
+func (rs *rows) Close() error {
+	return rs.rows.Close()
+}
+
+func (rs *rows) Next(dest []driver.Value) error {
+	if !rs.rows.Next() {
+		if err := rs.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	vals := rs.rows.Values()
+	for i, c := range rs.columns {
+		v, err := driverValue(vals[i], rs.types[i])
+		if err != nil {
+			return fmt.Errorf("column %s  %w", c, err)
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+// driverValue converts the value to the Go type of the column type.
+func driverValue(v *string, ct minisql.ColumnType) (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch ct {
+	case minisql.INTEGER:
+		return strconv.ParseInt(*v, 10, 64)
+	case minisql.REAL:
+		return strconv.ParseFloat(*v, 64)
+	case minisql.BOOLEAN:
+		return strconv.ParseBool(*v)
+	case minisql.TIMESTAMP:
+		return minisql.ParseTimestamp(*v)
+	default:
+		return *v, nil
+	}
+}
@@ -13,8 +13,23 @@ import (
 func main() {
 	var dbPath string
 	var schemaName string
+	var dataDir string
+	var format string
+	var httpAddr string
+	var fileDir string
 	flag.StringVar(&dbPath, "database", "", "filepath to a dump file of a database to load")
 	flag.StringVar(&schemaName, "schema", "", "filepath to a schema")
+	flag.StringVar(&dataDir, "data-dir", "", "directory of a durable database, logging every change so nothing is lost without a DUMP")
+	flag.StringVar(&format, "format", commands.FormatTable, "format of query results, table, csv, tsv, json, jsonl or markdown")
+	flag.StringVar(&httpAddr, "http", "", "address to serve the database as a JSON API over HTTP, instead of running the command line")
+	flag.StringVar(&fileDir, "file-dir", "", "directory of the files clients of a server may DUMP, RESTORE, SOURCE, IMPORT and EXPORT")
+	var compat bool
+	flag.BoolVar(&compat, "compat", false, "read WHERE conditions with the legacy rules, AND and OR from left to right and NULL equal to NULL")
+	var nullText *string
+	flag.Func("null", "text written for NULL values, by all but the json formats", func(s string) error {
+		nullText = &s
+		return nil
+	})
 	flag.Parse()
 
 	var scm minisql.Schema
@@ -25,24 +40,83 @@ func main() {
 		}
 		scm = s
 	}
-	commands.Database = minisql.NewDatabase(scm)
+	db, err := minisql.Open(minisql.Options{Dir: dataDir, Schema: scm, Compat: compat})
+	if err != nil {
+		log.Fatalf("failed to open database %s  %s", dataDir, err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
 
+	cli := commands.NewCLI(db)
+	cli.FileDir = fileDir
+	if err := cli.SetFormat(format); err != nil {
+		log.Fatalln(err)
+	}
+	if nullText != nil {
+		cli.SetNullText(*nullText)
+	}
 	if dbPath != "" {
-		if err := commands.RestoreCommand(dbPath, os.Stdout); err != nil {
+		if err := cli.RestoreCommand(dbPath, os.Stdout); err != nil {
 			log.Fatalf("failed to restore database %s  %s", dbPath, err)
 		}
 	}
 
-	//args := flag.Args()
-
 	ctx, cnl := context.WithCancel(context.Background())
 	defer cnl()
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, os.Kill)
 	done := make(chan bool)
 
-	go commands.RunCommands(ctx, os.Stdout, done)
+	args := flag.Args()
+	var cmd string
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+	if httpAddr != "" {
+		if cmd == "connect" {
+			log.Fatalln("-http can not be used to connect to a server")
+		}
+		go func() {
+			if err := commands.ListenAndServeHTTP(ctx, db, httpAddr, fileDir); err != nil {
+				log.Println(err)
+			}
+			cnl()
+		}()
+	}
+	switch cmd {
+	case "":
+		if httpAddr != "" {
+			go func() {
+				<-ctx.Done()
+				close(done)
+			}()
+			break
+		}
+		go cli.RunCommands(ctx, os.Stdout, done)
+	case "serve":
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		listen := fs.String("listen", commands.DefaultListen, "address to listen for clients on")
+		_ = fs.Parse(args)
+		go func() {
+			defer close(done)
+			if err := cli.ListenAndServe(ctx, *listen); err != nil {
+				log.Println(err)
+			}
+		}()
+	case "connect":
+		if len(args) != 1 {
+			log.Fatalln("connect requires the host:port of a server")
+		}
+		if err := cli.ConnectCommands(ctx, args[0], os.Stdout, done); err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		log.Fatalf("%q is an unknown command.  Use serve or connect", cmd)
+	}
 	for {
 		select {
 		case <-sig:
@@ -0,0 +1,498 @@
+package minisql
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// The binary format of a dump begins with a header of the binaryMagic, the format version and its flags.
+// The rest of the file, compressed with gzip when flagged, is a sequence of blocks, each a big endian uint32 length,
+// the data of the block and a big endian uint32 CRC32 checksum of the data.
+// The first byte of a block's data is its kind.  A table block is the schema of a table, followed by any number of
+// row blocks of that table's rows.  The last block is an end block, so a file cut short is found to be incomplete.
+//
+// Within blocks, numbers are unsigned varints and strings are a varint length followed by the bytes of the string.
+// A table block is the table name, the next id, the number of columns, the name and type of each column in name order,
+// each followed by a byte of its constraint flags, its default value when flagged, its CHECK condition and,
+// when flagged, the table, column and ON DELETE action it references, then
+// the number of indexes, and for each index its name, a unique byte, its type, and the number and names of its columns.
+// A row block is the number of rows, then the key of each row, followed by a value for each column of the table.
+// Values are a varint of zero for NULL, otherwise one more than the length of the value, followed by the value.
+const (
+	binaryMagic   = "MINISQL\x00"
+	binaryVersion = 2
+	// binaryVersion1 dumps have no column constraints.
+	binaryVersion1 = 1
+
+	binaryCompressed byte = 1 << 0
+
+	columnNotNull    byte = 1 << 0
+	columnUnique     byte = 1 << 1
+	columnHasDefault byte = 1 << 2
+	columnPrimaryKey byte = 1 << 3
+	columnReferences byte = 1 << 4
+
+	blockTable byte = 'T'
+	blockRows  byte = 'R'
+	blockEnd   byte = 'E'
+
+	// blockSize is the size a row block is written at, once it is at least this long.
+	blockSize = 64 * 1024
+	// maxBlockSize is the largest block accepted when reading, to stop a corrupt length allocating all the memory.
+	maxBlockSize = 256 * 1024 * 1024
+)
+
+// DumpBinary writes the whole database to the given file, in the compact binary format, compressed with gzip when compress is true.
+// Like Dump, the file is written to a temporary file, which then replaces any existing file.
+// The file is restored with Restore, which finds the format of the file from its contents.
+func DumpBinary(filename string, tdb *MiniDB, compress bool) error {
+	tdb.lock.RLock()
+	defer tdb.lock.RUnlock()
+	tables := map[string]*table{}
+	for tn, t := range tdb.tables {
+		tb, ok := t.(*table)
+		if !ok {
+			return fmt.Errorf("table %s can not be written as binary", tn)
+		}
+		tb.lock.RLock()
+		defer tb.lock.RUnlock()
+		tables[tn] = tb
+	}
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return writeBinary(w, tables, compress)
+	})
+}
+
+// isBinary checks if the reader begins with the header of the binary format.
+func isBinary(r *bufio.Reader) bool {
+	by, err := r.Peek(len(binaryMagic))
+	return err == nil && string(by) == binaryMagic
+}
+
+// writeBinary writes the tables, in name order, in the binary format.  The caller must hold the locks of all the tables.
+func writeBinary(w io.Writer, tables map[string]*table, compress bool) error {
+	var flags byte
+	if compress {
+		flags |= binaryCompressed
+	}
+	if _, err := io.WriteString(w, binaryMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{binaryVersion, flags}); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	var body io.Writer = bw
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(bw)
+		body = zw
+	}
+	names := make([]string, 0, len(tables))
+	for tn := range tables {
+		names = append(names, tn)
+	}
+	sort.Strings(names)
+	bk := &blockWriter{w: body}
+	for _, tn := range names {
+		if err := tables[tn].writeBinary(bk, tn); err != nil {
+			return err
+		}
+	}
+	bk.begin(blockEnd)
+	if err := bk.flush(); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeBinary writes the table block of the table, followed by the row blocks of its rows, in key order.
+func (tb *table) writeBinary(bk *blockWriter, name string) error {
+	cols := make([]string, 0, len(tb.columns))
+	for cn := range tb.columns {
+		cols = append(cols, cn)
+	}
+	sort.Strings(cols)
+	bk.begin(blockTable)
+	bk.putString(name)
+	bk.putUint(uint64(tb.nextID))
+	bk.putUint(uint64(len(cols)))
+	for _, cn := range cols {
+		cd := tb.columnDef(cn)
+		bk.putString(cn)
+		bk.putString(string(cd.Type))
+		var flags byte
+		if cd.NotNull {
+			flags |= columnNotNull
+		}
+		if cd.Unique {
+			flags |= columnUnique
+		}
+		if cd.Default != nil {
+			flags |= columnHasDefault
+		}
+		if cd.PrimaryKey {
+			flags |= columnPrimaryKey
+		}
+		if cd.References != nil {
+			flags |= columnReferences
+		}
+		bk.buf.WriteByte(flags)
+		if cd.Default != nil {
+			bk.putString(*cd.Default)
+		}
+		bk.putString(cd.Check)
+		if cd.References != nil {
+			bk.putString(cd.References.Table)
+			bk.putString(cd.References.Column)
+			bk.putString(string(cd.References.OnDelete))
+		}
+	}
+	defs := tb.indexDefs()
+	bk.putUint(uint64(len(defs)))
+	for _, def := range defs {
+		bk.putString(def.Name)
+		var unique byte
+		if def.Unique {
+			unique = 1
+		}
+		bk.buf.WriteByte(unique)
+		bk.putString(string(def.Type))
+		bk.putUint(uint64(len(def.Columns)))
+		for _, cn := range def.Columns {
+			bk.putString(cn)
+		}
+	}
+	if err := bk.flush(); err != nil {
+		return err
+	}
+
+	keys := make([]Key, 0, len(tb.keys))
+	for k, ok := range tb.keys {
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sortKeys(keys)
+	rows := &bytes.Buffer{}
+	var count int
+	writeRows := func() error {
+		bk.begin(blockRows)
+		bk.putUint(uint64(count))
+		bk.buf.Write(rows.Bytes())
+		rows.Reset()
+		count = 0
+		return bk.flush()
+	}
+	for _, k := range keys {
+		putUint(rows, uint64(k))
+		for _, cn := range cols {
+			v, ok := tb.columns[cn][k]
+			if !ok {
+				putUint(rows, 0)
+				continue
+			}
+			putUint(rows, uint64(len(v))+1)
+			rows.WriteString(v)
+		}
+		count++
+		if rows.Len() >= blockSize {
+			if err := writeRows(); err != nil {
+				return err
+			}
+		}
+	}
+	if count > 0 {
+		return writeRows()
+	}
+	return nil
+}
+
+// readBinary reads the tables written by writeBinary.
+func readBinary(r *bufio.Reader) (map[string]*table, error) {
+	header := make([]byte, len(binaryMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	version := header[len(binaryMagic)]
+	if version != binaryVersion && version != binaryVersion1 {
+		return nil, fmt.Errorf("binary dump version %d is not supported", version)
+	}
+	var body io.Reader = r
+	if header[len(binaryMagic)+1]&binaryCompressed != 0 {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = zr.Close() }()
+		body = zr
+	}
+
+	tables := map[string]*table{}
+	indexes := map[string][]IndexDef{}
+	bk := &blockReader{r: body, version: version}
+	var tb *table
+	var cols []string
+	for {
+		kind, err := bk.next()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case blockEnd:
+			// indexes are not dumped, only their definitions, so they are rebuilt from the rows
+			for tn, defs := range indexes {
+				if err = tables[tn].buildIndexes(defs); err != nil {
+					return nil, fmt.Errorf("table %s  %w", tn, err)
+				}
+			}
+			return tables, nil
+		case blockTable:
+			var name string
+			var defs []IndexDef
+			if name, tb, cols, defs, err = bk.readTable(); err != nil {
+				return nil, err
+			}
+			tables[name] = tb
+			indexes[name] = defs
+		case blockRows:
+			if tb == nil {
+				return nil, fmt.Errorf("block %d has rows before any table", bk.count)
+			}
+			if err = bk.readRows(tb, cols); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("block %d is of unknown kind %q", bk.count, kind)
+		}
+		if bk.data.Len() > 0 {
+			return nil, fmt.Errorf("block %d has %d unexpected bytes", bk.count, bk.data.Len())
+		}
+	}
+}
+
+// readTable reads a table block, returning the table name, the new table, its column names, in the order of the row values,
+// and the definitions of its indexes, which are built once the tables rows have been read.
+func (bk *blockReader) readTable() (string, *table, []string, []IndexDef, error) {
+	name := bk.getString()
+	tb := newTable(nil).(*table)
+	tb.nextID = Key(bk.getUint())
+	cols := make([]string, bk.getCount())
+	for i := range cols {
+		cols[i] = bk.getString()
+		ct, err := ParseColumnType(bk.getString())
+		if bk.err == nil && err != nil {
+			bk.err = err
+		}
+		cd := &ColumnDef{Type: ct}
+		if bk.version > binaryVersion1 {
+			flags := bk.getByte()
+			cd.NotNull = flags&columnNotNull != 0
+			cd.Unique = flags&columnUnique != 0
+			if flags&columnHasDefault != 0 {
+				dv := bk.getString()
+				cd.Default = &dv
+			}
+			cd.Check = bk.getString()
+			cd.PrimaryKey = flags&columnPrimaryKey != 0
+			if flags&columnReferences != 0 {
+				cd.References = &ForeignKey{
+					Table:    bk.getString(),
+					Column:   bk.getString(),
+					OnDelete: ReferentialAction(bk.getString()),
+				}
+			}
+		}
+		tb.columns[cols[i]] = column{}
+		tb.defs[cols[i]] = cd
+	}
+	defs := make([]IndexDef, bk.getCount())
+	for i := range defs {
+		defs[i].Name = bk.getString()
+		defs[i].Unique = bk.getByte() != 0
+		defs[i].Type = IndexType(bk.getString())
+		defs[i].Columns = make([]string, bk.getCount())
+		for ci := range defs[i].Columns {
+			defs[i].Columns[ci] = bk.getString()
+		}
+	}
+	if bk.err != nil {
+		return "", nil, nil, nil, bk.err
+	}
+	return name, tb, cols, defs, nil
+}
+
+// readRows reads a row block into the table.
+func (bk *blockReader) readRows(tb *table, cols []string) error {
+	count := bk.getCount()
+	for i := 0; i < count && bk.err == nil; i++ {
+		k := Key(bk.getUint())
+		tb.keys[k] = true
+		for _, cn := range cols {
+			if v, ok := bk.getValue(); ok {
+				tb.columns[cn][k] = v
+			}
+		}
+		if k >= tb.nextID {
+			tb.nextID = k + 1
+		}
+	}
+	return bk.err
+}
+
+// blockWriter builds each block in its buffer, writing it with its length and checksum when flushed.
+type blockWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// begin starts a new block of the given kind.
+func (bk *blockWriter) begin(kind byte) {
+	bk.buf.Reset()
+	bk.buf.WriteByte(kind)
+}
+
+func (bk *blockWriter) flush() error {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(bk.buf.Len()))
+	if _, err := bk.w.Write(n[:]); err != nil {
+		return err
+	}
+	if _, err := bk.w.Write(bk.buf.Bytes()); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(n[:], crc32.ChecksumIEEE(bk.buf.Bytes()))
+	_, err := bk.w.Write(n[:])
+	return err
+}
+
+func (bk *blockWriter) putUint(n uint64) {
+	putUint(&bk.buf, n)
+}
+
+func (bk *blockWriter) putString(s string) {
+	putUint(&bk.buf, uint64(len(s)))
+	bk.buf.WriteString(s)
+}
+
+func putUint(buf *bytes.Buffer, n uint64) {
+	var by [binary.MaxVarintLen64]byte
+	buf.Write(by[:binary.PutUvarint(by[:], n)])
+}
+
+// blockReader reads blocks, checking their checksums, and the values within the current block.
+// The first error reading a value is kept in err, and further reads return zero values, so a block is checked once it is read.
+type blockReader struct {
+	r       io.Reader
+	version byte
+	data    bytes.Reader
+	count   int
+	err     error
+}
+
+// next reads the next block, returning its kind.
+func (bk *blockReader) next() (byte, error) {
+	bk.count++
+	var n [4]byte
+	if _, err := io.ReadFull(bk.r, n[:]); err != nil {
+		return 0, bk.incomplete(err)
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size == 0 || size > maxBlockSize {
+		return 0, fmt.Errorf("block %d is corrupt, its length %d is invalid", bk.count, size)
+	}
+	by := make([]byte, size)
+	if _, err := io.ReadFull(bk.r, by); err != nil {
+		return 0, bk.incomplete(err)
+	}
+	if _, err := io.ReadFull(bk.r, n[:]); err != nil {
+		return 0, bk.incomplete(err)
+	}
+	if binary.BigEndian.Uint32(n[:]) != crc32.ChecksumIEEE(by) {
+		return 0, fmt.Errorf("block %d is corrupt, its checksum does not match", bk.count)
+	}
+	bk.data.Reset(by[1:])
+	bk.err = nil
+	return by[0], nil
+}
+
+func (bk *blockReader) incomplete(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("block %d is missing, the dump is incomplete", bk.count)
+	}
+	return err
+}
+
+func (bk *blockReader) fail() {
+	if bk.err == nil {
+		bk.err = fmt.Errorf("block %d is too short", bk.count)
+	}
+}
+
+func (bk *blockReader) getUint() uint64 {
+	if bk.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(&bk.data)
+	if err != nil {
+		bk.fail()
+	}
+	return n
+}
+
+// getCount reads a number of things, which can not be more than the remaining bytes of the block.
+func (bk *blockReader) getCount() int {
+	n := bk.getUint()
+	if n > uint64(bk.data.Len()) {
+		bk.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (bk *blockReader) getByte() byte {
+	if bk.err != nil {
+		return 0
+	}
+	b, err := bk.data.ReadByte()
+	if err != nil {
+		bk.fail()
+	}
+	return b
+}
+
+func (bk *blockReader) getString() string {
+	n := bk.getCount()
+	by := make([]byte, n)
+	if _, err := io.ReadFull(&bk.data, by); err != nil {
+		bk.fail()
+	}
+	return string(by)
+}
+
+// getValue reads a value, returning false for NULL.
+func (bk *blockReader) getValue() (string, bool) {
+	n := bk.getUint()
+	if n == 0 || bk.err != nil {
+		return "", false
+	}
+	if n-1 > uint64(bk.data.Len()) {
+		bk.fail()
+		return "", false
+	}
+	by := make([]byte, n-1)
+	_, _ = io.ReadFull(&bk.data, by)
+	return string(by), true
+}
@@ -0,0 +1,157 @@
+package minisql
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// binaryTestDB creates a database with a table of typed columns, NULL and empty values, an index and deleted rows.
+func binaryTestDB(t *testing.T) *MiniDB {
+	db := NewDatabase(Schema{
+		"t":     {"name": {Type: TEXT}, "qty": {Type: INTEGER}},
+		"empty": {"x": {Type: REAL}},
+	})
+	tb, _ := db.Table("t")
+	s := func(v string) *string { return &v }
+	for _, vals := range []Values{
+		{"name": s("one"), "qty": s("1")},
+		{"name": s(""), "qty": nil},
+		{"name": nil, "qty": s("3")},
+		{"name": s(strings.Repeat("x", blockSize)), "qty": s("4")},
+		{"name": s("gone")},
+	} {
+		if _, err := tb.Insert(vals); err != nil {
+			t.Fatalf("failed to insert  %s", err)
+		}
+	}
+	tb.Delete(4)
+	if err := tb.(Indexer).CreateIndex(IndexDef{Name: "byqty", Columns: []string{"qty"}, Unique: true, Type: HASH}); err != nil {
+		t.Fatalf("failed to create index  %s", err)
+	}
+	return db
+}
+
+func TestDumpBinary(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		db := binaryTestDB(t)
+		filename := filepath.Join(t.TempDir(), "db.bin")
+		if err := DumpBinary(filename, db, compress); err != nil {
+			t.Fatalf("failed to dump  %s", err)
+		}
+		restored := NewDatabase(nil)
+		if err := Restore(filename, restored); err != nil {
+			t.Fatalf("failed to restore binary dump  %s", err)
+		}
+		names := restored.TableNames()
+		sort.Strings(names)
+		if names := strings.Join(names, ","); names != "empty,t" {
+			t.Fatalf("unexpected tables restored %q", names)
+		}
+		tb, _ := restored.Table("t")
+		if cd, _ := tb.ColumnDef("qty"); cd.Type != INTEGER {
+			t.Fatalf("expected INTEGER column, found %s", cd)
+		}
+		if ixs := tb.(Indexer).Indexes(); len(ixs) != 1 || ixs[0].String() != "byqty UNIQUE HASH (qty)" {
+			t.Fatalf("unexpected indexes of restored table %v", ixs)
+		}
+		if tb.NextID() != 5 {
+			t.Fatalf("expected next id of 5, found %d", tb.NextID())
+		}
+		if tb.ContainsID(4) {
+			t.Fatalf("expected deleted row not to be restored")
+		}
+		for id, expect := range []string{"[one] [1]", "[] <NULL>", "<NULL> [3]", "[" + strings.Repeat("x", blockSize) + "] [4]"} {
+			vals, err := tb.(*table).SelectValues(Key(id), []string{"name", "qty"})
+			if err != nil {
+				t.Fatalf("failed to select row %d  %s", id, err)
+			}
+			if found := csvValues(vals); found != expect {
+				t.Fatalf("unexpected values of row %d, expected %.20q, found %.20q", id, expect, found)
+			}
+		}
+		// the index is rebuilt
+		if _, err := tb.Insert(Values{"qty": strPtr("3")}); err == nil {
+			t.Fatalf("expected restored unique index to refuse a duplicate")
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestDumpBinary_Corrupt(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		filename := filepath.Join(t.TempDir(), "db.bin")
+		if err := DumpBinary(filename, binaryTestDB(t), compress); err != nil {
+			t.Fatalf("failed to dump  %s", err)
+		}
+		by, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read dump  %s", err)
+		}
+		corrupt := filepath.Join(t.TempDir(), "corrupt.bin")
+		if !compress {
+			// a changed value is found by the checksum of its block
+			bad := append([]byte{}, by...)
+			i := strings.Index(string(bad), "one")
+			bad[i] = 'O'
+			if err = os.WriteFile(corrupt, bad, 0640); err != nil {
+				t.Fatalf("failed to write  %s", err)
+			}
+			if err = Restore(corrupt, NewDatabase(nil)); err == nil || !strings.Contains(err.Error(), "checksum") {
+				t.Fatalf("expected checksum error restoring changed dump, found %v", err)
+			}
+		}
+		if err = os.WriteFile(corrupt, by[:len(by)-10], 0640); err != nil {
+			t.Fatalf("failed to write  %s", err)
+		}
+		if err = Restore(corrupt, NewDatabase(nil)); err == nil {
+			t.Fatalf("expected error restoring a dump cut short")
+		}
+	}
+}
+
+func TestRestore_JSON(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "db.json")
+	if err := Dump(filename, binaryTestDB(t)); err != nil {
+		t.Fatalf("failed to dump  %s", err)
+	}
+	restored := NewDatabase(nil)
+	if err := Restore(filename, restored); err != nil {
+		t.Fatalf("failed to restore JSON dump  %s", err)
+	}
+	if v := selectName(t, restored, "t", 0); v == nil || *v != "one" {
+		t.Fatalf("unexpected value restored from JSON %v", v)
+	}
+}
+
+func TestOpenDatabase_JSONSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if err := Dump(filepath.Join(dir, jsonSnapshotFile), binaryTestDB(t)); err != nil {
+		t.Fatalf("failed to dump  %s", err)
+	}
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to open database with JSON snapshot  %s", err)
+	}
+	if v := selectName(t, db, "t", 0); v == nil || *v != "one" {
+		t.Fatalf("unexpected value loaded from JSON snapshot %v", v)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close  %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, jsonSnapshotFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected JSON snapshot to be replaced by the checkpoint")
+	}
+	if db, err = OpenDatabase(dir); err != nil {
+		t.Fatalf("failed to reopen database  %s", err)
+	}
+	defer func() { _ = db.Close() }()
+	if v := selectName(t, db, "t", 0); v == nil || *v != "one" {
+		t.Fatalf("unexpected value loaded from binary snapshot %v", v)
+	}
+}
@@ -2,7 +2,6 @@ package minisql
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 )
 
@@ -25,13 +24,6 @@ func (pc column) Insert(id Key, value string) error {
 	if _, ok := pc[id]; ok {
 		return fmt.Errorf("id %d already exists", id)
 	}
-	if strings.HasPrefix(value, "'") || strings.HasPrefix(value, "\"") {
-		v, err := strconv.Unquote(value)
-		if err != nil {
-			return err
-		}
-		value = v
-	}
 	pc[id] = value
 	return nil
 }
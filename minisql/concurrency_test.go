@@ -0,0 +1,100 @@
+package minisql
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestTable_Concurrent performs a mix of reads and writes on a single table at once.
+// Run with 'go test -race' to detect unsafe access.
+func TestTable_Concurrent(t *testing.T) {
+	tb := newTable(map[string]*ColumnDef{"n": {Type: INTEGER}, "s": {Type: TEXT}})
+	const workers = 8
+	const rows = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rows; i++ {
+				n := strconv.Itoa(i)
+				if _, err := tb.Insert(Values{"n": &n, "s": &n}); err != nil {
+					t.Errorf("insert failed  %v", err)
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rows; i++ {
+				last := tb.NextID()
+				for k := Key(0); k < last; k++ {
+					if !tb.ContainsID(k) {
+						continue
+					}
+					if _, err := tb.Select(k, []string{IDColumn, "n", "s"}); err != nil {
+						t.Errorf("select failed  %v", err)
+						return
+					}
+				}
+			}
+		}()
+		go func(w int) {
+			defer wg.Done()
+			s := "updated"
+			for i := 0; i < rows; i++ {
+				k := Key(i * workers)
+				if tb.ContainsID(k) {
+					_ = tb.Update(k, Values{"s": &s})
+				}
+				if i%10 == w {
+					tb.Delete(k + 1)
+				}
+				_ = tb.ColumnNames()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if tb.NextID() != workers*rows {
+		t.Fatalf("expected next id of %d, found %d", workers*rows, tb.NextID())
+	}
+}
+
+// TestMiniDB_Concurrent alters the database while tables are being read.
+func TestMiniDB_Concurrent(t *testing.T) {
+	db := NewDatabase(testSchema)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				tn := "t" + strconv.Itoa(w) + "_" + strconv.Itoa(i)
+				db.AlterDatabase(Schema{tn: {"c": {Type: TEXT}}})
+				db.AlterDatabase(Schema{tn: nil})
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				for _, tn := range db.TableNames() {
+					if _, err := db.Describe(tn); err != nil {
+						// table dropped since listed
+						continue
+					}
+				}
+				if !db.ContainsTable("t1") {
+					t.Errorf("expected table t1")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(db.TableNames()) != len(testSchema) {
+		t.Fatalf("expected %d tables, found %d", len(testSchema), len(db.TableNames()))
+	}
+}
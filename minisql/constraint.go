@@ -0,0 +1,161 @@
+package minisql
+
+import (
+	"fmt"
+	"sort"
+)
+
+// checkConstraints checks the given values of the row of the given key meet the NOT NULL and CHECK constraints of the columns.
+// A new row must be given the values of every column with a default.  The caller must hold the table lock.
+func (tb *table) checkConstraints(id Key, values Values) error {
+	insert := !tb.keys[id]
+	cns := make([]string, 0, len(tb.defs))
+	for cn, cd := range tb.defs {
+		if cd != nil && (cd.NotNull || cd.PrimaryKey || cd.Check != "") {
+			cns = append(cns, cn)
+		}
+	}
+	sort.Strings(cns)
+	for _, cn := range cns {
+		cd := tb.defs[cn]
+		if v, ok := values[cn]; (cd.NotNull || cd.PrimaryKey) && (ok || insert) && v == nil {
+			if cd.PrimaryKey {
+				return fmt.Errorf("PRIMARY KEY constraint of column %s failed, value is NULL", cn)
+			}
+			return fmt.Errorf("NOT NULL constraint of column %s failed, value is NULL", cn)
+		}
+	}
+	for _, cn := range cns {
+		cd := tb.defs[cn]
+		if cd.Check == "" {
+			continue
+		}
+		p, err := tb.check(cn)
+		if err != nil {
+			return err
+		}
+		row, changed := Values{}, insert
+		for _, c := range p.ColumnNames() {
+			v, ok := values[c]
+			changed = changed || ok
+			if !ok && !insert {
+				if cv, found := tb.columns[c][id]; found {
+					v = &cv
+				}
+			}
+			if v == nil {
+				// a NULL value is unknown, so can not fail the check
+				row = nil
+				break
+			}
+			row[c] = v
+		}
+		// rows which are unchanged have already passed
+		if row == nil || !changed {
+			continue
+		}
+		ok, err := p.Compare(row)
+		if err != nil {
+			return fmt.Errorf("CHECK constraint of column %s  %w", cn, err)
+		}
+		if !ok {
+			return fmt.Errorf("CHECK constraint of column %s failed, (%s) is false", cn, cd.Check)
+		}
+	}
+	return nil
+}
+
+// check gets the parsed CHECK constraint of the named column.  The caller must hold the table lock.
+func (tb *table) check(cn string) (Predicate, error) {
+	if p, ok := tb.checks[cn]; ok {
+		return p, nil
+	}
+	p, err := parsePredicate(tb.defs[cn].Check, func(c string) ColumnType {
+		if _, ok := tb.columns[c]; !ok {
+			return ""
+		}
+		return tb.columnType(c)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CHECK constraint of column %s  %w", cn, err)
+	}
+	for _, c := range p.ColumnNames() {
+		if _, ok := tb.columns[c]; !ok {
+			return nil, fmt.Errorf("CHECK constraint of column %s  %s is not a known column", cn, c)
+		}
+	}
+	if tb.checks == nil {
+		tb.checks = map[string]Predicate{}
+	}
+	tb.checks[cn] = p
+	return p, nil
+}
+
+// CheckReferences checks each value of a column with a foreign key, is a value of the column it references.
+// NULL values reference nothing, so are not checked.
+func CheckReferences(db Database, t Table, values Values) error {
+	for _, cn := range sortedValueNames(values) {
+		v := values[cn]
+		if v == nil {
+			continue
+		}
+		cd, err := t.ColumnDef(cn)
+		if err != nil || cd.References == nil {
+			continue
+		}
+		fk := cd.References
+		rt, err := db.Table(fk.Table)
+		if err != nil {
+			return fmt.Errorf("FOREIGN KEY constraint of column %s failed  %w", cn, err)
+		}
+		if len(FindKeys(rt, fk.Column, *v, 1)) == 0 {
+			return fmt.Errorf("FOREIGN KEY constraint of column %s failed, %s is not in %s(%s)",
+				cn, QuoteValue(*v), fk.Table, fk.Column)
+		}
+	}
+	return nil
+}
+
+// FindKeys finds the keys of the rows with the given value in the named column, up to the given limit, or all when negative.
+// The rows are found with an index of the column, when the table has one, otherwise the table is scanned.
+func FindKeys(t Table, column, value string, limit int) []Key {
+	if ix, ok := t.(Indexer); ok {
+		if keys, ok := ix.IndexKeys(KeyRange{
+			Column: column, Lower: &value, Upper: &value, LowerInclusive: true, UpperInclusive: true,
+		}); ok {
+			if limit >= 0 && len(keys) > limit {
+				keys = keys[:limit]
+			}
+			return keys
+		}
+	}
+	cd, err := t.ColumnDef(column)
+	if err != nil {
+		return nil
+	}
+	value, err = cd.Type.Convert(value)
+	if err != nil {
+		return nil
+	}
+	var keys []Key
+	last := t.NextID()
+	for k := Key(0); k < last && len(keys) != limit; k++ {
+		vals, err := t.Select(k, []string{column})
+		if err != nil || !t.ContainsID(k) {
+			continue
+		}
+		if v := vals[column]; v != nil && cd.Type.Compare(*v, value) == 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func sortedValueNames(values Values) []string {
+	names := make([]string, 0, len(values))
+	for n := range values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,142 @@
+package minisql
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func constraintTestTable() *table {
+	status := "new"
+	return newTable(map[string]*ColumnDef{
+		"email":  {Type: TEXT, NotNull: true, Unique: true},
+		"status": {Type: TEXT, Default: &status},
+		"qty":    {Type: INTEGER},
+	}).(*table)
+}
+
+func TestTable_Constraints(t *testing.T) {
+	tb := constraintTestTable()
+	s := func(v string) *string { return &v }
+	id, err := tb.Insert(Values{"email": s("a@b.c")})
+	if err != nil {
+		t.Fatalf("failed to insert  %s", err)
+	}
+	vals, _ := tb.Select(id, []string{"status", "qty"})
+	if vals["status"] == nil || *vals["status"] != "new" || vals["qty"] != nil {
+		t.Fatalf("expected default status and NULL qty, found %v", vals)
+	}
+	if _, err = tb.Insert(Values{"status": s("old")}); err == nil ||
+		err.Error() != "NOT NULL constraint of column email failed, value is NULL" {
+		t.Fatalf("expected NOT NULL error, found %v", err)
+	}
+	if _, err = tb.Insert(Values{"email": s("a@b.c")}); err == nil || !strings.HasPrefix(err.Error(), "UNIQUE constraint of column email failed") {
+		t.Fatalf("expected UNIQUE error, found %v", err)
+	}
+	id2, err := tb.Insert(Values{"email": s("x@y.z"), "status": nil})
+	if err != nil {
+		t.Fatalf("failed to insert  %s", err)
+	}
+	if vals, _ = tb.Select(id2, []string{"status"}); vals["status"] != nil {
+		t.Fatalf("expected NULL status when given NULL, found %s", *vals["status"])
+	}
+	if err = tb.Update(id2, Values{"email": s("a@b.c")}); err == nil || !strings.HasPrefix(err.Error(), "UNIQUE constraint") {
+		t.Fatalf("expected UNIQUE error updating, found %v", err)
+	}
+	if err = tb.Update(id2, Values{"email": nil}); err == nil || !strings.HasPrefix(err.Error(), "NOT NULL constraint") {
+		t.Fatalf("expected NOT NULL error updating, found %v", err)
+	}
+	if err = tb.Update(id2, Values{"qty": nil, "email": s("x@y.z")}); err != nil {
+		t.Fatalf("failed to update row to its own unique value  %s", err)
+	}
+	if ixs := tb.Indexes(); len(ixs) != 0 {
+		t.Fatalf("expected the UNIQUE index to be hidden, found %v", ixs)
+	}
+	if err = tb.DropIndex("email UNIQUE"); err == nil {
+		t.Fatalf("expected UNIQUE constraint index not to be dropped")
+	}
+
+	def := "1"
+	tb.AlterColumns(map[string]*ColumnDef{"flag": {Type: INTEGER, NotNull: true, Default: &def}})
+	for _, k := range []Key{id, id2} {
+		if vals, _ = tb.Select(k, []string{"flag"}); vals["flag"] == nil || *vals["flag"] != "1" {
+			t.Fatalf("expected existing row %d to have the new columns default", k)
+		}
+	}
+	if cd, _ := tb.ColumnDef("email"); cd.String() != "TEXT NOT NULL UNIQUE" {
+		t.Fatalf("unexpected column definition %q", cd)
+	}
+}
+
+func TestTable_ConstraintsPersist(t *testing.T) {
+	tb := constraintTestTable()
+	by, err := json.Marshal(tb)
+	if err != nil {
+		t.Fatalf("failed to marshal table  %s", err)
+	}
+	jtb := &table{}
+	if err = json.Unmarshal(by, jtb); err != nil {
+		t.Fatalf("failed to unmarshal table  %s", err)
+	}
+
+	db := NewDatabase(nil)
+	db.tables["t"] = constraintTestTable()
+	filename := filepath.Join(t.TempDir(), "db.bin")
+	if err = DumpBinary(filename, db, false); err != nil {
+		t.Fatalf("failed to dump  %s", err)
+	}
+	restored := NewDatabase(nil)
+	if err = Restore(filename, restored); err != nil {
+		t.Fatalf("failed to restore  %s", err)
+	}
+	btb, _ := restored.Table("t")
+
+	for _, rt := range []Table{jtb, btb} {
+		for cn, expect := range map[string]string{"email": "TEXT NOT NULL UNIQUE", "status": "TEXT DEFAULT 'new'", "qty": "INTEGER"} {
+			if cd, _ := rt.ColumnDef(cn); cd.String() != expect {
+				t.Fatalf("expected column %s to be restored as %q, found %q", cn, expect, cd)
+			}
+		}
+		s := "a"
+		if _, err = rt.Insert(Values{"email": &s}); err != nil {
+			t.Fatalf("failed to insert into restored table  %s", err)
+		}
+		if _, err = rt.Insert(Values{"email": &s}); err == nil {
+			t.Fatalf("expected restored table to keep its UNIQUE constraint")
+		}
+	}
+}
+
+func TestTable_PrimaryKey(t *testing.T) {
+	tb := newTable(map[string]*ColumnDef{
+		"a":    {Type: INTEGER, PrimaryKey: true},
+		"b":    {Type: TEXT, PrimaryKey: true},
+		"note": {Type: TEXT},
+	}).(*table)
+	s := func(v string) *string { return &v }
+	if _, err := tb.Insert(Values{"a": s("1"), "b": s("x")}); err != nil {
+		t.Fatalf("failed to insert  %s", err)
+	}
+	id, err := tb.Insert(Values{"a": s("1"), "b": s("y")})
+	if err != nil {
+		t.Fatalf("failed to insert row with a different composite key  %s", err)
+	}
+	if _, err = tb.Insert(Values{"a": s("01"), "b": s("x")}); err == nil ||
+		err.Error() != "PRIMARY KEY constraint of columns a, b failed, '1', 'x' is already in another row" {
+		t.Fatalf("expected PRIMARY KEY error, found %v", err)
+	}
+	if _, err = tb.Insert(Values{"a": s("2")}); err == nil ||
+		err.Error() != "PRIMARY KEY constraint of column b failed, value is NULL" {
+		t.Fatalf("expected PRIMARY KEY NULL error, found %v", err)
+	}
+	if err = tb.Update(id, Values{"b": s("x")}); err == nil || !strings.HasPrefix(err.Error(), "PRIMARY KEY constraint") {
+		t.Fatalf("expected PRIMARY KEY error updating, found %v", err)
+	}
+	if keys, ok := tb.IndexKeys(KeyRange{Column: "a", Lower: s("1"), Upper: s("1"), LowerInclusive: true, UpperInclusive: true}); ok {
+		t.Fatalf("expected composite key index not to find a single column, found %v", keys)
+	}
+	if cd, _ := tb.ColumnDef("a"); cd.String() != "INTEGER PRIMARY KEY" {
+		t.Fatalf("unexpected column definition %q", cd)
+	}
+}
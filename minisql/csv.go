@@ -0,0 +1,340 @@
+package minisql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVOptions are how the values of a table are read from, or written to, CSV.
+type CSVOptions struct {
+	// Header is a first line of the column names.
+	Header bool
+	// Delimiter separates the values of a line.  When zero, values are separated with a comma.
+	Delimiter rune
+	// Null is the text of a NULL value.  An unquoted value matching it is read as NULL, while a quoted value never is,
+	// so with the default, empty, Null, an empty string is written as "" and NULL as nothing.
+	Null string
+}
+
+func (o CSVOptions) delimiter() rune {
+	if o.Delimiter == 0 {
+		return ','
+	}
+	return o.Delimiter
+}
+
+func (o CSVOptions) validate() error {
+	d := o.delimiter()
+	if d == '"' || d == '\r' || d == '\n' || d == utf8.RuneError {
+		return fmt.Errorf("%q can not be used as a delimiter", d)
+	}
+	return nil
+}
+
+// ImportCSV inserts the rows read from the CSV into the named table, returning the number of rows inserted.
+// When the CSV has a header, its column names are the columns of the values, and a table which does not exist is created
+// with those columns.  Without a header, the values are the columns of the existing table, in the order of their names.
+// The rows are read and inserted one at a time, so the CSV may be of any size.
+// Like an INSERT, the values of columns with a foreign key must be values of the column they reference.
+// If any row fails, the rows already inserted are removed, as is a table created for them.
+func ImportCSV(db Database, tablename string, r io.Reader, opts CSVOptions) (int, error) {
+	if err := opts.validate(); err != nil {
+		return 0, err
+	}
+	cr := newCSVReader(r, opts)
+	var columns []string
+	if opts.Header {
+		header, err := cr.Read()
+		if err == io.EOF {
+			return 0, fmt.Errorf("missing header line")
+		}
+		if err != nil {
+			return 0, err
+		}
+		for i, v := range header {
+			if v == nil || *v == "" {
+				return 0, fmt.Errorf("missing column name %d in header", i+1)
+			}
+			columns = append(columns, *v)
+		}
+	}
+
+	var created bool
+	if !db.ContainsTable(tablename) {
+		if columns == nil {
+			return 0, fmt.Errorf("%w, a header is required to create it", UnknownTableError(tablename))
+		}
+		cols := map[string]*ColumnDef{}
+		for _, c := range columns {
+			if c == IDColumn {
+				continue
+			}
+			if _, ok := cols[c]; ok {
+				return 0, fmt.Errorf("column %s appears more than once in header", c)
+			}
+			cols[c] = &ColumnDef{Type: TEXT}
+		}
+		db.AlterDatabase(Schema{tablename: cols})
+		created = true
+	}
+	t, err := db.Table(tablename)
+	if err != nil {
+		return 0, err
+	}
+	if columns == nil {
+		columns = t.ColumnNames()
+	}
+
+	var inserted []Key
+	err = func() error {
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(rec) != len(columns) {
+				return fmt.Errorf("line %d has %d values, expected %d", cr.line, len(rec), len(columns))
+			}
+			vals := Values{}
+			for i, c := range columns {
+				if c != IDColumn {
+					vals[c] = rec[i]
+				}
+			}
+			if err := CheckReferences(db, t, vals); err != nil {
+				return fmt.Errorf("line %d  %w", cr.line, err)
+			}
+			k, err := t.Insert(vals)
+			if err != nil {
+				return fmt.Errorf("line %d  %w", cr.line, err)
+			}
+			inserted = append(inserted, k)
+		}
+	}()
+	if err != nil {
+		if created {
+			db.AlterDatabase(Schema{tablename: {}})
+		} else {
+			t.Delete(inserted...)
+		}
+		return 0, err
+	}
+	return len(inserted), nil
+}
+
+// ExportCSV writes the rows as CSV, returning the number of rows written.
+// The rows are written as they are read, so there may be any number of them.
+// The header is of the columns of the first row, so is only written when there are rows.
+func ExportCSV(w io.Writer, rows *Rows, opts CSVOptions) (int, error) {
+	if err := opts.validate(); err != nil {
+		return 0, err
+	}
+	cw := newCSVWriter(w, opts)
+	var count int
+	var columns []string
+	for rows.Next() {
+		if columns == nil {
+			columns = rows.Columns()
+			if opts.Header {
+				if err := cw.WriteHeader(columns); err != nil {
+					return count, err
+				}
+			}
+		}
+		if err := cw.Write(rows.Values()); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, cw.Flush()
+}
+
+const byteOrderMark = "\xef\xbb\xbf"
+
+// csvReader reads the lines of a CSV, one at a time.
+// Values may be quoted with double quotes, so they may contain the delimiter, line ends and quotes, doubled.
+type csvReader struct {
+	r     *bufio.Reader
+	comma rune
+	null  string
+	// line is the line number the last record read began on.
+	line int
+	next int
+}
+
+// newCSVReader creates a reader of the CSV, skipping any byte order mark, as written by some spreadsheets.
+func newCSVReader(r io.Reader, opts CSVOptions) *csvReader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(byteOrderMark)); err == nil && string(b) == byteOrderMark {
+		_, _ = br.Discard(len(byteOrderMark))
+	}
+	return &csvReader{r: br, comma: opts.delimiter(), null: opts.Null, next: 1}
+}
+
+// Read reads the values of the next line.  Unquoted values matching the null text are nil.
+// Returns io.EOF when there are no more lines.
+func (cr *csvReader) Read() ([]*string, error) {
+	cr.line = cr.next
+	if _, err := cr.r.Peek(1); err != nil {
+		return nil, err
+	}
+	var rec []*string
+	for {
+		v, end, err := cr.readValue()
+		if err != nil {
+			return nil, err
+		}
+		rec = append(rec, v)
+		if end {
+			return rec, nil
+		}
+	}
+}
+
+// readValue reads a single value, returning true when it is the last value of the line.
+func (cr *csvReader) readValue() (*string, bool, error) {
+	buf := &strings.Builder{}
+	r, _, err := cr.r.ReadRune()
+	if err == io.EOF {
+		return cr.unquoted(buf.String()), true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if r != '"' {
+		// unquoted value, up to the delimiter or line end
+		for {
+			switch {
+			case r == cr.comma:
+				return cr.unquoted(buf.String()), false, nil
+			case r == '\n' || r == '\r':
+				return cr.unquoted(buf.String()), true, cr.endLine(r)
+			}
+			buf.WriteRune(r)
+			if r, _, err = cr.r.ReadRune(); err == io.EOF {
+				return cr.unquoted(buf.String()), true, nil
+			} else if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	// quoted value, up to the closing quote, which must be followed by the delimiter or line end
+	for {
+		if r, _, err = cr.r.ReadRune(); err == io.EOF {
+			return nil, false, fmt.Errorf("line %d: unclosed quote", cr.line)
+		} else if err != nil {
+			return nil, false, err
+		}
+		if r == '\n' {
+			cr.next++
+		}
+		if r != '"' {
+			buf.WriteRune(r)
+			continue
+		}
+		r, _, err = cr.r.ReadRune()
+		v := buf.String()
+		switch {
+		case err == io.EOF:
+			return &v, true, nil
+		case err != nil:
+			return nil, false, err
+		case r == '"':
+			buf.WriteRune(r)
+		case r == cr.comma:
+			return &v, false, nil
+		case r == '\n' || r == '\r':
+			return &v, true, cr.endLine(r)
+		default:
+			return nil, false, fmt.Errorf("line %d: unexpected %q after closing quote", cr.line, r)
+		}
+	}
+}
+
+// unquoted gets an unquoted value, which is nil when it matches the null text.
+func (cr *csvReader) unquoted(v string) *string {
+	if v == cr.null {
+		return nil
+	}
+	return &v
+}
+
+// endLine reads the end of a line, a line feed, or a carriage return, optionally followed by a line feed.
+func (cr *csvReader) endLine(r rune) error {
+	cr.next++
+	if r != '\r' {
+		return nil
+	}
+	n, _, err := cr.r.ReadRune()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n != '\n' {
+		return cr.r.UnreadRune()
+	}
+	return nil
+}
+
+// csvWriter writes lines of CSV.  Values are quoted when they contain the delimiter, quotes or line ends,
+// and when they would otherwise be read as NULL.
+type csvWriter struct {
+	w     *bufio.Writer
+	comma rune
+	null  string
+}
+
+func newCSVWriter(w io.Writer, opts CSVOptions) *csvWriter {
+	return &csvWriter{w: bufio.NewWriter(w), comma: opts.delimiter(), null: opts.Null}
+}
+
+// WriteHeader writes a line of the column names, which are never NULL.
+func (cw *csvWriter) WriteHeader(columns []string) error {
+	rec := make([]*string, len(columns))
+	for i := range columns {
+		rec[i] = &columns[i]
+	}
+	return cw.Write(rec)
+}
+
+// Write writes a line of the values.
+func (cw *csvWriter) Write(rec []*string) error {
+	for i, v := range rec {
+		if i > 0 {
+			if _, err := cw.w.WriteRune(cw.comma); err != nil {
+				return err
+			}
+		}
+		s := cw.null
+		if v != nil {
+			s = *v
+			if cw.needsQuotes(s) {
+				s = `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+			}
+		}
+		if _, err := cw.w.WriteString(s); err != nil {
+			return err
+		}
+	}
+	_, err := cw.w.WriteString("\n")
+	return err
+}
+
+func (cw *csvWriter) needsQuotes(s string) bool {
+	return s == cw.null || strings.ContainsRune(s, cw.comma) || strings.ContainsAny(s, "\"\r\n")
+}
+
+func (cw *csvWriter) Flush() error {
+	return cw.w.Flush()
+}
@@ -0,0 +1,168 @@
+package minisql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func csvValues(rec []*string) string {
+	vals := make([]string, len(rec))
+	for i, v := range rec {
+		if v == nil {
+			vals[i] = "<NULL>"
+		} else {
+			vals[i] = "[" + *v + "]"
+		}
+	}
+	return strings.Join(vals, " ")
+}
+
+func TestCSVReader(t *testing.T) {
+	src := "\xef\xbb\xbfa,b,c\r\n" +
+		"1,,\"\"\n" +
+		"\"x, y\",\"say \"\"hi\"\"\",\"two\nlines\"\n" +
+		" s ,NULL,\"NULL\""
+	cr := newCSVReader(strings.NewReader(src), CSVOptions{})
+	expect := []string{
+		"[a] [b] [c]",
+		"[1] <NULL> []",
+		"[x, y] [say \"hi\"] [two\nlines]",
+		"[ s ] [NULL] [NULL]",
+	}
+	lines := []int{1, 2, 3, 5}
+	for i, e := range expect {
+		rec, err := cr.Read()
+		if err != nil {
+			t.Fatalf("failed to read line %d  %s", i+1, err)
+		}
+		if found := csvValues(rec); found != e {
+			t.Fatalf("unexpected values, expected %q, found %q", e, found)
+		}
+		if cr.line != lines[i] {
+			t.Fatalf("expected record to begin on line %d, found %d", lines[i], cr.line)
+		}
+	}
+	if _, err := cr.Read(); err == nil {
+		t.Fatalf("expected EOF after the last line")
+	}
+
+	cr = newCSVReader(strings.NewReader("a;NULL;\n"), CSVOptions{Delimiter: ';', Null: "NULL"})
+	if rec, err := cr.Read(); err != nil || csvValues(rec) != "[a] <NULL> []" {
+		t.Fatalf("unexpected values with delimiter and null text %q  %v", csvValues(rec), err)
+	}
+
+	for _, bad := range []string{"\"abc", "\"abc\"x,1"} {
+		if _, err := newCSVReader(strings.NewReader(bad), CSVOptions{}).Read(); err == nil {
+			t.Fatalf("expected error reading %q", bad)
+		}
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cw := newCSVWriter(buf, CSVOptions{})
+	s := func(v string) *string { return &v }
+	if err := cw.WriteHeader([]string{"a", "b"}); err != nil {
+		t.Fatalf("failed to write header  %s", err)
+	}
+	for _, rec := range [][]*string{
+		{s("1"), nil},
+		{s(""), s("x,y")},
+		{s("say \"hi\""), s("two\nlines")},
+	} {
+		if err := cw.Write(rec); err != nil {
+			t.Fatalf("failed to write  %s", err)
+		}
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("failed to flush  %s", err)
+	}
+	expect := "a,b\n1,\n\"\",\"x,y\"\n\"say \"\"hi\"\"\",\"two\nlines\"\n"
+	if buf.String() != expect {
+		t.Fatalf("unexpected CSV, expected %q, found %q", expect, buf.String())
+	}
+
+	// what is written is read back the same
+	cr := newCSVReader(strings.NewReader(buf.String()), CSVOptions{})
+	var found []string
+	for {
+		rec, err := cr.Read()
+		if err != nil {
+			break
+		}
+		found = append(found, csvValues(rec))
+	}
+	if strings.Join(found, "|") != "[a] [b]|[1] <NULL>|[] [x,y]|[say \"hi\"] [two\nlines]" {
+		t.Fatalf("unexpected values read back %q", found)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	db := NewDatabase(Schema{"t": {"a": {Type: TEXT}, "b": {Type: INTEGER}}})
+	n, err := ImportCSV(db, "new", strings.NewReader("x,y\n1,\n2,\"\"\n"), CSVOptions{Header: true})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 rows imported into a new table, found %d  %v", n, err)
+	}
+	nt, err := db.Table("new")
+	if err != nil {
+		t.Fatalf("expected new table to be created  %s", err)
+	}
+	if cols := strings.Join(nt.ColumnNames(), ","); cols != "x,y" {
+		t.Fatalf("unexpected columns of new table %q", cols)
+	}
+	vals, _ := nt.Select(1, []string{"y"})
+	if vals["y"] == nil || *vals["y"] != "" {
+		t.Fatalf("expected quoted empty value to be an empty string, found %v", vals["y"])
+	}
+
+	// without a header, values are the columns of the table, in name order
+	if n, err = ImportCSV(db, "t", strings.NewReader("one,1\ntwo,2\n"), CSVOptions{}); err != nil || n != 2 {
+		t.Fatalf("expected 2 rows imported, found %d  %v", n, err)
+	}
+	tb, _ := db.Table("t")
+	// a failing row removes the rows already inserted
+	if _, err = ImportCSV(db, "t", strings.NewReader("b,a\n3,three\nfour,four\n"), CSVOptions{Header: true}); err == nil {
+		t.Fatalf("expected error importing invalid integer")
+	} else if !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected error on line 3, found %s", err)
+	}
+	var count int
+	for k := Key(0); k < tb.NextID(); k++ {
+		if tb.ContainsID(k) {
+			count++
+		}
+	}
+	if count != 2 {
+		t.Fatalf("expected failed import to leave 2 rows, found %d", count)
+	}
+	// and a table created for them
+	if _, err = ImportCSV(db, "bad", strings.NewReader("a,b\n1\n"), CSVOptions{Header: true}); err == nil {
+		t.Fatalf("expected error importing a short line")
+	}
+	if db.ContainsTable("bad") {
+		t.Fatalf("expected table created by failed import to be removed")
+	}
+	if _, err = ImportCSV(db, "missing", strings.NewReader("1,2\n"), CSVOptions{}); err == nil {
+		t.Fatalf("expected error importing into a missing table without a header")
+	}
+}
+
+func TestImportCSV_ForeignKey(t *testing.T) {
+	db := NewDatabase(Schema{
+		"par": {"id": {Type: INTEGER, Unique: true}},
+		"ch":  {"pid": {Type: INTEGER, References: &ForeignKey{Table: "par", Column: "id"}}},
+	})
+	pt, _ := db.Table("par")
+	one := "1"
+	if _, err := pt.Insert(Values{"id": &one}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	_, err := ImportCSV(db, "ch", strings.NewReader("pid\n1\n\n99\n"), CSVOptions{Header: true})
+	if err == nil || !strings.Contains(err.Error(), "line 4") || !strings.Contains(err.Error(), "FOREIGN KEY constraint of column pid failed") {
+		t.Fatalf("expected foreign key error on line 4, found %v", err)
+	}
+	if ct, _ := db.Table("ch"); ct.ContainsID(0) || ct.ContainsID(1) {
+		t.Fatalf("expected failed import to leave no rows")
+	}
+}
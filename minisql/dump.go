@@ -1,25 +1,25 @@
 package minisql
 
 import (
+	"bufio"
 	"encoding/json"
 	"io"
 	"log"
 	"os"
 )
 
+// Dump writes the whole database to the given file.  The dump is written to a temporary file, which then replaces
+// any existing file, so an existing dump is never left partly written.
 func Dump(filename string, tdb *MiniDB) error {
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
-	if err != nil {
-		return err
-	}
-	defer func(f io.WriteCloser) {
-		if err := f.Close(); err != nil {
-			log.Println(err)
-		}
-	}(f)
-	return json.NewEncoder(f).Encode(&tdb.tables)
+	tdb.lock.RLock()
+	defer tdb.lock.RUnlock()
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(&tdb.tables)
+	})
 }
 
+// Restore loads the tables in the given dump file into the database, replacing any tables of the same name.
+// The dump may be written by Dump or DumpBinary, its format being found from its contents.
 func Restore(filename string, tdb *MiniDB) error {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -31,12 +31,37 @@ func Restore(filename string, tdb *MiniDB) error {
 		}
 	}(f)
 
-	tables := map[string]*table{}
-	if err := json.NewDecoder(f).Decode(&tables); err != nil {
+	tables, err := readDump(bufio.NewReader(f))
+	if err != nil {
 		return err
 	}
+	tdb.lock.Lock()
 	for k, t := range tables {
+		if old, ok := tdb.tables[k].(*table); ok {
+			old.attach("", nil)
+		}
+		if tdb.log != nil {
+			t.attach(k, tdb.log)
+		}
 		tdb.tables[k] = t
 	}
+	durable := tdb.log != nil
+	tdb.lock.Unlock()
+	if durable {
+		// the restored tables are not in the log, so they are kept in a new snapshot
+		return tdb.Checkpoint()
+	}
 	return nil
 }
+
+// readDump reads the tables of a dump, in the binary format, or in JSON.
+func readDump(r *bufio.Reader) (map[string]*table, error) {
+	if isBinary(r) {
+		return readBinary(r)
+	}
+	tables := map[string]*table{}
+	if err := json.NewDecoder(r).Decode(&tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
@@ -0,0 +1,360 @@
+package minisql
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IndexType is the structure an index uses to find its rows.
+type IndexType string
+
+const (
+	// HASH indexes find the rows with values equal to a given value.
+	HASH IndexType = "HASH"
+	// ORDERED indexes keep their values in order, to find rows with equal values, or values within a range.
+	ORDERED IndexType = "ORDERED"
+)
+
+// indexTypeAliases maps alternative index type names onto the supported types.
+var indexTypeAliases = map[string]IndexType{
+	"HASH":     HASH,
+	"ORDERED":  ORDERED,
+	"BTREE":    ORDERED,
+	"SKIPLIST": ORDERED,
+}
+
+// ParseIndexType parses the given index type name.  An empty name results in an ORDERED index.
+func ParseIndexType(s string) (IndexType, error) {
+	if s == "" {
+		return ORDERED, nil
+	}
+	it, ok := indexTypeAliases[strings.ToUpper(s)]
+	if !ok {
+		return "", fmt.Errorf("%q is not a known index type", s)
+	}
+	return it, nil
+}
+
+// IndexDef defines an index on one or more columns of a table.
+type IndexDef struct {
+	Name    string    `json:"name"`
+	Columns []string  `json:"columns"`
+	Unique  bool      `json:"unique,omitempty"`
+	Type    IndexType `json:"type"`
+}
+
+func (d IndexDef) String() string {
+	s := []string{d.Name}
+	if d.Unique {
+		s = append(s, "UNIQUE")
+	}
+	s = append(s, string(d.Type), fmt.Sprintf("(%s)", strings.Join(d.Columns, ", ")))
+	return strings.Join(s, " ")
+}
+
+// Indexer is implemented by a Table which can hold indexes of its rows.
+type Indexer interface {
+	// CreateIndex builds a new index of the tables rows.  A UNIQUE index fails when existing rows share the same values.
+	CreateIndex(def IndexDef) error
+	// DropIndex removes the named index.
+	DropIndex(name string) error
+	// Indexes gets the definitions of the tables indexes, in name order.
+	Indexes() []IndexDef
+	// IndexKeys finds the keys, in key order, of the rows with a column value within the given range, using an index of that column.
+	// Returns false when the table has no index able to find the range.
+	IndexKeys(r KeyRange) ([]Key, bool)
+}
+
+// KeyRange is a range of values of a column, to find in an index.
+type KeyRange struct {
+	Column string
+	// Lower and Upper bound the values found, a nil bound being unbounded.  For equality, both bounds are the same inclusive value.
+	Lower, Upper                   *string
+	LowerInclusive, UpperInclusive bool
+	// Prefix, when not empty, finds the TEXT values beginning with the prefix.
+	Prefix string
+	// Nulls includes the rows where the column is NULL.
+	Nulls bool
+}
+
+// isEqual checks if the range is a single value.
+func (r KeyRange) isEqual(ct ColumnType) bool {
+	return r.Lower != nil && r.Upper != nil && r.LowerInclusive && r.UpperInclusive &&
+		r.Prefix == "" && !r.Nulls && ct.Compare(*r.Lower, *r.Upper) == 0
+}
+
+// index is an index of the values in one or more columns of a table, held as either a hash map or an ordered skip list.
+type index struct {
+	def   IndexDef
+	types []ColumnType
+	hash  map[string]map[Key]bool
+	list  *skipList
+	// constraint is UNIQUE or PRIMARY KEY for the index held for the constraint of that name,
+	// or empty for an index created by CREATE INDEX.
+	constraint string
+}
+
+// add adds the row of the given key, with the given column values, to the index.
+func (ix *index) add(vals []*string, id Key) {
+	if ix.list != nil {
+		ix.list.insert(vals, id)
+		return
+	}
+	hk := ix.hashKey(vals)
+	ks, ok := ix.hash[hk]
+	if !ok {
+		ks = map[Key]bool{}
+		ix.hash[hk] = ks
+	}
+	ks[id] = true
+}
+
+// remove removes the row of the given key, with the given column values, from the index.
+func (ix *index) remove(vals []*string, id Key) {
+	if ix.list != nil {
+		ix.list.remove(vals, id)
+		return
+	}
+	hk := ix.hashKey(vals)
+	delete(ix.hash[hk], id)
+	if len(ix.hash[hk]) == 0 {
+		delete(ix.hash, hk)
+	}
+}
+
+// find gets the keys of the rows with values equal to all of the given values.
+func (ix *index) find(vals []*string) []Key {
+	var keys []Key
+	if ix.list != nil {
+		n := ix.list.seek(func(n *skipNode) bool {
+			return ix.list.compareValues(n.vals, vals) < 0
+		})
+		for ; n != nil && ix.list.compareValues(n.vals, vals) == 0; n = n.next[0] {
+			keys = append(keys, n.id)
+		}
+		return keys
+	}
+	for k := range ix.hash[ix.hashKey(vals)] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// conflicts checks if adding the given values for the given key would break a unique index.
+// Rows with any NULL value never conflict.
+func (ix *index) conflicts(vals []*string, id Key) bool {
+	if !ix.def.Unique {
+		return false
+	}
+	for _, v := range vals {
+		if v == nil {
+			return false
+		}
+	}
+	for _, k := range ix.find(vals) {
+		if k != id {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictError gets the error of the given values breaking the unique index.
+func (ix *index) conflictError(vals []*string) error {
+	if ix.constraint == "" {
+		return fmt.Errorf("duplicate value in unique index %s", ix.def.Name)
+	}
+	vs := make([]string, len(vals))
+	for i, v := range vals {
+		vs[i] = "NULL"
+		if v != nil {
+			vs[i] = QuoteValue(*v)
+		}
+	}
+	col := "column"
+	if len(ix.def.Columns) > 1 {
+		col = "columns"
+	}
+	return fmt.Errorf("%s constraint of %s %s failed, %s is already in another row",
+		ix.constraint, col, strings.Join(ix.def.Columns, ", "), strings.Join(vs, ", "))
+}
+
+// scan gets the keys of the rows with a value in the first column of the index within the given range.
+func (ix *index) scan(r KeyRange) []Key {
+	if ix.list == nil {
+		if !r.isEqual(ix.types[0]) || len(ix.def.Columns) != 1 {
+			return nil
+		}
+		return ix.find([]*string{r.Lower})
+	}
+	ct := ix.types[0]
+	var n *skipNode
+	switch {
+	case r.Lower == nil && r.Nulls:
+		n = ix.list.head.next[0]
+	case r.Lower == nil:
+		n = ix.list.seek(func(n *skipNode) bool {
+			return n.vals[0] == nil
+		})
+	default:
+		n = ix.list.seek(func(n *skipNode) bool {
+			c := ct.CompareValues(n.vals[0], r.Lower)
+			return c < 0 || (c == 0 && !r.LowerInclusive)
+		})
+	}
+	var keys []Key
+	for ; n != nil; n = n.next[0] {
+		v := n.vals[0]
+		if v == nil {
+			keys = append(keys, n.id)
+			continue
+		}
+		if r.Prefix != "" && !strings.HasPrefix(*v, r.Prefix) {
+			break
+		}
+		if r.Upper != nil {
+			c := ct.Compare(*v, *r.Upper)
+			if c > 0 || (c == 0 && !r.UpperInclusive) {
+				break
+			}
+		}
+		keys = append(keys, n.id)
+	}
+	return keys
+}
+
+// hashKey encodes the given values into a single key of the hash map.
+func (ix *index) hashKey(vals []*string) string {
+	buf := strings.Builder{}
+	for i, v := range vals {
+		if v == nil {
+			buf.WriteString("-")
+			continue
+		}
+		s := *v
+		if cv, err := ix.types[i].Convert(s); err == nil {
+			s = cv
+		}
+		buf.WriteString(strconv.Itoa(len(s)))
+		buf.WriteString(":")
+		buf.WriteString(s)
+	}
+	return buf.String()
+}
+
+func newIndex(def IndexDef, types []ColumnType) *index {
+	ix := &index{def: def, types: types}
+	if def.Type == HASH {
+		ix.hash = map[string]map[Key]bool{}
+	} else {
+		ix.list = newSkipList(types)
+	}
+	return ix
+}
+
+// maxSkipLevel is the most levels a skip list node may have, enough for many millions of rows.
+const maxSkipLevel = 24
+
+// skipList is an ordered list of index entries, ordered by their values and then their key.
+type skipList struct {
+	head  *skipNode
+	level int
+	types []ColumnType
+	rnd   *rand.Rand
+}
+
+type skipNode struct {
+	vals []*string
+	id   Key
+	next []*skipNode
+}
+
+// compareValues compares two entries values, column by column.
+func (sl *skipList) compareValues(v1, v2 []*string) int {
+	for i := range v1 {
+		if c := sl.types[i].CompareValues(v1[i], v2[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (sl *skipList) compare(n *skipNode, vals []*string, id Key) int {
+	if c := sl.compareValues(n.vals, vals); c != 0 {
+		return c
+	}
+	return compareOrdered(n.id < id, n.id == id)
+}
+
+// predecessors finds, at every level, the last node for which less is true.
+func (sl *skipList) predecessors(less func(n *skipNode) bool) []*skipNode {
+	preds := make([]*skipNode, maxSkipLevel)
+	n := sl.head
+	for lvl := sl.level - 1; lvl >= 0; lvl-- {
+		for n.next[lvl] != nil && less(n.next[lvl]) {
+			n = n.next[lvl]
+		}
+		preds[lvl] = n
+	}
+	return preds
+}
+
+// seek finds the first node for which less is false.  less must be true for all the nodes before that node.
+func (sl *skipList) seek(less func(n *skipNode) bool) *skipNode {
+	return sl.predecessors(less)[0].next[0]
+}
+
+func (sl *skipList) insert(vals []*string, id Key) {
+	preds := sl.predecessors(func(n *skipNode) bool {
+		return sl.compare(n, vals, id) < 0
+	})
+	lvl := 1
+	for lvl < maxSkipLevel && sl.rnd.Intn(4) == 0 {
+		lvl++
+	}
+	for ; sl.level < lvl; sl.level++ {
+		preds[sl.level] = sl.head
+	}
+	n := &skipNode{vals: vals, id: id, next: make([]*skipNode, lvl)}
+	for i := 0; i < lvl; i++ {
+		n.next[i] = preds[i].next[i]
+		preds[i].next[i] = n
+	}
+}
+
+func (sl *skipList) remove(vals []*string, id Key) {
+	preds := sl.predecessors(func(n *skipNode) bool {
+		return sl.compare(n, vals, id) < 0
+	})
+	n := preds[0].next[0]
+	if n == nil || sl.compare(n, vals, id) != 0 {
+		return
+	}
+	for i := 0; i < len(n.next); i++ {
+		if preds[i].next[i] == n {
+			preds[i].next[i] = n.next[i]
+		}
+	}
+	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
+		sl.level--
+	}
+}
+
+func newSkipList(types []ColumnType) *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, maxSkipLevel)},
+		level: 1,
+		types: types,
+		rnd:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// sortKeys sorts the given keys into ascending order.
+func sortKeys(keys []Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+}
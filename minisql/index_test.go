@@ -0,0 +1,164 @@
+package minisql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSkipList_Order(t *testing.T) {
+	sl := newSkipList([]ColumnType{INTEGER})
+	rnd := rand.New(rand.NewSource(1))
+	entries := map[Key]int{}
+	for i := 0; i < 500; i++ {
+		v := rnd.Intn(100)
+		s := fmt.Sprintf("%d", v)
+		sl.insert([]*string{&s}, Key(i))
+		entries[Key(i)] = v
+	}
+	for k := Key(0); k < 500; k += 3 {
+		s := fmt.Sprintf("%d", entries[k])
+		sl.remove([]*string{&s}, k)
+		delete(entries, k)
+	}
+	var count int
+	var last *skipNode
+	for n := sl.head.next[0]; n != nil; n = n.next[0] {
+		if last != nil && sl.compare(last, n.vals, n.id) >= 0 {
+			t.Fatalf("expected %v (%d) before %v (%d)", *last.vals[0], last.id, *n.vals[0], n.id)
+		}
+		last = n
+		count++
+	}
+	if count != len(entries) {
+		t.Fatalf("expected %d entries, found %d", len(entries), count)
+	}
+}
+
+func indexTestTable(t *testing.T) *table {
+	tb := newTable(map[string]*ColumnDef{"name": {Type: TEXT}, "qty": {Type: INTEGER}}).(*table)
+	for i, n := range []string{"one", "two", "three"} {
+		name := n
+		qty := fmt.Sprintf("%d", i*10)
+		if _, err := tb.Insert(Values{"name": &name, "qty": &qty}); err != nil {
+			t.Fatalf("unexpected error  %v", err)
+		}
+	}
+	return tb
+}
+
+func TestTable_UniqueIndex(t *testing.T) {
+	tb := indexTestTable(t)
+	twoKey := Key(1)
+	for _, it := range []IndexType{HASH, ORDERED} {
+		if err := tb.CreateIndex(IndexDef{Name: "u", Columns: []string{"name"}, Unique: true, Type: it}); err != nil {
+			t.Fatalf("unexpected error  %v", err)
+		}
+		two := "two"
+		if _, err := tb.Insert(Values{"name": &two}); err == nil {
+			t.Fatalf("expected duplicate insert to fail with %s index", it)
+		}
+		if err := tb.Update(0, Values{"name": &two}); err == nil {
+			t.Fatalf("expected duplicate update to fail with %s index", it)
+		}
+		if err := tb.Update(twoKey, Values{"name": &two}); err != nil {
+			t.Fatalf("expected update to the same value to succeed  %v", err)
+		}
+		if _, err := tb.Insert(Values{"qty": nil}); err != nil {
+			t.Fatalf("expected NULL values not to conflict  %v", err)
+		}
+		if _, err := tb.Insert(Values{"qty": nil}); err != nil {
+			t.Fatalf("expected NULL values not to conflict  %v", err)
+		}
+		tb.Delete(twoKey)
+		k, err := tb.Insert(Values{"name": &two})
+		if err != nil {
+			t.Fatalf("expected insert of deleted value to succeed  %v", err)
+		}
+		twoKey = k
+		if err := tb.DropIndex("u"); err != nil {
+			t.Fatalf("unexpected error  %v", err)
+		}
+	}
+	one := "one"
+	_, _ = tb.Insert(Values{"name": &one})
+	if err := tb.CreateIndex(IndexDef{Name: "u", Columns: []string{"name"}, Unique: true}); err == nil {
+		t.Fatalf("expected unique index on duplicate values to fail")
+	}
+	if len(tb.Indexes()) != 0 {
+		t.Fatalf("expected failed index not to be created")
+	}
+}
+
+func TestTable_IndexDefinitions(t *testing.T) {
+	tb := indexTestTable(t)
+	if err := tb.CreateIndex(IndexDef{Name: "i", Columns: []string{"missing"}}); err == nil {
+		t.Fatalf("expected index of unknown column to fail")
+	}
+	if err := tb.CreateIndex(IndexDef{Name: "i", Columns: []string{"qty", "name"}}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if err := tb.CreateIndex(IndexDef{Name: "i", Columns: []string{"name"}}); err == nil {
+		t.Fatalf("expected duplicate index name to fail")
+	}
+	ten := "10"
+	keys, ok := tb.IndexKeys(KeyRange{Column: "qty", Lower: &ten, LowerInclusive: true})
+	if !ok || !reflect.DeepEqual(keys, []Key{1, 2}) {
+		t.Fatalf("expected keys %v from composite index, found %v", []Key{1, 2}, keys)
+	}
+	if _, ok := tb.IndexKeys(KeyRange{Column: "name", Lower: &ten}); ok {
+		t.Fatalf("expected no index of the second column of a composite index")
+	}
+
+	by, err := json.Marshal(tb)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	restored := &table{}
+	if err := json.Unmarshal(by, restored); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if !reflect.DeepEqual(restored.Indexes(), tb.Indexes()) {
+		t.Fatalf("expected restored indexes %v, found %v", tb.Indexes(), restored.Indexes())
+	}
+	keys, ok = restored.IndexKeys(KeyRange{Column: "qty", Lower: &ten, LowerInclusive: true})
+	if !ok || !reflect.DeepEqual(keys, []Key{1, 2}) {
+		t.Fatalf("expected keys %v from restored index, found %v", []Key{1, 2}, keys)
+	}
+
+	tb.AlterColumns(map[string]*ColumnDef{"name": nil})
+	if len(tb.Indexes()) != 0 {
+		t.Fatalf("expected index to be dropped with its column, found %v", tb.Indexes())
+	}
+}
+
+func TestSession_RollbackIndexes(t *testing.T) {
+	db := NewDatabase(nil)
+	db.AlterDatabase(Schema{"t": {"name": {Type: TEXT}}})
+	tb, _ := db.Table("t")
+	_ = tb.(Indexer).CreateIndex(IndexDef{Name: "keep", Columns: []string{"name"}})
+
+	s := db.NewSession()
+	_ = s.Begin()
+	st, _ := s.Table("t")
+	ix := st.(Indexer)
+	if err := ix.CreateIndex(IndexDef{Name: "added", Columns: []string{"name"}, Type: HASH}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if err := ix.DropIndex("keep"); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	_ = s.Rollback()
+
+	var names []string
+	for _, def := range tb.(Indexer).Indexes() {
+		names = append(names, def.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"keep"}) {
+		t.Fatalf("expected indexes %v after rollback, found %v", []string{"keep"}, names)
+	}
+}
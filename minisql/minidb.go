@@ -2,15 +2,44 @@ package minisql
 
 import (
 	"fmt"
+	"log"
+	"sync"
 )
 
 type Key int64
 
+// Database is the set of tables queries are performed on.  It is implemented by both MiniDB and Session.
+type Database interface {
+	TableNames() []string
+	ContainsTable(tablename string) bool
+	Table(tablename string) (Table, error)
+	Describe(tablename string) ([]string, error)
+	AlterDatabase(schema Schema)
+}
+
+// UnknownTableError is the error of a table name which is not in the database.
+type UnknownTableError string
+
+func (e UnknownTableError) Error() string {
+	return fmt.Sprintf("%q is not a known table", string(e))
+}
+
+// MiniDB is a collection of named tables.
+// It is safe for concurrent use.  Tables are locked individually, so queries on different tables,
+// or reading queries on the same table, run in parallel, while changes to a table are serialised.
 type MiniDB struct {
+	lock   sync.RWMutex
 	tables map[string]Table
+	// dir and log are set for a durable database, opened with OpenDatabase.
+	dir string
+	log *wal
+	// compat is set when queries are parsed with the legacy rules of earlier versions.
+	compat bool
 }
 
-func (db MiniDB) TableNames() []string {
+func (db *MiniDB) TableNames() []string {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
 	names := make([]string, len(db.tables))
 	var i int
 	for tn := range db.tables {
@@ -20,48 +49,96 @@ func (db MiniDB) TableNames() []string {
 	return names
 }
 
-func (db MiniDB) ContainsTable(tablename string) bool {
+func (db *MiniDB) ContainsTable(tablename string) bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
 	_, ok := db.tables[tablename]
 	return ok
 }
 
-func (db MiniDB) Table(tablename string) (Table, error) {
+func (db *MiniDB) Table(tablename string) (Table, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
 	t, ok := db.tables[tablename]
 	if !ok {
-		return nil, fmt.Errorf("%q is not a known table", tablename)
+		return nil, UnknownTableError(tablename)
 	}
 	return t, nil
 }
 
-func (db MiniDB) Describe(tablename string) ([]string, error) {
-	t, ok := db.tables[tablename]
-	if !ok {
+func (db *MiniDB) Describe(tablename string) ([]string, error) {
+	t, err := db.Table(tablename)
+	if err != nil {
 		return nil, fmt.Errorf("%s is an unknown table", tablename)
 	}
 	return t.ColumnNames(), nil
 }
 
 func (db *MiniDB) AlterDatabase(schema Schema) {
+	db.alterDatabase(schema, 0)
+}
+
+// alterDatabase alters the database, logging the change as part of the given transaction, or outside of one when zero.
+func (db *MiniDB) alterDatabase(schema Schema, tx int64) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
 	for tn, cols := range schema {
+		t, ok := db.tables[tn]
+		if ok && len(cols) > 0 {
+			// table already exists, it logs its own changes
+			if tb, ok := t.(*table); ok {
+				tb.alterColumns(cols, tx)
+			} else {
+				t.AlterColumns(cols)
+			}
+			continue
+		}
+		if err := db.log.append(&walRecord{Op: walAlter, Tx: tx, Schema: Schema{tn: cols}}); err != nil {
+			log.Println(err)
+			continue
+		}
 		if len(cols) == 0 {
 			// drop table with no columns
+			if tb, ok := t.(*table); ok {
+				tb.attach("", nil)
+			}
 			delete(db.tables, tn)
 			continue
 		}
-
-		t, ok := db.tables[tn]
-		if ok {
-			// table already exists
-			t.AlterColumns(cols)
-			continue
-		}
 		t = newTable(cols)
 		if len(t.ColumnNames()) > 0 {
+			if tb, ok := t.(*table); ok && db.log != nil {
+				tb.attach(tn, db.log)
+			}
 			db.tables[tn] = t
 		}
 	}
 }
 
+// setTable replaces the named table with the given one, removing it when nil.
+func (db *MiniDB) setTable(tablename string, t Table) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	tb, _ := t.(*table)
+	if db.log != nil {
+		if err := db.log.append(&walRecord{Op: walTable, Table: tablename, Data: tb}); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if old, ok := db.tables[tablename].(*table); ok && old != tb {
+		old.attach("", nil)
+	}
+	if t == nil {
+		delete(db.tables, tablename)
+		return
+	}
+	if tb != nil && db.log != nil {
+		tb.attach(tablename, db.log)
+	}
+	db.tables[tablename] = t
+}
+
 func NewDatabase(schema Schema) *MiniDB {
 	db := &MiniDB{tables: map[string]Table{}}
 	if schema != nil {
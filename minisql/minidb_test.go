@@ -6,19 +6,19 @@ import (
 
 var testSchema = Schema{
 	"t1": {
-		"c1-1": true,
-		"c1-2": true,
-		"c1-3": true,
+		"c1-1": {Type: TEXT},
+		"c1-2": {Type: TEXT},
+		"c1-3": {Type: TEXT},
 	},
 	"t2": {
-		"c2-1": true,
-		"c2-2": true,
-		"c2-3": true,
+		"c2-1": {Type: TEXT},
+		"c2-2": {Type: TEXT},
+		"c2-3": {Type: TEXT},
 	},
 	"t3": {
-		"c3-1": true,
-		"c3-2": true,
-		"c3-3": true,
+		"c3-1": {Type: TEXT},
+		"c3-2": {Type: TEXT},
+		"c3-3": {Type: TEXT},
 	},
 }
 
@@ -70,7 +70,7 @@ func TestMiniDB_Describe(t *testing.T) {
 			t.Fatalf("unexpected number of columns in table %s.  Expected %d, found %d", tn, len(sm), len(cns))
 		}
 		for _, cn := range cns {
-			if !sm[cn] {
+			if sm[cn] == nil {
 				t.Fatalf("unexpected column name %s in table %s", cn, tn)
 			}
 		}
@@ -0,0 +1,196 @@
+package minisql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Rows are the results of a query, read one at a time with Next.
+//
+//	rows, err := db.Query(ctx, "SELECT name, qty FROM stock WHERE qty > ?", 10)
+//	...
+//	defer rows.Close()
+//	for rows.Next() {
+//		var name string
+//		var qty int
+//		if err := rows.Scan(&name, &qty); err != nil {
+//			...
+//		}
+//	}
+//	if err := rows.Err(); err != nil {
+//		...
+//	}
+type Rows struct {
+	ctx     context.Context
+	results <-chan Result
+	cancel  context.CancelFunc
+	// next is a result read ahead, before Next is called
+	next    Result
+	current Result
+	err     error
+	closed  bool
+}
+
+// Next moves to the next result, returning false when there are no more results, or an error stopped the query.
+func (rs *Rows) Next() bool {
+	if rs.next != nil {
+		rs.current, rs.next = rs.next, nil
+		return true
+	}
+	if rs.closed {
+		return false
+	}
+	if !rs.read() {
+		rs.Close()
+		return false
+	}
+	return true
+}
+
+// read reads the next result into current, returning false when there are none, or the result is an error.
+func (rs *Rows) read() bool {
+	rs.current = nil
+	select {
+	case <-rs.ctx.Done():
+		rs.err = rs.ctx.Err()
+		return false
+	case r, ok := <-rs.results:
+		if !ok {
+			return false
+		}
+		if e := ErrorOf(r); e != nil {
+			rs.err = fmt.Errorf("%s", *e)
+			return false
+		}
+		rs.current = r
+		return true
+	}
+}
+
+// Columns gets the names of the columns of the current result, or, before Next is called, of the first result.
+func (rs *Rows) Columns() []string {
+	if r := rs.result(); r != nil {
+		return r.Columns()
+	}
+	return nil
+}
+
+// TableName gets the name of the table of the current result, or, before Next is called, of the first result.
+func (rs *Rows) TableName() string {
+	if r := rs.result(); r != nil {
+		return r.TableName()
+	}
+	return ""
+}
+
+// Values gets the values of the current result, in the order of its columns.  NULL values are nil.
+func (rs *Rows) Values() []*string {
+	if rs.current == nil {
+		return nil
+	}
+	vals := rs.current.Values()
+	cols := rs.current.Columns()
+	values := make([]*string, len(cols))
+	for i, c := range cols {
+		values[i] = vals[c]
+	}
+	return values
+}
+
+func (rs *Rows) result() Result {
+	if rs.current != nil {
+		return rs.current
+	}
+	return rs.next
+}
+
+// Err gets the error which stopped the query, if any.
+func (rs *Rows) Err() error {
+	return rs.err
+}
+
+// Close stops the query.  It is safe to close the rows more than once.
+func (rs *Rows) Close() error {
+	if !rs.closed {
+		rs.closed = true
+		rs.cancel()
+	}
+	return nil
+}
+
+// scanner is implemented by types which scan a value themselves, such as the sql.Null types.
+type scanner interface {
+	Scan(src interface{}) error
+}
+
+// Scan copies the values of the current result into the destinations, in the order of the columns.
+// Destinations may be pointers to a string, []byte, int, int64, float64, bool, time.Time or interface{},
+// or a type with a Scan(interface{}) error method, such as sql.NullString.
+// A NULL value can only be scanned into a *string, an interface{} or a type with a Scan method, which are given nil.
+func (rs *Rows) Scan(dest ...interface{}) error {
+	if rs.current == nil {
+		return fmt.Errorf("Scan called without a result, use Next")
+	}
+	values := rs.Values()
+	if len(dest) != len(values) {
+		return fmt.Errorf("expected %d destinations, found %d", len(values), len(dest))
+	}
+	cols := rs.current.Columns()
+	for i, d := range dest {
+		if err := scanValue(values[i], d); err != nil {
+			return fmt.Errorf("column %s  %w", cols[i], err)
+		}
+	}
+	return nil
+}
+
+func scanValue(v *string, dest interface{}) error {
+	switch d := dest.(type) {
+	case **string:
+		*d = v
+		return nil
+	case *interface{}:
+		if v == nil {
+			*d = nil
+		} else {
+			*d = *v
+		}
+		return nil
+	case scanner:
+		if v == nil {
+			return d.Scan(nil)
+		}
+		return d.Scan(*v)
+	}
+	if v == nil {
+		return fmt.Errorf("NULL can not be scanned into %T", dest)
+	}
+	s := *v
+	var err error
+	switch d := dest.(type) {
+	case *string:
+		*d = s
+	case *[]byte:
+		*d = []byte(s)
+	case *int:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 0)
+		*d = int(i)
+	case *int64:
+		*d, err = strconv.ParseInt(s, 10, 64)
+	case *float64:
+		*d, err = strconv.ParseFloat(s, 64)
+	case *bool:
+		*d, err = strconv.ParseBool(s)
+	case *time.Time:
+		*d, err = ParseTimestamp(s)
+	default:
+		return fmt.Errorf("values can not be scanned into %T", dest)
+	}
+	if err != nil {
+		return fmt.Errorf("%q can not be scanned into %T", s, dest)
+	}
+	return nil
+}
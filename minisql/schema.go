@@ -10,7 +10,184 @@ import (
 	"strings"
 )
 
-type Schema map[string]map[string]bool
+// Schema describes the columns of one or more tables, mapping table names to their column definitions.
+// A table with no columns, or a nil column definition, signifies that table or column is to be dropped.
+type Schema map[string]map[string]*ColumnDef
+
+// ColumnDef defines a single column in a table.
+type ColumnDef struct {
+	Type ColumnType `json:"type"`
+	// NotNull refuses NULL values of the column.
+	NotNull bool `json:"not_null,omitempty"`
+	// Unique refuses a value of the column equal to the value of another row.  NULL values are not compared.
+	Unique bool `json:"unique,omitempty"`
+	// Default is the value of the column in an inserted row not given one, or nil for NULL.
+	Default *string `json:"default,omitempty"`
+	// Check is a condition the values of each row must meet.  A row with a NULL value of any column in the condition passes it.
+	Check string `json:"check,omitempty"`
+	// PrimaryKey marks the column as part of the tables primary key.  The values of all its primary key columns
+	// together must be unique, and none of them NULL.
+	PrimaryKey bool `json:"primary_key,omitempty"`
+	// References, when not nil, is the column of another table whose rows must hold each value of the column.
+	References *ForeignKey `json:"references,omitempty"`
+}
+
+// ReferentialAction is what happens to the rows referencing a row which is deleted.
+type ReferentialAction string
+
+const (
+	// RESTRICT refuses to delete a referenced row.
+	RESTRICT ReferentialAction = "RESTRICT"
+	// CASCADE deletes the referencing rows along with the row they reference.
+	CASCADE ReferentialAction = "CASCADE"
+	// SETNULL sets the references of the referencing rows to NULL.
+	SETNULL ReferentialAction = "SET NULL"
+)
+
+// ForeignKey references a column of another table, by a column whose values must be values of the referenced column.
+type ForeignKey struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	// OnDelete is the action taken when a referenced row is deleted.  Empty is RESTRICT.
+	OnDelete ReferentialAction `json:"on_delete,omitempty"`
+}
+
+func (fk ForeignKey) String() string {
+	s := fmt.Sprintf("REFERENCES %s (%s)", sqlName(fk.Table), sqlName(fk.Column))
+	if fk.OnDelete != "" {
+		s += " ON DELETE " + string(fk.OnDelete)
+	}
+	return s
+}
+
+// Action gets the ON DELETE action of the foreign key.
+func (fk ForeignKey) Action() ReferentialAction {
+	if fk.OnDelete == "" {
+		return RESTRICT
+	}
+	return fk.OnDelete
+}
+
+// ParseReferentialAction parses the name of an ON DELETE action.
+func ParseReferentialAction(s string) (ReferentialAction, error) {
+	for _, ra := range []ReferentialAction{RESTRICT, CASCADE, SETNULL} {
+		if strings.EqualFold(strings.Join(strings.Fields(s), " "), string(ra)) {
+			return ra, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a known action, must be RESTRICT, CASCADE or SET NULL", s)
+}
+
+// String gets the type of the column, followed by any constraints.
+func (cd ColumnDef) String() string {
+	if c := cd.Constraints(); c != "" {
+		return strings.Join([]string{string(cd.columnType()), c}, " ")
+	}
+	return string(cd.columnType())
+}
+
+// Constraints gets the constraints of the column, as they are written in a CREATE TABLE query, or empty when it has none.
+func (cd ColumnDef) Constraints() string {
+	var s []string
+	if cd.PrimaryKey {
+		s = append(s, "PRIMARY KEY")
+	}
+	if cd.NotNull {
+		s = append(s, "NOT NULL")
+	}
+	if cd.Unique {
+		s = append(s, "UNIQUE")
+	}
+	if cd.Default != nil {
+		s = append(s, "DEFAULT "+QuoteValue(*cd.Default))
+	}
+	if cd.Check != "" {
+		s = append(s, fmt.Sprintf("CHECK (%s)", cd.Check))
+	}
+	if cd.References != nil {
+		s = append(s, cd.References.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// Validate checks the column definition, converting its default to the canonical form of its type.
+// A CHECK condition is parsed by the registered PredicateParser.
+func (cd *ColumnDef) Validate() error {
+	if cd.Default != nil {
+		v, err := cd.columnType().Convert(*cd.Default)
+		if err != nil {
+			return fmt.Errorf("DEFAULT  %w", err)
+		}
+		cd.Default = &v
+	}
+	if cd.Check != "" {
+		if _, err := parsePredicate(cd.Check, func(string) ColumnType { return TEXT }); err != nil {
+			return fmt.Errorf("CHECK  %w", err)
+		}
+	}
+	return nil
+}
+
+func (cd ColumnDef) columnType() ColumnType {
+	if cd.Type == "" {
+		return TEXT
+	}
+	return cd.Type
+}
+
+// UnmarshalJSON reads a column definition, also accepting the older schema form of a 'true' value for an untyped column,
+// or just the type name.
+func (cd *ColumnDef) UnmarshalJSON(bytes []byte) error {
+	var b bool
+	if err := json.Unmarshal(bytes, &b); err == nil {
+		if !b {
+			return fmt.Errorf("false is not a valid column definition")
+		}
+		cd.Type = TEXT
+		return nil
+	}
+	var ct ColumnType
+	if err := json.Unmarshal(bytes, &ct); err == nil {
+		cd.Type = ct
+		return nil
+	}
+	// columnDef has the fields of ColumnDef, without its UnmarshalJSON
+	type columnDef ColumnDef
+	def := &columnDef{}
+	if err := json.Unmarshal(bytes, def); err != nil {
+		return err
+	}
+	*cd = ColumnDef(*def)
+	return nil
+}
+
+// Predicate is a condition of the values of a row, such as the CHECK constraint of a column.
+type Predicate interface {
+	// Compare checks if the values, of at least the columns of the predicate, meet the condition.
+	// The error is of a condition which can not be evaluated with the values.  e.g. an INTEGER which overflows.
+	Compare(values Values) (bool, error)
+	// ColumnNames gets the names of the columns the predicate compares.
+	ColumnNames() []string
+}
+
+// PredicateParser parses a condition into a Predicate, comparing the values of its columns by the given column types.
+// The types of names which are not columns are empty.
+type PredicateParser func(condition string, types func(column string) ColumnType) (Predicate, error)
+
+var predicateParser PredicateParser
+
+// RegisterPredicateParser sets the parser of the CHECK constraints of columns.
+// The parser of SQL conditions is registered by the queries package when it is imported.
+func RegisterPredicateParser(p PredicateParser) {
+	predicateParser = p
+}
+
+func parsePredicate(condition string, types func(column string) ColumnType) (Predicate, error) {
+	if predicateParser == nil {
+		return nil, fmt.Errorf("no condition parser registered, import eurozulu/miniSQL/queries")
+	}
+	return predicateParser(condition, types)
+}
 
 func (s Schema) Save(filepath string) error {
 	f, err := os.Open(filepath)
@@ -42,7 +219,7 @@ func LoadSchema(filepath string) (Schema, error) {
 	return sc, nil
 }
 
-func NewSchemaFromTables(db *MiniDB, tableName ...string) (Schema, error) {
+func NewSchemaFromTables(db Database, tableName ...string) (Schema, error) {
 	if len(tableName) == 0 {
 		return nil, fmt.Errorf("must proive at least one table to generate schema from")
 	}
@@ -52,9 +229,13 @@ func NewSchemaFromTables(db *MiniDB, tableName ...string) (Schema, error) {
 		if err != nil {
 			return nil, err
 		}
-		cols := map[string]bool{}
+		cols := map[string]*ColumnDef{}
 		for _, cn := range t.ColumnNames() {
-			cols[cn] = true
+			cd, err := t.ColumnDef(cn)
+			if err != nil {
+				return nil, err
+			}
+			cols[cn] = cd
 		}
 		sc[tn] = cols
 	}
@@ -74,9 +255,17 @@ func NewSchema(schema string) (Schema, error) {
 	if colList == "" {
 		return nil, fmt.Errorf("no columns in brackets stated for table %q", table)
 	}
-	cols := map[string]bool{}
+	cols := map[string]*ColumnDef{}
 	for _, col := range strings.Split(colList, ",") {
-		cols[strings.TrimSpace(col)] = true
+		name, ts := stringutil.FirstWord(strings.TrimSpace(col))
+		if name == "" {
+			return nil, fmt.Errorf("missing column name in table %q", table)
+		}
+		ct, err := ParseColumnType(ts)
+		if err != nil {
+			return nil, fmt.Errorf("column %s  %w", name, err)
+		}
+		cols[name] = &ColumnDef{Type: ct}
 	}
 	return Schema{table: cols}, nil
 }
@@ -0,0 +1,352 @@
+package minisql
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Transactional is implemented by a Database which can group changes into transactions.
+type Transactional interface {
+	// Begin starts a new transaction.
+	Begin() error
+	// Commit ends the current transaction, keeping all its changes.
+	Commit() error
+	// Rollback ends the current transaction, undoing all its changes.
+	Rollback() error
+	// Savepoint marks the current point in the transaction, to allow a partial rollback to that point.
+	Savepoint(name string) error
+	// RollbackTo undoes all the changes made since the named savepoint.  The transaction and the savepoint remain.
+	RollbackTo(name string) error
+	// Release removes the named savepoint, and any made after it, keeping all the changes.
+	Release(name string) error
+	// InTransaction checks if a transaction is in progress.
+	InTransaction() bool
+}
+
+// Session is a single user's view of a MiniDB, allowing changes to be grouped into transactions.
+// Outside of a transaction, every change is applied immediately.
+// Inside a transaction, changes are still applied immediately, and so are visible to other sessions,
+// but an undo log is kept, so that a rollback can return the database to its state at the start of the transaction,
+// or to a savepoint.  Rollback restores both the data and the structure of the tables.
+// On a durable database, the changes made in a transaction are only replayed from the log once the transaction commits.
+type Session struct {
+	db   *MiniDB
+	lock sync.Mutex
+	tx   *transaction
+	// compat is set when the queries of the session are parsed with the legacy rules of earlier versions.
+	compat bool
+}
+
+type transaction struct {
+	// id is the id of the transaction in the log its changes are written to, or zero when the database is not durable.
+	id         int64
+	log        *wal
+	undo       []func()
+	savepoints []savepoint
+}
+
+type savepoint struct {
+	name  string
+	index int
+}
+
+func (s *Session) TableNames() []string {
+	return s.db.TableNames()
+}
+
+func (s *Session) ContainsTable(tablename string) bool {
+	return s.db.ContainsTable(tablename)
+}
+
+func (s *Session) Describe(tablename string) ([]string, error) {
+	return s.db.Describe(tablename)
+}
+
+// Table gets the named table.  Inside a transaction, changes made to the table are logged so they can be rolled back.
+func (s *Session) Table(tablename string) (Table, error) {
+	t, err := s.db.Table(tablename)
+	if err != nil {
+		return nil, err
+	}
+	tb, ok := t.(*table)
+	if !ok || !s.InTransaction() {
+		return t, nil
+	}
+	return &txTable{table: tb, session: s}, nil
+}
+
+func (s *Session) AlterDatabase(schema Schema) {
+	if !s.InTransaction() {
+		s.db.AlterDatabase(schema)
+		return
+	}
+	for tn, cols := range schema {
+		t, err := s.db.Table(tn)
+		switch {
+		case err != nil:
+			// new table, undo by removing it
+			s.logUndo(func() {
+				s.db.setTable(tn, nil)
+			})
+		case len(cols) == 0:
+			// dropped table, undo by putting it back
+			s.logUndo(func() {
+				s.db.setTable(tn, t)
+			})
+		default:
+			if tb, ok := t.(*table); ok {
+				names := make([]string, 0, len(cols))
+				for cn := range cols {
+					names = append(names, cn)
+				}
+				states := tb.columnStates(names)
+				indexes := tb.Indexes()
+				s.logUndo(func() {
+					tb.restoreColumns(states)
+					tb.restoreIndexes(indexes)
+				})
+			}
+		}
+		s.db.alterDatabase(Schema{tn: cols}, s.txID())
+	}
+}
+
+func (s *Session) Begin() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx != nil {
+		return fmt.Errorf("transaction already in progress")
+	}
+	s.db.lock.RLock()
+	w := s.db.log
+	s.db.lock.RUnlock()
+	id, err := w.begin()
+	if err != nil {
+		return err
+	}
+	s.tx = &transaction{id: id, log: w}
+	return nil
+}
+
+func (s *Session) Commit() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	err := s.tx.log.end(s.tx.id, walCommit)
+	s.tx = nil
+	return err
+}
+
+func (s *Session) Rollback() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	s.tx.undoTo(0)
+	err := s.tx.log.end(s.tx.id, walRollback)
+	s.tx = nil
+	return err
+}
+
+func (s *Session) Savepoint(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx == nil {
+		return fmt.Errorf("SAVEPOINT can only be used in a transaction")
+	}
+	s.tx.savepoints = append(s.tx.savepoints, savepoint{name: name, index: len(s.tx.undo)})
+	return nil
+}
+
+func (s *Session) RollbackTo(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	i := s.tx.savepointIndex(name)
+	if i < 0 {
+		return fmt.Errorf("savepoint %q is not known", name)
+	}
+	s.tx.undoTo(s.tx.savepoints[i].index)
+	// savepoints made after the one rolled back to are removed
+	s.tx.savepoints = s.tx.savepoints[:i+1]
+	return nil
+}
+
+func (s *Session) Release(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	i := s.tx.savepointIndex(name)
+	if i < 0 {
+		return fmt.Errorf("savepoint %q is not known", name)
+	}
+	s.tx.savepoints = s.tx.savepoints[:i]
+	return nil
+}
+
+func (s *Session) InTransaction() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tx != nil
+}
+
+// Compat checks if the queries of the session are parsed with the legacy rules of earlier versions.
+func (s *Session) Compat() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compat
+}
+
+// SetCompat sets if the queries the session parses after it follow the legacy rules of earlier versions.
+// In compat mode, AND and OR are read strictly from left to right, NULL equals NULL, and NULL is less than every other value.
+// Statements already prepared keep the rules they were parsed with.
+func (s *Session) SetCompat(on bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.compat = on
+}
+
+// txID gets the id the changes of the current transaction are logged with, or zero when there is none.
+func (s *Session) txID() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx == nil {
+		return 0
+	}
+	return s.tx.id
+}
+
+// logUndo adds a function to the undo log, which reverses a change about to be made.
+// Outside of a transaction, the function is ignored.
+func (s *Session) logUndo(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tx != nil {
+		s.tx.undo = append(s.tx.undo, f)
+	}
+}
+
+// undoTo performs the undo log, in reverse order, back to the given index.
+func (tx *transaction) undoTo(index int) {
+	for i := len(tx.undo) - 1; i >= index; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = tx.undo[:index]
+}
+
+// savepointIndex finds the most recent savepoint with the given name, or -1 if not found.
+func (tx *transaction) savepointIndex(name string) int {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// txTable logs the changes made to a table during a transaction, so they may be undone.
+type txTable struct {
+	*table
+	session *Session
+}
+
+func (tt *txTable) AlterColumns(cols map[string]*ColumnDef) {
+	names := make([]string, 0, len(cols))
+	for cn := range cols {
+		names = append(names, cn)
+	}
+	states := tt.columnStates(names)
+	indexes := tt.Indexes()
+	tt.session.logUndo(func() {
+		tt.restoreColumns(states)
+		tt.restoreIndexes(indexes)
+	})
+	tt.table.alterColumns(cols, tt.session.txID())
+}
+
+func (tt *txTable) CreateIndex(def IndexDef) error {
+	if err := tt.table.createIndex(def, tt.session.txID()); err != nil {
+		return err
+	}
+	tt.session.logUndo(func() {
+		_ = tt.table.DropIndex(def.Name)
+	})
+	return nil
+}
+
+func (tt *txTable) DropIndex(name string) error {
+	var dropped []IndexDef
+	for _, def := range tt.Indexes() {
+		if def.Name == name {
+			dropped = append(dropped, def)
+		}
+	}
+	if err := tt.table.dropIndex(name, tt.session.txID()); err != nil {
+		return err
+	}
+	tt.session.logUndo(func() {
+		tt.restoreIndexes(dropped)
+	})
+	return nil
+}
+
+func (tt *txTable) Insert(values Values) (Key, error) {
+	id, err := tt.table.insert(values, tt.session.txID())
+	if err != nil {
+		return id, err
+	}
+	tt.session.logUndo(func() {
+		tt.restoreRow(id, nil, false)
+	})
+	return id, nil
+}
+
+func (tt *txTable) Update(id Key, values Values) error {
+	cols := make([]string, 0, len(values))
+	for cn := range values {
+		cols = append(cols, cn)
+	}
+	old, err := tt.table.Select(id, cols)
+	if err != nil {
+		return err
+	}
+	if err = tt.table.update(id, values, tt.session.txID()); err != nil {
+		return err
+	}
+	tt.session.logUndo(func() {
+		tt.restoreRow(id, old, true)
+	})
+	return nil
+}
+
+func (tt *txTable) Delete(id ...Key) []Key {
+	cols := tt.ColumnNames()
+	rows := map[Key]Values{}
+	for _, k := range id {
+		if !tt.ContainsID(k) {
+			continue
+		}
+		if v, err := tt.table.Select(k, cols); err == nil {
+			rows[k] = v
+		}
+	}
+	dks := tt.table.delete(id, tt.session.txID())
+	tt.session.logUndo(func() {
+		for _, k := range dks {
+			tt.restoreRow(k, rows[k], true)
+		}
+	})
+	return dks
+}
+
+// NewSession creates a new Session on the database, parsing queries with the same rules as the database.
+func (db *MiniDB) NewSession() *Session {
+	return &Session{db: db, compat: db.compat}
+}
@@ -0,0 +1,157 @@
+package minisql
+
+import (
+	"testing"
+)
+
+func sessionTestDB(t *testing.T) (*MiniDB, Table) {
+	db := NewDatabase(Schema{"t": {"name": {Type: TEXT}, "qty": {Type: INTEGER}}})
+	tb, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	for _, n := range []string{"one", "two"} {
+		s := n
+		if _, err := tb.Insert(Values{"name": &s}); err != nil {
+			t.Fatalf("unexpected error  %v", err)
+		}
+	}
+	return db, tb
+}
+
+func rowCount(tb Table) int {
+	var count int
+	for k := Key(0); k < tb.NextID(); k++ {
+		if tb.ContainsID(k) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSession_RollbackRows(t *testing.T) {
+	db, tb := sessionTestDB(t)
+	s := db.NewSession()
+	if err := s.Begin(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if !s.InTransaction() {
+		t.Fatalf("expected session to be in transaction")
+	}
+	stb, err := s.Table("t")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	three := "three"
+	if _, err := stb.Insert(Values{"name": &three}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	uno := "uno"
+	if err := stb.Update(0, Values{"name": &uno}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	stb.Delete(1)
+	if rowCount(tb) != 2 {
+		t.Fatalf("expected %d rows before rollback, found %d", 2, rowCount(tb))
+	}
+
+	if err := s.Rollback(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if s.InTransaction() {
+		t.Fatalf("expected transaction to have ended")
+	}
+	if rowCount(tb) != 2 {
+		t.Fatalf("expected %d rows after rollback, found %d", 2, rowCount(tb))
+	}
+	for k, expect := range map[Key]string{0: "one", 1: "two"} {
+		v, err := tb.Select(k, []string{"name"})
+		if err != nil {
+			t.Fatalf("unexpected error  %v", err)
+		}
+		if v["name"] == nil || *v["name"] != expect {
+			t.Fatalf("expected row %d to be %q, found %v", k, expect, v["name"])
+		}
+	}
+}
+
+func TestSession_RollbackStructure(t *testing.T) {
+	db, _ := sessionTestDB(t)
+	s := db.NewSession()
+	if err := s.Begin(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	s.AlterDatabase(Schema{"t2": {"x": {Type: TEXT}}})
+	s.AlterDatabase(Schema{"t": {"qty": nil, "extra": {Type: REAL}}})
+	if !db.ContainsTable("t2") {
+		t.Fatalf("expected t2 to be created during the transaction")
+	}
+	s.AlterDatabase(Schema{"t": nil})
+	if db.ContainsTable("t") {
+		t.Fatalf("expected t to be dropped during the transaction")
+	}
+
+	if err := s.Rollback(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if db.ContainsTable("t2") {
+		t.Fatalf("expected t2 to be removed by rollback")
+	}
+	tb, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("expected t to be restored by rollback  %v", err)
+	}
+	cns := tb.ColumnNames()
+	if len(cns) != 2 || cns[0] != "name" || cns[1] != "qty" {
+		t.Fatalf("expected columns [name qty], found %v", cns)
+	}
+	if cd, _ := tb.ColumnDef("qty"); cd == nil || cd.Type != INTEGER {
+		t.Fatalf("expected qty to be restored as %s, found %v", INTEGER, cd)
+	}
+	if rowCount(tb) != 2 {
+		t.Fatalf("expected %d rows after rollback, found %d", 2, rowCount(tb))
+	}
+}
+
+func TestSession_Savepoints(t *testing.T) {
+	db, tb := sessionTestDB(t)
+	s := db.NewSession()
+	if err := s.Savepoint("sp"); err == nil {
+		t.Fatalf("expected error for savepoint outside of transaction")
+	}
+	_ = s.Begin()
+	stb, _ := s.Table("t")
+	three := "three"
+	_, _ = stb.Insert(Values{"name": &three})
+	if err := s.Savepoint("sp"); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	stb.Delete(0, 1, 2)
+	if rowCount(tb) != 0 {
+		t.Fatalf("expected %d rows, found %d", 0, rowCount(tb))
+	}
+	if err := s.RollbackTo("sp"); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if rowCount(tb) != 3 {
+		t.Fatalf("expected %d rows after rollback to savepoint, found %d", 3, rowCount(tb))
+	}
+	if !s.InTransaction() {
+		t.Fatalf("expected transaction to remain after rollback to savepoint")
+	}
+	if err := s.Release("sp"); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if err := s.RollbackTo("sp"); err == nil {
+		t.Fatalf("expected error rolling back to released savepoint")
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if err := s.Commit(); err == nil {
+		t.Fatalf("expected error committing with no transaction")
+	}
+	if rowCount(tb) != 3 {
+		t.Fatalf("expected %d rows after commit, found %d", 3, rowCount(tb))
+	}
+}
@@ -0,0 +1,199 @@
+package minisql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// sqlBatchSize is the number of rows inserted by each INSERT of an SQL dump.
+const sqlBatchSize = 100
+
+// DumpSQL writes the whole database to the given file, as a script of the CREATE and INSERT queries which rebuild it.
+// Like Dump, the script is written to a temporary file, which then replaces any existing file.
+func DumpSQL(filename string, tdb *MiniDB) error {
+	tdb.lock.RLock()
+	defer tdb.lock.RUnlock()
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return writeSQL(w, tdb.tables)
+	})
+}
+
+// writeSQL writes the tables, in name order, as a script of queries.
+// Tables referenced by the foreign keys of other tables are written before them, so their rows are inserted first.
+// Each table is created, followed by its indexes, then its rows are inserted, in key order, one row to a line.
+// The rows are inserted with their _id, so they keep it when the script is run.
+func writeSQL(w io.Writer, tables map[string]Table) error {
+	names := make([]string, 0, len(tables))
+	for tn := range tables {
+		names = append(names, tn)
+	}
+	sort.Strings(names)
+	names = referencedFirst(names, tables)
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "-- minisql dump of %d tables\n", len(names)); err != nil {
+		return err
+	}
+	for _, tn := range names {
+		tb, ok := tables[tn].(*table)
+		if !ok {
+			return fmt.Errorf("table %s can not be written as SQL", tn)
+		}
+		if err := tb.writeSQL(bw, tn); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// referencedFirst orders the table names so each table follows the tables it references, otherwise keeping their order.
+// Tables which reference each other remain in their given order.
+func referencedFirst(names []string, tables map[string]Table) []string {
+	refs := map[string][]string{}
+	for _, tn := range names {
+		t := tables[tn]
+		for _, cn := range t.ColumnNames() {
+			if cd, err := t.ColumnDef(cn); err == nil && cd.References != nil && cd.References.Table != tn {
+				refs[tn] = append(refs[tn], cd.References.Table)
+			}
+		}
+	}
+	ordered := make([]string, 0, len(names))
+	done := map[string]bool{}
+	for len(ordered) < len(names) {
+		before := len(ordered)
+		for _, tn := range names {
+			if done[tn] {
+				continue
+			}
+			ready := true
+			for _, rn := range refs[tn] {
+				if _, ok := tables[rn]; ok && !done[rn] {
+					ready = false
+				}
+			}
+			if ready {
+				done[tn] = true
+				ordered = append(ordered, tn)
+			}
+		}
+		if len(ordered) == before {
+			// the remaining tables reference each other
+			for _, tn := range names {
+				if !done[tn] {
+					done[tn] = true
+					ordered = append(ordered, tn)
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+func (tb *table) writeSQL(w *bufio.Writer, name string) error {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	cols := make([]string, 0, len(tb.columns))
+	for cn := range tb.columns {
+		cols = append(cols, cn)
+	}
+	sort.Strings(cols)
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		_, err := fmt.Fprintf(w, "-- table %s has no columns\n", QuoteName(name))
+		return err
+	}
+
+	defs := make([]string, len(cols))
+	names := make([]string, len(cols))
+	for i, cn := range cols {
+		names[i] = QuoteName(cn)
+		defs[i] = fmt.Sprintf("%s %s", names[i], tb.columnDef(cn))
+	}
+	if _, err := fmt.Fprintf(w, "CREATE TABLE %s (%s);\n", QuoteName(name), strings.Join(defs, ", ")); err != nil {
+		return err
+	}
+	for _, def := range tb.indexDefs() {
+		if err := writeIndexSQL(w, name, def); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]Key, 0, len(tb.keys))
+	for k, ok := range tb.keys {
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sortKeys(keys)
+	insert := fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES ", QuoteName(name), IDColumn, strings.Join(names, ", "))
+	for i, k := range keys {
+		sep := ",\n"
+		if i%sqlBatchSize == 0 {
+			sep = insert
+		}
+		if _, err := w.WriteString(sep); err != nil {
+			return err
+		}
+		vals := make([]string, len(cols))
+		for ci, cn := range cols {
+			vals[ci] = "NULL"
+			if v, ok := tb.columns[cn][k]; ok {
+				vals[ci] = QuoteValue(v)
+			}
+		}
+		end := ""
+		if i%sqlBatchSize == sqlBatchSize-1 || i == len(keys)-1 {
+			end = ";\n"
+		}
+		if _, err := fmt.Fprintf(w, "(%d, %s)%s", k, strings.Join(vals, ", "), end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeIndexSQL(w io.Writer, tablename string, def IndexDef) error {
+	cols := make([]string, len(def.Columns))
+	for i, cn := range def.Columns {
+		cols[i] = QuoteName(cn)
+	}
+	create := "CREATE INDEX"
+	if def.Unique {
+		create = "CREATE UNIQUE INDEX"
+	}
+	using := ""
+	if def.Type != "" {
+		using = " USING " + string(def.Type)
+	}
+	_, err := fmt.Fprintf(w, "%s %s ON %s%s (%s);\n", create, QuoteName(def.Name), QuoteName(tablename), using, strings.Join(cols, ", "))
+	return err
+}
+
+// QuoteName quotes the name in back quotes, so it is read as a name, whatever it contains.
+func QuoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// sqlName gets the name as it is written in a query, quoting it only when it is not a plain name.
+func sqlName(name string) string {
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return QuoteName(name)
+		}
+	}
+	if name == "" {
+		return QuoteName(name)
+	}
+	return name
+}
+
+// QuoteValue quotes the value in single quotes, so it is read as a value, whatever it contains.
+func QuoteValue(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
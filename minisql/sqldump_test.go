@@ -0,0 +1,68 @@
+package minisql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSQL(t *testing.T) {
+	db := NewDatabase(Schema{
+		"t":     {"n": {Type: INTEGER}, "my name": {Type: TEXT}},
+		"empty": {"x": {Type: REAL}},
+	})
+	tb, _ := db.Table("t")
+	s := func(v string) *string { return &v }
+	for _, vals := range []Values{
+		{"n": s("1"), "my name": s("it's")},
+		{"n": s("2")},
+		{"n": s("3"), "my name": s("two\nlines")},
+	} {
+		if _, err := tb.Insert(vals); err != nil {
+			t.Fatalf("failed to insert  %s", err)
+		}
+	}
+	tb.Delete(1)
+	if err := tb.(Indexer).CreateIndex(IndexDef{Name: "byn", Columns: []string{"n"}, Unique: true, Type: HASH}); err != nil {
+		t.Fatalf("failed to create index  %s", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeSQL(buf, db.tables); err != nil {
+		t.Fatalf("failed to write SQL  %s", err)
+	}
+	expect := strings.Join([]string{
+		"-- minisql dump of 2 tables",
+		"",
+		"CREATE TABLE `empty` (`x` REAL);",
+		"",
+		"CREATE TABLE `t` (`my name` TEXT, `n` INTEGER);",
+		"CREATE UNIQUE INDEX `byn` ON `t` USING HASH (`n`);",
+		"INSERT INTO `t` (_id, `my name`, `n`) VALUES (0, 'it''s', '1'),",
+		"(2, 'two\nlines', '3');",
+		"",
+	}, "\n")
+	if buf.String() != expect {
+		t.Fatalf("unexpected SQL, expected:\n%s\nfound:\n%s", expect, buf.String())
+	}
+}
+
+func TestWriteSQL_Batches(t *testing.T) {
+	db := NewDatabase(Schema{"t": {"n": {Type: INTEGER}}})
+	tb, _ := db.Table("t")
+	for i := 0; i < sqlBatchSize*2+1; i++ {
+		if _, err := tb.Insert(Values{}); err != nil {
+			t.Fatalf("failed to insert  %s", err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := writeSQL(buf, db.tables); err != nil {
+		t.Fatalf("failed to write SQL  %s", err)
+	}
+	if n := strings.Count(buf.String(), "INSERT INTO"); n != 3 {
+		t.Fatalf("expected 3 INSERT queries, found %d", n)
+	}
+	if n := strings.Count(buf.String(), ";\n"); n != 4 {
+		t.Fatalf("expected 4 queries, found %d", n)
+	}
+}
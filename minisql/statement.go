@@ -0,0 +1,253 @@
+package minisql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Result is a single result of a statement, such as the values of a selected row, or the outcome of a change.
+type Result interface {
+	TableName() string
+	Values() Values
+	// Columns gets the names of the values, in the order they were selected.
+	Columns() []string
+}
+
+// ErrorColumn is the name of the only value of a result reporting an error, found while a statement was executed.
+const ErrorColumn = "ERROR"
+
+// ErrorOf gets the message of a result reporting an error, or nil when the result is not an error.
+func ErrorOf(r Result) *string {
+	vals := r.Values()
+	if e, ok := vals[ErrorColumn]; ok && len(vals) == 1 {
+		return e
+	}
+	return nil
+}
+
+// Statement is a parsed query, to execute on a Database.
+// Any errors in the statement are returned by Execute, before it starts, while errors found as it runs are sent as results.
+type Statement interface {
+	Execute(ctx context.Context, db Database) (<-chan Result, error)
+}
+
+// Inserter is implemented by a Statement which inserts rows.
+type Inserter interface {
+	// InsertsRows checks if the results of the statement are the _id of each row it inserted.
+	InsertsRows() bool
+}
+
+// PreparedStatement is a parsed query, with placeholders for the values of its parameters.
+type PreparedStatement interface {
+	// NumParameters gets the number of parameters the statement must be given.
+	NumParameters() int
+	// Bind gets the statement with its placeholders replaced by the given values, the first value for parameter 1.
+	Bind(args ...interface{}) (Statement, error)
+}
+
+// Parser parses a query into a PreparedStatement.
+// When compat is set, the query is parsed with the legacy rules of earlier versions.
+type Parser func(query string, compat bool) (PreparedStatement, error)
+
+var parser Parser
+
+// RegisterParser sets the parser of the queries run by Exec and Query.
+// The parser of the SQL queries is registered by the queries package when it is imported, e.g.
+//
+//	import _ "eurozulu/miniSQL/queries"
+func RegisterParser(p Parser) {
+	parser = p
+}
+
+func parse(query string, compat bool) (PreparedStatement, error) {
+	if parser == nil {
+		return nil, fmt.Errorf("no query parser registered, import eurozulu/miniSQL/queries")
+	}
+	return parser(query, compat)
+}
+
+// Options are how a database is opened by Open.
+type Options struct {
+	// Dir is the data directory of a durable database.  When empty, the database is held in memory.
+	Dir string
+	// Restore is a dump file, whose tables are restored into the database when it is opened.
+	Restore string
+	// Schema is the structure of the tables the database is altered to, when it is opened.
+	Schema Schema
+	// Compat parses the queries of the database, and the sessions on it, with the legacy rules of earlier versions.
+	Compat bool
+}
+
+// Open opens a database.  Each database is independent, so any number may be open at once.
+// The database must be closed, to write a final snapshot of a durable database.
+func Open(opts Options) (*MiniDB, error) {
+	db := NewDatabase(nil)
+	if opts.Dir != "" {
+		var err error
+		if db, err = OpenDatabase(opts.Dir); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Schema != nil {
+		db.AlterDatabase(opts.Schema)
+	}
+	if opts.Restore != "" {
+		if err := Restore(opts.Restore, db); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	db.compat = opts.Compat
+	return db, nil
+}
+
+// Exec runs the query, with its parameter placeholders replaced by the arguments, and returns the number of rows it changed.
+// Outside of a transaction, the changes are made immediately.
+func (db *MiniDB) Exec(ctx context.Context, query string, args ...interface{}) (int, error) {
+	return runExec(ctx, db, query, db.compat, args)
+}
+
+// Query runs the query, with its parameter placeholders replaced by the arguments, and returns its results as they are found.
+// The Rows must be closed, unless they are read to the end.
+func (db *MiniDB) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return runQuery(ctx, db, query, db.compat, args)
+}
+
+// Prepare parses the query once, to run any number of times with the values of its parameters.
+func (db *MiniDB) Prepare(query string) (*Stmt, error) {
+	return prepare(db, query, db.compat)
+}
+
+// Exec runs the query in the session, with its parameter placeholders replaced by the arguments, and returns the number of rows it changed.
+func (s *Session) Exec(ctx context.Context, query string, args ...interface{}) (int, error) {
+	return runExec(ctx, s, query, s.Compat(), args)
+}
+
+// Query runs the query in the session, with its parameter placeholders replaced by the arguments, and returns its results as they are found.
+func (s *Session) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return runQuery(ctx, s, query, s.Compat(), args)
+}
+
+// Prepare parses the query once, to run in the session any number of times with the values of its parameters.
+func (s *Session) Prepare(query string) (*Stmt, error) {
+	return prepare(s, query, s.Compat())
+}
+
+// Stmt is a query, parsed once, which may be run any number of times with different values of its parameters.
+// A Stmt may be used by any number of routines at once.
+type Stmt struct {
+	db        Database
+	statement PreparedStatement
+}
+
+func prepare(db Database, query string, compat bool) (*Stmt, error) {
+	ps, err := parse(query, compat)
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{db: db, statement: ps}, nil
+}
+
+// NumParameters gets the number of parameters the statement must be given.
+func (st *Stmt) NumParameters() int {
+	return st.statement.NumParameters()
+}
+
+// Exec runs the statement with the given values of its parameters, and returns the number of rows it changed.
+func (st *Stmt) Exec(ctx context.Context, args ...interface{}) (int, error) {
+	c, err := st.ExecChanges(ctx, args...)
+	return c.Rows, err
+}
+
+// Changes are the changes made by a statement run with ExecChanges.
+type Changes struct {
+	// Rows is the number of rows changed.
+	Rows int
+	// LastID is the _id of the last row inserted, or -1 when no row was inserted.
+	LastID int64
+}
+
+// ExecChanges runs the statement with the given values of its parameters, and returns the changes it made.
+func (st *Stmt) ExecChanges(ctx context.Context, args ...interface{}) (Changes, error) {
+	s, err := st.statement.Bind(args...)
+	if err != nil {
+		return Changes{LastID: -1}, err
+	}
+	return execStatement(ctx, st.db, s)
+}
+
+// Query runs the statement with the given values of its parameters, and returns its results as they are found.
+func (st *Stmt) Query(ctx context.Context, args ...interface{}) (*Rows, error) {
+	s, err := st.statement.Bind(args...)
+	if err != nil {
+		return nil, err
+	}
+	return queryStatement(ctx, st.db, s)
+}
+
+func runExec(ctx context.Context, db Database, query string, compat bool, args []interface{}) (int, error) {
+	st, err := prepare(db, query, compat)
+	if err != nil {
+		return 0, err
+	}
+	return st.Exec(ctx, args...)
+}
+
+func runQuery(ctx context.Context, db Database, query string, compat bool, args []interface{}) (*Rows, error) {
+	st, err := prepare(db, query, compat)
+	if err != nil {
+		return nil, err
+	}
+	return st.Query(ctx, args...)
+}
+
+// execStatement runs the query to its end.  The count of changed rows is the number of results, except for a single
+// result of the number of rows deleted.
+func execStatement(ctx context.Context, db Database, st Statement) (Changes, error) {
+	c := Changes{LastID: -1}
+	ins, ok := st.(Inserter)
+	inserts := ok && ins.InsertsRows()
+	rows, err := queryStatement(ctx, db, st)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		vals := rows.current.Values()
+		if d := vals["deleted"]; d != nil && len(vals) == 1 {
+			if n, err := strconv.Atoi(*d); err == nil {
+				c.Rows += n
+				continue
+			}
+		}
+		if id := vals[IDColumn]; inserts && id != nil {
+			if n, err := strconv.ParseInt(*id, 10, 64); err == nil {
+				c.LastID = n
+			}
+		}
+		c.Rows++
+	}
+	return c, rows.Err()
+}
+
+func queryStatement(ctx context.Context, db Database, st Statement) (*Rows, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	results, err := st.Execute(ctx, db)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rows := &Rows{ctx: ctx, results: results, cancel: cancel}
+	// the first result is read, to report an error executing the query as an error of the query
+	if !rows.read() {
+		rows.Close()
+		if rows.err != nil {
+			return nil, rows.err
+		}
+		return rows, nil
+	}
+	rows.next = rows.current
+	rows.current = nil
+	return rows, nil
+}
@@ -0,0 +1,258 @@
+package minisql_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eurozulu/miniSQL/minisql"
+	_ "eurozulu/miniSQL/queries"
+)
+
+func openTestDB(t *testing.T, opts minisql.Options) *minisql.MiniDB {
+	db, err := minisql.Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open  %s", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func mustExec(t *testing.T, db *minisql.MiniDB, query string, args ...interface{}) int {
+	n, err := db.Exec(context.Background(), query, args...)
+	if err != nil {
+		t.Fatalf("failed to exec %q  %s", query, err)
+	}
+	return n
+}
+
+func TestMiniDB_ExecQuery(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, minisql.Options{})
+	mustExec(t, db, "CREATE TABLE t (name, qty INTEGER, price REAL, ok BOOLEAN, at TIMESTAMP)")
+	at := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	if n := mustExec(t, db, "INSERT INTO t (name, qty, price, ok, at) VALUES (?, ?, ?, ?, ?)", "it's", 3, 1.5, true, at); n != 1 {
+		t.Fatalf("expected 1 row inserted, found %d", n)
+	}
+	mustExec(t, db, "INSERT INTO t (name, qty) VALUES (?, ?)", "two", 5)
+
+	rows, err := db.Query(ctx, "SELECT name, qty, price, ok, at FROM t ORDER BY qty")
+	if err != nil {
+		t.Fatalf("query failed  %s", err)
+	}
+	if cols := rows.Columns(); len(cols) != 5 || cols[0] != "name" || cols[4] != "at" {
+		t.Fatalf("unexpected columns %v", cols)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		var qty int
+		var price sql.NullFloat64
+		var ok *string
+		var found interface{}
+		if err = rows.Scan(&name, &qty, &price, &ok, &found); err != nil {
+			t.Fatalf("failed to scan  %s", err)
+		}
+		names = append(names, name)
+		if name == "it's" && (qty != 3 || price.Float64 != 1.5 || ok == nil || *ok != "true" || found != at.Format(time.RFC3339Nano)) {
+			t.Fatalf("unexpected row %d %v %v %v", qty, price, ok, found)
+		}
+		if name == "two" && (qty != 5 || price.Valid || ok != nil || found != nil) {
+			t.Fatalf("expected NULL values, found %v %v %v", price, ok, found)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("rows failed  %s", err)
+	}
+	if len(names) != 2 || names[0] != "it's" || names[1] != "two" {
+		t.Fatalf("unexpected rows %v", names)
+	}
+
+	var found time.Time
+	var ok bool
+	rows, _ = db.Query(ctx, "SELECT at, ok FROM t WHERE qty = ?", 3)
+	if !rows.Next() {
+		t.Fatalf("expected a row  %v", rows.Err())
+	}
+	if err = rows.Scan(&found, &ok); err != nil || !found.Equal(at) || !ok {
+		t.Fatalf("unexpected values %v %v  %v", found, ok, err)
+	}
+	_ = rows.Close()
+
+	rows, _ = db.Query(ctx, "SELECT price FROM t WHERE qty = ?", 5)
+	var price float64
+	if !rows.Next() || rows.Scan(&price) == nil {
+		t.Fatalf("expected error scanning NULL into float64")
+	}
+	_ = rows.Close()
+
+	if n := mustExec(t, db, "UPDATE t SET qty = ? WHERE name = ?", 7, "two"); n != 1 {
+		t.Fatalf("expected 1 row updated, found %d", n)
+	}
+	if n := mustExec(t, db, "DELETE FROM t"); n != 2 {
+		t.Fatalf("expected 2 rows deleted, found %d", n)
+	}
+
+	if _, err = db.Query(ctx, "SELECT zz FROM t"); err == nil {
+		t.Fatalf("expected error selecting unknown column")
+	}
+	if _, err = db.Exec(ctx, "INSERT INTO t (qty) VALUES (?)", "abc"); err == nil {
+		t.Fatalf("expected error inserting invalid integer")
+	}
+	if _, err = db.Exec(ctx, "BEGIN"); err == nil {
+		t.Fatalf("expected error beginning a transaction without a session")
+	}
+}
+
+func TestMiniDB_QuotedValues(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, minisql.Options{})
+	mustExec(t, db, "CREATE TABLE t (id INTEGER, s TEXT)")
+	expect := map[int]string{
+		1: `"quoted"`,
+		2: `"abc`,
+		3: `'x'`,
+		4: `it's"`,
+		5: `"quoted"`,
+		6: `'x'`,
+	}
+	mustExec(t, db, `INSERT INTO t (id, s) VALUES (1, '"quoted"'), (2, '"abc'), (3, '''x'''), (4, 'it''s"')`)
+	mustExec(t, db, "INSERT INTO t (id, s) VALUES (?, ?), (?, ?)", 5, `"quoted"`, 6, `'x'`)
+	check := func(when string) {
+		rows, err := db.Query(ctx, "SELECT id, s FROM t")
+		if err != nil {
+			t.Fatalf("query failed  %s", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			var s string
+			if err = rows.Scan(&id, &s); err != nil {
+				t.Fatalf("failed to scan  %s", err)
+			}
+			if s != expect[id] {
+				t.Fatalf("unexpected value of row %d %s, expected %s, found %s", id, when, expect[id], s)
+			}
+		}
+	}
+	check("inserted")
+	mustExec(t, db, `UPDATE t SET s = '"quoted"' WHERE id = 3`)
+	mustExec(t, db, "UPDATE t SET s = ? WHERE id = ?", `'x'`, 4)
+	expect[3], expect[4] = `"quoted"`, `'x'`
+	check("updated")
+}
+
+func TestOpen_Independent(t *testing.T) {
+	db1 := openTestDB(t, minisql.Options{Schema: minisql.Schema{"t": {"a": {}}}})
+	db2 := openTestDB(t, minisql.Options{})
+	mustExec(t, db1, "INSERT INTO t (a) VALUES (1)")
+	if db2.ContainsTable("t") {
+		t.Fatalf("expected databases to be independent")
+	}
+
+	dump := filepath.Join(t.TempDir(), "db.json")
+	if err := minisql.Dump(dump, db1); err != nil {
+		t.Fatalf("failed to dump  %s", err)
+	}
+	db3 := openTestDB(t, minisql.Options{Restore: dump})
+	rows, err := db3.Query(context.Background(), "SELECT a FROM t")
+	if err != nil {
+		t.Fatalf("query failed  %s", err)
+	}
+	var a string
+	if !rows.Next() || rows.Scan(&a) != nil || a != "1" {
+		t.Fatalf("expected restored row, found %q", a)
+	}
+	_ = rows.Close()
+}
+
+func TestSession_Query(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, minisql.Options{Schema: minisql.Schema{"t": {"a": {}}}})
+	s := db.NewSession()
+	for _, q := range []string{"BEGIN", "INSERT INTO t (a) VALUES (1)", "ROLLBACK"} {
+		if _, err := s.Exec(ctx, q); err != nil {
+			t.Fatalf("failed to exec %q  %s", q, err)
+		}
+	}
+	if n := mustExec(t, db, "DELETE FROM t"); n != 0 {
+		t.Fatalf("expected rolled back insert, found %d rows", n)
+	}
+}
+
+func TestSession_Compat(t *testing.T) {
+	ctx := context.Background()
+	schema := minisql.Schema{"t": {"a": {}}}
+	legacy := openTestDB(t, minisql.Options{Schema: schema, Compat: true})
+	standard := openTestDB(t, minisql.Options{Schema: schema})
+	for _, db := range []*minisql.MiniDB{legacy, standard} {
+		mustExec(t, db, "INSERT INTO t (a) VALUES (NULL)")
+	}
+	// NULL equals NULL only in compat mode
+	if n := mustExec(t, legacy, "UPDATE t SET a = 'x' WHERE a = NULL"); n != 1 {
+		t.Fatalf("expected compat database to match NULL = NULL, found %d rows", n)
+	}
+	if n := mustExec(t, standard, "UPDATE t SET a = 'x' WHERE a = NULL"); n != 0 {
+		t.Fatalf("expected standard database to not match NULL = NULL, found %d rows", n)
+	}
+
+	s1, s2 := standard.NewSession(), standard.NewSession()
+	s1.SetCompat(true)
+	if !s1.Compat() || s2.Compat() || !legacy.NewSession().Compat() {
+		t.Fatalf("expected sessions to keep their own mode, starting in the mode of their database")
+	}
+	if n, err := s2.Exec(ctx, "UPDATE t SET a = 'y' WHERE a = NULL"); err != nil || n != 0 {
+		t.Fatalf("expected standard session to not match NULL = NULL, found %d rows  %v", n, err)
+	}
+	if n, err := s1.Exec(ctx, "UPDATE t SET a = 'y' WHERE a = NULL"); err != nil || n != 1 {
+		t.Fatalf("expected compat session to match NULL = NULL, found %d rows  %v", n, err)
+	}
+}
+
+func TestMiniDB_Prepare(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, minisql.Options{Schema: minisql.Schema{"t": {"a": {}, "b": {Type: minisql.INTEGER}}}})
+	ins, err := db.Prepare("INSERT INTO t (a, b) VALUES (?, ?)")
+	if err != nil {
+		t.Fatalf("failed to prepare  %s", err)
+	}
+	if ins.NumParameters() != 2 {
+		t.Fatalf("expected 2 parameters, found %d", ins.NumParameters())
+	}
+	for i := 0; i < 5; i++ {
+		if _, err = ins.Exec(ctx, fmt.Sprintf("r%d", i), i); err != nil {
+			t.Fatalf("failed to exec prepared insert  %s", err)
+		}
+	}
+	if _, err = ins.Exec(ctx, "x"); err == nil {
+		t.Fatalf("expected error with too few arguments")
+	}
+	sel, err := db.Prepare("SELECT a FROM t WHERE b >= $1 ORDER BY b LIMIT $2")
+	if err != nil {
+		t.Fatalf("failed to prepare  %s", err)
+	}
+	rows, err := sel.Query(ctx, 3, 1)
+	if err != nil {
+		t.Fatalf("query failed  %s", err)
+	}
+	var a string
+	if !rows.Next() || rows.Scan(&a) != nil || a != "r3" || rows.Next() {
+		t.Fatalf("expected a single row r3, found %q", a)
+	}
+	if n, err := db.Exec(ctx, "DELETE FROM t WHERE b < ?", 2); err != nil || n != 2 {
+		t.Fatalf("expected 2 rows deleted, found %d  %v", n, err)
+	}
+	if c, err := ins.ExecChanges(ctx, "r5", 5); err != nil || c.Rows != 1 || c.LastID != 5 {
+		t.Fatalf("expected row 5 inserted, found %+v  %v", c, err)
+	}
+	upd, err := db.Prepare("UPDATE t SET a = ? WHERE b > 3")
+	if err != nil {
+		t.Fatalf("failed to prepare  %s", err)
+	}
+	if c, err := upd.ExecChanges(ctx, "z"); err != nil || c.Rows != 2 || c.LastID != -1 {
+		t.Fatalf("expected 2 rows updated and none inserted, found %+v  %v", c, err)
+	}
+}
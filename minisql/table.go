@@ -3,12 +3,23 @@ package minisql
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"sort"
 	"strconv"
+	"sync"
 )
 
+// Table is a set of named columns, holding values for each row key.
+// Tables are safe for concurrent use.  Each method is performed under the tables lock, so any number of
+// reads may take place at once, while a change waits for, and blocks, all other access to the table.
 type Table interface {
+	// ColumnNames gets the names of the columns in the table, excluding the implicit _id column.
 	ColumnNames() []string
-	AlterColumns(cols map[string]bool)
+	// ColumnDef gets the definition of the named column.
+	ColumnDef(name string) (*ColumnDef, error)
+	// AlterColumns adds the given columns, dropping those given as nil.
+	// Existing columns keep their type and data, taking the constraints of their given definition.
+	AlterColumns(cols map[string]*ColumnDef)
 	ContainsID(k Key) bool
 	NextID() Key
 
@@ -19,52 +30,141 @@ type Table interface {
 }
 
 type table struct {
+	lock    sync.RWMutex
 	keys    keyColumn
 	columns map[string]column
+	defs    map[string]*ColumnDef
+	indexes map[string]*index
+	// checks are the parsed CHECK constraints of the columns, parsed when first used.
+	checks map[string]Predicate
+	nextID Key
+	// name and log are set when the table belongs to a durable database, to log its changes under its name.
+	name string
+	log  *wal
 }
 
-func (tb table) ColumnNames() []string {
-	cns := make([]string, len(tb.columns)+1)
-	cns[0] = "_id"
+// attach sets the log the tables changes are written to, before they are made.  A nil log stops the logging.
+func (tb *table) attach(name string, w *wal) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	tb.name = name
+	tb.log = w
+}
+
+func (tb *table) ColumnNames() []string {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	cns := make([]string, len(tb.columns))
 	var i int
 	for cn := range tb.columns {
-		i++
 		cns[i] = cn
+		i++
 	}
+	sort.Strings(cns)
 	return cns
 }
 
-func (tb table) ContainsID(k Key) bool {
-	return tb.keys[k]
+func (tb *table) ColumnDef(name string) (*ColumnDef, error) {
+	if name == IDColumn {
+		return &ColumnDef{Type: INTEGER}, nil
+	}
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	if _, ok := tb.columns[name]; !ok {
+		return nil, fmt.Errorf("%s is not a known column", name)
+	}
+	cd := tb.columnDef(name)
+	return &cd, nil
 }
 
-func (tb table) NextID() Key {
-	nk := Key(0)
-	for k := range tb.keys {
-		if k >= nk {
-			nk = k + 1
-		}
+// columnDef gets a copy of the definition of the named column, with its type.
+func (tb *table) columnDef(name string) ColumnDef {
+	cd := ColumnDef{Type: TEXT}
+	if def := tb.defs[name]; def != nil {
+		cd = *def
+		cd.Type = def.columnType()
 	}
-	return nk
+	return cd
 }
 
-func (tb *table) AlterColumns(cols map[string]bool) {
-	for n, ok := range cols {
-		if !ok {
+func (tb *table) columnType(name string) ColumnType {
+	cd, ok := tb.defs[name]
+	if !ok || cd == nil {
+		return TEXT
+	}
+	return cd.columnType()
+}
+
+func (tb *table) ContainsID(k Key) bool {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	return tb.keys[k]
+}
+
+// NextID gets the key the next inserted row will be given.  Keys are never reused, so deleting rows does not alter the next id.
+func (tb *table) NextID() Key {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	return tb.nextID
+}
+
+func (tb *table) AlterColumns(cols map[string]*ColumnDef) {
+	tb.alterColumns(cols, 0)
+}
+
+// alterColumns alters the columns, logging the change as part of the given transaction, or outside of one when zero.
+func (tb *table) alterColumns(cols map[string]*ColumnDef, tx int64) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if err := tb.log.append(&walRecord{Op: walAlter, Tx: tx, Schema: Schema{tb.name: cols}}); err != nil {
+		log.Println(err)
+		return
+	}
+	for n, cd := range cols {
+		if cd == nil {
 			delete(tb.columns, n)
+			delete(tb.defs, n)
 			continue
 		}
-		if _, ok := tb.columns[n]; !ok {
-			tb.columns[n] = column{}
+		def := *cd
+		if _, ok := tb.columns[n]; ok {
+			// existing columns keep their type and data, taking the constraints of the new definition
+			def.Type = tb.columnType(n)
+			tb.defs[n] = &def
+			continue
 		}
+		def.Type = cd.columnType()
+		tb.columns[n] = tb.defaultColumn(def.Default)
+		tb.defs[n] = &def
+	}
+	tb.checks = nil
+	tb.dropIndexesOfMissingColumns()
+	if err := tb.constrainIndexes(); err != nil {
+		log.Println(err)
 	}
 }
 
-func (tb table) Select(id Key, columns []string) (Values, error) {
+// defaultColumn creates the data of a new column, holding the given default value in every existing row.
+func (tb *table) defaultColumn(value *string) column {
+	col := column{}
+	if value == nil {
+		return col
+	}
+	for k, ok := range tb.keys {
+		if ok {
+			col[k] = *value
+		}
+	}
+	return col
+}
+
+func (tb *table) Select(id Key, columns []string) (Values, error) {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
 	vals := Values{}
 	for _, c := range columns {
 		var v *string
-		if c == "_id" {
+		if c == IDColumn {
 			s := strconv.Itoa(int(id))
 			v = &s
 		} else {
@@ -82,7 +182,7 @@ func (tb table) Select(id Key, columns []string) (Values, error) {
 	return vals, nil
 }
 
-func (tb table) SelectValues(id Key, columns []string) ([]*string, error) {
+func (tb *table) SelectValues(id Key, columns []string) ([]*string, error) {
 	svals, err := tb.Select(id, columns)
 	if err != nil {
 		return nil, err
@@ -96,32 +196,74 @@ func (tb table) SelectValues(id Key, columns []string) ([]*string, error) {
 	return vals, nil
 }
 
-func (tb table) Update(id Key, values Values) error {
-	if !tb.ContainsID(id) {
+func (tb *table) Update(id Key, values Values) error {
+	return tb.update(id, values, 0)
+}
+
+// update updates the row, logging the change as part of the given transaction, or outside of one when zero.
+func (tb *table) update(id Key, values Values, tx int64) error {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if !tb.keys[id] {
 		return fmt.Errorf("%d is not a known _id", id)
 	}
-
-	for k, v := range values {
-		c, ok := tb.columns[k]
-		if !ok {
-			return fmt.Errorf("%s column not known", k)
+	values, err := tb.convertValues(values)
+	if err != nil {
+		return err
+	}
+	if err = tb.checkConstraints(id, values); err != nil {
+		return err
+	}
+	changed := tb.indexesOf(values)
+	for _, ix := range changed {
+		if ix.conflicts(tb.indexValues(ix, id, values), id) {
+			return ix.conflictError(tb.indexValues(ix, id, values))
 		}
+	}
+	if err = tb.log.append(&walRecord{Op: walUpdate, Tx: tx, Table: tb.name, ID: id, Values: values}); err != nil {
+		return err
+	}
+	for _, ix := range changed {
+		ix.remove(tb.indexValues(ix, id, nil), id)
+	}
+	for k, v := range values {
+		c := tb.columns[k]
 		if v != nil {
 			c.Update(id, *v)
 		} else {
 			_ = c.Delete(id)
 		}
 	}
+	for _, ix := range changed {
+		ix.add(tb.indexValues(ix, id, nil), id)
+	}
 	return nil
 }
 
-func (tb table) Delete(id ...Key) []Key {
+func (tb *table) Delete(id ...Key) []Key {
+	return tb.delete(id, 0)
+}
+
+// delete deletes the rows, logging the change as part of the given transaction, or outside of one when zero.
+func (tb *table) delete(id []Key, tx int64) []Key {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
 	var dks []Key
 	for _, k := range id {
 		if tb.keys[k] {
-			tb.keys[k] = false
 			dks = append(dks, k)
 		}
+	}
+	if len(dks) == 0 {
+		return nil
+	}
+	if err := tb.log.append(&walRecord{Op: walDelete, Tx: tx, Table: tb.name, IDs: dks}); err != nil {
+		log.Println(err)
+		return nil
+	}
+	for _, k := range dks {
+		tb.removeFromIndexes(k)
+		tb.keys[k] = false
 		for _, col := range tb.columns {
 			delete(col, k)
 		}
@@ -129,53 +271,280 @@ func (tb table) Delete(id ...Key) []Key {
 	return dks
 }
 
-func (tb table) Insert(values Values) (Key, error) {
-	id := tb.NextID()
+func (tb *table) Insert(values Values) (Key, error) {
+	return tb.insert(values, 0)
+}
+
+// insert inserts the row, logging the change as part of the given transaction, or outside of one when zero.
+// The row is given the next id, unless the values hold an _id which has not been used.
+func (tb *table) insert(values Values, tx int64) (Key, error) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	id := tb.nextID
+	if v, ok := values[IDColumn]; ok {
+		k, err := tb.unusedID(v)
+		if err != nil {
+			return -1, err
+		}
+		id = k
+		vals := Values{}
+		for cn, v := range values {
+			if cn != IDColumn {
+				vals[cn] = v
+			}
+		}
+		values = vals
+	}
+	values, err := tb.convertValues(values)
+	if err != nil {
+		return -1, err
+	}
+	for cn, cd := range tb.defs {
+		if _, ok := values[cn]; !ok && cd != nil && cd.Default != nil {
+			dv := *cd.Default
+			values[cn] = &dv
+		}
+	}
+	if err = tb.checkConstraints(id, values); err != nil {
+		return -1, err
+	}
+	for _, ix := range tb.indexes {
+		if ix.conflicts(tb.indexValues(ix, id, values), id) {
+			return -1, ix.conflictError(tb.indexValues(ix, id, values))
+		}
+	}
+	if err = tb.log.append(&walRecord{Op: walInsert, Tx: tx, Table: tb.name, ID: id, Values: values}); err != nil {
+		return -1, err
+	}
+	for k, v := range values {
+		if v != nil {
+			tb.columns[k][id] = *v
+		}
+	}
+	tb.keys[id] = true
+	tb.addToIndexes(id)
+	if id >= tb.nextID {
+		tb.nextID = id + 1
+	}
+	return id, nil
+}
+
+// unusedID parses the given _id of a row to insert, which must not be the id of any row, including those deleted.
+func (tb *table) unusedID(v *string) (Key, error) {
+	if v == nil {
+		return -1, fmt.Errorf("%s can not be NULL", IDColumn)
+	}
+	k, err := strconv.ParseInt(*v, 10, 64)
+	if err != nil || k < 0 {
+		return -1, fmt.Errorf("%q is not a valid %s", *v, IDColumn)
+	}
+	if _, ok := tb.keys[Key(k)]; ok {
+		return -1, fmt.Errorf("%s %d is already used", IDColumn, k)
+	}
+	return Key(k), nil
+}
+
+// restoreRow sets the row of the given key to the given values, as they were previously stored, without validation.
+// When exists is false, the row is removed.
+func (tb *table) restoreRow(id Key, values Values, exists bool) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if err := tb.log.append(&walRecord{Op: walRow, Table: tb.name, ID: id, Values: values, Exists: exists}); err != nil {
+		log.Println(err)
+		return
+	}
+	if id >= tb.nextID {
+		tb.nextID = id + 1
+	}
+	if tb.keys[id] {
+		tb.removeFromIndexes(id)
+	}
+	if !exists {
+		// the key remains known, as deleted, so it is not reused.
+		tb.keys[id] = false
+		for _, col := range tb.columns {
+			delete(col, id)
+		}
+		return
+	}
+	tb.keys[id] = true
 	for k, v := range values {
-		c, ok := tb.columns[k]
+		col, ok := tb.columns[k]
 		if !ok {
-			return -1, fmt.Errorf("%s column not known", k)
+			continue
 		}
 		if v != nil {
-			if err := c.Insert(id, *v); err != nil {
-				return -1, err
-			}
+			col[id] = *v
 		} else {
-			_ = c.Delete(id)
+			delete(col, id)
 		}
 	}
-	tb.keys[id] = true
-	return id, nil
+	tb.addToIndexes(id)
 }
 
-func (tb table) MarshalJSON() ([]byte, error) {
+// columnState is the data and definition of a column, or nil when the column does not exist.
+type columnState struct {
+	data column
+	def  *ColumnDef
+}
+
+// columnStates gets the current state of the named columns, to return to using restoreColumns.
+// Columns are only ever dropped as a whole, so the state holds the column data itself, rather than a copy.
+func (tb *table) columnStates(names []string) map[string]*columnState {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	states := map[string]*columnState{}
+	for _, n := range names {
+		if col, ok := tb.columns[n]; ok {
+			states[n] = &columnState{data: col, def: tb.defs[n]}
+		} else {
+			states[n] = nil
+		}
+	}
+	return states
+}
+
+// restoreColumns returns columns to a previous state, removing those which did not exist.
+func (tb *table) restoreColumns(states map[string]*columnState) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if tb.log != nil {
+		cols := map[string]*walColumn{}
+		for n, st := range states {
+			if st == nil {
+				cols[n] = nil
+				continue
+			}
+			cols[n] = &walColumn{Data: st.data, Type: st.def.columnType(), Def: st.def}
+		}
+		if err := tb.log.append(&walRecord{Op: walColumns, Table: tb.name, Columns: cols}); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	for n, st := range states {
+		if st == nil {
+			delete(tb.columns, n)
+			delete(tb.defs, n)
+			continue
+		}
+		tb.columns[n] = st.data
+		tb.defs[n] = st.def
+	}
+	tb.checks = nil
+	tb.dropIndexesOfMissingColumns()
+	// restored column data is not in the indexes, so they are rebuilt
+	for n, ix := range tb.indexes {
+		tb.indexes[n], _ = tb.buildIndex(ix.def)
+		tb.indexes[n].constraint = ix.constraint
+	}
+	if err := tb.constrainIndexes(); err != nil {
+		log.Println(err)
+	}
+}
+
+// convertValues validates the given values against the column types, returning the values in their canonical form.
+func (tb *table) convertValues(values Values) (Values, error) {
+	vals := Values{}
+	for k, v := range values {
+		if _, ok := tb.columns[k]; !ok {
+			return nil, fmt.Errorf("%s column not known", k)
+		}
+		if v == nil {
+			vals[k] = nil
+			continue
+		}
+		cv, err := tb.columnType(k).Convert(*v)
+		if err != nil {
+			return nil, fmt.Errorf("column %s  %w", k, err)
+		}
+		vals[k] = &cv
+	}
+	return vals, nil
+}
+
+func (tb *table) MarshalJSON() ([]byte, error) {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	return tb.marshalJSON()
+}
+
+// marshalJSON marshals the table, with the caller holding its lock.
+func (tb *table) marshalJSON() ([]byte, error) {
 	s := &struct {
-		Keys    keyColumn         `json:"Keys"`
-		Columns map[string]column `json:"columns"`
+		Keys    keyColumn             `json:"Keys"`
+		Columns map[string]column     `json:"columns"`
+		Types   map[string]ColumnType `json:"types,omitempty"`
+		Defs    map[string]*ColumnDef `json:"defs,omitempty"`
+		Indexes []IndexDef            `json:"indexes,omitempty"`
 	}{
 		Keys:    tb.keys,
 		Columns: tb.columns,
+		Types:   map[string]ColumnType{},
+		Defs:    map[string]*ColumnDef{},
+		Indexes: tb.indexDefs(),
+	}
+	for cn := range tb.columns {
+		s.Types[cn] = tb.columnType(cn)
+		if cd := tb.defs[cn]; cd != nil && cd.Constraints() != "" {
+			s.Defs[cn] = cd
+		}
 	}
 	return json.Marshal(s)
 }
 
 func (tb *table) UnmarshalJSON(bytes []byte) error {
 	s := &struct {
-		Keys    keyColumn         `json:"Keys"`
-		Columns map[string]column `json:"columns"`
+		Keys    keyColumn             `json:"Keys"`
+		Columns map[string]column     `json:"columns"`
+		Types   map[string]ColumnType `json:"types"`
+		Defs    map[string]*ColumnDef `json:"defs"`
+		Indexes []IndexDef            `json:"indexes"`
 	}{}
 	if err := json.Unmarshal(bytes, s); err != nil {
 		return err
 	}
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
 	tb.keys = s.Keys
 	tb.columns = s.Columns
-	return nil
+	if tb.keys == nil {
+		tb.keys = keyColumn{}
+	}
+	if tb.columns == nil {
+		tb.columns = map[string]column{}
+	}
+	// dumps without types are loaded as TEXT columns
+	tb.defs = map[string]*ColumnDef{}
+	tb.checks = nil
+	for cn := range tb.columns {
+		ct, ok := s.Types[cn]
+		if !ok {
+			ct = TEXT
+		}
+		cd := &ColumnDef{}
+		if def, ok := s.Defs[cn]; ok && def != nil {
+			cd = def
+		}
+		cd.Type = ct
+		tb.defs[cn] = cd
+	}
+	tb.nextID = 0
+	for k := range tb.keys {
+		if k >= tb.nextID {
+			tb.nextID = k + 1
+		}
+	}
+	// indexes are not dumped, only their definitions, so they are rebuilt from the rows
+	return tb.buildIndexes(s.Indexes)
 }
 
-func newTable(columns map[string]bool) Table {
+func newTable(columns map[string]*ColumnDef) Table {
 	t := &table{
 		keys:    keyColumn{},
 		columns: map[string]column{},
+		defs:    map[string]*ColumnDef{},
+		indexes: map[string]*index{},
 	}
 	if len(columns) > 0 {
 		t.AlterColumns(columns)
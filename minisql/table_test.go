@@ -3,7 +3,7 @@ package minisql
 import "testing"
 
 func TestNewTable(t *testing.T) {
-	cols := map[string]bool{"one": true, "two": true, "three": true}
+	cols := map[string]*ColumnDef{"one": {Type: TEXT}, "two": {Type: INTEGER}, "three": {Type: TEXT}}
 	tb := newTable(cols)
 	if tb == nil {
 		t.Fatalf("Newtable returned nil")
@@ -11,7 +11,7 @@ func TestNewTable(t *testing.T) {
 }
 
 func TestTable_ColumnNames(t *testing.T) {
-	cols := map[string]bool{"one": true, "two": true, "three": true}
+	cols := map[string]*ColumnDef{"one": {Type: TEXT}, "two": {Type: INTEGER}, "three": {Type: TEXT}}
 	tb := newTable(cols)
 
 	tns := tb.ColumnNames()
@@ -19,35 +19,35 @@ func TestTable_ColumnNames(t *testing.T) {
 		t.Fatalf("Expected %d columns, found %d", len(cols), len(tns))
 	}
 	for _, tn := range tns {
-		if !cols[tn] {
+		if cols[tn] == nil {
 			t.Fatalf("unexpected column name %s", tn)
 		}
 	}
 }
 
 func TestTable_AlterColumns(t *testing.T) {
-	cols := map[string]bool{"one": true, "two": true, "three": true}
+	cols := map[string]*ColumnDef{"one": {Type: TEXT}, "two": {Type: INTEGER}, "three": {Type: TEXT}}
 	tb := newTable(cols)
 	cns := tb.ColumnNames()
 	if len(cns) != len(cols) {
 		t.Fatalf("Expected %d columns, found %d", len(cols), len(cns))
 	}
 
-	cols["two"] = false
+	cols["two"] = nil
 	tb.AlterColumns(cols)
 	cns = tb.ColumnNames()
 	if len(cns) != (len(cols) - 1) {
 		t.Fatalf("Expected %d columns, found %d", (len(cols) - 1), len(cns))
 	}
 	for _, tn := range cns {
-		if !cols[tn] {
+		if cols[tn] == nil {
 			t.Fatalf("unexpected column name %s", tn)
 		}
 	}
 }
 
 func TestTable_NextID(t *testing.T) {
-	cols := map[string]bool{"one": true, "two": true, "three": true}
+	cols := map[string]*ColumnDef{"one": {Type: TEXT}, "two": {Type: INTEGER}, "three": {Type: TEXT}}
 	vals := []string{"1", "2", "3"}
 
 	tb := newTable(cols)
@@ -57,7 +57,7 @@ func TestTable_NextID(t *testing.T) {
 	}
 	_, err := tb.Insert(Values{"one": &vals[0]})
 	if err != nil {
-		t.Fatalf("Insert failed  %w", err)
+		t.Fatalf("Insert failed  %v", err)
 	}
 	k = tb.NextID()
 	if k != 1 {
@@ -65,12 +65,12 @@ func TestTable_NextID(t *testing.T) {
 	}
 	_, err = tb.Insert(Values{"two": &vals[1]})
 	if err != nil {
-		t.Fatalf("Insert failed  %w", err)
+		t.Fatalf("Insert failed  %v", err)
 	}
 
 	_, err = tb.Insert(Values{"three": &vals[2]})
 	if err != nil {
-		t.Fatalf("Insert failed  %w", err)
+		t.Fatalf("Insert failed  %v", err)
 	}
 	k = tb.NextID()
 	if k != 3 {
@@ -88,3 +88,57 @@ func TestTable_NextID(t *testing.T) {
 		t.Fatalf("Expected %d next id, found %d", 3, k)
 	}
 }
+
+func TestTable_InsertID(t *testing.T) {
+	tb := newTable(map[string]*ColumnDef{"one": {Type: TEXT}})
+	s := func(v string) *string { return &v }
+	for _, id := range []string{"5", "2"} {
+		k, err := tb.Insert(Values{IDColumn: s(id), "one": s("x")})
+		if err != nil {
+			t.Fatalf("Insert of _id %s failed  %v", id, err)
+		}
+		if v, _ := tb.Select(k, []string{IDColumn}); *v[IDColumn] != id {
+			t.Fatalf("Expected _id %s, found %s", id, *v[IDColumn])
+		}
+	}
+	if k := tb.NextID(); k != 6 {
+		t.Fatalf("Expected %d next id, found %d", 6, k)
+	}
+	tb.Delete(2)
+	for _, id := range []*string{s("5"), s("2"), s("-1"), s("x"), nil} {
+		if _, err := tb.Insert(Values{IDColumn: id}); err == nil {
+			t.Fatalf("Expected insert of _id %v to fail", id)
+		}
+	}
+	if k, err := tb.Insert(Values{}); err != nil || k != 6 {
+		t.Fatalf("Expected insert without _id to be given %d, found %d  %v", 6, k, err)
+	}
+}
+
+func TestTable_InsertTyped(t *testing.T) {
+	cols := map[string]*ColumnDef{"age": {Type: INTEGER}, "name": {Type: TEXT}}
+	tb := newTable(cols)
+	good := "010"
+	id, err := tb.Insert(Values{"age": &good})
+	if err != nil {
+		t.Fatalf("Insert failed  %v", err)
+	}
+	v, err := tb.Select(id, []string{"age"})
+	if err != nil {
+		t.Fatalf("Select failed  %v", err)
+	}
+	if v["age"] == nil || *v["age"] != "10" {
+		t.Fatalf("expected converted value %q, found %v", "10", v["age"])
+	}
+
+	bad := "ten"
+	if _, err = tb.Insert(Values{"age": &bad}); err == nil {
+		t.Fatalf("expected error inserting invalid INTEGER")
+	}
+	if err = tb.Update(id, Values{"age": &bad}); err == nil {
+		t.Fatalf("expected error updating with invalid INTEGER")
+	}
+	if tb.NextID() != 1 {
+		t.Fatalf("Expected failed insert to not use an id")
+	}
+}
@@ -0,0 +1,341 @@
+package minisql
+
+import (
+	"fmt"
+	"sort"
+)
+
+func (tb *table) CreateIndex(def IndexDef) error {
+	return tb.createIndex(def, 0)
+}
+
+// createIndex creates the index, logging the change as part of the given transaction, or outside of one when zero.
+func (tb *table) createIndex(def IndexDef, tx int64) error {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if def.Type == "" {
+		def.Type = ORDERED
+	}
+	if err := tb.validIndex(def); err != nil {
+		return err
+	}
+	if _, ok := tb.indexes[def.Name]; ok {
+		return fmt.Errorf("index %q already exists", def.Name)
+	}
+	ix, err := tb.buildIndex(def)
+	if err != nil {
+		return err
+	}
+	if err = tb.log.append(&walRecord{Op: walCreateIndex, Tx: tx, Table: tb.name, Index: &def}); err != nil {
+		return err
+	}
+	tb.indexes[def.Name] = ix
+	return nil
+}
+
+func (tb *table) DropIndex(name string) error {
+	return tb.dropIndex(name, 0)
+}
+
+// dropIndex drops the index, logging the change as part of the given transaction, or outside of one when zero.
+func (tb *table) dropIndex(name string, tx int64) error {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if ix, ok := tb.indexes[name]; !ok || ix.constraint != "" {
+		return fmt.Errorf("%q is not a known index", name)
+	}
+	if err := tb.log.append(&walRecord{Op: walDropIndex, Tx: tx, Table: tb.name, Index: &IndexDef{Name: name}}); err != nil {
+		return err
+	}
+	delete(tb.indexes, name)
+	return nil
+}
+
+func (tb *table) Indexes() []IndexDef {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	return tb.indexDefs()
+}
+
+// IndexKeys finds the rows within the range using the best index of the column.
+// A HASH index is preferred to find a single value, otherwise the range is found with an ORDERED index.
+func (tb *table) IndexKeys(r KeyRange) ([]Key, bool) {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	if _, ok := tb.columns[r.Column]; !ok {
+		return nil, false
+	}
+	ct := tb.columnType(r.Column)
+	// index values are held in their canonical form
+	for _, b := range []**string{&r.Lower, &r.Upper} {
+		if *b == nil {
+			continue
+		}
+		cv, err := ct.Convert(**b)
+		if err != nil {
+			return nil, false
+		}
+		*b = &cv
+	}
+	if r.Prefix != "" {
+		if ct != TEXT {
+			return nil, false
+		}
+		prefix := r.Prefix
+		r.Lower, r.LowerInclusive = &prefix, true
+	}
+	// the indexes of constraints are also used, in name order along with the others
+	names := make([]string, 0, len(tb.indexes))
+	for n := range tb.indexes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var found *index
+	for _, n := range names {
+		ix := tb.indexes[n]
+		if ix.def.Columns[0] != r.Column {
+			continue
+		}
+		if ix.list == nil {
+			if len(ix.def.Columns) == 1 && r.isEqual(ct) {
+				found = ix
+				break
+			}
+			continue
+		}
+		if found == nil {
+			found = ix
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	keys := found.scan(r)
+	sortKeys(keys)
+	return keys, true
+}
+
+// validIndex checks the definition names an index of known columns.
+func (tb *table) validIndex(def IndexDef) error {
+	if def.Name == "" {
+		return fmt.Errorf("index has no name")
+	}
+	if len(def.Columns) == 0 {
+		return fmt.Errorf("index %s has no columns", def.Name)
+	}
+	seen := map[string]bool{}
+	for _, cn := range def.Columns {
+		if _, ok := tb.columns[cn]; !ok {
+			return fmt.Errorf("%s is not a known column", cn)
+		}
+		if seen[cn] {
+			return fmt.Errorf("column %s appears more than once in index %s", cn, def.Name)
+		}
+		seen[cn] = true
+	}
+	if _, err := ParseIndexType(string(def.Type)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// buildIndex creates a new index of all the rows in the table.
+// A unique index with duplicate values is returned, complete, with an error.
+func (tb *table) buildIndex(def IndexDef) (*index, error) {
+	types := make([]ColumnType, len(def.Columns))
+	for i, cn := range def.Columns {
+		types[i] = tb.columnType(cn)
+	}
+	ix := newIndex(def, types)
+	var err error
+	for k, ok := range tb.keys {
+		if !ok {
+			continue
+		}
+		vals := tb.indexValues(ix, k, nil)
+		if err == nil && ix.conflicts(vals, k) {
+			err = fmt.Errorf("duplicate values %s in unique index %s", Values(tb.rowOf(ix, k)), def.Name)
+		}
+		ix.add(vals, k)
+	}
+	return ix, err
+}
+
+// buildIndexes replaces the indexes of the table with new indexes of the given definitions, built from the rows,
+// along with the indexes of its UNIQUE columns.
+// The caller must hold the table lock, or be its only user.
+func (tb *table) buildIndexes(defs []IndexDef) error {
+	tb.indexes = map[string]*index{}
+	for _, def := range defs {
+		if err := tb.validIndex(def); err != nil {
+			return err
+		}
+		ix, err := tb.buildIndex(def)
+		if err != nil {
+			return err
+		}
+		tb.indexes[def.Name] = ix
+	}
+	return tb.constrainIndexes()
+}
+
+// constrainIndexes creates the unique indexes of the primary key and UNIQUE columns, dropping those no longer constrained.
+// The caller must hold the table lock.
+func (tb *table) constrainIndexes() error {
+	wanted := tb.constraintIndexDefs()
+	for n, ix := range tb.indexes {
+		if def, ok := wanted[n]; ix.constraint != "" && (!ok || !equalNames(def.Columns, ix.def.Columns)) {
+			delete(tb.indexes, n)
+		}
+	}
+	names := make([]string, 0, len(wanted))
+	for n := range wanted {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if _, ok := tb.indexes[n]; ok {
+			continue
+		}
+		def := wanted[n]
+		ix, err := tb.buildIndex(def)
+		ix.constraint = primaryKeyIndex
+		if n != primaryKeyIndex {
+			ix.constraint = "UNIQUE"
+		}
+		tb.indexes[n] = ix
+		if err != nil {
+			return fmt.Errorf("%s constraint failed  %w", ix.constraint, err)
+		}
+	}
+	return nil
+}
+
+// primaryKeyIndex is the name of the unique index of a tables primary key columns.
+const primaryKeyIndex = "PRIMARY KEY"
+
+// constraintIndexDefs gets the definitions of the unique indexes the constraints of the columns need, by name.
+func (tb *table) constraintIndexDefs() map[string]IndexDef {
+	defs := map[string]IndexDef{}
+	var pk []string
+	for cn, cd := range tb.defs {
+		if cd == nil {
+			continue
+		}
+		if cd.Unique {
+			defs[cn+" UNIQUE"] = IndexDef{Name: cn + " UNIQUE", Columns: []string{cn}, Unique: true, Type: HASH}
+		}
+		if cd.PrimaryKey {
+			pk = append(pk, cn)
+		}
+	}
+	if len(pk) > 0 {
+		sort.Strings(pk)
+		defs[primaryKeyIndex] = IndexDef{Name: primaryKeyIndex, Columns: pk, Unique: true, Type: HASH}
+	}
+	return defs
+}
+
+func equalNames(n1, n2 []string) bool {
+	if len(n1) != len(n2) {
+		return false
+	}
+	for i := range n1 {
+		if n1[i] != n2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// indexDefs gets the definitions of the indexes created by CREATE INDEX, in name order.
+func (tb *table) indexDefs() []IndexDef {
+	defs := make([]IndexDef, 0, len(tb.indexes))
+	for _, ix := range tb.indexes {
+		if ix.constraint == "" {
+			defs = append(defs, ix.def)
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+	return defs
+}
+
+// indexValues gets the values of the indexes columns for the given row, using any given values in place of the rows values.
+func (tb *table) indexValues(ix *index, id Key, values Values) []*string {
+	vals := make([]*string, len(ix.def.Columns))
+	for i, cn := range ix.def.Columns {
+		if v, ok := values[cn]; ok {
+			vals[i] = v
+			continue
+		}
+		if v, ok := tb.columns[cn][id]; ok {
+			vals[i] = &v
+		}
+	}
+	return vals
+}
+
+// rowOf gets the values of the indexes columns for the given row.
+func (tb *table) rowOf(ix *index, id Key) map[string]*string {
+	row := map[string]*string{}
+	for i, v := range tb.indexValues(ix, id, nil) {
+		row[ix.def.Columns[i]] = v
+	}
+	return row
+}
+
+// indexesOf gets the indexes of any of the given columns.
+func (tb *table) indexesOf(values Values) []*index {
+	var ixs []*index
+	for _, ix := range tb.indexes {
+		for _, cn := range ix.def.Columns {
+			if _, ok := values[cn]; ok {
+				ixs = append(ixs, ix)
+				break
+			}
+		}
+	}
+	return ixs
+}
+
+func (tb *table) addToIndexes(id Key) {
+	for _, ix := range tb.indexes {
+		ix.add(tb.indexValues(ix, id, nil), id)
+	}
+}
+
+func (tb *table) removeFromIndexes(id Key) {
+	for _, ix := range tb.indexes {
+		ix.remove(tb.indexValues(ix, id, nil), id)
+	}
+}
+
+// restoreIndexes recreates any of the given indexes the table no longer has.
+func (tb *table) restoreIndexes(defs []IndexDef) {
+	for _, def := range defs {
+		if !tb.hasIndex(def.Name) {
+			_ = tb.CreateIndex(def)
+		}
+	}
+}
+
+func (tb *table) hasIndex(name string) bool {
+	tb.lock.RLock()
+	defer tb.lock.RUnlock()
+	_, ok := tb.indexes[name]
+	return ok
+}
+
+// dropIndexesOfMissingColumns drops any index of a column which no longer exists.
+func (tb *table) dropIndexesOfMissingColumns() {
+	for n, ix := range tb.indexes {
+		for _, cn := range ix.def.Columns {
+			if _, ok := tb.columns[cn]; !ok {
+				delete(tb.indexes, n)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package minisql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnType is the data type of the values held in a column.
+// Values are always stored as strings, in the canonical form of their type, so the type
+// defines how a value is validated when stored and how two values are ordered when compared.
+type ColumnType string
+
+const (
+	TEXT      ColumnType = "TEXT"
+	INTEGER   ColumnType = "INTEGER"
+	REAL      ColumnType = "REAL"
+	BOOLEAN   ColumnType = "BOOLEAN"
+	TIMESTAMP ColumnType = "TIMESTAMP"
+)
+
+// IDColumn is the name of the implicit key column every table has.
+const IDColumn = "_id"
+
+// typeAliases maps alternative type names onto the supported types.
+var typeAliases = map[string]ColumnType{
+	"TEXT":      TEXT,
+	"STRING":    TEXT,
+	"VARCHAR":   TEXT,
+	"CHAR":      TEXT,
+	"INTEGER":   INTEGER,
+	"INT":       INTEGER,
+	"BIGINT":    INTEGER,
+	"REAL":      REAL,
+	"FLOAT":     REAL,
+	"DOUBLE":    REAL,
+	"NUMERIC":   REAL,
+	"BOOLEAN":   BOOLEAN,
+	"BOOL":      BOOLEAN,
+	"TIMESTAMP": TIMESTAMP,
+	"DATETIME":  TIMESTAMP,
+	"DATE":      TIMESTAMP,
+}
+
+// timestampLayouts are the formats accepted for TIMESTAMP values. The first is the canonical form.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseColumnType parses the given type name into a ColumnType.
+// An empty name results in TEXT, the type of untyped columns.
+func ParseColumnType(s string) (ColumnType, error) {
+	if s == "" {
+		return TEXT, nil
+	}
+	ct, ok := typeAliases[strings.ToUpper(s)]
+	if !ok {
+		return "", fmt.Errorf("%q is not a known column type", s)
+	}
+	return ct, nil
+}
+
+// Convert validates the given value as this type and returns it in the types canonical form.
+func (ct ColumnType) Convert(value string) (string, error) {
+	switch ct {
+	case TEXT, "":
+		return value, nil
+	case INTEGER:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid %s", value, ct)
+		}
+		return strconv.FormatInt(i, 10), nil
+	case REAL:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid %s", value, ct)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case BOOLEAN:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid %s", value, ct)
+		}
+		return strconv.FormatBool(b), nil
+	case TIMESTAMP:
+		t, err := ParseTimestamp(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid %s", value, ct)
+		}
+		return t.Format(time.RFC3339Nano), nil
+	default:
+		return "", fmt.Errorf("%q is not a known column type", string(ct))
+	}
+}
+
+// Compare compares two values as this type, returning -1, 0 or 1 when v1 is less than, equal or greater than v2.
+// Values which can not be read as the type are compared as TEXT.
+func (ct ColumnType) Compare(v1, v2 string) int {
+	switch ct {
+	case INTEGER, REAL:
+		i1, err1 := strconv.ParseInt(strings.TrimSpace(v1), 10, 64)
+		i2, err2 := strconv.ParseInt(strings.TrimSpace(v2), 10, 64)
+		if err1 == nil && err2 == nil {
+			return compareOrdered(i1 < i2, i1 == i2)
+		}
+		f1, err1 := strconv.ParseFloat(strings.TrimSpace(v1), 64)
+		f2, err2 := strconv.ParseFloat(strings.TrimSpace(v2), 64)
+		if err1 == nil && err2 == nil {
+			return compareOrdered(f1 < f2, f1 == f2)
+		}
+	case BOOLEAN:
+		b1, err1 := strconv.ParseBool(strings.TrimSpace(v1))
+		b2, err2 := strconv.ParseBool(strings.TrimSpace(v2))
+		if err1 == nil && err2 == nil {
+			return compareOrdered(!b1 && b2, b1 == b2)
+		}
+	case TIMESTAMP:
+		t1, err1 := ParseTimestamp(v1)
+		t2, err2 := ParseTimestamp(v2)
+		if err1 == nil && err2 == nil {
+			return compareOrdered(t1.Before(t2), t1.Equal(t2))
+		}
+	}
+	return strings.Compare(v1, v2)
+}
+
+// CompareValues compares two values, which may be NULL, as this type.  NULL is ordered before all other values.
+func (ct ColumnType) CompareValues(v1, v2 *string) int {
+	switch {
+	case v1 == nil && v2 == nil:
+		return 0
+	case v1 == nil:
+		return -1
+	case v2 == nil:
+		return 1
+	}
+	return ct.Compare(*v1, *v2)
+}
+
+func compareOrdered(less, equal bool) int {
+	if equal {
+		return 0
+	}
+	if less {
+		return -1
+	}
+	return 1
+}
+
+func (ct *ColumnType) UnmarshalJSON(bytes []byte) error {
+	var s string
+	if err := json.Unmarshal(bytes, &s); err != nil {
+		return err
+	}
+	t, err := ParseColumnType(s)
+	if err != nil {
+		return err
+	}
+	*ct = t
+	return nil
+}
+
+// ParseTimestamp parses the given string as a timestamp, using any of the supported TIMESTAMP formats.
+func ParseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	var err error
+	for _, l := range timestampLayouts {
+		var t time.Time
+		if t, err = time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
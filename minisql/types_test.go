@@ -0,0 +1,82 @@
+package minisql
+
+import "testing"
+
+func TestParseColumnType(t *testing.T) {
+	ct, err := ParseColumnType("")
+	if err != nil {
+		t.Fatalf("unexpected error parsing empty type  %v", err)
+	}
+	if ct != TEXT {
+		t.Fatalf("expected empty type to be %s, found %s", TEXT, ct)
+	}
+	ct, err = ParseColumnType("int")
+	if err != nil {
+		t.Fatalf("unexpected error parsing int type  %v", err)
+	}
+	if ct != INTEGER {
+		t.Fatalf("expected int type to be %s, found %s", INTEGER, ct)
+	}
+	if _, err = ParseColumnType("blob"); err == nil {
+		t.Fatalf("expected error parsing unknown type")
+	}
+}
+
+func TestColumnType_Convert(t *testing.T) {
+	tests := []struct {
+		Type     ColumnType
+		Value    string
+		Expected string
+		Fails    bool
+	}{
+		{TEXT, " any thing ", " any thing ", false},
+		{INTEGER, " 42", "42", false},
+		{INTEGER, "4.2", "", true},
+		{REAL, "4.50", "4.5", false},
+		{REAL, "four", "", true},
+		{BOOLEAN, "TRUE", "true", false},
+		{BOOLEAN, "0", "false", false},
+		{BOOLEAN, "yes", "", true},
+		{TIMESTAMP, "2021-02-03", "2021-02-03T00:00:00Z", false},
+		{TIMESTAMP, "2021-02-03 10:11:12", "2021-02-03T10:11:12Z", false},
+		{TIMESTAMP, "yesterday", "", true},
+	}
+	for _, test := range tests {
+		v, err := test.Type.Convert(test.Value)
+		if test.Fails {
+			if err == nil {
+				t.Fatalf("expected error converting %q to %s", test.Value, test.Type)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error converting %q to %s  %v", test.Value, test.Type, err)
+		}
+		if v != test.Expected {
+			t.Fatalf("unexpected value converting %q to %s. Expected %q, found %q", test.Value, test.Type, test.Expected, v)
+		}
+	}
+}
+
+func TestColumnType_Compare(t *testing.T) {
+	tests := []struct {
+		Type     ColumnType
+		V1, V2   string
+		Expected int
+	}{
+		{TEXT, "10", "9", -1},
+		{INTEGER, "10", "9", 1},
+		{INTEGER, "-3", "2", -1},
+		{REAL, "1.0", "1", 0},
+		{REAL, "2.5", "10", -1},
+		{BOOLEAN, "false", "true", -1},
+		{TIMESTAMP, "2021-02-03", "2021-02-03T00:00:00Z", 0},
+		{TIMESTAMP, "2021-12-01", "2022-01-01", -1},
+		{INTEGER, "abc", "abd", -1},
+	}
+	for _, test := range tests {
+		if c := test.Type.Compare(test.V1, test.V2); c != test.Expected {
+			t.Fatalf("unexpected compare of %s %q and %q. Expected %d, found %d", test.Type, test.V1, test.V2, test.Expected, c)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package minisql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// SnapshotFile is the name of the snapshot, in a durable database's directory.
+	SnapshotFile = "snapshot.db"
+	// jsonSnapshotFile is the name of the snapshot written in JSON, by earlier versions, loaded when there is no SnapshotFile.
+	jsonSnapshotFile = "snapshot.json"
+	// WALFile is the name of the write-ahead log, in a durable database's directory.
+	WALFile = "wal.log"
+)
+
+type walOp string
+
+const (
+	walInsert  walOp = "insert"
+	walUpdate  walOp = "update"
+	walDelete  walOp = "delete"
+	walAlter   walOp = "alter"
+	walRow     walOp = "row"
+	walColumns walOp = "columns"
+	walTable   walOp = "table"
+
+	walCreateIndex walOp = "create_index"
+	walDropIndex   walOp = "drop_index"
+
+	walBegin    walOp = "begin"
+	walCommit   walOp = "commit"
+	walRollback walOp = "rollback"
+)
+
+// errTransactionOpen is the error of a checkpoint made while a transaction is in progress.
+var errTransactionOpen = errors.New("can not checkpoint while a transaction is in progress")
+
+// walRecord is a single change to the database, as written to the write-ahead log, one JSON record per line.
+// A change made inside a transaction carries the id of the transaction, and is only replayed once the transaction commits.
+type walRecord struct {
+	Op      walOp                 `json:"op"`
+	Tx      int64                 `json:"tx,omitempty"`
+	Table   string                `json:"table,omitempty"`
+	ID      Key                   `json:"id,omitempty"`
+	IDs     []Key                 `json:"ids,omitempty"`
+	Exists  bool                  `json:"exists,omitempty"`
+	Values  Values                `json:"values,omitempty"`
+	Schema  Schema                `json:"schema,omitempty"`
+	Columns map[string]*walColumn `json:"columns,omitempty"`
+	Data    *table                `json:"data,omitempty"`
+	Index   *IndexDef             `json:"index,omitempty"`
+}
+
+// walColumn is the data and definition of a column restored by a rollback, or nil when the column is removed.
+// Logs written before columns had constraints hold only the columns type.
+type walColumn struct {
+	Data column     `json:"data"`
+	Type ColumnType `json:"type"`
+	Def  *ColumnDef `json:"def,omitempty"`
+}
+
+// wal is an append only log of every change made to a durable database.
+// Each change is written, and synced to disk, before it is applied.
+// Transactions are marked in the log by begin, and commit or rollback, records.
+type wal struct {
+	lock sync.Mutex
+	file *os.File
+	// lastTx is the id of the last transaction begun, and open holds those not yet ended.
+	lastTx int64
+	open   map[int64]bool
+}
+
+// append writes the given record to the end of the log.  A nil log, of a database which is not durable, ignores the record.
+func (w *wal) append(r *walRecord) error {
+	if w == nil {
+		return nil
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.write(r)
+}
+
+// write writes the given record to the end of the log.  The caller must hold the log lock.
+// A closed log ignores the record.
+func (w *wal) write(r *walRecord) error {
+	if w.file == nil {
+		return nil
+	}
+	by, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = w.file.Write(append(by, '\n')); err != nil {
+		return fmt.Errorf("failed to write to log  %w", err)
+	}
+	return w.file.Sync()
+}
+
+// begin starts a new transaction in the log, returning its id.  A nil log returns zero, logging changes outside of any transaction.
+func (w *wal) begin() (int64, error) {
+	if w == nil {
+		return 0, nil
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.lastTx++
+	if err := w.write(&walRecord{Op: walBegin, Tx: w.lastTx}); err != nil {
+		return 0, err
+	}
+	if w.open == nil {
+		w.open = map[int64]bool{}
+	}
+	w.open[w.lastTx] = true
+	return w.lastTx, nil
+}
+
+// end ends the given transaction, with a commit or rollback record.
+func (w *wal) end(tx int64, op walOp) error {
+	if w == nil || tx == 0 {
+		return nil
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.open, tx)
+	return w.write(&walRecord{Op: op, Tx: tx})
+}
+
+// close closes the log file.  Any record written after it is closed is ignored.
+func (w *wal) close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// reset empties the log, once its changes are held in a snapshot.  The caller must hold the log lock.
+func (w *wal) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// OpenDatabase opens a durable database, held in the given directory.
+// The database is loaded from the last snapshot, and the changes in the write-ahead log since that snapshot are replayed on top of it.
+// From then on, every change to the database is appended to the log before it is made, so nothing is lost should the process end
+// without a Checkpoint.  The directory is created if it does not exist.
+func OpenDatabase(dir string) (*MiniDB, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	db := NewDatabase(nil)
+	db.dir = dir
+	err := Restore(filepath.Join(dir, SnapshotFile), db)
+	if os.IsNotExist(err) {
+		err = Restore(filepath.Join(dir, jsonSnapshotFile), db)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load snapshot  %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, WALFile), os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, err
+	}
+	lastTx, err := db.replay(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to replay log  %w", err)
+	}
+	db.attachLog(&wal{file: f, lastTx: lastTx})
+	return db, nil
+}
+
+// replay applies the changes in the log to the database, leaving the file positioned at the end of the last complete record.
+// A record cut short by a crash while it was being written is discarded.
+// The changes of a transaction are only applied when the log holds its commit, so those rolled back, or in progress when
+// the log was last written, are discarded.  The id of the last transaction in the log is returned.
+func (db *MiniDB) replay(f *os.File) (int64, error) {
+	committed := map[int64]bool{}
+	var lastTx int64
+	offset, err := readLog(f, func(rec *walRecord) error {
+		if rec.Op == walCommit {
+			committed[rec.Tx] = true
+		}
+		if rec.Tx > lastTx {
+			lastTx = rec.Tx
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	_, err = readLog(f, func(rec *walRecord) error {
+		switch {
+		case rec.Op == walBegin || rec.Op == walCommit || rec.Op == walRollback:
+			return nil
+		case rec.Tx != 0 && !committed[rec.Tx]:
+			return nil
+		}
+		return db.applyRecord(rec)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	return lastTx, err
+}
+
+// readLog reads each complete record in the log, from the current position, returning the offset following the last one.
+func readLog(f *os.File, read func(rec *walRecord) error) (int64, error) {
+	r := bufio.NewReader(f)
+	var offset int64
+	var line int
+	for {
+		by, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// any partial record, without its line end, was never completely written.
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		line++
+		offset += int64(len(by))
+		if len(bytes.TrimSpace(by)) == 0 {
+			continue
+		}
+		rec := &walRecord{}
+		if err := json.Unmarshal(by, rec); err != nil {
+			return 0, fmt.Errorf("record %d is invalid  %w", line, err)
+		}
+		if err := read(rec); err != nil {
+			return 0, fmt.Errorf("record %d failed  %w", line, err)
+		}
+	}
+	return offset, nil
+}
+
+func (db *MiniDB) applyRecord(rec *walRecord) error {
+	switch rec.Op {
+	case walAlter:
+		db.AlterDatabase(rec.Schema)
+		return nil
+	case walTable:
+		if rec.Data == nil {
+			db.setTable(rec.Table, nil)
+		} else {
+			db.setTable(rec.Table, rec.Data)
+		}
+		return nil
+	}
+	t, err := db.Table(rec.Table)
+	if err != nil {
+		return err
+	}
+	tb, ok := t.(*table)
+	if !ok {
+		return fmt.Errorf("%q can not be replayed", rec.Table)
+	}
+	switch rec.Op {
+	case walInsert, walUpdate:
+		tb.restoreRow(rec.ID, rec.Values, true)
+	case walRow:
+		tb.restoreRow(rec.ID, rec.Values, rec.Exists)
+	case walDelete:
+		tb.Delete(rec.IDs...)
+	case walCreateIndex:
+		return tb.CreateIndex(*rec.Index)
+	case walDropIndex:
+		return tb.DropIndex(rec.Index.Name)
+	case walColumns:
+		states := map[string]*columnState{}
+		for cn, c := range rec.Columns {
+			if c == nil {
+				states[cn] = nil
+				continue
+			}
+			def := c.Def
+			if def == nil {
+				def = &ColumnDef{Type: c.Type}
+			}
+			states[cn] = &columnState{data: c.Data, def: def}
+		}
+		tb.restoreColumns(states)
+	default:
+		return fmt.Errorf("%q is not a known log operation", rec.Op)
+	}
+	return nil
+}
+
+// attachLog starts logging all changes to the database, and its tables, to the given log.
+func (db *MiniDB) attachLog(w *wal) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.log = w
+	for tn, t := range db.tables {
+		if tb, ok := t.(*table); ok {
+			tb.attach(tn, w)
+		}
+	}
+}
+
+// Durable checks if the database was opened with OpenDatabase, logging all its changes.
+func (db *MiniDB) Durable() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.log != nil
+}
+
+// Checkpoint writes a new snapshot of a durable database and empties its write-ahead log.
+// The snapshot is written to a temporary file, which then replaces the previous snapshot, so a crash
+// during a checkpoint leaves the previous snapshot, and the log, intact.
+// All changes wait for the checkpoint to complete.
+// A snapshot would hold the uncommitted changes of any transaction in progress, so the checkpoint fails while there is one.
+func (db *MiniDB) Checkpoint() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.log == nil {
+		return fmt.Errorf("database is not durable")
+	}
+	// hold every table, so no change is made between the snapshot and emptying the log.
+	tables := map[string]*table{}
+	for tn, t := range db.tables {
+		tb, ok := t.(*table)
+		if !ok {
+			continue
+		}
+		tb.lock.RLock()
+		defer tb.lock.RUnlock()
+		tables[tn] = tb
+	}
+	db.log.lock.Lock()
+	defer db.log.lock.Unlock()
+	if len(db.log.open) > 0 {
+		return errTransactionOpen
+	}
+
+	err := writeFileAtomic(filepath.Join(db.dir, SnapshotFile), func(w io.Writer) error {
+		return writeBinary(w, tables, false)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot  %w", err)
+	}
+	if err = db.log.reset(); err != nil {
+		return err
+	}
+	// a JSON snapshot, of an earlier version, is replaced by the new snapshot
+	if err = os.Remove(filepath.Join(db.dir, jsonSnapshotFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Close checkpoints a durable database and closes its log.  Further changes to the database are no longer logged.
+// While a transaction is in progress, the log is closed without a checkpoint, so the transaction is discarded when the log is replayed.
+func (db *MiniDB) Close() error {
+	if !db.Durable() {
+		return nil
+	}
+	err := db.Checkpoint()
+	if err == errTransactionOpen {
+		err = nil
+	}
+	db.lock.Lock()
+	w := db.log
+	db.lock.Unlock()
+	db.attachLog(nil)
+	if cerr := w.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeFileAtomic writes a file by writing to a temporary file in the same directory, then renaming it to the given name.
+// Readers of the file see either the previous file or the completely written file, never a partial one.
+func writeFileAtomic(filename string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if err = write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0640); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs a directory, so a rename within it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	// not all platforms support syncing a directory
+	_ = d.Sync()
+	return nil
+}
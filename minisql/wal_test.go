@@ -0,0 +1,248 @@
+package minisql
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func selectName(t *testing.T, db *MiniDB, tn string, id Key) *string {
+	tb, err := db.Table(tn)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	v, err := tb.Select(id, []string{"name"})
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	return v["name"]
+}
+
+// crash abandons a durable database without a checkpoint, as if the process had ended.
+func crash(db *MiniDB) {
+	_ = db.log.file.Close()
+	db.attachLog(nil)
+}
+
+func TestOpenDatabase_Replay(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if !db.Durable() {
+		t.Fatalf("expected database to be durable")
+	}
+	db.AlterDatabase(Schema{"t": {"name": {Type: TEXT}, "qty": {Type: INTEGER}}})
+	db.AlterDatabase(Schema{"gone": {"x": {Type: TEXT}}})
+	tb, _ := db.Table("t")
+	if err := tb.(Indexer).CreateIndex(IndexDef{Name: "byname", Columns: []string{"name"}, Unique: true}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	for _, n := range []string{"one", "two", "three"} {
+		s := n
+		if _, err := tb.Insert(Values{"name": &s}); err != nil {
+			t.Fatalf("unexpected error  %v", err)
+		}
+	}
+	uno := "uno"
+	if err := tb.Update(0, Values{"name": &uno}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	tb.Delete(1)
+	db.AlterDatabase(Schema{"t": {"price": {Type: REAL}}, "gone": nil})
+
+	s := db.NewSession()
+	_ = s.Begin()
+	stb, _ := s.Table("t")
+	four := "four"
+	_, _ = stb.Insert(Values{"name": &four})
+	stb.Delete(0)
+	s.AlterDatabase(Schema{"t": nil})
+	_ = s.Rollback()
+	crash(db)
+
+	db, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	if db.ContainsTable("gone") {
+		t.Fatalf("expected dropped table to remain dropped")
+	}
+	tb, err = db.Table("t")
+	if err != nil {
+		t.Fatalf("expected table to be replayed  %v", err)
+	}
+	if cd, err := tb.ColumnDef("price"); err != nil || cd.Type != REAL {
+		t.Fatalf("expected replayed column price %s, found %v  %v", REAL, cd, err)
+	}
+	if v := selectName(t, db, "t", 0); v == nil || *v != "uno" {
+		t.Fatalf("expected updated row to be %q, found %v", "uno", v)
+	}
+	if tb.ContainsID(1) {
+		t.Fatalf("expected deleted row to remain deleted")
+	}
+	if !tb.ContainsID(2) || tb.ContainsID(3) {
+		t.Fatalf("expected only the committed rows to be replayed")
+	}
+	uno = "uno"
+	if keys, ok := tb.(Indexer).IndexKeys(KeyRange{Column: "name", Lower: &uno, Upper: &uno,
+		LowerInclusive: true, UpperInclusive: true}); !ok || len(keys) != 1 || keys[0] != 0 {
+		t.Fatalf("expected replayed index to find row %d, found %v", 0, keys)
+	}
+	if tb.NextID() != 4 {
+		t.Fatalf("expected next id %d, found %d", 4, tb.NextID())
+	}
+}
+
+func TestOpenDatabase_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	db.AlterDatabase(Schema{"t": {"name": {Type: TEXT}}})
+	tb, _ := db.Table("t")
+	one := "one"
+	_, _ = tb.Insert(Values{"name": &one})
+	// a record cut short by a crash, part way through writing it
+	if _, err := db.log.file.WriteString(`{"op":"insert","table":"t","id":1,"val`); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	crash(db)
+
+	db, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	tb, _ = db.Table("t")
+	if !tb.ContainsID(0) || tb.ContainsID(1) {
+		t.Fatalf("expected only the complete record to be replayed")
+	}
+	two := "two"
+	if _, err := tb.Insert(Values{"name": &two}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	crash(db)
+
+	db, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("expected the torn record to have been discarded  %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	if v := selectName(t, db, "t", 1); v == nil || *v != "two" {
+		t.Fatalf("expected row after torn record to be %q, found %v", "two", v)
+	}
+}
+
+func TestMiniDB_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	db.AlterDatabase(Schema{"t": {"name": {Type: TEXT}}})
+	tb, _ := db.Table("t")
+	one := "one"
+	_, _ = tb.Insert(Values{"name": &one})
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, WALFile))
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if fi.Size() != 0 {
+		t.Fatalf("expected empty log after checkpoint, found %d bytes", fi.Size())
+	}
+	two := "two"
+	_, _ = tb.Insert(Values{"name": &two})
+	crash(db)
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) > 0 {
+		t.Fatalf("expected no temporary files, found %v", matches)
+	}
+	db, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	for k, expect := range map[Key]string{0: "one", 1: "two"} {
+		if v := selectName(t, db, "t", k); v == nil || *v != expect {
+			t.Fatalf("expected row %d to be %q, found %v", k, expect, v)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if db.Durable() {
+		t.Fatalf("expected closed database to no longer be durable")
+	}
+	restored := NewDatabase(nil)
+	if err := Restore(filepath.Join(dir, SnapshotFile), restored); err != nil {
+		t.Fatalf("expected snapshot to be a valid dump  %v", err)
+	}
+	if !restored.ContainsTable("t") {
+		t.Fatalf("expected snapshot to contain the table")
+	}
+}
+
+func TestOpenDatabase_Transactions(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	db.AlterDatabase(Schema{"t": {"name": {Type: TEXT}}})
+	committed := db.NewSession()
+	open := db.NewSession()
+	_ = committed.Begin()
+	_ = open.Begin()
+	ctb, _ := committed.Table("t")
+	otb, _ := open.Table("t")
+	one, two := "one", "two"
+	_, _ = ctb.Insert(Values{"name": &one})
+	_, _ = otb.Insert(Values{"name": &two})
+	open.AlterDatabase(Schema{"u": {"x": {Type: TEXT}}})
+	if err := committed.Commit(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if err := db.Checkpoint(); err == nil {
+		t.Fatalf("expected checkpoint to fail while a transaction is in progress")
+	}
+	crash(db)
+
+	db, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	tb, _ := db.Table("t")
+	if !tb.ContainsID(0) || tb.ContainsID(1) || db.ContainsTable("u") {
+		t.Fatalf("expected only the committed transaction to be replayed")
+	}
+	s := db.NewSession()
+	_ = s.Begin()
+	stb, _ := s.Table("t")
+	three := "three"
+	_, _ = stb.Insert(Values{"name": &three})
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+
+	db, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	tb, _ = db.Table("t")
+	if !tb.ContainsID(0) || tb.ContainsID(2) {
+		t.Fatalf("expected the transaction in progress when closed to be discarded")
+	}
+}
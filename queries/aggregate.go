@@ -0,0 +1,517 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/queries/whereclause"
+	"eurozulu/miniSQL/stringutil"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The aggregate functions, which reduce the values of a column, in a group of rows, to a single value.
+const (
+	COUNT = whereclause.COUNT
+	SUM   = whereclause.SUM
+	AVG   = whereclause.AVG
+	MIN   = whereclause.MIN
+	MAX   = whereclause.MAX
+)
+
+// aggregate is an aggregate function of a column.  e.g. COUNT(*), SUM(price) or COUNT(DISTINCT name)
+type aggregate struct {
+	Function string
+	// Column is the column aggregated, or "*" to count the rows.
+	Column string
+	// Distinct only aggregates the different values of the column.
+	Distinct bool
+}
+
+// String gets the aggregate in its standard form, as read by whereclause.ReadColumnName.
+func (a aggregate) String() string {
+	col := a.Column
+	if a.Distinct {
+		col = "DISTINCT " + col
+	}
+	return fmt.Sprintf("%s(%s)", a.Function, col)
+}
+
+// columnType gets the type of the aggregated value, from the type of the column in the given table.
+func (a aggregate) columnType(t minisql.Table) (minisql.ColumnType, error) {
+	if a.Function == COUNT {
+		return minisql.INTEGER, nil
+	}
+	cd, err := t.ColumnDef(a.Column)
+	if err != nil {
+		return "", err
+	}
+	switch a.Function {
+	case AVG:
+		return minisql.REAL, nil
+	case SUM:
+		if cd.Type == minisql.INTEGER {
+			return minisql.INTEGER, nil
+		}
+		return minisql.REAL, nil
+	default:
+		return cd.Type, nil
+	}
+}
+
+// parseAggregate parses a column name, read by whereclause.ReadColumnName, as an aggregate function.
+// Returns nil, without an error, when the name is not an aggregate function.
+func parseAggregate(name string) (*aggregate, error) {
+	i := strings.Index(name, "(")
+	if i < 0 || !strings.HasSuffix(name, ")") {
+		return nil, nil
+	}
+	a := &aggregate{Function: name[:i], Column: name[i+1 : len(name)-1]}
+	if col := strings.TrimPrefix(a.Column, "DISTINCT "); col != a.Column {
+		a.Column = col
+		a.Distinct = true
+	}
+	switch a.Function {
+	case COUNT:
+		if a.Distinct && a.Column == "*" {
+			return nil, fmt.Errorf("COUNT(DISTINCT *) is not supported, count the distinct values of a column")
+		}
+	case SUM, AVG, MIN, MAX:
+		if a.Column == "*" {
+			return nil, fmt.Errorf("%s(*) is not supported, only COUNT(*)", a.Function)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a known aggregate function", a.Function)
+	}
+	return a, nil
+}
+
+// parseAggregates parses the aggregate functions used in the columns, HAVING and ORDER BY of the query, as it is read.
+// Only the names read with brackets, by whereclause.ReadColumnName, are aggregates.  Aggregates can not be used in WHERE.
+func (q SelectQuery) parseAggregates() (map[string]*aggregate, error) {
+	for _, c := range whereclause.ColumnNames(q.Where) {
+		if a, _ := parseAggregate(c); a != nil {
+			return nil, fmt.Errorf("%s can not be used in WHERE, use HAVING to filter aggregates", c)
+		}
+	}
+	names := q.sourceColumns()
+	if q.Having != nil {
+		names = append(names, q.Having.ColumnNames()...)
+	}
+	if q.OrderBy != nil {
+		names = append(names, q.OrderBy.columnNames()...)
+	}
+	var aggs map[string]*aggregate
+	for _, n := range names {
+		a, err := parseAggregate(n)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil {
+			continue
+		}
+		if aggs == nil {
+			aggs = map[string]*aggregate{}
+		}
+		aggs[n] = a
+	}
+	return aggs, nil
+}
+
+// accumulator accumulates the values of a column, in a single group, into the aggregated value.
+type accumulator struct {
+	aggregate *aggregate
+	ct        minisql.ColumnType
+	count     int64
+	// SUM and AVG are summed as integers, until a value is not an integer or the sum overflows.
+	sum     int64
+	realSum float64
+	isReal  bool
+	// value is the MIN or MAX value
+	value *string
+	// seen are the values already aggregated by a DISTINCT aggregate
+	seen map[string]bool
+}
+
+func (ac *accumulator) add(values minisql.Values) error {
+	a := ac.aggregate
+	if a.Column == "*" {
+		ac.count++
+		return nil
+	}
+	v := values[a.Column]
+	if v == nil {
+		// NULL values are never aggregated
+		return nil
+	}
+	if a.Distinct {
+		if ac.seen[*v] {
+			return nil
+		}
+		ac.seen[*v] = true
+	}
+	ac.count++
+	switch a.Function {
+	case SUM, AVG:
+		f, err := strconv.ParseFloat(strings.TrimSpace(*v), 64)
+		if err != nil {
+			return fmt.Errorf("%s failed as %q is not a number", a, *v)
+		}
+		ac.realSum += f
+		if ac.isReal {
+			return nil
+		}
+		i, err := strconv.ParseInt(strings.TrimSpace(*v), 10, 64)
+		sum := ac.sum + i
+		if err != nil || (i > 0 && sum < ac.sum) || (i < 0 && sum > ac.sum) {
+			ac.isReal = true
+			return nil
+		}
+		ac.sum = sum
+	case MIN:
+		if ac.value == nil || ac.ct.Compare(*v, *ac.value) < 0 {
+			ac.value = v
+		}
+	case MAX:
+		if ac.value == nil || ac.ct.Compare(*v, *ac.value) > 0 {
+			ac.value = v
+		}
+	}
+	return nil
+}
+
+// result gets the aggregated value.  Aggregates of no values, other than COUNT, are NULL.
+func (ac *accumulator) result() *string {
+	var s string
+	switch ac.aggregate.Function {
+	case COUNT:
+		s = strconv.FormatInt(ac.count, 10)
+	case SUM:
+		if ac.count == 0 {
+			return nil
+		}
+		if ac.isReal {
+			s = strconv.FormatFloat(ac.realSum, 'g', -1, 64)
+		} else {
+			s = strconv.FormatInt(ac.sum, 10)
+		}
+	case AVG:
+		if ac.count == 0 {
+			return nil
+		}
+		s = strconv.FormatFloat(ac.realSum/float64(ac.count), 'g', -1, 64)
+	default:
+		return ac.value
+	}
+	return &s
+}
+
+func newAccumulator(a *aggregate, ct minisql.ColumnType) *accumulator {
+	ac := &accumulator{aggregate: a, ct: ct}
+	if a.Distinct {
+		ac.seen = map[string]bool{}
+	}
+	return ac
+}
+
+// grouping groups the selected rows by the values of the grouped columns, aggregating the rows of each group into a single result.
+type grouping struct {
+	// GroupBy are the columns grouped by.  Without any, all the rows are a single group.
+	GroupBy []string
+	// Aggregates are the aggregates of each group, keyed by their name.
+	Aggregates map[string]*aggregate
+	// Selected are the columns selected from the table, to group and aggregate.
+	Selected []string
+	// Types are the types of the selected columns and aggregates.
+	Types map[string]minisql.ColumnType
+	// Having is an optional filter of the grouped results.
+	Having whereclause.Expression
+	// Columns are the grouped columns and aggregates of each result, named with the Names.
+	Columns []string
+	Names   []string
+	// Computed are the values of the columns computed from the grouped columns and aggregates, keyed by their column.
+	Computed map[string]whereclause.Value
+}
+
+// group is the values of the grouped columns, shared by all the rows of the group, and the aggregates of those rows.
+type group struct {
+	values       minisql.Values
+	accumulators map[string]*accumulator
+}
+
+// Aggregate reads all the given results, of the Selected columns, and groups them.
+// Once all the results are read, the result of each group, in the order the groups were first found, is sent to the returned channel.
+func (g grouping) Aggregate(ctx context.Context, name string, results <-chan Result) <-chan Result {
+	chOut := make(chan Result)
+	go func(chIn <-chan Result, chOut chan<- Result) {
+		defer close(chOut)
+		send := func(r Result) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case chOut <- r:
+				return true
+			}
+		}
+		groups, err := g.readGroups(ctx, chIn)
+		if err != nil {
+			es := err.Error()
+			send(NewResult(name, minisql.Values{"ERROR": &es}))
+			// drain the remaining results, so the select is not blocked
+			for range chIn {
+			}
+			return
+		}
+		rows, err := g.groupResults(groups)
+		if err != nil {
+			es := err.Error()
+			send(NewResult(name, minisql.Values{"ERROR": &es}))
+			return
+		}
+		for _, r := range rows {
+			if !send(NewResultOfColumns(name, g.Names, r)) {
+				return
+			}
+		}
+	}(results, chOut)
+	return chOut
+}
+
+// groupResults gets the result of each group, named with the result names, leaving out those groups the HAVING clause excludes.
+func (g grouping) groupResults(groups []*group) ([]minisql.Values, error) {
+	var rows []minisql.Values
+	for _, gr := range groups {
+		vals, err := g.groupValues(gr)
+		if err != nil {
+			return nil, err
+		}
+		if g.Having != nil {
+			ok, err := whereclause.Compare(g.Having, vals)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		r := minisql.Values{}
+		for i, c := range g.Columns {
+			r[g.Names[i]] = vals[c]
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// readGroups reads all the results into their groups.  Without any grouped columns, there is always one group, even without any results.
+func (g grouping) readGroups(ctx context.Context, results <-chan Result) ([]*group, error) {
+	var groups []*group
+	byKey := map[string]*group{}
+	if len(g.GroupBy) == 0 {
+		groups = append(groups, g.newGroup(nil))
+		byKey[""] = groups[0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case r, ok := <-results:
+			if !ok {
+				return groups, nil
+			}
+			vals := r.Values()
+			if e, ok := vals["ERROR"]; ok && len(vals) == 1 {
+				return nil, fmt.Errorf("%s", *e)
+			}
+			gk := groupKey(g.GroupBy, vals)
+			gr, ok := byKey[gk]
+			if !ok {
+				gr = g.newGroup(vals)
+				byKey[gk] = gr
+				groups = append(groups, gr)
+			}
+			for _, ac := range gr.accumulators {
+				if err := ac.add(vals); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+func (g grouping) newGroup(values minisql.Values) *group {
+	gr := &group{values: minisql.Values{}, accumulators: map[string]*accumulator{}}
+	for _, c := range g.GroupBy {
+		gr.values[c] = values[c]
+	}
+	for n, a := range g.Aggregates {
+		gr.accumulators[n] = newAccumulator(a, g.Types[a.Column])
+	}
+	return gr
+}
+
+// groupValues gets the values of the grouped columns and the aggregates of the group, also named with their result names.
+func (g grouping) groupValues(gr *group) (minisql.Values, error) {
+	vals := minisql.Values{}
+	for c, v := range gr.values {
+		vals[c] = v
+	}
+	for n, ac := range gr.accumulators {
+		vals[n] = ac.result()
+	}
+	for c, v := range g.Computed {
+		cv, err := whereclause.Evaluate(v, vals)
+		if err != nil {
+			return nil, err
+		}
+		vals[c] = cv
+	}
+	for i, c := range g.Columns {
+		if _, ok := vals[g.Names[i]]; !ok {
+			vals[g.Names[i]] = vals[c]
+		}
+	}
+	return vals, nil
+}
+
+// groupKey encodes the values of the grouped columns into a single key.  NULL values are grouped together.
+func groupKey(columns []string, values minisql.Values) string {
+	buf := strings.Builder{}
+	for _, c := range columns {
+		v := values[c]
+		if v == nil {
+			buf.WriteString("-")
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%d:%s", len(*v), *v))
+	}
+	return buf.String()
+}
+
+// grouping gets the grouping of the query results, when the query has a GROUP BY or HAVING clause, or selects any aggregates.
+// Returns nil when the query selects single rows.
+// Every selected column, which is not an aggregate, must be grouped by.
+func (q SelectQuery) grouping(t minisql.Table) (*grouping, error) {
+	used := q.sourceColumns()
+	if q.Having != nil {
+		used = append(used, q.Having.ColumnNames()...)
+	}
+	if q.OrderBy != nil {
+		used = append(used, q.OrderBy.columnNames()...)
+	}
+	aggs := map[string]*aggregate{}
+	for _, c := range used {
+		if a := q.Aggregates[c]; a != nil {
+			aggs[c] = a
+		}
+	}
+	if len(q.GroupBy) == 0 && len(aggs) == 0 && q.Having == nil {
+		return nil, nil
+	}
+
+	g := &grouping{
+		GroupBy:    q.GroupBy,
+		Aggregates: aggs,
+		Types:      map[string]minisql.ColumnType{},
+		Columns:    q.Columns,
+		Names:      q.Names,
+	}
+	for _, c := range q.GroupBy {
+		if err := validColumn(t, c); err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range q.Columns {
+		if v, ok := q.Expressions[c]; ok {
+			for _, vc := range v.ColumnNames() {
+				if aggs[vc] == nil && !stringutil.Contains(vc, q.GroupBy) {
+					return nil, fmt.Errorf("%s in %s must be in the GROUP BY columns, or used in an aggregate function", vc, q.Names[i])
+				}
+			}
+			continue
+		}
+		if aggs[c] == nil && !stringutil.Contains(c, q.GroupBy) {
+			return nil, fmt.Errorf("%s must be in the GROUP BY columns, or used in an aggregate function", q.Names[i])
+		}
+	}
+
+	g.Selected = append(g.Selected, q.GroupBy...)
+	for n, a := range aggs {
+		if a.Column != "*" && !stringutil.Contains(a.Column, g.Selected) {
+			if err := validColumn(t, a.Column); err != nil {
+				return nil, fmt.Errorf("%w in %s", err, n)
+			}
+			g.Selected = append(g.Selected, a.Column)
+		}
+		ct, err := a.columnType(t)
+		if err != nil {
+			return nil, err
+		}
+		g.Types[n] = ct
+	}
+	for _, c := range g.Selected {
+		cd, err := t.ColumnDef(c)
+		if err != nil {
+			return nil, err
+		}
+		g.Types[c] = cd.Type
+	}
+	for c, v := range q.Expressions {
+		if !stringutil.Contains(c, q.Columns) {
+			continue
+		}
+		if g.Computed == nil {
+			g.Computed = map[string]whereclause.Value{}
+		}
+		g.Computed[c] = whereclause.ValueWithTypes(v, g.Types)
+		if err := whereclause.CheckValueTypes(g.Computed[c]); err != nil {
+			return nil, err
+		}
+		g.Types[c] = valueType(g.Computed[c])
+	}
+	for i, c := range q.Columns {
+		g.Types[q.Names[i]] = g.Types[c]
+	}
+
+	if q.Having != nil {
+		for _, c := range q.Having.ColumnNames() {
+			if _, ok := g.Types[c]; !ok || (!stringutil.Contains(c, q.Names) && !stringutil.Contains(c, q.GroupBy) && aggs[c] == nil) {
+				return nil, fmt.Errorf("%s in HAVING must be in the GROUP BY columns, a selected column or an aggregate function", c)
+			}
+		}
+		g.Having = whereclause.WithTypes(q.Having, g.Types)
+		if err := whereclause.CheckTypes(g.Having); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// readGroupBy reads an optional GROUP BY clause, a comma delimited list of column names, and an optional HAVING clause following it.
+func readGroupBy(ts *lexer.TokenStream, compat bool) ([]string, whereclause.Expression, error) {
+	var cols []string
+	if ts.AcceptKeyword("GROUP") {
+		if err := ts.ExpectKeyword("BY"); err != nil {
+			return nil, nil, err
+		}
+		for {
+			c, err := ts.ExpectQualifiedName("column name to group by")
+			if err != nil {
+				return nil, nil, err
+			}
+			cols = append(cols, c)
+			if !ts.AcceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if !ts.AcceptKeyword("HAVING") {
+		return cols, nil, nil
+	}
+	having, err := whereclause.ReadExpression(ts, compat)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid HAVING  %w", err)
+	}
+	return cols, having, nil
+}
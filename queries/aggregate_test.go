@@ -0,0 +1,158 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func aggregateTestDB(t *testing.T) *minisql.MiniDB {
+	tdb := minisql.NewDatabase(minisql.Schema{
+		"sales": {
+			"region":  {Type: minisql.TEXT},
+			"product": {Type: minisql.TEXT},
+			"qty":     {Type: minisql.INTEGER},
+			"price":   {Type: minisql.REAL},
+		},
+	})
+	for _, ins := range []string{
+		"INSERT INTO sales (region, product, qty, price) VALUES ('north', 'apple', 3, 1.5)",
+		"INSERT INTO sales (region, product, qty, price) VALUES ('north', 'pear', 5, 2)",
+		"INSERT INTO sales (region, product, qty, price) VALUES ('south', 'apple', 7, 1.25)",
+		"INSERT INTO sales (region, product, qty, price) VALUES ('south', 'apple', 10, 1.25)",
+		"INSERT INTO sales (region, product, price) VALUES ('east', 'fig', 4)",
+		"INSERT INTO sales (product, qty) VALUES ('fig', 2)",
+	} {
+		runQuery(t, tdb, ins)
+	}
+	return tdb
+}
+
+func TestSelectQuery_Aggregates(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	tests := []struct {
+		query   string
+		columns []string
+		expect  []string
+	}{
+		{"SELECT COUNT(*), COUNT(qty), COUNT(DISTINCT product), SUM(qty), AVG(price), MIN(price), MAX(product) FROM sales",
+			[]string{"COUNT(*)", "COUNT(qty)", "COUNT(DISTINCT product)", "SUM(qty)", "AVG(price)", "MIN(price)", "MAX(product)"},
+			[]string{"6,5,3,27,2,1.25,pear"}},
+		{"SELECT count(*) AS n, sum(qty) AS total FROM sales WHERE qty > 100",
+			[]string{"n", "total"}, []string{"0,NULL"}},
+		{"SELECT region, COUNT(*) AS n, SUM(qty) AS total FROM sales GROUP BY region",
+			[]string{"region", "n", "total"}, []string{"NULL,1,2", "east,1,NULL", "north,2,8", "south,2,17"}},
+		{"SELECT region, product, COUNT(*) FROM sales GROUP BY region, product",
+			[]string{"region", "product", "COUNT(*)"},
+			[]string{"NULL,fig,1", "east,fig,1", "north,apple,1", "north,pear,1", "south,apple,2"}},
+		{"SELECT region FROM sales GROUP BY region HAVING SUM(qty) > 7",
+			[]string{"region"}, []string{"north", "south"}},
+		{"SELECT region, COUNT(*) AS n FROM sales GROUP BY region HAVING n = 1 AND region IS NOT NULL",
+			[]string{"region", "n"}, []string{"east,1"}},
+		{"SELECT region, MAX(qty) FROM sales WHERE product = 'apple' GROUP BY region",
+			[]string{"region", "MAX(qty)"}, []string{"north,3", "south,10"}},
+		{"SELECT SUM(price) FROM sales WHERE region = 'south'",
+			[]string{"SUM(price)"}, []string{"2.5"}},
+	}
+	for _, test := range tests {
+		rows := runQuery(t, tdb, test.query, test.columns...)
+		sort.Strings(rows)
+		if !reflect.DeepEqual(rows, test.expect) {
+			t.Fatalf("unexpected result of %q, expected %v, found %v", test.query, test.expect, rows)
+		}
+	}
+}
+
+func TestSelectQuery_AggregateOrderBy(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	rows := runQuery(t, tdb, "SELECT region, SUM(qty) AS total FROM sales GROUP BY region ORDER BY total DESC", "region", "total")
+	if !reflect.DeepEqual(rows, []string{"south,17", "north,8", "NULL,2", "east,NULL"}) {
+		t.Fatalf("unexpected order, found %v", rows)
+	}
+	q, _ := ParseQuery("SELECT region FROM sales GROUP BY region ORDER BY COUNT(*), region")
+	rCh, err := q.Execute(context.TODO(), tdb)
+	if err != nil {
+		t.Fatalf("failed to execute query  %s", err)
+	}
+	var regions []string
+	for r := range rCh {
+		if len(r.Values()) != 1 {
+			t.Fatalf("expected the aggregate ordered by to be removed from result, found %v", r.Values())
+		}
+		regions = append(regions, stringOrNull(r.Values()["region"]))
+	}
+	if !reflect.DeepEqual(regions, []string{"NULL", "east", "north", "south"}) {
+		t.Fatalf("unexpected order, found %v", regions)
+	}
+}
+
+func TestSelectQuery_AggregateInto(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	runQuery(t, tdb, "SELECT region, SUM(qty) AS total INTO totals FROM sales WHERE region IS NOT NULL GROUP BY region")
+	tb, err := tdb.Table("totals")
+	if err != nil {
+		t.Fatalf("expected new table  %s", err)
+	}
+	if cd, _ := tb.ColumnDef("total"); cd == nil || cd.Type != minisql.INTEGER {
+		t.Fatalf("expected total to be an INTEGER column, found %v", cd)
+	}
+	rows := runQuery(t, tdb, "SELECT region, total FROM totals ORDER BY region", "region", "total")
+	if !reflect.DeepEqual(rows, []string{"east,NULL", "north,8", "south,17"}) {
+		t.Fatalf("unexpected rows selected into new table, found %v", rows)
+	}
+}
+
+func TestSelectQuery_AggregateErrors(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	for _, query := range []string{
+		"SELECT region, qty FROM sales GROUP BY region",
+		"SELECT * FROM sales GROUP BY region",
+		"SELECT region FROM sales GROUP BY region HAVING qty > 1",
+		"SELECT region FROM sales GROUP BY region ORDER BY qty",
+		"SELECT SUM(missing) FROM sales",
+		"SELECT region FROM sales GROUP BY missing",
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse query %q  %s", query, err)
+		}
+		if _, err = q.Execute(context.TODO(), tdb); err == nil {
+			t.Fatalf("expected error executing %q", query)
+		}
+	}
+	for _, query := range []string{
+		"SELECT COUNT( FROM sales",
+		"SELECT COUNT(*) FROM sales GROUP region",
+		"SELECT COUNT(*) FROM sales GROUP BY",
+		"SELECT COUNT(*) FROM sales HAVING",
+		"SELECT region FROM sales WHERE COUNT(*) > 1 GROUP BY region",
+		"SELECT MEDIAN(qty) FROM sales",
+		"SELECT SUM(*) FROM sales",
+		"SELECT region FROM sales GROUP BY region HAVING MEDIAN(qty) > 1",
+		"SELECT region FROM sales GROUP BY region ORDER BY COUNT(DISTINCT *)",
+		"SELECT region FROM sales ORDER BY NOSUCH(qty)",
+		"UPDATE sales SET qty = NOSUCH(qty)",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Fatalf("expected error parsing %q", query)
+		}
+	}
+
+	q, _ := ParseQuery("SELECT SUM(product) FROM sales")
+	rCh, err := q.Execute(context.TODO(), tdb)
+	if err != nil {
+		t.Fatalf("failed to execute query  %s", err)
+	}
+	if r := <-rCh; r == nil || r.Values()["ERROR"] == nil {
+		t.Fatalf("expected error summing TEXT values")
+	}
+}
+
+func stringOrNull(s *string) string {
+	if s == nil {
+		return "NULL"
+	}
+	return *s
+}
@@ -0,0 +1,107 @@
+package queries
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/whereclause"
+	"eurozulu/miniSQL/stringutil"
+	"fmt"
+)
+
+// bindExpressions binds the computed columns of the query to the types of the columns, and aggregates, of the table.
+// Each column, or aggregate, a computed column is read from must be known.
+func (q SelectQuery) bindExpressions(t minisql.Table) (map[string]whereclause.Value, error) {
+	if len(q.Expressions) == 0 {
+		return nil, nil
+	}
+	exs := map[string]whereclause.Value{}
+	for c, v := range q.Expressions {
+		v = whereclause.ValueWithTableTypes(v, t)
+		types := map[string]minisql.ColumnType{}
+		for _, n := range v.ColumnNames() {
+			a := q.Aggregates[n]
+			if a == nil {
+				cd, err := t.ColumnDef(n)
+				if err != nil {
+					return nil, fmt.Errorf("%w in %s", err, c)
+				}
+				types[n] = cd.Type
+				continue
+			}
+			if a.Column != "*" {
+				if err := validColumn(t, a.Column); err != nil {
+					return nil, fmt.Errorf("%w in %s", err, c)
+				}
+			}
+			ct, err := a.columnType(t)
+			if err != nil {
+				return nil, err
+			}
+			types[n] = ct
+		}
+		exs[c] = whereclause.ValueWithTypes(v, types)
+		if err := whereclause.CheckValueTypes(exs[c]); err != nil {
+			return nil, err
+		}
+	}
+	return exs, nil
+}
+
+// selectOrderExpressions selects the values computed to order by, which are not already selected.
+// Returns the names of the values it selects, which are removed from the results once they are sorted.
+func (q *SelectQuery) selectOrderExpressions() []string {
+	if q.OrderBy == nil || q.Into != "" || len(q.OrderBy.Expressions) == 0 {
+		return nil
+	}
+	exs := map[string]whereclause.Value{}
+	for c, v := range q.Expressions {
+		exs[c] = v
+	}
+	var hidden []string
+	for _, c := range q.OrderBy.Columns {
+		v, ok := q.OrderBy.Expressions[c]
+		if !ok || stringutil.Contains(c, q.Names) {
+			continue
+		}
+		q.Columns = append(q.Columns, c)
+		q.Names = append(q.Names, c)
+		exs[c] = v
+		hidden = append(hidden, c)
+	}
+	q.Expressions = exs
+	return hidden
+}
+
+// sourceColumns gets the columns to select from the table, the selected columns, or those computed columns are read from.
+func (q SelectQuery) sourceColumns() []string {
+	var cols []string
+	for _, c := range q.Columns {
+		if v, ok := q.Expressions[c]; ok {
+			cols = append(cols, v.ColumnNames()...)
+			continue
+		}
+		cols = append(cols, c)
+	}
+	return stringutil.UniqueStrings(cols)
+}
+
+// computeValues adds the values of the computed columns, to the values of the columns they are read from.
+func (q SelectQuery) computeValues(values minisql.Values) (minisql.Values, error) {
+	for _, c := range q.Columns {
+		if v, ok := q.Expressions[c]; ok {
+			cv, err := whereclause.Evaluate(v, values)
+			if err != nil {
+				return nil, err
+			}
+			values[c] = cv
+		}
+	}
+	return values, nil
+}
+
+// valueType gets the type of a computed value.  Values without a type, such as quoted literals, are TEXT.
+func valueType(v whereclause.Value) minisql.ColumnType {
+	if ct := v.Type(); ct != "" {
+		return ct
+	}
+	return minisql.TEXT
+}
@@ -0,0 +1,244 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSelectQuery_Computed(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	tests := []struct {
+		query   string
+		columns []string
+		expect  []string
+	}{
+		{"SELECT product, price * qty AS total, 'const' AS tag FROM sales WHERE region = 'north'",
+			[]string{"product", "total", "tag"}, []string{"apple,4.5,const", "pear,10,const"}},
+		{"SELECT qty * 2, qty+1 FROM sales WHERE region = 'south'",
+			[]string{"qty * 2", "qty+1"}, []string{"14,8", "20,11"}},
+		{"SELECT product || '@' || region AS label FROM sales WHERE qty < 5",
+			[]string{"label"}, []string{"NULL", "apple@north"}},
+		{"SELECT 1 + 2 AS three, -qty FROM sales WHERE product = 'pear'",
+			[]string{"three", "-qty"}, []string{"3,-5"}},
+		{"SELECT region, SUM(qty) * 2 AS double, COUNT(*) + 1 FROM sales WHERE region IS NOT NULL GROUP BY region",
+			[]string{"region", "double", "COUNT(*) + 1"}, []string{"east,NULL,2", "north,16,3", "south,34,3"}},
+		{"SELECT region || '!' FROM sales WHERE region IS NOT NULL GROUP BY region",
+			[]string{"region || '!'"}, []string{"east!", "north!", "south!"}},
+	}
+	for _, test := range tests {
+		rows := runQuery(t, tdb, test.query, test.columns...)
+		sort.Strings(rows)
+		if !reflect.DeepEqual(rows, test.expect) {
+			t.Fatalf("unexpected result of %q, expected %v, found %v", test.query, test.expect, rows)
+		}
+	}
+}
+
+func TestSelectQuery_ComputedOrderBy(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	rows := runQuery(t, tdb, "SELECT product, qty * price AS total FROM sales WHERE region IS NOT NULL ORDER BY total DESC",
+		"product", "total")
+	if !reflect.DeepEqual(rows, []string{"apple,12.5", "pear,10", "apple,8.75", "apple,4.5", "fig,NULL"}) {
+		t.Fatalf("unexpected order, found %v", rows)
+	}
+}
+
+func TestSelectQuery_ComputedInto(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	runQuery(t, tdb, "SELECT product, qty * 2 AS double, qty * price AS total, 'x' AS tag INTO totals FROM sales WHERE region = 'south'")
+	tb, err := tdb.Table("totals")
+	if err != nil {
+		t.Fatalf("expected new table  %s", err)
+	}
+	for c, ct := range map[string]minisql.ColumnType{
+		"product": minisql.TEXT, "double": minisql.INTEGER, "total": minisql.REAL, "tag": minisql.TEXT,
+	} {
+		if cd, _ := tb.ColumnDef(c); cd == nil || cd.Type != ct {
+			t.Fatalf("expected %s to be a %s column, found %v", c, ct, cd)
+		}
+	}
+	rows := runQuery(t, tdb, "SELECT product, double, total, tag FROM totals ORDER BY double", "product", "double", "total", "tag")
+	if !reflect.DeepEqual(rows, []string{"apple,14,8.75,x", "apple,20,12.5,x"}) {
+		t.Fatalf("unexpected rows selected into new table, found %v", rows)
+	}
+}
+
+func TestSelectQuery_ComputedIntoUnnamed(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	runQuery(t, tdb, "SELECT UPPER(product), qty + 1 INTO upper FROM sales WHERE region = 'south'")
+	rows := runQuery(t, tdb, "SELECT * FROM upper ORDER BY qty_1", "upper_product", "qty_1")
+	if !reflect.DeepEqual(rows, []string{"APPLE,8", "APPLE,11"}) {
+		t.Fatalf("unexpected rows selected into new table, found %v", rows)
+	}
+	runQuery(t, tdb, "SELECT COUNT(*), SUM(qty) INTO counts FROM sales")
+	rows = runQuery(t, tdb, "SELECT * FROM counts", "count", "sum_qty")
+	if !reflect.DeepEqual(rows, []string{"6,27"}) {
+		t.Fatalf("unexpected rows selected into new table, found %v", rows)
+	}
+	// columns named as aggregates are still columns, once they are in a table
+	tdb.AlterDatabase(minisql.Schema{"named": {"COUNT(*)": {Type: minisql.INTEGER}, "UPPER(x)": {}}})
+	runQuery(t, tdb, "INSERT INTO named (`COUNT(*)`, `UPPER(x)`) VALUES (3, 'a')")
+	rows = runQuery(t, tdb, "SELECT * FROM named", "COUNT(*)", "UPPER(x)")
+	if !reflect.DeepEqual(rows, []string{"3,a"}) {
+		t.Fatalf("unexpected rows of columns named as aggregates, found %v", rows)
+	}
+}
+
+func TestSelectQuery_ComputedErrors(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	for _, query := range []string{
+		"SELECT qty * `missing` FROM sales",
+		"SELECT region, qty * 2 FROM sales GROUP BY region",
+		"SELECT SUM(missing) + 1 FROM sales",
+		"SELECT ABS(product) FROM sales",
+		"SELECT qty FROM sales WHERE ROUND(region) = 1",
+		"SELECT region FROM sales GROUP BY region HAVING FLOOR(region) > 1",
+		"SELECT qty FROM sales ORDER BY MOD(product, 2)",
+		"UPDATE sales SET qty = ABS(product)",
+		"DELETE FROM sales WHERE ABS(region) > 1",
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse query %q  %s", query, err)
+		}
+		if _, err = q.Execute(context.TODO(), tdb); err == nil {
+			t.Fatalf("expected error executing %q", query)
+		}
+	}
+	for _, query := range []string{
+		"SELECT qty * FROM sales",
+		"SELECT (qty + 1 FROM sales",
+		"SELECT qty + 1 AS FROM sales",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Fatalf("expected error parsing %q", query)
+		}
+	}
+}
+
+func TestSelectQuery_Overflow(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	for _, query := range []string{
+		"SELECT qty * 9223372036854775807 FROM sales",
+		"SELECT product FROM sales WHERE qty + 9223372036854775807 > 0",
+		"SELECT region, SUM(qty) * 9223372036854775807 FROM sales GROUP BY region",
+		"SELECT region FROM sales GROUP BY region HAVING COUNT(*) - -9223372036854775807 > 0",
+		"SELECT ABS(qty - qty - 9223372036854775807 - 1) FROM sales",
+		"UPDATE sales SET qty = qty * 9223372036854775807",
+		"DELETE FROM sales WHERE qty * 9223372036854775807 > 0",
+	} {
+		if err := queryError(tdb, query); err == nil || !strings.Contains(err.Error(), "overflows an INTEGER") {
+			t.Fatalf("expected %q to overflow, found %v", query, err)
+		}
+	}
+	rows := runQuery(t, tdb, "SELECT product, qty FROM sales WHERE qty IS NOT NULL ORDER BY qty", "product", "qty")
+	if !reflect.DeepEqual(rows, []string{"fig,2", "apple,3", "pear,5", "apple,7", "apple,10"}) {
+		t.Fatalf("expected overflowing queries to leave every row unchanged, found %v", rows)
+	}
+	rows = runQuery(t, tdb, "SELECT qty * 9223372036854775807.0 AS big FROM sales WHERE qty = 2", "big")
+	if len(rows) != 1 {
+		t.Fatalf("expected REAL arithmetic not to overflow, found %v", rows)
+	}
+}
+
+func TestSelectQuery_Functions(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	tests := []struct {
+		query   string
+		columns []string
+		expect  []string
+	}{
+		{"SELECT UPPER(product) AS p, ROUND(price * qty, 1) FROM sales WHERE LENGTH(region) = 5",
+			[]string{"p", "ROUND(price * qty, 1)"}, []string{"APPLE,12.5", "APPLE,4.5", "APPLE,8.8", "PEAR,10"}},
+		{"SELECT COALESCE(region, 'none') AS r, IFNULL(qty, 0) AS q FROM sales WHERE product = 'fig'",
+			[]string{"r", "q"}, []string{"east,0", "none,2"}},
+		{"SELECT region, ROUND(AVG(price), 1) AS avg FROM sales WHERE region IS NOT NULL GROUP BY region",
+			[]string{"region", "avg"}, []string{"east,4", "north,1.8", "south,1.3"}},
+		{"SELECT UPPER(region) FROM sales WHERE region IS NOT NULL GROUP BY region",
+			[]string{"UPPER(region)"}, []string{"EAST", "NORTH", "SOUTH"}},
+	}
+	for _, test := range tests {
+		rows := runQuery(t, tdb, test.query, test.columns...)
+		sort.Strings(rows)
+		if !reflect.DeepEqual(rows, test.expect) {
+			t.Fatalf("unexpected result of %q, expected %v, found %v", test.query, test.expect, rows)
+		}
+	}
+}
+
+func TestSelectQuery_FunctionOrderBy(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	tests := []struct {
+		query   string
+		columns []string
+		expect  []string
+	}{
+		{"SELECT product, qty FROM sales WHERE qty IS NOT NULL ORDER BY MOD(qty, 5), qty",
+			[]string{"product", "qty"}, []string{"pear,5", "apple,10", "fig,2", "apple,7", "apple,3"}},
+		{"SELECT product, qty FROM sales WHERE qty IS NOT NULL ORDER BY ABS(qty - 6), qty",
+			[]string{"product", "qty"}, []string{"pear,5", "apple,7", "apple,3", "fig,2", "apple,10"}},
+		{"SELECT UPPER(product) AS p FROM sales WHERE region = 'north' ORDER BY UPPER(product) DESC",
+			[]string{"p"}, []string{"PEAR", "APPLE"}},
+		{"SELECT region FROM sales WHERE region IS NOT NULL GROUP BY region ORDER BY LENGTH(region), region",
+			[]string{"region"}, []string{"east", "north", "south"}},
+	}
+	for _, test := range tests {
+		if rows := runQuery(t, tdb, test.query, test.columns...); !reflect.DeepEqual(rows, test.expect) {
+			t.Fatalf("unexpected order of %q, expected %v, found %v", test.query, test.expect, rows)
+		}
+	}
+	q, _ := ParseQuery("SELECT product FROM sales WHERE region = 'south' ORDER BY LOWER(product)")
+	rCh, err := q.Execute(context.TODO(), tdb)
+	if err != nil {
+		t.Fatalf("failed to execute query  %s", err)
+	}
+	for r := range rCh {
+		if len(r.Values()) != 1 {
+			t.Fatalf("expected the value ordered by to be removed from result, found %v", r.Values())
+		}
+	}
+}
+
+func TestUpdateQuery_Functions(t *testing.T) {
+	tdb := aggregateTestDB(t)
+	runQuery(t, tdb, "UPDATE sales SET product = UPPER(product), qty = COALESCE(qty, 0) + 1, price = 1 WHERE product = 'fig'")
+	rows := runQuery(t, tdb, "SELECT product, qty, price FROM sales WHERE LOWER(product) = 'fig' ORDER BY qty",
+		"product", "qty", "price")
+	if !reflect.DeepEqual(rows, []string{"FIG,1,1", "FIG,3,1"}) {
+		t.Fatalf("unexpected rows after update, found %v", rows)
+	}
+	update := mustPrepare(t, "UPDATE sales SET qty = qty * ?, region = ? WHERE region = ?", 3)
+	runPrepared(t, tdb, update, []interface{}{10, "west", "south"})
+	rows = runQuery(t, tdb, "SELECT region, qty FROM sales WHERE region = 'west' ORDER BY qty", "region", "qty")
+	if !reflect.DeepEqual(rows, []string{"west,70", "west,100"}) {
+		t.Fatalf("unexpected rows after prepared update, found %v", rows)
+	}
+
+	for _, query := range []string{
+		"UPDATE sales SET qty = `missing` + 1",
+		"UPDATE sales SET qty = SUM(qty)",
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse query %q  %s", query, err)
+		}
+		if _, err = q.Execute(context.TODO(), tdb); err == nil {
+			t.Fatalf("expected error executing %q", query)
+		}
+	}
+	for _, query := range []string{
+		"SELECT UPPER(product, region) FROM sales",
+		"SELECT product FROM sales WHERE ABS('x') > 1",
+		"SELECT product FROM sales ORDER BY ROUND(price, 'x')",
+		"UPDATE sales SET product = SUBSTR(product)",
+		"UPDATE sales SET qty = qty +",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Fatalf("expected error parsing %q", query)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestQueries_Concurrent runs a mixed workload of queries against the same tables at once.
+// Run with 'go test -race' to detect unsafe access.
+func TestQueries_Concurrent(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{
+		"items": {"name": {Type: minisql.TEXT}, "qty": {Type: minisql.INTEGER}},
+	})
+	const workers = 6
+	const rows = 50
+
+	run := func(query string) error {
+		q, err := ParseQuery(query)
+		if err != nil {
+			return err
+		}
+		rCh, err := q.Execute(context.Background(), tdb)
+		if err != nil {
+			return err
+		}
+		for r := range rCh {
+			if e, ok := r.Values()["ERROR"]; ok {
+				return fmt.Errorf("%s", *e)
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*4)
+	for w := 0; w < workers; w++ {
+		wg.Add(4)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rows; i++ {
+				if err := run(fmt.Sprintf("INSERT INTO items (name, qty) VALUES ('w%d', %d)", w, i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rows; i++ {
+				if err := run("SELECT * FROM items WHERE qty > 10 ORDER BY qty DESC"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rows; i++ {
+				if err := run(fmt.Sprintf("UPDATE items SET qty = 1000 WHERE name = 'w%d' AND qty = %d", w, i)); err != nil {
+					errs <- err
+					return
+				}
+				if err := run(fmt.Sprintf("DELETE FROM items WHERE qty < %d", i%5)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			tn := fmt.Sprintf("copy%d", w)
+			for i := 0; i < rows/10; i++ {
+				if err := run(fmt.Sprintf("SELECT name, qty INTO %s FROM items WHERE qty >= 1000", tn)); err != nil {
+					errs <- err
+					return
+				}
+				if err := run("DROP TABLE " + tn); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error in concurrent queries  %v", err)
+	}
+}
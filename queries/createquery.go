@@ -0,0 +1,445 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/queries/whereclause"
+	"eurozulu/miniSQL/stringutil"
+	"fmt"
+	"sort"
+)
+
+// CreateTableQuery creates a new table with the given columns
+type CreateTableQuery struct {
+	TableName string
+	Columns   map[string]*minisql.ColumnDef
+}
+
+func (q CreateTableQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	if db.ContainsTable(q.TableName) {
+		return nil, fmt.Errorf("table %q already exists", q.TableName)
+	}
+	if err := checkColumns(db, q.TableName, q.Columns, nil); err != nil {
+		return nil, err
+	}
+	db.AlterDatabase(minisql.Schema{q.TableName: q.Columns})
+	return resultsOf(NewResult(q.TableName, minisql.Values{"created": &q.TableName})), nil
+}
+
+// CreateColumnQuery adds new columns to an existing table
+type CreateColumnQuery struct {
+	TableName string
+	Columns   map[string]*minisql.ColumnDef
+}
+
+func (q CreateColumnQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	cols, err := db.Describe(q.TableName)
+	if err != nil {
+		return nil, err
+	}
+	t, err := db.Table(q.TableName)
+	if err != nil {
+		return nil, err
+	}
+	rows := rowCount(t, 2)
+	var results []Result
+	for _, cn := range sortedKeys(q.Columns) {
+		if cn == minisql.IDColumn || stringutil.Contains(cn, cols) {
+			return nil, fmt.Errorf("column %q already exists in table %s", cn, q.TableName)
+		}
+		cd := q.Columns[cn]
+		if cd.PrimaryKey {
+			return nil, fmt.Errorf("PRIMARY KEY column %s can only be created with table %s", cn, q.TableName)
+		}
+		if cd.NotNull && cd.Default == nil && rows > 0 {
+			return nil, fmt.Errorf("NOT NULL column %s needs a DEFAULT value for the existing rows of table %s", cn, q.TableName)
+		}
+		if cd.Unique && cd.Default != nil && rows > 1 {
+			return nil, fmt.Errorf("UNIQUE column %s can not have a DEFAULT value for the existing rows of table %s", cn, q.TableName)
+		}
+		n := cn
+		results = append(results, NewResult(q.TableName, minisql.Values{"created": &n}))
+	}
+	if err = checkColumns(db, q.TableName, q.Columns, cols); err != nil {
+		return nil, err
+	}
+	db.AlterDatabase(minisql.Schema{q.TableName: q.Columns})
+	return resultsOf(results...), nil
+}
+
+// checkColumns checks the CHECK constraints of the given columns of the table only refer to those columns,
+// or the given existing columns, and are met by their defaults.  Their foreign keys must reference a unique column of the same type.
+func checkColumns(db minisql.Database, tablename string, cols map[string]*minisql.ColumnDef, existing []string) error {
+	for _, cn := range sortedKeys(cols) {
+		cd := cols[cn]
+		if cd.References != nil {
+			if err := checkForeignKey(db, tablename, cn, cols); err != nil {
+				return err
+			}
+		}
+		if cd.Check == "" {
+			continue
+		}
+		ex, err := whereclause.ParseExpression(cd.Check)
+		if err != nil {
+			return err
+		}
+		for _, c := range ex.ColumnNames() {
+			if _, ok := cols[c]; !ok && !stringutil.Contains(c, existing) {
+				return fmt.Errorf("CHECK constraint of column %s refers to %s, which is not a known column", cn, c)
+			}
+		}
+		if err = checkDefaults(cn, ex, cols, existing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDefaults checks a row given the default values of the columns passes the CHECK constraint of the named column.
+// A constraint of any column without a default, which is NULL in such a row, passes.
+// The constraint is bound to the types of the columns, and the existing columns, as the columns of computed values are only known once bound.
+func checkDefaults(cn string, ex whereclause.Expression, cols map[string]*minisql.ColumnDef, existing []string) error {
+	types := map[string]minisql.ColumnType{}
+	for _, c := range existing {
+		types[c] = minisql.TEXT
+	}
+	for c, cd := range cols {
+		types[c] = cd.Type
+		if types[c] == "" {
+			types[c] = minisql.TEXT
+		}
+	}
+	ex = whereclause.WithTypes(ex, types)
+	row := minisql.Values{}
+	for _, c := range ex.ColumnNames() {
+		cd, ok := cols[c]
+		if !ok || cd.Default == nil {
+			return nil
+		}
+		row[c] = cd.Default
+	}
+	ok, err := whereclause.Compare(ex, row)
+	if err != nil {
+		return fmt.Errorf("CHECK constraint of column %s  %w", cn, err)
+	}
+	if !ok {
+		return fmt.Errorf("CHECK constraint of column %s fails the DEFAULT values, (%s) is false", cn, cols[cn].Check)
+	}
+	return nil
+}
+
+// rowCount counts the rows of the table, up to the given limit.
+func rowCount(t minisql.Table, limit int) int {
+	var n int
+	last := t.NextID()
+	for k := minisql.Key(0); k < last && n < limit; k++ {
+		if t.ContainsID(k) {
+			n++
+		}
+	}
+	return n
+}
+
+// CreateIndexQuery creates a new index on one or more columns of a table
+type CreateIndexQuery struct {
+	TableName string
+	Index     minisql.IndexDef
+}
+
+func (q CreateIndexQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	if tn, ok := findIndex(db, q.Index.Name); ok {
+		return nil, fmt.Errorf("index %q already exists on table %s", q.Index.Name, tn)
+	}
+	ix, err := tableIndexer(db, q.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err = ix.CreateIndex(q.Index); err != nil {
+		return nil, err
+	}
+	return resultsOf(NewResult(q.TableName, minisql.Values{"created": &q.Index.Name})), nil
+}
+
+// tableIndexer gets the named table, as a table which can be indexed.
+func tableIndexer(db minisql.Database, tablename string) (minisql.Indexer, error) {
+	t, err := db.Table(tablename)
+	if err != nil {
+		return nil, err
+	}
+	ix, ok := t.(minisql.Indexer)
+	if !ok {
+		return nil, fmt.Errorf("table %s does not support indexes", tablename)
+	}
+	return ix, nil
+}
+
+// findIndex finds the table holding the named index.  Index names are unique across all the tables.
+func findIndex(db minisql.Database, name string) (string, bool) {
+	for _, tn := range db.TableNames() {
+		ix, err := tableIndexer(db, tn)
+		if err != nil {
+			continue
+		}
+		for _, def := range ix.Indexes() {
+			if def.Name == name {
+				return tn, true
+			}
+		}
+	}
+	return "", false
+}
+
+// columnConstraints are the keywords which begin a column constraint.
+var columnConstraints = []string{"NOT", "NULL", "UNIQUE", "DEFAULT", "CHECK", "PRIMARY", "REFERENCES"}
+
+// readColumnDefs reads a bracketed, comma delimited list of column names, each optionally followed by its type and constraints.
+// e.g. (id INTEGER, name NOT NULL UNIQUE, created TIMESTAMP, status DEFAULT 'new', qty INTEGER CHECK (qty >= 0))
+// A composite primary key is listed after the columns, e.g. (a, b, PRIMARY KEY (a, b))
+func readColumnDefs(ts *lexer.TokenStream) (map[string]*minisql.ColumnDef, error) {
+	if err := ts.ExpectSymbol("("); err != nil {
+		return nil, err
+	}
+	cols := map[string]*minisql.ColumnDef{}
+	for {
+		t := ts.Peek()
+		if t.IsKeyword("PRIMARY") && ts.PeekAt(1).IsKeyword("KEY") && ts.PeekAt(2).IsSymbol("(") {
+			if err := readPrimaryKey(ts, cols); err != nil {
+				return nil, err
+			}
+			if !ts.AcceptSymbol(",") {
+				break
+			}
+			continue
+		}
+		name, err := ts.ExpectName("column name")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := cols[name]; ok || name == minisql.IDColumn {
+			return nil, ts.Errorf(t, "column name %q appears more than once", name)
+		}
+		var ct minisql.ColumnType = minisql.TEXT
+		if tt := ts.Peek(); tt.Type == lexer.IDENT && !tt.IsKeyword(columnConstraints...) {
+			ts.Next()
+			if ct, err = minisql.ParseColumnType(tt.Text); err != nil {
+				return nil, ts.Errorf(tt, "%v", err)
+			}
+		}
+		cd := &minisql.ColumnDef{Type: ct}
+		if err = readColumnConstraints(ts, cd); err != nil {
+			return nil, err
+		}
+		if err = cd.Validate(); err != nil {
+			return nil, ts.Errorf(t, "column %s  %v", name, err)
+		}
+		cols[name] = cd
+		if !ts.AcceptSymbol(",") {
+			break
+		}
+	}
+	if err := ts.ExpectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
+
+// readPrimaryKey reads the PRIMARY KEY of a list of columns, marking each of those columns as part of the key.
+func readPrimaryKey(ts *lexer.TokenStream, cols map[string]*minisql.ColumnDef) error {
+	ts.Next()
+	ts.Next()
+	t := ts.Peek()
+	names, err := ts.ExpectNameList("primary key column name")
+	if err != nil {
+		return err
+	}
+	for _, cn := range cols {
+		if cn.PrimaryKey {
+			return ts.Errorf(t, "a table can only have one PRIMARY KEY")
+		}
+	}
+	for _, n := range names {
+		cd, ok := cols[n]
+		if !ok {
+			return ts.Errorf(t, "PRIMARY KEY column %s is not a column of the table", n)
+		}
+		cd.PrimaryKey = true
+	}
+	return nil
+}
+
+// readColumnConstraints reads any constraints following a column type, in any order.
+// NOT NULL | NULL | UNIQUE | PRIMARY KEY | DEFAULT <value> | CHECK (<condition>)
+// | REFERENCES <table> (<column>) [ON DELETE RESTRICT | CASCADE | SET NULL]
+func readColumnConstraints(ts *lexer.TokenStream, cd *minisql.ColumnDef) error {
+	for {
+		t := ts.Peek()
+		switch {
+		case t.IsKeyword("PRIMARY"):
+			ts.Next()
+			if err := ts.ExpectKeyword("KEY"); err != nil {
+				return err
+			}
+			cd.PrimaryKey = true
+		case t.IsKeyword("REFERENCES"):
+			ts.Next()
+			fk, err := readForeignKey(ts)
+			if err != nil {
+				return err
+			}
+			cd.References = fk
+		case t.IsKeyword("NOT"):
+			ts.Next()
+			if err := ts.ExpectKeyword("NULL"); err != nil {
+				return err
+			}
+			cd.NotNull = true
+		case t.IsKeyword("NULL"):
+			ts.Next()
+			cd.NotNull = false
+		case t.IsKeyword("UNIQUE"):
+			ts.Next()
+			cd.Unique = true
+		case t.IsKeyword("DEFAULT"):
+			ts.Next()
+			v, err := whereclause.ReadValue(ts)
+			if err != nil {
+				return err
+			}
+			cd.Default = v
+		case t.IsKeyword("CHECK"):
+			ts.Next()
+			if err := ts.ExpectSymbol("("); err != nil {
+				return err
+			}
+			first := ts.Peek()
+			if _, err := whereclause.ReadExpression(ts, false); err != nil {
+				return err
+			}
+			cd.Check = ts.Source(first)
+			if err := ts.ExpectSymbol(")"); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// readForeignKey reads the table and column of a foreign key, following the REFERENCES keyword, and its optional ON DELETE action.
+func readForeignKey(ts *lexer.TokenStream) (*minisql.ForeignKey, error) {
+	table, err := ts.ExpectName("referenced table name")
+	if err != nil {
+		return nil, err
+	}
+	t := ts.Peek()
+	cols, err := ts.ExpectNameList("referenced column name")
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) != 1 {
+		return nil, ts.Errorf(t, "REFERENCES must name a single column of %s", table)
+	}
+	fk := &minisql.ForeignKey{Table: table, Column: cols[0]}
+	if ts.AcceptKeyword("ON") {
+		if err = ts.ExpectKeyword("DELETE"); err != nil {
+			return nil, err
+		}
+		t = ts.Next()
+		action := t.Text
+		if t.IsKeyword("SET") {
+			if err = ts.ExpectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			action = string(minisql.SETNULL)
+		}
+		if fk.OnDelete, err = minisql.ParseReferentialAction(action); err != nil {
+			return nil, ts.Errorf(t, "%v", err)
+		}
+	}
+	return fk, nil
+}
+
+// readCreateIndexQuery reads a CREATE INDEX query, following the INDEX keyword.
+// CREATE [UNIQUE] INDEX <name> ON <table> [USING HASH | ORDERED] (<column> [, <column>...])
+func readCreateIndexQuery(ts *lexer.TokenStream, unique bool) (Query, error) {
+	name, err := ts.ExpectName("index name")
+	if err != nil {
+		return nil, err
+	}
+	if err = ts.ExpectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	table, err := ts.ExpectName("table name")
+	if err != nil {
+		return nil, err
+	}
+	it := minisql.ORDERED
+	if ts.AcceptKeyword("USING") {
+		t := ts.Peek()
+		n, err := ts.ExpectName("index type")
+		if err != nil {
+			return nil, err
+		}
+		if it, err = minisql.ParseIndexType(n); err != nil {
+			return nil, ts.Errorf(t, "%v", err)
+		}
+	}
+	cols, err := ts.ExpectNameList("column name")
+	if err != nil {
+		return nil, err
+	}
+	return &CreateIndexQuery{
+		TableName: table,
+		Index:     minisql.IndexDef{Name: name, Columns: cols, Unique: unique, Type: it},
+	}, nil
+}
+
+// readCreateQuery reads a CREATE TABLE, COLUMN or INDEX query from the tokens, following the CREATE keyword.
+func readCreateQuery(ts *lexer.TokenStream) (Query, error) {
+	t := ts.Next()
+	if t.IsKeyword("UNIQUE") {
+		if err := ts.ExpectKeyword("INDEX"); err != nil {
+			return nil, err
+		}
+		return readCreateIndexQuery(ts, true)
+	}
+	if t.IsKeyword("INDEX") {
+		return readCreateIndexQuery(ts, false)
+	}
+	if !t.IsKeyword("TABLE", "COLUMN", "COL") {
+		return nil, ts.Errorf(t, "%s is an unknown CREATE type, must be TABLE, COLUMN or INDEX", t)
+	}
+	table, err := ts.ExpectName("table name")
+	if err != nil {
+		return nil, err
+	}
+	cols, err := readColumnDefs(ts)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsKeyword("TABLE") {
+		return &CreateTableQuery{TableName: table, Columns: cols}, nil
+	}
+	return &CreateColumnQuery{TableName: table, Columns: cols}, nil
+}
+
+// resultsOf creates a closed channel containing the given results.
+func resultsOf(results ...Result) <-chan Result {
+	ch := make(chan Result, len(results))
+	for _, r := range results {
+		ch <- r
+	}
+	close(ch)
+	return ch
+}
+
+func sortedKeys(m map[string]*minisql.ColumnDef) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,11 +3,9 @@ package queries
 import (
 	"context"
 	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
 	"eurozulu/miniSQL/queries/whereclause"
-	"eurozulu/miniSQL/stringutil"
-	"fmt"
 	"strconv"
-	"strings"
 )
 
 type DeleteQuery struct {
@@ -15,59 +13,79 @@ type DeleteQuery struct {
 	Where     whereclause.WhereClause
 }
 
-func (q DeleteQuery) Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Result, error) {
-	if !db.ContainsTable(q.TableName) {
-		return nil, fmt.Errorf("%q is not a known table", q.TableName)
+func (q DeleteQuery) Execute(ctx context.Context, db minisql.Database) (<-chan Result, error) {
+	t, err := db.Table(q.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err = whereclause.CheckWhereTypes(q.Where, t); err != nil {
+		return nil, err
 	}
 	ch := make(chan Result)
 	go func(q *DeleteQuery, ch chan<- Result) {
 		defer close(ch)
-		t, _ := db.Table(q.TableName)
 		var keys []minisql.Key
-		for k := range q.Where.Keys(ctx, t) {
+		found, errs := q.Where.Keys(ctx, t)
+		for k := range found {
 			keys = append(keys, k)
 		}
-		keys = t.Delete(keys...)
+		err := <-errs
+		if err == nil {
+			// rows referenced by foreign keys are deleted along with their references
+			var p *deletePlan
+			if p, err = planDelete(db, q.TableName, keys); err == nil {
+				keys, err = p.apply(q.TableName)
+			}
+		}
 		ks := strconv.Itoa(len(keys))
+		r := NewResult(q.TableName, minisql.Values{"deleted": &ks})
+		if err != nil {
+			es := err.Error()
+			r = NewResult(q.TableName, minisql.Values{"ERROR": &es})
+		}
 		select {
 		case <-ctx.Done():
 			return
-		case ch <- NewResult(q.TableName, minisql.Values{"deleted": &ks}):
+		case ch <- r:
 		}
 	}(&q, ch)
 	return ch, nil
 }
 
+// readDeleteQuery reads a DELETE query from the tokens, following the DELETE keyword.
+func readDeleteQuery(ts *lexer.TokenStream, compat bool) (*DeleteQuery, error) {
+	if err := ts.ExpectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := ts.ExpectName("table name for delete")
+	if err != nil {
+		return nil, err
+	}
+	wh, err := whereclause.ReadWhere(ts, compat)
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteQuery{
+		TableName: table,
+		Where:     wh,
+	}, nil
+}
+
 // NewDeleteQuery creates a new delete query from the given string
 // Query should be a valid delete without the preceeding DELETE.
 // i.e it should begin with the keyword FROM.
 // e.g. "FROM mytable WHERE _id=2"
 func NewDeleteQuery(q string) (*DeleteQuery, error) {
-	if !strings.HasPrefix(strings.ToUpper(q), "FROM ") {
-		return nil, fmt.Errorf("missing FROM in query")
+	ts, err := lexer.NewTokenStream(q)
+	if err != nil {
+		return nil, err
 	}
-	// strip leading FROM
-	_, q = stringutil.FirstWord(q)
-	table, rest := stringutil.FirstWord(q)
-	if table == "" {
-		return nil, fmt.Errorf("missing table name for delete")
+	dq, err := readDeleteQuery(ts, false)
+	if err != nil {
+		return nil, err
 	}
-
-	var wh whereclause.WhereClause
-	if len(rest) > 1 {
-		if !strings.HasPrefix(strings.ToUpper(q), "WHERE") {
-			return nil, fmt.Errorf("%s is not a recognised WHERE", q)
-		}
-		// strip leading 'WHERE'
-		_, rest = stringutil.FirstWord(rest)
-		w, err := whereclause.NewWhere(rest)
-		if err != nil {
-			return nil, err
-		}
-		wh = w
+	if err = ts.ExpectEnd(); err != nil {
+		return nil, err
 	}
-	return &DeleteQuery{
-		TableName: table,
-		Where:     wh,
-	}, nil
+	return dq, nil
 }
@@ -0,0 +1,192 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/stringutil"
+	"fmt"
+	"sort"
+)
+
+// DropTableQuery deletes a table and all its data
+type DropTableQuery struct {
+	TableName string
+	// Cascade drops the foreign keys of other tables which reference the table.  Without it, a referenced table is not dropped.
+	Cascade bool
+}
+
+func (q DropTableQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	if !db.ContainsTable(q.TableName) {
+		return nil, minisql.UnknownTableError(q.TableName)
+	}
+	refs := referencesFrom(db, q.TableName, q.TableName)
+	if len(refs) > 0 && !q.Cascade {
+		return nil, fmt.Errorf("table %s is referenced by %s, use DROP TABLE %s CASCADE to drop its foreign keys",
+			q.TableName, refs[0], q.TableName)
+	}
+	for _, ref := range refs {
+		t, err := db.Table(ref.Table)
+		if err != nil {
+			return nil, err
+		}
+		cd, err := t.ColumnDef(ref.Column)
+		if err != nil {
+			return nil, err
+		}
+		cd.References = nil
+		db.AlterDatabase(minisql.Schema{ref.Table: {ref.Column: cd}})
+	}
+	db.AlterDatabase(minisql.Schema{q.TableName: nil})
+	return resultsOf(NewResult(q.TableName, minisql.Values{"dropped": &q.TableName})), nil
+}
+
+// referencesFrom finds the references to the named table, from tables other than the given tables.
+func referencesFrom(db minisql.Database, tablename string, excluded ...string) []reference {
+	var refs []reference
+	for _, ref := range referencesTo(db, tablename) {
+		if !stringutil.Contains(ref.Table, excluded) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// DropColumnQuery deletes columns, and their data, from a table
+type DropColumnQuery struct {
+	TableName string
+	Columns   []string
+}
+
+func (q DropColumnQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	cols, err := db.Describe(q.TableName)
+	if err != nil {
+		return nil, err
+	}
+	t, err := db.Table(q.TableName)
+	if err != nil {
+		return nil, err
+	}
+	sc := map[string]*minisql.ColumnDef{}
+	var results []Result
+	for _, c := range q.Columns {
+		if !stringutil.Contains(c, cols) {
+			return nil, fmt.Errorf("%q is not a known column in table %s", c, q.TableName)
+		}
+		if cd, err := t.ColumnDef(c); err == nil && cd.PrimaryKey {
+			return nil, fmt.Errorf("PRIMARY KEY column %s can not be dropped", c)
+		}
+		for _, ref := range referencesTo(db, q.TableName) {
+			if ref.Key.Column == c && !(ref.Table == q.TableName && stringutil.Contains(ref.Column, q.Columns)) {
+				return nil, fmt.Errorf("column %s is referenced by %s", c, ref)
+			}
+		}
+		sc[c] = nil
+		n := c
+		results = append(results, NewResult(q.TableName, minisql.Values{"dropped": &n}))
+	}
+	db.AlterDatabase(minisql.Schema{q.TableName: sc})
+	return resultsOf(results...), nil
+}
+
+// DropDatabaseQuery drops the named tables, or all the tables when none are named.
+type DropDatabaseQuery struct {
+	TableNames []string
+}
+
+func (q DropDatabaseQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	tbs := q.TableNames
+	if len(tbs) == 0 {
+		tbs = db.TableNames()
+		sort.Strings(tbs)
+	}
+	sc, err := minisql.NewSchemaFromTables(db, tbs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, tn := range tbs {
+		if refs := referencesFrom(db, tn, tbs...); len(refs) > 0 {
+			return nil, fmt.Errorf("table %s is referenced by %s, which is not dropped", tn, refs[0])
+		}
+	}
+	// remove col defs from scheam to force drop of table
+	results := make([]Result, len(tbs))
+	for i, tn := range tbs {
+		sc[tn] = nil
+		n := tn
+		results[i] = NewResult(tn, minisql.Values{"dropped": &n})
+	}
+	db.AlterDatabase(sc)
+	return resultsOf(results...), nil
+}
+
+// DropIndexQuery deletes an index.  The table is optional, as index names are unique across all tables.
+type DropIndexQuery struct {
+	IndexName string
+	TableName string
+}
+
+func (q DropIndexQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	tn, ok := findIndex(db, q.IndexName)
+	if !ok || (q.TableName != "" && q.TableName != tn) {
+		return nil, fmt.Errorf("%q is not a known index", q.IndexName)
+	}
+	ix, err := tableIndexer(db, tn)
+	if err != nil {
+		return nil, err
+	}
+	if err = ix.DropIndex(q.IndexName); err != nil {
+		return nil, err
+	}
+	return resultsOf(NewResult(tn, minisql.Values{"dropped": &q.IndexName})), nil
+}
+
+// readDropQuery reads a DROP TABLE, COLUMN, INDEX or DATABASE query from the tokens, following the DROP keyword.
+func readDropQuery(ts *lexer.TokenStream) (Query, error) {
+	t := ts.Next()
+	switch {
+	case t.IsKeyword("TABLE"):
+		table, err := ts.ExpectName("table name")
+		if err != nil {
+			return nil, err
+		}
+		return &DropTableQuery{TableName: table, Cascade: ts.AcceptKeyword("CASCADE")}, nil
+
+	case t.IsKeyword("COLUMN", "COL"):
+		table, err := ts.ExpectName("table name")
+		if err != nil {
+			return nil, err
+		}
+		cols, err := ts.ExpectNameList("column name")
+		if err != nil {
+			return nil, err
+		}
+		return &DropColumnQuery{TableName: table, Columns: cols}, nil
+
+	case t.IsKeyword("INDEX"):
+		name, err := ts.ExpectName("index name")
+		if err != nil {
+			return nil, err
+		}
+		q := &DropIndexQuery{IndexName: name}
+		if ts.AcceptKeyword("ON") {
+			if q.TableName, err = ts.ExpectName("table name"); err != nil {
+				return nil, err
+			}
+		}
+		return q, nil
+
+	case t.IsKeyword("DATABASE"):
+		var tables []string
+		for ts.Peek().IsName() {
+			tables = append(tables, ts.Next().Text)
+			if !ts.AcceptSymbol(",") {
+				break
+			}
+		}
+		return &DropDatabaseQuery{TableNames: tables}, nil
+
+	default:
+		return nil, ts.Errorf(t, "DROP %s, is not a known drop type, must be DATABASE, TABLE, COLUMN or INDEX", t)
+	}
+}
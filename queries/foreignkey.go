@@ -0,0 +1,263 @@
+package queries
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"fmt"
+	"sort"
+)
+
+// reference is a column of a table with a foreign key, referencing a column of another table.
+type reference struct {
+	Table  string
+	Column string
+	Key    minisql.ForeignKey
+}
+
+func (r reference) String() string {
+	return fmt.Sprintf("%s(%s)", r.Table, r.Column)
+}
+
+// referencesTo finds the columns, of all the tables, which reference the named table, in table and column order.
+func referencesTo(db minisql.Database, tablename string) []reference {
+	tns := db.TableNames()
+	sort.Strings(tns)
+	var refs []reference
+	for _, tn := range tns {
+		t, err := db.Table(tn)
+		if err != nil {
+			continue
+		}
+		for _, cn := range t.ColumnNames() {
+			cd, err := t.ColumnDef(cn)
+			if err == nil && cd.References != nil && cd.References.Table == tablename {
+				refs = append(refs, reference{Table: tn, Column: cn, Key: *cd.References})
+			}
+		}
+	}
+	return refs
+}
+
+// checkForeignKey checks the foreign key of the named column, of the given columns of a table, references a column
+// which is unique, of the same type.  A table may reference its own columns.
+func checkForeignKey(db minisql.Database, tablename, column string, cols map[string]*minisql.ColumnDef) error {
+	cd := cols[column]
+	fk := cd.References
+	var ref *minisql.ColumnDef
+	pk := 0
+	if fk.Table == tablename {
+		ref = cols[fk.Column]
+		for _, c := range cols {
+			if c.PrimaryKey {
+				pk++
+			}
+		}
+	} else if t, err := db.Table(fk.Table); err == nil {
+		ref, _ = t.ColumnDef(fk.Column)
+		for _, cn := range t.ColumnNames() {
+			if c, err := t.ColumnDef(cn); err == nil && c.PrimaryKey {
+				pk++
+			}
+		}
+	} else {
+		return fmt.Errorf("column %s references %s, which is not a known table", column, fk.Table)
+	}
+	switch {
+	case ref == nil:
+		return fmt.Errorf("column %s references %s(%s), which is not a known column", column, fk.Table, fk.Column)
+	case !ref.Unique && !(ref.PrimaryKey && pk == 1):
+		return fmt.Errorf("column %s references %s(%s), which is not a PRIMARY KEY or UNIQUE column", column, fk.Table, fk.Column)
+	case ref.Type != cd.Type:
+		return fmt.Errorf("column %s of type %s references %s(%s) of type %s", column, cd.Type, fk.Table, fk.Column, ref.Type)
+	case fk.Action() == minisql.SETNULL && (cd.NotNull || cd.PrimaryKey):
+		return fmt.Errorf("column %s can not be SET NULL ON DELETE, as it is NOT NULL", column)
+	}
+	return nil
+}
+
+// checkReferenced refuses changing a value of a column of the given row, which is referenced by the rows of other tables.
+func checkReferenced(db minisql.Database, tablename string, t minisql.Table, id minisql.Key, values minisql.Values) error {
+	for _, ref := range referencesTo(db, tablename) {
+		v, ok := values[ref.Key.Column]
+		if !ok {
+			continue
+		}
+		old, err := t.Select(id, []string{ref.Key.Column})
+		if err != nil || old[ref.Key.Column] == nil {
+			continue
+		}
+		cd, _ := t.ColumnDef(ref.Key.Column)
+		if v != nil {
+			if cv, err := cd.Type.Convert(*v); err == nil && cd.Type.Compare(cv, *old[ref.Key.Column]) == 0 {
+				continue
+			}
+		}
+		rt, err := db.Table(ref.Table)
+		if err != nil {
+			continue
+		}
+		for _, k := range minisql.FindKeys(rt, ref.Column, *old[ref.Key.Column], 2) {
+			if ref.Table != tablename || k != id {
+				return referencedError(ref, *old[ref.Key.Column])
+			}
+		}
+	}
+	return nil
+}
+
+func referencedError(ref reference, value string) error {
+	return fmt.Errorf("FOREIGN KEY constraint of %s failed, %s of %s(%s) is still referenced",
+		ref, minisql.QuoteValue(value), ref.Key.Table, ref.Key.Column)
+}
+
+// deletePlan is the rows to be deleted by a DELETE, along with the rows deleted by the CASCADE of their references,
+// and the references to be SET NULL.
+type deletePlan struct {
+	db      minisql.Database
+	deletes map[string]map[minisql.Key]bool
+	// nulls are the columns of the rows, of each table, to set to NULL.
+	nulls map[string]map[minisql.Key][]string
+}
+
+// planDelete plans deleting the given rows of the named table, failing if any row is referenced by a RESTRICT foreign key.
+func planDelete(db minisql.Database, tablename string, keys []minisql.Key) (*deletePlan, error) {
+	p := &deletePlan{db: db, deletes: map[string]map[minisql.Key]bool{}, nulls: map[string]map[minisql.Key][]string{}}
+	if err := p.add(tablename, keys); err != nil {
+		return nil, err
+	}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// add adds the rows of the table, and the rows referencing them with ON DELETE CASCADE, to the rows deleted.
+func (p *deletePlan) add(tablename string, keys []minisql.Key) error {
+	ks, ok := p.deletes[tablename]
+	if !ok {
+		ks = map[minisql.Key]bool{}
+		p.deletes[tablename] = ks
+	}
+	var added []minisql.Key
+	for _, k := range keys {
+		if !ks[k] {
+			ks[k] = true
+			added = append(added, k)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	for _, ref := range referencesTo(p.db, tablename) {
+		if ref.Key.Action() != minisql.CASCADE {
+			continue
+		}
+		refs, err := p.referencing(ref, added)
+		if err != nil {
+			return err
+		}
+		if err = p.add(ref.Table, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check finds the rows, not being deleted, which reference a deleted row, failing for a RESTRICT reference,
+// or adding the reference to those SET NULL.
+func (p *deletePlan) check() error {
+	tns := make([]string, 0, len(p.deletes))
+	for tn := range p.deletes {
+		tns = append(tns, tn)
+	}
+	sort.Strings(tns)
+	for _, tn := range tns {
+		keys := sortedKeySet(p.deletes[tn])
+		for _, ref := range referencesTo(p.db, tn) {
+			if ref.Key.Action() == minisql.CASCADE {
+				continue
+			}
+			refs, err := p.referencing(ref, keys)
+			if err != nil {
+				return err
+			}
+			for _, k := range refs {
+				if p.deletes[ref.Table][k] {
+					continue
+				}
+				if ref.Key.Action() == minisql.RESTRICT {
+					return fmt.Errorf("FOREIGN KEY constraint of %s failed, row %d references a deleted row of %s", ref, k, tn)
+				}
+				if p.nulls[ref.Table] == nil {
+					p.nulls[ref.Table] = map[minisql.Key][]string{}
+				}
+				p.nulls[ref.Table][k] = append(p.nulls[ref.Table][k], ref.Column)
+			}
+		}
+	}
+	return nil
+}
+
+// referencing finds the keys of the rows, of the referencing table, which reference any of the given rows.
+func (p *deletePlan) referencing(ref reference, keys []minisql.Key) ([]minisql.Key, error) {
+	t, err := p.db.Table(ref.Key.Table)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := p.db.Table(ref.Table)
+	if err != nil {
+		return nil, err
+	}
+	var found []minisql.Key
+	for _, k := range keys {
+		vals, err := t.Select(k, []string{ref.Key.Column})
+		if err != nil {
+			return nil, err
+		}
+		if v := vals[ref.Key.Column]; v != nil {
+			found = append(found, minisql.FindKeys(rt, ref.Column, *v, -1)...)
+		}
+	}
+	return found, nil
+}
+
+// apply sets the referencing columns to NULL, then deletes the rows, returning the keys deleted from the named table.
+func (p *deletePlan) apply(tablename string) ([]minisql.Key, error) {
+	for tn, rows := range p.nulls {
+		t, err := p.db.Table(tn)
+		if err != nil {
+			return nil, err
+		}
+		for k, cols := range rows {
+			vals := minisql.Values{}
+			for _, cn := range cols {
+				vals[cn] = nil
+			}
+			if err = t.Update(k, vals); err != nil {
+				return nil, fmt.Errorf("failed to SET NULL the references of row %d of %s  %w", k, tn, err)
+			}
+		}
+	}
+	var deleted []minisql.Key
+	for tn, ks := range p.deletes {
+		t, err := p.db.Table(tn)
+		if err != nil {
+			return nil, err
+		}
+		keys := t.Delete(sortedKeySet(ks)...)
+		if tn == tablename {
+			deleted = keys
+		}
+	}
+	return deleted, nil
+}
+
+func sortedKeySet(ks map[minisql.Key]bool) []minisql.Key {
+	keys := make([]minisql.Key, 0, len(ks))
+	for k := range ks {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,100 @@
+package queries
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"strings"
+	"testing"
+)
+
+func foreignKeyTestDB(t *testing.T, onDelete string) *minisql.MiniDB {
+	db := minisql.NewDatabase(nil)
+	runQuery(t, db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	runQuery(t, db, "CREATE TABLE orders (id INTEGER PRIMARY KEY, user INTEGER REFERENCES users (id)"+onDelete+", item)")
+	runQuery(t, db, "CREATE TABLE lines (order_id INTEGER REFERENCES orders (id) ON DELETE CASCADE, line INTEGER, PRIMARY KEY (order_id, line))")
+	runQuery(t, db, "INSERT INTO users (id, name) VALUES (1, 'ann'), (2, 'bob')")
+	runQuery(t, db, "INSERT INTO orders (id, user, item) VALUES (10, 1, 'a'), (11, 1, 'b'), (12, 2, 'c'), (13, NULL, 'd')")
+	runQuery(t, db, "INSERT INTO lines (order_id, line) VALUES (10, 1), (10, 2), (12, 1)")
+	return db
+}
+
+func TestForeignKey_Insert(t *testing.T) {
+	db := foreignKeyTestDB(t, "")
+	for query, expect := range map[string]string{
+		"INSERT INTO orders (id, user) VALUES (14, 3)":      "FOREIGN KEY constraint of column user failed, '3' is not in users(id)",
+		"UPDATE orders SET user = 5 WHERE id = 10":          "FOREIGN KEY constraint of column user failed, '5' is not in users(id)",
+		"UPDATE users SET id = 3 WHERE id = 1":              "FOREIGN KEY constraint of orders(user) failed, '1' of users(id) is still referenced",
+		"INSERT INTO lines (order_id, line) VALUES (10, 1)": "PRIMARY KEY constraint of columns line, order_id failed",
+	} {
+		if err := queryError(db, query); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("expected %q to fail with %q, found %v", query, expect, err)
+		}
+	}
+	runQuery(t, db, "UPDATE orders SET user = 2 WHERE id = 11")
+	runQuery(t, db, "UPDATE users SET id = 1 WHERE id = 1")
+	runQuery(t, db, "INSERT INTO orders (id, user) VALUES (14, '02')")
+}
+
+func TestForeignKey_OnDelete(t *testing.T) {
+	db := foreignKeyTestDB(t, "")
+	if err := queryError(db, "DELETE FROM users WHERE id = 1"); err == nil || !strings.Contains(err.Error(), "FOREIGN KEY constraint of orders(user) failed") {
+		t.Fatalf("expected RESTRICT to refuse delete, found %v", err)
+	}
+	if rows := runQuery(t, db, "SELECT id FROM users", "id"); len(rows) != 2 {
+		t.Fatalf("expected no users to be deleted, found %v", rows)
+	}
+
+	db = foreignKeyTestDB(t, " ON DELETE CASCADE")
+	if rows := runQuery(t, db, "DELETE FROM users WHERE id = 1", "deleted"); strings.Join(rows, " ") != "1" {
+		t.Fatalf("expected one user deleted, found %v", rows)
+	}
+	if rows := runQuery(t, db, "SELECT id FROM orders ORDER BY id", "id"); strings.Join(rows, " ") != "12 13" {
+		t.Fatalf("expected orders of the user to be deleted, found %v", rows)
+	}
+	if rows := runQuery(t, db, "SELECT order_id, line FROM lines", "order_id", "line"); strings.Join(rows, " ") != "12,1" {
+		t.Fatalf("expected lines of the deleted orders to be deleted, found %v", rows)
+	}
+
+	db = foreignKeyTestDB(t, " ON DELETE SET NULL")
+	runQuery(t, db, "DELETE FROM users WHERE id = 1")
+	if rows := runQuery(t, db, "SELECT id, user FROM orders ORDER BY id", "id", "user"); strings.Join(rows, " ") != "10,NULL 11,NULL 12,2 13,NULL" {
+		t.Fatalf("expected orders of the user to reference NULL, found %v", rows)
+	}
+}
+
+func TestForeignKey_Create(t *testing.T) {
+	db := foreignKeyTestDB(t, "")
+	for query, expect := range map[string]string{
+		"CREATE TABLE x (a REFERENCES nothing (id))":                                   "nothing, which is not a known table",
+		"CREATE TABLE x (a INTEGER REFERENCES users (name))":                           "which is not a PRIMARY KEY or UNIQUE column",
+		"CREATE TABLE x (a TEXT REFERENCES users (id))":                                "of type TEXT references users(id) of type INTEGER",
+		"CREATE TABLE x (a INTEGER REFERENCES lines (order_id))":                       "which is not a PRIMARY KEY or UNIQUE column",
+		"CREATE TABLE x (a INTEGER NOT NULL REFERENCES users (id) ON DELETE SET NULL)": "can not be SET NULL",
+		"CREATE TABLE x (a INTEGER REFERENCES users (id) ON DELETE NOTHING)":           "is not a known action",
+		"CREATE TABLE x (a, PRIMARY KEY (b))":                                          "PRIMARY KEY column b is not a column of the table",
+		"CREATE COLUMN users (b PRIMARY KEY)":                                          "can only be created with table",
+		"DROP TABLE users":                                                             "table users is referenced by orders(user)",
+		"DROP DATABASE users, lines":                                                   "table users is referenced by orders(user)",
+		"DROP COLUMN users (id)":                                                       "PRIMARY KEY column id can not be dropped",
+		"DROP COLUMN orders (id)":                                                      "PRIMARY KEY column id can not be dropped",
+	} {
+		if err := queryError(db, query); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("expected %q to fail with %q, found %v", query, expect, err)
+		}
+	}
+	runQuery(t, db, "CREATE TABLE tree (id INTEGER PRIMARY KEY, parent INTEGER REFERENCES tree (id) ON DELETE CASCADE)")
+	runQuery(t, db, "INSERT INTO tree (id, parent) VALUES (1, NULL), (2, 1), (3, 2)")
+	runQuery(t, db, "DELETE FROM tree WHERE id = 2")
+	if rows := runQuery(t, db, "SELECT id FROM tree", "id"); strings.Join(rows, " ") != "1" {
+		t.Fatalf("expected descendants in the same table to be deleted, found %v", rows)
+	}
+
+	runQuery(t, db, "DROP TABLE users CASCADE")
+	if db.ContainsTable("users") {
+		t.Fatalf("expected users to be dropped")
+	}
+	orders, _ := db.Table("orders")
+	if cd, _ := orders.ColumnDef("user"); cd.References != nil {
+		t.Fatalf("expected foreign key of orders to be dropped, found %s", cd)
+	}
+	runQuery(t, db, "INSERT INTO orders (id, user) VALUES (20, 99)")
+}
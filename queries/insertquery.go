@@ -3,36 +3,45 @@ package queries
 import (
 	"context"
 	"eurozulu/miniSQL/minisql"
-	"eurozulu/miniSQL/stringutil"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/queries/whereclause"
 	"fmt"
 	"log"
-	"strings"
+	"strconv"
 )
 
 type InsertQuery struct {
 	TableName string
 	Columns   []string
-	Values    minisql.Values
-	Select    *SelectQuery
+	// Rows are the values of each row in the VALUES lists.
+	Rows []minisql.Values
+	// Parameters are the numbers of the parameters which give the values of the named columns, of each row.
+	Parameters []map[string]int
+	Select     *SelectQuery
 }
 
-func (q InsertQuery) Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Result, error) {
+// InsertsRows marks the query as inserting rows, each of its results being the _id of a row it inserted.
+func (q InsertQuery) InsertsRows() bool {
+	return true
+}
+
+func (q InsertQuery) Execute(ctx context.Context, db minisql.Database) (<-chan Result, error) {
 	// perform sanity checks on query before starting execution
 	t, err := db.Table(q.TableName)
 	if err != nil {
 		return nil, err
 	}
-	cols, err := expandColumnNames(t, q.Columns)
+	cols, _, err := expandColumnNames(t, q.Columns, nil, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%w in table %s", err, q.TableName)
 	}
 	q.Columns = cols
 	ch := make(chan Result)
 
-	go func(db *minisql.MiniDB, sq *InsertQuery, results chan<- Result) {
+	go func(db minisql.Database, sq *InsertQuery, results chan<- Result) {
 		defer close(results)
-		if sq.Values != nil {
-			err = sq.insertValues(ctx, db, results, sq.Values)
+		if sq.Rows != nil {
+			err = sq.insertRows(ctx, db, results)
 
 		} else if sq.Select != nil {
 			err = sq.insertSelect(ctx, db, results)
@@ -53,7 +62,24 @@ func (q InsertQuery) Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Re
 	return ch, nil
 }
 
-func (q InsertQuery) insertSelect(ctx context.Context, db *minisql.MiniDB, results chan<- Result) error {
+// insertRows inserts the rows of the VALUES lists.
+// If any insert fails, all the rows already inserted by the query are removed.
+func (q InsertQuery) insertRows(ctx context.Context, db minisql.Database, results chan<- Result) error {
+	var inserted []minisql.Key
+	for _, vals := range q.Rows {
+		id, err := q.insertValues(ctx, db, results, vals)
+		if err != nil {
+			q.removeInserted(db, inserted)
+			return err
+		}
+		inserted = append(inserted, id)
+	}
+	return nil
+}
+
+// insertSelect inserts the results of the select query.
+// If any insert fails, all the rows already inserted by the query are removed.
+func (q InsertQuery) insertSelect(ctx context.Context, db minisql.Database, results chan<- Result) error {
 	// use sub context to cancel select if error encountered
 	subCtx, cnl := context.WithCancel(ctx)
 	defer cnl()
@@ -62,107 +88,178 @@ func (q InsertQuery) insertSelect(ctx context.Context, db *minisql.MiniDB, resul
 	if err != nil {
 		return fmt.Errorf("SELECT query of %s failed  %v", q.Select.TableName, err)
 	}
+	var inserted []minisql.Key
 	for r := range rs {
 		vals := r.Values()
+		if e, ok := vals["ERROR"]; ok && len(vals) == 1 {
+			err = fmt.Errorf("SELECT query of %s failed  %s", q.Select.TableName, *e)
+			break
+		}
 		delete(vals, "_id")
-		if err = q.insertValues(ctx, db, results, vals); err != nil {
-			return err
+		var id minisql.Key
+		if id, err = q.insertValues(ctx, db, results, vals); err != nil {
+			break
 		}
+		inserted = append(inserted, id)
+	}
+	if err != nil {
+		cnl()
+		q.removeInserted(db, inserted)
+		return err
 	}
 	return nil
 }
 
-func (q InsertQuery) insertValues(ctx context.Context, db *minisql.MiniDB, results chan<- Result, values minisql.Values) error {
-	t, _ := db.Table(q.TableName)
+func (q InsertQuery) removeInserted(db minisql.Database, inserted []minisql.Key) {
+	if t, err := db.Table(q.TableName); err == nil {
+		t.Delete(inserted...)
+	}
+}
+
+func (q InsertQuery) insertValues(ctx context.Context, db minisql.Database, results chan<- Result, values minisql.Values) (minisql.Key, error) {
+	t, err := db.Table(q.TableName)
+	if err != nil {
+		return -1, err
+	}
 	if len(q.Columns) != len(values) {
-		return fmt.Errorf("columns / values count mismatch")
+		return -1, fmt.Errorf("columns / values count mismatch")
+	}
+	if err = minisql.CheckReferences(db, t, values); err != nil {
+		return -1, fmt.Errorf("failed to insert into table %q  %w", q.TableName, err)
 	}
 	id, err := t.Insert(values)
 	if err != nil {
-		return fmt.Errorf("failed to insert into table %q  %w", q.TableName, err)
+		return -1, fmt.Errorf("failed to insert into table %q  %w", q.TableName, err)
 	}
-	idp := fmt.Sprintf("%s:%d", q.TableName, id)
+	ids := strconv.Itoa(int(id))
 	select {
 	case <-ctx.Done():
 		break
-	case results <- NewResult(q.TableName, minisql.Values{"inserted": &idp}):
+	case results <- NewResult(q.TableName, minisql.Values{"_id": &ids}):
 	}
-	return nil
+	return id, nil
 }
 
-func valuesList(keys []string, vals []string) (minisql.Values, error) {
+func valuesList(keys []string, vals []*string) (minisql.Values, error) {
 	if len(keys) != len(vals) {
 		return nil, fmt.Errorf("columns / values count mismatch")
 	}
 	vm := minisql.Values{}
 	for i, k := range keys {
-		vs := strings.Trim(vals[i], "'")
-		vm[k] = &vs
+		vm[k] = vals[i]
 	}
 	return vm, nil
 }
 
-func newInsertSelectQuery(table string, cols []string, query string) (*InsertQuery, error) {
-	q, err := NewSelectQuery(query)
-	if err != nil {
-		return nil, err
+// readValueList reads a bracketed, comma delimited list of values, any of which may be a parameter placeholder.
+// Returns the values and the number of the parameter of each value, which is zero for literal values.
+func readValueList(ts *lexer.TokenStream) ([]*string, []int, error) {
+	if err := ts.ExpectSymbol("("); err != nil {
+		return nil, nil, err
+	}
+	var vals []*string
+	var params []int
+	for {
+		v, param, err := whereclause.ReadValueOrParameter(ts)
+		if err != nil {
+			return nil, nil, err
+		}
+		vals = append(vals, v)
+		params = append(params, param)
+		if !ts.AcceptSymbol(",") {
+			break
+		}
 	}
-	return &InsertQuery{
-		TableName: table,
-		Columns:   cols,
-		Select:    q,
-	}, nil
+	if err := ts.ExpectSymbol(")"); err != nil {
+		return nil, nil, err
+	}
+	return vals, params, nil
 }
 
-func newInsertValuesQuery(table string, cols []string, values string) (*InsertQuery, error) {
-	valList, rest := stringutil.BracketedString(values)
-	if valList == "" {
-		return nil, fmt.Errorf("no values found after VALUES.  Place comma delimited values in brackets. Must be same amount of values as columns")
+// readInsertQuery reads an INSERT query from the tokens, following the INSERT keyword.
+func readInsertQuery(ts *lexer.TokenStream, compat bool) (*InsertQuery, error) {
+	if err := ts.ExpectKeyword("INTO"); err != nil {
+		return nil, err
 	}
-	if strings.TrimSpace(rest) != "" {
-		return nil, fmt.Errorf("unexpected text found %q after vqlues", rest)
+	table, err := ts.ExpectName("table name after INTO")
+	if err != nil {
+		return nil, err
 	}
-	vs, err := valuesList(cols, stringutil.SplitTrim(valList, ","))
+	if !ts.Peek().IsSymbol("(") {
+		return nil, ts.Errorf(ts.Peek(), "invalid INSERT query.  No columns found. list columns to insert, inside brackets")
+	}
+	cols, err := ts.ExpectNameList("column name")
 	if err != nil {
 		return nil, err
 	}
-	return &InsertQuery{
-		TableName: table,
-		Columns:   cols,
-		Values:    vs,
-	}, nil
+
+	t := ts.Peek()
+	switch {
+	case ts.AcceptKeyword("VALUES"):
+		q := &InsertQuery{
+			TableName: table,
+			Columns:   cols,
+		}
+		for {
+			t := ts.Peek()
+			vals, params, err := readValueList(ts)
+			if err != nil {
+				return nil, err
+			}
+			vs, err := valuesList(cols, vals)
+			if err != nil {
+				return nil, ts.Errorf(t, "%v", err)
+			}
+			var pm map[string]int
+			for i, p := range params {
+				if p == 0 {
+					continue
+				}
+				if pm == nil {
+					pm = map[string]int{}
+				}
+				pm[cols[i]] = p
+			}
+			q.Rows = append(q.Rows, vs)
+			q.Parameters = append(q.Parameters, pm)
+			if !ts.AcceptSymbol(",") {
+				break
+			}
+		}
+		return q, nil
+
+	case ts.AcceptKeyword("SELECT"):
+		q, err := readSelectQuery(ts, compat)
+		if err != nil {
+			return nil, err
+		}
+		return &InsertQuery{
+			TableName: table,
+			Columns:   cols,
+			Select:    q,
+		}, nil
+
+	default:
+		return nil, ts.Errorf(t, "invalid INSERT query.  missing VALUES or SELECT keyword, found %s", t)
+	}
 }
 
 // NewInsertQuery creates a new insert query from the given string
 // i.e it should begin with the INTO keyword.
-// e.g. "INTO mytable (col1, col2, col3) VALUES ("one", "two", "three") "
+// e.g. "INTO mytable (col1, col2, col3) VALUES ("one", "two", "three"), ("four", "five", "six") "
 func NewInsertQuery(q string) (*InsertQuery, error) {
-	// Strip any leading INSERT and INTO commands
-	if strings.HasPrefix(strings.ToUpper(q), "INSERT") {
-		_, q = stringutil.FirstWord(q)
-	}
-	if strings.HasPrefix(strings.ToUpper(q), "INTO") {
-		_, q = stringutil.FirstWord(q)
-	} else {
-		return nil, fmt.Errorf("missing INTO in query")
-	}
-	table, rest := stringutil.FirstWord(q)
-	if table == "" {
-		return nil, fmt.Errorf("missing table name after INTO")
-	}
-	var colList string
-	colList, rest = stringutil.BracketedString(rest)
-	if colList == "" {
-		return nil, fmt.Errorf("invalid INSERT query.  No columns found. list columns to insert, inside brackets")
+	ts, err := lexer.NewTokenStream(q)
+	if err != nil {
+		return nil, err
 	}
-	cols := stringutil.SplitTrim(colList, ",")
-	rest = strings.TrimSpace(rest)
-
-	if strings.HasPrefix(strings.ToUpper(rest), "VALUES") {
-		return newInsertValuesQuery(table, cols, strings.TrimSpace(rest[len("VALUES"):]))
+	// Strip any leading INSERT
+	ts.AcceptKeyword("INSERT")
+	iq, err := readInsertQuery(ts, false)
+	if err != nil {
+		return nil, err
 	}
-	if strings.HasPrefix(strings.ToUpper(rest), "SELECT") {
-		return newInsertSelectQuery(table, cols, strings.TrimSpace(rest[len("SELECT"):]))
+	if err = ts.ExpectEnd(); err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("invalid INSERT query.  missing VALUES or SELECT keyword")
+	return iq, nil
 }
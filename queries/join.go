@@ -0,0 +1,561 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"fmt"
+	"strings"
+)
+
+// JoinType is the kind of join, defining which rows are kept when they have no matching row in the other table.
+type JoinType string
+
+const (
+	// INNER_JOIN keeps only the rows with a match in both tables.
+	INNER_JOIN JoinType = "INNER"
+	// LEFT_JOIN keeps every row of the left tables, with NULL values where the joined table has no match.
+	LEFT_JOIN JoinType = "LEFT"
+	// RIGHT_JOIN keeps every row of the joined table, with NULL values where the left tables have no match.
+	RIGHT_JOIN JoinType = "RIGHT"
+	// FULL_JOIN keeps every row of both sides, with NULL values where either side has no match.
+	FULL_JOIN JoinType = "FULL"
+	// CROSS_JOIN joins every row of the left tables with every row of the joined table.
+	CROSS_JOIN JoinType = "CROSS"
+)
+
+// Join joins a table to the rows of the tables preceding it in a SELECT.
+type Join struct {
+	Type      JoinType
+	TableName string
+	Alias     string
+	// On are the columns which must be equal for rows to join.
+	On []JoinCondition
+}
+
+// JoinCondition compares a column of the joined table with a column of a preceding table, for equality.
+type JoinCondition struct {
+	Left  string
+	Right string
+}
+
+// reservedWords are the keywords which may follow a table name, so can not be used as a table alias without AS.
+var reservedWords = []string{
+	"WHERE", "ORDER", "GROUP", "HAVING", "LIMIT", "OFFSET", "ON", "USING",
+	"JOIN", "INNER", "LEFT", "RIGHT", "FULL", "OUTER", "CROSS",
+}
+
+// readTableAlias reads an optional alias following a table name. e.g. mytable AS t or mytable t
+func readTableAlias(ts *lexer.TokenStream) (string, error) {
+	if ts.AcceptKeyword("AS") {
+		return ts.ExpectName("table alias after AS")
+	}
+	if t := ts.Peek(); t.IsName() && !t.IsKeyword(reservedWords...) {
+		return ts.Next().Text, nil
+	}
+	return "", nil
+}
+
+// readJoins reads any JOIN clauses following the first table of a SELECT.
+// [INNER | LEFT [OUTER] | RIGHT [OUTER] | FULL [OUTER]] JOIN <table> [[AS] <alias>] ON <column> = <column> [AND <column> = <column>...]
+// CROSS JOIN <table> [[AS] <alias>]
+func readJoins(ts *lexer.TokenStream) ([]*Join, error) {
+	var joins []*Join
+	for {
+		t := ts.Peek()
+		var jt JoinType
+		switch {
+		case t.IsKeyword("JOIN"):
+			jt = INNER_JOIN
+		case t.IsKeyword(string(INNER_JOIN), string(CROSS_JOIN)):
+			ts.Next()
+			jt = JoinType(strings.ToUpper(t.Text))
+		case t.IsKeyword(string(LEFT_JOIN), string(RIGHT_JOIN), string(FULL_JOIN)):
+			ts.Next()
+			jt = JoinType(strings.ToUpper(t.Text))
+			ts.AcceptKeyword("OUTER")
+		default:
+			return joins, nil
+		}
+		if err := ts.ExpectKeyword("JOIN"); err != nil {
+			return nil, err
+		}
+		table, err := ts.ExpectName("table name to join")
+		if err != nil {
+			return nil, err
+		}
+		alias, err := readTableAlias(ts)
+		if err != nil {
+			return nil, err
+		}
+		j := &Join{Type: jt, TableName: table, Alias: alias}
+		if jt != CROSS_JOIN {
+			if err = ts.ExpectKeyword("ON"); err != nil {
+				return nil, err
+			}
+			if j.On, err = readJoinConditions(ts); err != nil {
+				return nil, err
+			}
+		}
+		joins = append(joins, j)
+	}
+}
+
+// readJoinConditions reads the ON conditions of a join, one or more column equalities, linked with AND.
+func readJoinConditions(ts *lexer.TokenStream) ([]JoinCondition, error) {
+	var conds []JoinCondition
+	for {
+		left, err := ts.ExpectQualifiedName("column name")
+		if err != nil {
+			return nil, err
+		}
+		if t := ts.Peek(); !t.IsSymbol("=") {
+			return nil, ts.Errorf(t, "expected '=' in JOIN condition, only equality joins are supported, found %s", t)
+		}
+		ts.Next()
+		right, err := ts.ExpectQualifiedName("column name")
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, JoinCondition{Left: left, Right: right})
+		if !ts.AcceptKeyword("AND") {
+			return conds, nil
+		}
+	}
+}
+
+// joinSource is one of the tables of a joined view, with the name its columns are qualified with.
+type joinSource struct {
+	alias string
+	table minisql.Table
+	// on are the columns of this table, and of the preceding tables, which must be equal to join.
+	on []joinColumns
+	jt JoinType
+}
+
+// joinColumns are a pair of resolved columns compared to join rows.
+type joinColumns struct {
+	left  columnRef
+	right string
+}
+
+// columnRef refers to a column of one of the joined tables.
+type columnRef struct {
+	source int
+	column string
+}
+
+// noKey marks a missing row of an outer join, where all the columns of that table are NULL.
+const noKey minisql.Key = -1
+
+// joinView presents the rows of joined tables as a single, read only, table.
+// Each row of the view is the key of a row from each of the tables, or noKey where an outer join found no match.
+// Columns are named with their table name or alias, e.g. mytable.mycolumn, and may be used unqualified
+// when only one of the tables has a column with that name.
+// The rows are joined when first read.  A view of a single table, used to name the table with an alias,
+// has the same rows and keys as the table.
+type joinView struct {
+	ctx     context.Context
+	name    string
+	sources []*joinSource
+	rows    [][]minisql.Key
+	joined  bool
+}
+
+func (v *joinView) ColumnNames() []string {
+	var names []string
+	for _, src := range v.sources {
+		for _, cn := range src.table.ColumnNames() {
+			names = append(names, src.alias+"."+cn)
+		}
+	}
+	return names
+}
+
+// allColumnNames gets the qualified names of every column, including the _id columns, table by table.
+func (v *joinView) allColumnNames() []string {
+	var names []string
+	for i := range v.sources {
+		names = append(names, v.sourceColumnNames(i)...)
+	}
+	return names
+}
+
+func (v *joinView) sourceColumnNames(i int) []string {
+	src := v.sources[i]
+	names := []string{src.alias + "." + minisql.IDColumn}
+	for _, cn := range src.table.ColumnNames() {
+		names = append(names, src.alias+"."+cn)
+	}
+	return names
+}
+
+// sourceIndex finds the table with the given alias.
+func (v *joinView) sourceIndex(alias string) int {
+	for i, src := range v.sources {
+		if src.alias == alias {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolve finds the table and column a column name refers to.
+func (v *joinView) resolve(name string) (columnRef, error) {
+	if i := strings.Index(name, "."); i >= 0 {
+		alias, cn := name[:i], name[i+1:]
+		si := v.sourceIndex(alias)
+		if si < 0 {
+			return columnRef{}, fmt.Errorf("%s is an unknown table in column %s", alias, name)
+		}
+		if !hasColumn(v.sources[si].table, cn) {
+			return columnRef{}, fmt.Errorf("%s is an unknown column", name)
+		}
+		return columnRef{source: si, column: cn}, nil
+	}
+	ref := columnRef{source: -1, column: name}
+	for i, src := range v.sources {
+		if !hasColumn(src.table, name) {
+			continue
+		}
+		if ref.source >= 0 {
+			return columnRef{}, fmt.Errorf("%s is an ambiguous column, qualify it with its table name", name)
+		}
+		ref.source = i
+	}
+	if ref.source < 0 {
+		return columnRef{}, fmt.Errorf("%s is an unknown column", name)
+	}
+	return ref, nil
+}
+
+func (v *joinView) ColumnDef(name string) (*minisql.ColumnDef, error) {
+	ref, err := v.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return v.sources[ref.source].table.ColumnDef(ref.column)
+}
+
+func (v *joinView) AlterColumns(_ map[string]*minisql.ColumnDef) {
+}
+
+func (v *joinView) ContainsID(k minisql.Key) bool {
+	_, ok := v.row(k)
+	return ok
+}
+
+func (v *joinView) NextID() minisql.Key {
+	if len(v.sources) == 1 {
+		return v.sources[0].table.NextID()
+	}
+	v.join()
+	return minisql.Key(len(v.rows))
+}
+
+// row gets the keys of each table in the given row of the view.
+func (v *joinView) row(id minisql.Key) ([]minisql.Key, bool) {
+	if len(v.sources) == 1 {
+		return []minisql.Key{id}, v.sources[0].table.ContainsID(id)
+	}
+	v.join()
+	if id < 0 || int(id) >= len(v.rows) {
+		return nil, false
+	}
+	return v.rows[id], true
+}
+
+func (v *joinView) Select(id minisql.Key, columns []string) (minisql.Values, error) {
+	row, ok := v.row(id)
+	if !ok {
+		return nil, fmt.Errorf("%d is not a known row", id)
+	}
+	// group the columns by their table, to select each tables columns at once
+	refs := make([]columnRef, len(columns))
+	bySource := map[int][]string{}
+	for i, c := range columns {
+		ref, err := v.resolve(c)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = ref
+		bySource[ref.source] = append(bySource[ref.source], ref.column)
+	}
+	selected := map[int]minisql.Values{}
+	for si, cols := range bySource {
+		if row[si] == noKey {
+			selected[si] = minisql.Values{}
+			continue
+		}
+		vals, err := v.sources[si].table.Select(row[si], cols)
+		if err != nil {
+			return nil, err
+		}
+		selected[si] = vals
+	}
+	vals := minisql.Values{}
+	for i, c := range columns {
+		vals[c] = selected[refs[i].source][refs[i].column]
+	}
+	return vals, nil
+}
+
+func (v *joinView) Insert(_ minisql.Values) (minisql.Key, error) {
+	return -1, fmt.Errorf("joined rows can not be inserted")
+}
+
+func (v *joinView) Update(_ minisql.Key, _ minisql.Values) error {
+	return fmt.Errorf("joined rows can not be updated")
+}
+
+func (v *joinView) Delete(_ ...minisql.Key) []minisql.Key {
+	return nil
+}
+
+// IndexKeys finds rows using the indexes of the table, when the view is of a single table.
+func (v *joinView) IndexKeys(r minisql.KeyRange) ([]minisql.Key, bool) {
+	if len(v.sources) != 1 {
+		return nil, false
+	}
+	ix, ok := v.sources[0].table.(minisql.Indexer)
+	if !ok {
+		return nil, false
+	}
+	ref, err := v.resolve(r.Column)
+	if err != nil {
+		return nil, false
+	}
+	r.Column = ref.column
+	// rows of a single table view are numbered by the tables keys
+	return ix.IndexKeys(r)
+}
+
+func (v *joinView) CreateIndex(_ minisql.IndexDef) error {
+	return fmt.Errorf("joined rows can not be indexed")
+}
+
+func (v *joinView) DropIndex(_ string) error {
+	return fmt.Errorf("joined rows can not be indexed")
+}
+
+func (v *joinView) Indexes() []minisql.IndexDef {
+	return nil
+}
+
+// join reads the keys of the joined rows, the first time it is called.
+// It is only called from the single goroutine reading the view.
+func (v *joinView) join() {
+	if v.joined {
+		return
+	}
+	v.joined = true
+	for _, k := range tableKeys(v.sources[0].table) {
+		v.rows = append(v.rows, []minisql.Key{k})
+	}
+	for si := 1; si < len(v.sources); si++ {
+		if v.ctx.Err() != nil {
+			v.rows = nil
+			return
+		}
+		v.rows = v.joinSource(si, v.rows)
+	}
+}
+
+// joinSource joins the rows of the source at the given index to the rows of the preceding sources.
+// Rows are matched with a hash of the joined tables values, so each table is read only once.
+func (v *joinView) joinSource(si int, rows [][]minisql.Key) [][]minisql.Key {
+	src := v.sources[si]
+	rightKeys := tableKeys(src.table)
+	var joined [][]minisql.Key
+	appendRow := func(left []minisql.Key, right minisql.Key) {
+		row := make([]minisql.Key, si+1)
+		copy(row, left)
+		row[si] = right
+		joined = append(joined, row)
+	}
+
+	if src.jt == CROSS_JOIN {
+		for _, row := range rows {
+			for _, rk := range rightKeys {
+				appendRow(row, rk)
+			}
+		}
+		return joined
+	}
+
+	// build the hash of the joined tables rows
+	rightCols := make([]string, len(src.on))
+	for i, on := range src.on {
+		rightCols[i] = on.right
+	}
+	hash := map[string][]minisql.Key{}
+	for _, rk := range rightKeys {
+		vals, err := src.table.Select(rk, rightCols)
+		if err != nil {
+			continue
+		}
+		if hk, ok := joinHashKey(src.table, rightCols, vals); ok {
+			hash[hk] = append(hash[hk], rk)
+		}
+	}
+
+	// probe the hash with each of the preceding rows
+	matched := map[minisql.Key]bool{}
+	for _, row := range rows {
+		var matches []minisql.Key
+		if hk, ok := v.leftHashKey(src, row); ok {
+			matches = hash[hk]
+		}
+		for _, rk := range matches {
+			appendRow(row, rk)
+			matched[rk] = true
+		}
+		if len(matches) == 0 && (src.jt == LEFT_JOIN || src.jt == FULL_JOIN) {
+			appendRow(row, noKey)
+		}
+	}
+	if src.jt == RIGHT_JOIN || src.jt == FULL_JOIN {
+		empty := make([]minisql.Key, si)
+		for i := range empty {
+			empty[i] = noKey
+		}
+		for _, rk := range rightKeys {
+			if !matched[rk] {
+				appendRow(empty, rk)
+			}
+		}
+	}
+	return joined
+}
+
+// leftHashKey gets the hash key of the preceding tables values, compared by the join.
+func (v *joinView) leftHashKey(src *joinSource, row []minisql.Key) (string, bool) {
+	vals := make([]*string, len(src.on))
+	for i, on := range src.on {
+		k := row[on.left.source]
+		if k == noKey {
+			return "", false
+		}
+		t := v.sources[on.left.source].table
+		sv, err := t.Select(k, []string{on.left.column})
+		if err != nil {
+			return "", false
+		}
+		vals[i] = canonicalValue(t, on.left.column, sv[on.left.column])
+	}
+	return hashKeyOf(vals)
+}
+
+// joinHashKey gets the hash key of the given values of the named columns.
+func joinHashKey(t minisql.Table, cols []string, values minisql.Values) (string, bool) {
+	vals := make([]*string, len(cols))
+	for i, c := range cols {
+		vals[i] = canonicalValue(t, c, values[c])
+	}
+	return hashKeyOf(vals)
+}
+
+// hashKeyOf encodes the values into a single key.  Rows with a NULL value never join, so have no key.
+func hashKeyOf(vals []*string) (string, bool) {
+	buf := strings.Builder{}
+	for _, v := range vals {
+		if v == nil {
+			return "", false
+		}
+		buf.WriteString(fmt.Sprintf("%d:%s", len(*v), *v))
+	}
+	return buf.String(), true
+}
+
+// canonicalValue gets the value in the canonical form of its columns type, so equal values of different forms, e.g. 1.0 and 1, join.
+func canonicalValue(t minisql.Table, column string, v *string) *string {
+	if v == nil {
+		return nil
+	}
+	cd, err := t.ColumnDef(column)
+	if err != nil {
+		return v
+	}
+	cv, err := cd.Type.Convert(*v)
+	if err != nil {
+		return v
+	}
+	return &cv
+}
+
+// tableKeys gets all the keys of the rows in the table, in order.
+func tableKeys(t minisql.Table) []minisql.Key {
+	var keys []minisql.Key
+	last := t.NextID()
+	for k := minisql.Key(0); k < last; k++ {
+		if t.ContainsID(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// hasColumn checks if the table has the named column, or it is the _id column.
+func hasColumn(t minisql.Table, name string) bool {
+	_, err := t.ColumnDef(name)
+	return err == nil
+}
+
+// newJoinView creates a view of the given table joined with the given joins.
+// The join conditions are validated, each must compare a column of the joined table with a column of a preceding table.
+func newJoinView(ctx context.Context, db minisql.Database, tablename, alias string, joins []*Join) (*joinView, error) {
+	t, err := db.Table(tablename)
+	if err != nil {
+		return nil, err
+	}
+	if alias == "" {
+		alias = tablename
+	}
+	names := []string{tablename}
+	v := &joinView{ctx: ctx, sources: []*joinSource{{alias: alias, table: t}}}
+	for _, j := range joins {
+		jt, err := db.Table(j.TableName)
+		if err != nil {
+			return nil, err
+		}
+		a := j.Alias
+		if a == "" {
+			a = j.TableName
+		}
+		if v.sourceIndex(a) >= 0 {
+			return nil, fmt.Errorf("table name %s appears more than once, use an alias to name each table", a)
+		}
+		v.sources = append(v.sources, &joinSource{alias: a, table: jt, jt: j.Type})
+		names = append(names, j.TableName)
+		si := len(v.sources) - 1
+		for _, on := range j.On {
+			jc, err := v.resolveJoinCondition(si, on)
+			if err != nil {
+				return nil, err
+			}
+			v.sources[si].on = append(v.sources[si].on, jc)
+		}
+	}
+	v.name = strings.Join(names, " JOIN ")
+	return v, nil
+}
+
+// resolveJoinCondition resolves the columns of the condition, one of which must belong to the joined table, at the given index.
+func (v *joinView) resolveJoinCondition(si int, on JoinCondition) (joinColumns, error) {
+	left, err := v.resolve(on.Left)
+	if err != nil {
+		return joinColumns{}, err
+	}
+	right, err := v.resolve(on.Right)
+	if err != nil {
+		return joinColumns{}, err
+	}
+	if left.source == si {
+		left, right = right, left
+	}
+	if right.source != si || left.source >= si {
+		return joinColumns{}, fmt.Errorf("join condition %s = %s must compare a column of %s with a column of a preceding table",
+			on.Left, on.Right, v.sources[si].alias)
+	}
+	return joinColumns{left: left, right: right.column}, nil
+}
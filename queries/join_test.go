@@ -0,0 +1,184 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func joinTestDB(t *testing.T) *minisql.MiniDB {
+	tdb := minisql.NewDatabase(minisql.Schema{
+		"customers": {"id": {Type: minisql.INTEGER}, "name": {Type: minisql.TEXT}},
+		"orders":    {"cid": {Type: minisql.INTEGER}, "total": {Type: minisql.INTEGER}},
+	})
+	for _, ins := range []string{
+		"INSERT INTO customers (id, name) VALUES (1, 'ann')",
+		"INSERT INTO customers (id, name) VALUES (2, 'bob')",
+		"INSERT INTO customers (id, name) VALUES (3, 'cat')",
+		"INSERT INTO orders (cid, total) VALUES (1, 10)",
+		"INSERT INTO orders (cid, total) VALUES (1, 20)",
+		"INSERT INTO orders (cid, total) VALUES (2, 30)",
+		"INSERT INTO orders (cid, total) VALUES (4, 40)",
+		"INSERT INTO orders (total) VALUES (50)",
+	} {
+		runQuery(t, tdb, ins)
+	}
+	return tdb
+}
+
+// runQuery runs the given query, returning its results as strings of the given columns values.
+func runQuery(t *testing.T, db minisql.Database, query string, columns ...string) []string {
+	q, err := ParseQuery(query)
+	if err != nil {
+		t.Fatalf("Failed to parse query %q  %s", query, err)
+	}
+	rCh, err := q.Execute(context.TODO(), db)
+	if err != nil {
+		t.Fatalf("failed to execute query %q  %s", query, err)
+	}
+	var rows []string
+	for r := range rCh {
+		if e, ok := r.Values()["ERROR"]; ok {
+			t.Fatalf("unexpected error in %q  %s", query, *e)
+		}
+		var vals []string
+		for _, c := range columns {
+			v, ok := r.Values()[c]
+			switch {
+			case !ok:
+				t.Fatalf("column %s missing from result of %q  %v", c, query, r.Values())
+			case v == nil:
+				vals = append(vals, "NULL")
+			default:
+				vals = append(vals, *v)
+			}
+		}
+		rows = append(rows, strings.Join(vals, ","))
+	}
+	return rows
+}
+
+func TestSelectQuery_Joins(t *testing.T) {
+	tdb := joinTestDB(t)
+	tests := []struct {
+		query   string
+		columns []string
+		expect  []string
+	}{
+		{"SELECT c.name, o.total FROM customers c JOIN orders o ON c.id = o.cid",
+			[]string{"c.name", "o.total"}, []string{"ann,10", "ann,20", "bob,30"}},
+		{"SELECT name, total FROM customers INNER JOIN orders ON customers.id = orders.cid",
+			[]string{"name", "total"}, []string{"ann,10", "ann,20", "bob,30"}},
+		{"SELECT c.name, o.total FROM customers c LEFT JOIN orders o ON c.id = o.cid",
+			[]string{"c.name", "o.total"}, []string{"ann,10", "ann,20", "bob,30", "cat,NULL"}},
+		{"SELECT c.name, o.total FROM customers c RIGHT JOIN orders o ON o.cid = c.id",
+			[]string{"c.name", "o.total"}, []string{"NULL,40", "NULL,50", "ann,10", "ann,20", "bob,30"}},
+		{"SELECT c.name, o.total FROM customers c FULL JOIN orders o ON c.id = o.cid",
+			[]string{"c.name", "o.total"}, []string{"NULL,40", "NULL,50", "ann,10", "ann,20", "bob,30", "cat,NULL"}},
+		{"SELECT c.name, o.total FROM customers c CROSS JOIN orders o WHERE o.total > 30",
+			[]string{"c.name", "o.total"}, []string{"ann,40", "ann,50", "bob,40", "bob,50", "cat,40", "cat,50"}},
+		{"SELECT c.name, o.total FROM customers c LEFT JOIN orders o ON c.id = o.cid WHERE o.total IS NULL",
+			[]string{"c.name", "o.total"}, []string{"cat,NULL"}},
+		{"SELECT o.* FROM customers c JOIN orders o ON c.id = o.cid WHERE c.name = 'bob'",
+			[]string{"o._id", "o.cid", "o.total"}, []string{"2,2,30"}},
+	}
+	for _, test := range tests {
+		rows := runQuery(t, tdb, test.query, test.columns...)
+		sort.Strings(rows)
+		if !reflect.DeepEqual(rows, test.expect) {
+			t.Fatalf("unexpected result of %q, expected %v, found %v", test.query, test.expect, rows)
+		}
+	}
+}
+
+func TestSelectQuery_JoinOrderBy(t *testing.T) {
+	tdb := joinTestDB(t)
+	rows := runQuery(t, tdb, "SELECT c.name FROM customers c JOIN orders o ON c.id = o.cid ORDER BY o.total DESC", "c.name")
+	if !reflect.DeepEqual(rows, []string{"bob", "ann", "ann"}) {
+		t.Fatalf("unexpected order, found %v", rows)
+	}
+	q, _ := ParseQuery("SELECT c.name FROM customers c JOIN orders o ON c.id = o.cid ORDER BY o.total")
+	rCh, _ := q.Execute(context.TODO(), tdb)
+	for r := range rCh {
+		if _, ok := r.Values()["o.total"]; ok {
+			t.Fatalf("expected order by column to be removed from result, found %v", r.Values())
+		}
+	}
+}
+
+func TestSelectQuery_JoinInto(t *testing.T) {
+	tdb := joinTestDB(t)
+	runQuery(t, tdb, "SELECT c.name, o.total AS amount INTO spend FROM customers c JOIN orders o ON c.id = o.cid")
+	rows := runQuery(t, tdb, "SELECT name, amount FROM spend ORDER BY amount", "name", "amount")
+	if !reflect.DeepEqual(rows, []string{"ann,10", "ann,20", "bob,30"}) {
+		t.Fatalf("unexpected rows selected into new table, found %v", rows)
+	}
+	q, _ := ParseQuery("SELECT c.name, o.total AS name INTO names FROM customers c JOIN orders o ON c.id = o.cid")
+	rCh, err := q.Execute(context.TODO(), tdb)
+	if err != nil {
+		t.Fatalf("failed to execute query  %s", err)
+	}
+	r := <-rCh
+	if r == nil || r.Values()["ERROR"] == nil {
+		t.Fatalf("expected error selecting duplicate column names into a table")
+	}
+}
+
+func TestSelectQuery_JoinErrors(t *testing.T) {
+	tdb := joinTestDB(t)
+	for _, query := range []string{
+		"SELECT _id FROM customers c JOIN orders o ON c.id = o.cid",
+		"SELECT c.missing FROM customers c JOIN orders o ON c.id = o.cid",
+		"SELECT * FROM customers c JOIN orders c ON c.id = c.cid",
+		"SELECT * FROM customers c JOIN orders o ON o.cid = o.total",
+		"SELECT * FROM customers c JOIN missing m ON c.id = m.id",
+		"SELECT x.* FROM customers c JOIN orders o ON c.id = o.cid",
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse query %q  %s", query, err)
+		}
+		if _, err = q.Execute(context.TODO(), tdb); err == nil {
+			t.Fatalf("expected error executing %q", query)
+		}
+	}
+	for _, query := range []string{
+		"SELECT * FROM customers c JOIN orders o",
+		"SELECT * FROM customers c JOIN orders o ON c.id > o.cid",
+		"SELECT * FROM customers c CROSS JOIN orders o ON c.id = o.cid",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Fatalf("expected error parsing %q", query)
+		}
+	}
+}
+
+func TestSelectQuery_HashJoin(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{
+		"l": {"k": {Type: minisql.INTEGER}},
+		"r": {"k": {Type: minisql.INTEGER}, "v": {Type: minisql.TEXT}},
+	})
+	for i := 0; i < 200; i++ {
+		runQuery(t, tdb, fmt.Sprintf("INSERT INTO l (k) VALUES (%d)", i%50))
+		runQuery(t, tdb, fmt.Sprintf("INSERT INTO r (k, v) VALUES (%d, 'v%d')", i%70, i))
+	}
+	joined := runQuery(t, tdb, "SELECT l._id, r._id FROM l JOIN r ON l.k = r.k", "l._id", "r._id")
+	// compare with every pair of rows, joined by a cross join
+	crossed := runQuery(t, tdb, "SELECT l._id, r._id, l.k, r.k FROM l CROSS JOIN r", "l._id", "r._id", "l.k", "r.k")
+	var expect []string
+	for _, row := range crossed {
+		vals := strings.Split(row, ",")
+		if vals[2] == vals[3] {
+			expect = append(expect, strings.Join(vals[:2], ","))
+		}
+	}
+	sort.Strings(joined)
+	sort.Strings(expect)
+	if len(expect) == 0 || !reflect.DeepEqual(joined, expect) {
+		t.Fatalf("hash join differs from cross join, expected %d rows, found %d", len(expect), len(joined))
+	}
+}
@@ -0,0 +1,386 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenType is the kind of a Token
+type TokenType int
+
+const (
+	EOF TokenType = iota
+	// IDENT is an unquoted word, such as a keyword, table or column name.
+	IDENT
+	// QUOTED_IDENT is a name enclosed in back quotes, e.g. `my column`.
+	QUOTED_IDENT
+	// STRING is a literal enclosed in single or double quotes.  The token text is the unquoted string.
+	STRING
+	// NUMBER is an unsigned integer or decimal literal.
+	NUMBER
+	// SYMBOL is an operator or punctuation, e.g. '(', ',', '<=' or '*'
+	SYMBOL
+	// PARAMETER is a placeholder for a value given when the query is run, either '?' or '$' and its number, e.g. $1.
+	PARAMETER
+)
+
+func (tt TokenType) String() string {
+	switch tt {
+	case EOF:
+		return "end of query"
+	case IDENT, QUOTED_IDENT:
+		return "name"
+	case STRING:
+		return "string"
+	case NUMBER:
+		return "number"
+	case SYMBOL:
+		return "symbol"
+	case PARAMETER:
+		return "parameter"
+	default:
+		return "unknown"
+	}
+}
+
+// Position is the line and column, starting at 1, of a token in the source.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// Token is a single element of a query.
+type Token struct {
+	Type TokenType
+	Text string
+	Pos  Position
+	// Offset and End are the byte offsets of the start and end of the token in the source.
+	Offset int
+	End    int
+}
+
+func (t Token) String() string {
+	switch t.Type {
+	case EOF:
+		return t.Type.String()
+	case STRING:
+		return fmt.Sprintf("'%s'", t.Text)
+	case QUOTED_IDENT:
+		return fmt.Sprintf("`%s`", t.Text)
+	default:
+		return fmt.Sprintf("%q", t.Text)
+	}
+}
+
+// IsKeyword checks if the token is an unquoted word matching any of the given keywords, ignoring case.
+func (t Token) IsKeyword(keywords ...string) bool {
+	if t.Type != IDENT {
+		return false
+	}
+	for _, kw := range keywords {
+		if strings.EqualFold(t.Text, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSymbol checks if the token is any of the given symbols.
+func (t Token) IsSymbol(symbols ...string) bool {
+	if t.Type != SYMBOL {
+		return false
+	}
+	for _, s := range symbols {
+		if t.Text == s {
+			return true
+		}
+	}
+	return false
+}
+
+// IsName checks if the token can be used as a name, i.e. a quoted or unquoted identifier.
+func (t Token) IsName() bool {
+	return t.Type == IDENT || t.Type == QUOTED_IDENT
+}
+
+// SyntaxError is an error found in the query, at a given position.
+type SyntaxError struct {
+	Pos     Position
+	Message string
+	// Incomplete is set when the query ends inside a quoted string, so may be continued on the following line.
+	Incomplete bool
+}
+
+func (se SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", se.Pos, se.Message)
+}
+
+// IsIncomplete checks if the error is of a query ending inside a quoted string, which may be continued on the following line.
+func IsIncomplete(err error) bool {
+	var se SyntaxError
+	return errors.As(err, &se) && se.Incomplete
+}
+
+// symbols are the operators and punctuation, longest first, so the longest match is found first.
+var symbols = []string{
+	"<=", ">=", "<>", "!=", "||",
+	"(", ")", ",", ";", ".", "*", "=", "<", ">", "+", "-", "/", "%",
+}
+
+// Tokenize breaks the given query into its tokens. The last token is always an EOF token.
+// Comments, either '--' to the end of the line or enclosed in '/* */', are skipped.
+// A query may use either '?' or '$n' parameter placeholders, but not both.
+func Tokenize(s string) ([]Token, error) {
+	l := &lexer{src: s, line: 1, col: 1}
+	var tokens []Token
+	var positional *Token
+	var numbered *Token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.Type == PARAMETER {
+			if t.Text == "?" {
+				positional = &t
+			} else {
+				numbered = &t
+			}
+			if positional != nil && numbered != nil {
+				return nil, SyntaxError{Pos: t.Pos, Message: "can not use both '?' and '$n' parameters in the same query"}
+			}
+		}
+		if t.Type == EOF {
+			return tokens, nil
+		}
+	}
+}
+
+// ParameterNumber gets the number, from 1, of the parameter placeholder at the given index in the tokens.
+// '?' placeholders are numbered in the order they appear, '$n' placeholders are numbered n.
+func ParameterNumber(tokens []Token, index int) int {
+	t := tokens[index]
+	if t.Text != "?" {
+		n, _ := strconv.Atoi(t.Text[1:])
+		return n
+	}
+	n := 1
+	for _, pt := range tokens[:index] {
+		if pt.Type == PARAMETER {
+			n++
+		}
+	}
+	return n
+}
+
+// ParameterCount gets the number of parameters the tokens have placeholders for.
+// That is the count of '?' placeholders, or the highest n of the '$n' placeholders.
+func ParameterCount(tokens []Token) int {
+	var count int
+	for i, t := range tokens {
+		if t.Type != PARAMETER {
+			continue
+		}
+		if n := ParameterNumber(tokens, i); n > count {
+			count = n
+		}
+	}
+	return count
+}
+
+type lexer struct {
+	src    string
+	offset int
+	line   int
+	col    int
+}
+
+func (l *lexer) next() (Token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return Token{}, err
+	}
+	start := Token{Pos: Position{Line: l.line, Column: l.col}, Offset: l.offset}
+	if l.offset >= len(l.src) {
+		start.Type = EOF
+		start.End = l.offset
+		return start, nil
+	}
+	r := l.peekRune(0)
+	switch {
+	case r == '\'' || r == '"':
+		return l.readQuoted(start, STRING, r)
+	case r == '`':
+		return l.readQuoted(start, QUOTED_IDENT, r)
+	case isDigit(r) || (r == '.' && isDigit(l.peekRune(1))):
+		return l.readNumber(start), nil
+	case isIdentStart(r):
+		return l.readIdent(start), nil
+	case r == '?' || r == '$':
+		return l.readParameter(start)
+	}
+	for _, sym := range symbols {
+		if strings.HasPrefix(l.src[l.offset:], sym) {
+			l.advance(len(sym))
+			start.Type = SYMBOL
+			start.Text = sym
+			start.End = l.offset
+			return start, nil
+		}
+	}
+	return Token{}, SyntaxError{Pos: start.Pos, Message: fmt.Sprintf("unexpected character %q", r)}
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.offset < len(l.src) {
+		r := l.peekRune(0)
+		switch {
+		case unicode.IsSpace(r):
+			l.advance(utf8.RuneLen(r))
+		case strings.HasPrefix(l.src[l.offset:], "--"):
+			for l.offset < len(l.src) && l.peekRune(0) != '\n' {
+				l.advance(utf8.RuneLen(l.peekRune(0)))
+			}
+		case strings.HasPrefix(l.src[l.offset:], "/*"):
+			pos := Position{Line: l.line, Column: l.col}
+			i := strings.Index(l.src[l.offset+2:], "*/")
+			if i < 0 {
+				return SyntaxError{Pos: pos, Message: "unclosed comment"}
+			}
+			l.advance(i + 4)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// readQuoted reads a string enclosed in the given quote.
+// A quote may be included in the string by doubling it, so two single quotes inside a single quoted string read as one quote.
+func (l *lexer) readQuoted(t Token, tt TokenType, quote rune) (Token, error) {
+	l.advance(1)
+	buf := strings.Builder{}
+	for {
+		if l.offset >= len(l.src) {
+			return Token{}, SyntaxError{Pos: t.Pos, Message: fmt.Sprintf("unclosed quote %c", quote), Incomplete: true}
+		}
+		r := l.peekRune(0)
+		l.advance(utf8.RuneLen(r))
+		if r == quote {
+			if l.offset < len(l.src) && l.peekRune(0) == quote {
+				l.advance(1)
+			} else {
+				break
+			}
+		}
+		buf.WriteRune(r)
+	}
+	t.Type = tt
+	t.Text = buf.String()
+	t.End = l.offset
+	return t, nil
+}
+
+func (l *lexer) readNumber(t Token) Token {
+	var dot bool
+	for l.offset < len(l.src) {
+		r := l.peekRune(0)
+		if r == '.' && !dot {
+			dot = true
+		} else if !isDigit(r) {
+			break
+		}
+		l.advance(1)
+	}
+	// exponent, e.g. 1e10, 2.5E-3
+	if r := l.peekRune(0); r == 'e' || r == 'E' {
+		n := 1
+		if s := l.peekRune(1); s == '+' || s == '-' {
+			n++
+		}
+		if isDigit(l.peekRune(n)) {
+			l.advance(n)
+			for isDigit(l.peekRune(0)) {
+				l.advance(1)
+			}
+		}
+	}
+	t.Type = NUMBER
+	t.Text = l.src[t.Offset:l.offset]
+	t.End = l.offset
+	return t
+}
+
+// readParameter reads a parameter placeholder, either '?' or '$' followed by a number, from 1.
+func (l *lexer) readParameter(t Token) (Token, error) {
+	dollar := l.peekRune(0) == '$'
+	l.advance(1)
+	for dollar && isDigit(l.peekRune(0)) {
+		l.advance(1)
+	}
+	t.Type = PARAMETER
+	t.Text = l.src[t.Offset:l.offset]
+	t.End = l.offset
+	if t.Text == "$" || strings.TrimLeft(t.Text, "$0") == "" {
+		return Token{}, SyntaxError{Pos: t.Pos, Message: fmt.Sprintf("invalid parameter %q, expected '$' followed by a number from 1", t.Text)}
+	}
+	return t, nil
+}
+
+// readIdent reads a word.  Words may contain letters, digits, underscores and hyphens.
+// A hyphen is only part of the word when it is followed by a letter or digit, so "col-1" is one name, where "col - 1" is not.
+func (l *lexer) readIdent(t Token) Token {
+	for l.offset < len(l.src) {
+		r := l.peekRune(0)
+		if r == '-' {
+			if n := l.peekRune(1); !isIdentStart(n) && !isDigit(n) {
+				break
+			}
+		} else if !isIdentStart(r) && !isDigit(r) {
+			break
+		}
+		l.advance(utf8.RuneLen(r))
+	}
+	t.Type = IDENT
+	t.Text = l.src[t.Offset:l.offset]
+	t.End = l.offset
+	return t
+}
+
+// peekRune gets the rune n bytes ahead of the current offset, or zero when beyond the end of the source.
+func (l *lexer) peekRune(n int) rune {
+	if l.offset+n >= len(l.src) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.offset+n:])
+	return r
+}
+
+// advance moves the offset on by n bytes, tracking the line and column
+func (l *lexer) advance(n int) {
+	for _, r := range l.src[l.offset : l.offset+n] {
+		if r == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+	l.offset += n
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
@@ -0,0 +1,141 @@
+package lexer_test
+
+import (
+	"eurozulu/miniSQL/queries/lexer"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := lexer.Tokenize("SELECT information, `my col` FROM t -- comment\n WHERE a >= -1.5 /* block\n comment */ AND b != 'it''s' AND c-1 = \"x\";")
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing  %v", err)
+	}
+	expected := []struct {
+		Type lexer.TokenType
+		Text string
+	}{
+		{lexer.IDENT, "SELECT"},
+		{lexer.IDENT, "information"},
+		{lexer.SYMBOL, ","},
+		{lexer.QUOTED_IDENT, "my col"},
+		{lexer.IDENT, "FROM"},
+		{lexer.IDENT, "t"},
+		{lexer.IDENT, "WHERE"},
+		{lexer.IDENT, "a"},
+		{lexer.SYMBOL, ">="},
+		{lexer.SYMBOL, "-"},
+		{lexer.NUMBER, "1.5"},
+		{lexer.IDENT, "AND"},
+		{lexer.IDENT, "b"},
+		{lexer.SYMBOL, "!="},
+		{lexer.STRING, "it's"},
+		{lexer.IDENT, "AND"},
+		{lexer.IDENT, "c-1"},
+		{lexer.SYMBOL, "="},
+		{lexer.STRING, "x"},
+		{lexer.SYMBOL, ";"},
+		{lexer.EOF, ""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, found %d  %v", len(expected), len(tokens), tokens)
+	}
+	for i, e := range expected {
+		if tokens[i].Type != e.Type || tokens[i].Text != e.Text {
+			t.Fatalf("unexpected token %d. Expected %v %q, found %v %q", i, e.Type, e.Text, tokens[i].Type, tokens[i].Text)
+		}
+	}
+}
+
+func TestTokenize_Position(t *testing.T) {
+	tokens, err := lexer.Tokenize("SELECT a\n  FROM t")
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing  %v", err)
+	}
+	from := tokens[2]
+	if from.Pos.Line != 2 || from.Pos.Column != 3 {
+		t.Fatalf("unexpected position of FROM, expected line 2, column 3, found %s", from.Pos)
+	}
+}
+
+func TestTokenize_Errors(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 'abc":         "line 1, column 8: unclosed quote",
+		"SELECT a\n/* abc":    "line 2, column 1: unclosed comment",
+		"SELECT a FROM t # x": "line 1, column 17: unexpected character",
+		"a = $ AND b = 1":     "line 1, column 5: invalid parameter",
+		"a = $0":              "line 1, column 5: invalid parameter",
+		"a = ? AND b = $1":    "line 1, column 15: can not use both",
+	}
+	for q, expect := range tests {
+		_, err := lexer.Tokenize(q)
+		if err == nil {
+			t.Fatalf("expected error tokenizing %q", q)
+		}
+		if !strings.HasPrefix(err.Error(), expect) {
+			t.Fatalf("unexpected error tokenizing %q. Expected %q, found %q", q, expect, err)
+		}
+		if incomplete := strings.HasSuffix(expect, "unclosed quote"); lexer.IsIncomplete(err) != incomplete {
+			t.Fatalf("expected incomplete to be %v tokenizing %q", incomplete, q)
+		}
+	}
+}
+
+func TestTokenStream_ExpectQualifiedName(t *testing.T) {
+	ts, err := lexer.NewTokenStream("a.b c . *")
+	if err != nil {
+		t.Fatalf("unexpected error tokenizing  %v", err)
+	}
+	n, err := ts.ExpectQualifiedName("column")
+	if err != nil || n != "a.b" {
+		t.Fatalf("unexpected qualified name, expected %q, found %q  %v", "a.b", n, err)
+	}
+	n, err = ts.ExpectQualifiedName("column")
+	if err != nil || n != "c" {
+		t.Fatalf("unexpected qualified name, expected %q, found %q  %v", "c", n, err)
+	}
+	if !ts.AcceptSymbol(".") || !ts.AcceptSymbol("*") {
+		t.Fatalf("expected the '.' without a following name to remain, found %v", ts.Peek())
+	}
+}
+
+func TestTokenStream_ExpectParameter(t *testing.T) {
+	tests := map[string]struct {
+		numbers []int
+		count   int
+	}{
+		"a = ? AND b = ? OR c = ?": {[]int{1, 2, 3}, 3},
+		"a = $2 AND b = $1":        {[]int{2, 1}, 2},
+		"a = $1 OR b = $1":         {[]int{1, 1}, 1},
+	}
+	for q, test := range tests {
+		expect := test.numbers
+		ts, err := lexer.NewTokenStream(q)
+		if err != nil {
+			t.Fatalf("unexpected error tokenizing %q  %v", q, err)
+		}
+		var found []int
+		for !ts.AtEnd() {
+			if ts.Peek().Type != lexer.PARAMETER {
+				ts.Next()
+				continue
+			}
+			n, err := ts.ExpectParameter()
+			if err != nil {
+				t.Fatalf("unexpected error reading parameter of %q  %v", q, err)
+			}
+			found = append(found, n)
+		}
+		if len(found) != len(expect) {
+			t.Fatalf("expected %d parameters in %q, found %v", len(expect), q, found)
+		}
+		for i, n := range expect {
+			if found[i] != n {
+				t.Fatalf("unexpected parameter numbers in %q, expected %v, found %v", q, expect, found)
+			}
+		}
+		if ts.Parameters() != test.count {
+			t.Fatalf("expected %d parameters in %q, found %d", test.count, q, ts.Parameters())
+		}
+	}
+}
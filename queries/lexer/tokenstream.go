@@ -0,0 +1,190 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenStream reads through the tokens of a query, providing the parsers with a lookahead and error reporting.
+type TokenStream struct {
+	src    string
+	tokens []Token
+	index  int
+}
+
+// Peek gets the next token without consuming it.
+func (ts *TokenStream) Peek() Token {
+	return ts.PeekAt(0)
+}
+
+// PeekAt gets the token n tokens ahead of the next token, without consuming any tokens.
+func (ts *TokenStream) PeekAt(n int) Token {
+	i := ts.index + n
+	if i >= len(ts.tokens) {
+		return ts.tokens[len(ts.tokens)-1]
+	}
+	return ts.tokens[i]
+}
+
+// Next consumes and returns the next token
+func (ts *TokenStream) Next() Token {
+	t := ts.Peek()
+	if ts.index < len(ts.tokens)-1 {
+		ts.index++
+	}
+	return t
+}
+
+// Mark gets the current position in the stream, which may be returned to with Reset.
+func (ts *TokenStream) Mark() int {
+	return ts.index
+}
+
+// Reset returns the stream to a previously marked position.
+func (ts *TokenStream) Reset(mark int) {
+	ts.index = mark
+}
+
+// AtEnd checks if all the tokens, other than an optional trailing semicolon, have been consumed.
+func (ts *TokenStream) AtEnd() bool {
+	t := ts.Peek()
+	if t.IsSymbol(";") {
+		t = ts.PeekAt(1)
+	}
+	return t.Type == EOF
+}
+
+// ExpectEnd ensures all the tokens, other than an optional trailing semicolon, have been consumed.
+func (ts *TokenStream) ExpectEnd() error {
+	if ts.AtEnd() {
+		return nil
+	}
+	return ts.Errorf(ts.Peek(), "unexpected %s", ts.Peek())
+}
+
+// AcceptKeyword consumes the next token if it is the given keyword, returning true if consumed.
+func (ts *TokenStream) AcceptKeyword(keyword string) bool {
+	if !ts.Peek().IsKeyword(keyword) {
+		return false
+	}
+	ts.Next()
+	return true
+}
+
+// AcceptSymbol consumes the next token if it is the given symbol, returning true if consumed.
+func (ts *TokenStream) AcceptSymbol(symbol string) bool {
+	if !ts.Peek().IsSymbol(symbol) {
+		return false
+	}
+	ts.Next()
+	return true
+}
+
+// ExpectKeyword consumes the next token, which must be the given keyword.
+func (ts *TokenStream) ExpectKeyword(keyword string) error {
+	if !ts.AcceptKeyword(keyword) {
+		return ts.Errorf(ts.Peek(), "expected %s, found %s", strings.ToUpper(keyword), ts.Peek())
+	}
+	return nil
+}
+
+// ExpectSymbol consumes the next token, which must be the given symbol.
+func (ts *TokenStream) ExpectSymbol(symbol string) error {
+	if !ts.AcceptSymbol(symbol) {
+		return ts.Errorf(ts.Peek(), "expected '%s', found %s", symbol, ts.Peek())
+	}
+	return nil
+}
+
+// ExpectName consumes the next token, which must be a name, returning the name.
+// what describes the name expected, for error reporting. e.g. "table name"
+func (ts *TokenStream) ExpectName(what string) (string, error) {
+	t := ts.Peek()
+	if !t.IsName() {
+		return "", ts.Errorf(t, "expected %s, found %s", what, t)
+	}
+	ts.Next()
+	return t.Text, nil
+}
+
+// ExpectQualifiedName consumes a name, which may be qualified with a preceding table name and '.', returning the full name.
+// e.g. mycolumn or mytable.mycolumn
+func (ts *TokenStream) ExpectQualifiedName(what string) (string, error) {
+	n, err := ts.ExpectName(what)
+	if err != nil {
+		return "", err
+	}
+	if !ts.Peek().IsSymbol(".") || !ts.PeekAt(1).IsName() {
+		return n, nil
+	}
+	ts.Next()
+	return n + "." + ts.Next().Text, nil
+}
+
+// ExpectNameList consumes a bracketed, comma delimited list of names. e.g. (col1, col2)
+func (ts *TokenStream) ExpectNameList(what string) ([]string, error) {
+	if err := ts.ExpectSymbol("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		n, err := ts.ExpectName(what)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+		if !ts.AcceptSymbol(",") {
+			break
+		}
+	}
+	if err := ts.ExpectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ExpectParameter consumes the next token, which must be a parameter placeholder, returning its number, from 1.
+func (ts *TokenStream) ExpectParameter() (int, error) {
+	t := ts.Peek()
+	if t.Type != PARAMETER {
+		return 0, ts.Errorf(t, "expected a parameter, found %s", t)
+	}
+	n := ParameterNumber(ts.tokens, ts.index)
+	ts.Next()
+	return n, nil
+}
+
+// Parameters gets the number of parameters the query has placeholders for.
+func (ts *TokenStream) Parameters() int {
+	return ParameterCount(ts.tokens)
+}
+
+// Rest gets the source following the last consumed token.
+func (ts *TokenStream) Rest() string {
+	if ts.index == 0 {
+		return ts.src
+	}
+	return ts.src[ts.tokens[ts.index-1].End:]
+}
+
+// Source gets the source of the tokens from the given token, up to and including the last consumed token.
+func (ts *TokenStream) Source(from Token) string {
+	if ts.index == 0 || ts.tokens[ts.index-1].End < from.Offset {
+		return ""
+	}
+	return ts.src[from.Offset:ts.tokens[ts.index-1].End]
+}
+
+// Errorf creates a SyntaxError at the position of the given token.
+func (ts *TokenStream) Errorf(t Token, format string, args ...interface{}) error {
+	return SyntaxError{Pos: t.Pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewTokenStream tokenizes the given query into a new TokenStream
+func NewTokenStream(s string) (*TokenStream, error) {
+	tokens, err := Tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenStream{src: s, tokens: tokens}, nil
+}
@@ -0,0 +1,106 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"fmt"
+	"strconv"
+)
+
+// resultLimit limits the results of a query to, at most, limit results, following the first offset results, which are skipped.
+// The query is run with the limits context, which is cancelled once the limit is reached, so no more results are produced.
+type resultLimit struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	offset int
+	limit  int
+}
+
+// Limit passes on the limited results.  Errors are always passed on, and are not counted.
+func (rl resultLimit) Limit(results <-chan Result) <-chan Result {
+	ctx := rl.ctx
+	chOut := make(chan Result)
+	go func(chIn <-chan Result, chOut chan<- Result) {
+		defer close(chOut)
+		defer rl.cancel()
+		var skipped, sent int
+		for sent < rl.limit {
+			var r Result
+			select {
+			case <-ctx.Done():
+				return
+			case rs, ok := <-chIn:
+				if !ok {
+					return
+				}
+				r = rs
+			}
+			if minisql.ErrorOf(r) == nil {
+				if skipped < rl.offset {
+					skipped++
+					continue
+				}
+				sent++
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case chOut <- r:
+			}
+		}
+	}(results, chOut)
+	return chOut
+}
+
+func newResultLimit(ctx context.Context, offset, limit int) *resultLimit {
+	ctx, cancel := context.WithCancel(ctx)
+	return &resultLimit{ctx: ctx, cancel: cancel, offset: offset, limit: limit}
+}
+
+// readLimit reads an optional LIMIT clause, a count of rows optionally followed by OFFSET and the count of rows to skip.
+// Either count may be a parameter placeholder, whose number is set on the query in place of the count.
+// The query limit is left nil when there is no LIMIT clause.
+func (q *SelectQuery) readLimit(ts *lexer.TokenStream) error {
+	if !ts.AcceptKeyword("LIMIT") {
+		return nil
+	}
+	limit, param, err := readCount(ts, "LIMIT")
+	if err != nil {
+		return err
+	}
+	q.Limit = &limit
+	q.LimitParameter = param
+	if ts.AcceptKeyword("OFFSET") {
+		if q.Offset, q.OffsetParameter, err = readCount(ts, "OFFSET"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCount reads a whole number, of zero or more, or a parameter placeholder, whose number is returned in place of the count.
+func readCount(ts *lexer.TokenStream, what string) (int, int, error) {
+	if ts.Peek().Type == lexer.PARAMETER {
+		param, err := ts.ExpectParameter()
+		return 0, param, err
+	}
+	t := ts.Next()
+	if t.Type != lexer.NUMBER {
+		return 0, 0, ts.Errorf(t, "expected a number of rows after %s, found %s", what, t)
+	}
+	n, err := parseCount(t.Text)
+	if err != nil {
+		return 0, 0, ts.Errorf(t, "%s must be a whole number of rows, found %s", what, t.Text)
+	}
+	return n, 0, nil
+}
+
+// parseCount parses a whole number of rows, of zero or more.
+func parseCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a whole number of rows", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,122 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// countingDB counts the rows of its tables checked by a query
+type countingDB struct {
+	*minisql.MiniDB
+	checked *int64
+}
+
+func (db countingDB) Table(name string) (minisql.Table, error) {
+	t, err := db.MiniDB.Table(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingTable{Table: t, checked: db.checked}, nil
+}
+
+type countingTable struct {
+	minisql.Table
+	checked *int64
+}
+
+func (t countingTable) ContainsID(k minisql.Key) bool {
+	atomic.AddInt64(t.checked, 1)
+	return t.Table.ContainsID(k)
+}
+
+func limitTestDB(t *testing.T, rows int) *minisql.MiniDB {
+	tdb := minisql.NewDatabase(minisql.Schema{"nums": {"n": {Type: minisql.INTEGER}, "odd": {Type: minisql.BOOLEAN}}})
+	for i := 0; i < rows; i++ {
+		runQuery(t, tdb, fmt.Sprintf("INSERT INTO nums (n, odd) VALUES (%d, %v)", (i*7)%rows, i%2 == 1))
+	}
+	return tdb
+}
+
+func TestSelectQuery_Limit(t *testing.T) {
+	tdb := limitTestDB(t, 20)
+	tests := []struct {
+		query  string
+		expect []string
+	}{
+		{"SELECT n FROM nums LIMIT 3", []string{"0", "7", "14"}},
+		{"SELECT n FROM nums LIMIT 3 OFFSET 2", []string{"14", "1", "8"}},
+		{"SELECT n FROM nums LIMIT 0", nil},
+		{"SELECT n FROM nums LIMIT 5 OFFSET 18", []string{"6", "13"}},
+		{"SELECT n FROM nums WHERE odd = true LIMIT 2", []string{"7", "1"}},
+		{"SELECT n FROM nums ORDER BY n LIMIT 3", []string{"0", "1", "2"}},
+		{"SELECT n FROM nums ORDER BY n DESC LIMIT 2 OFFSET 1", []string{"18", "17"}},
+		{"SELECT n FROM nums ORDER BY n LIMIT 30 OFFSET 17", []string{"17", "18", "19"}},
+		{"SELECT COUNT(*) AS n FROM nums GROUP BY odd ORDER BY n LIMIT 1", []string{"10"}},
+	}
+	for _, test := range tests {
+		rows := runQuery(t, tdb, test.query, "n")
+		if !reflect.DeepEqual(rows, test.expect) {
+			t.Fatalf("unexpected result of %q, expected %v, found %v", test.query, test.expect, rows)
+		}
+	}
+	for _, query := range []string{
+		"SELECT n FROM nums LIMIT",
+		"SELECT n FROM nums LIMIT -1",
+		"SELECT n FROM nums LIMIT 1.5",
+		"SELECT n FROM nums LIMIT 2 OFFSET",
+		"SELECT n FROM nums LIMIT 2 ORDER BY n",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Fatalf("expected error parsing %q", query)
+		}
+	}
+}
+
+func TestSelectQuery_LimitStopsSelect(t *testing.T) {
+	var checked int64
+	db := countingDB{MiniDB: limitTestDB(t, 2000), checked: &checked}
+	rows := runQuery(t, db, "SELECT n FROM nums LIMIT 5", "n")
+	if len(rows) != 5 {
+		t.Fatalf("expected 5 rows, found %d", len(rows))
+	}
+	// the keys are buffered, so some more rows than the limit may be checked before the select is cancelled
+	if c := atomic.LoadInt64(&checked); c >= 2000 {
+		t.Fatalf("expected select to stop once the limit was reached, checked %d rows", c)
+	}
+}
+
+func TestSortedResult_Top(t *testing.T) {
+	tdb := limitTestDB(t, 500)
+	all := runQuery(t, tdb, "SELECT n, odd FROM nums ORDER BY odd, n DESC", "n", "odd")
+	top := runQuery(t, tdb, "SELECT n, odd FROM nums ORDER BY odd, n DESC LIMIT 40 OFFSET 230", "n", "odd")
+	if !reflect.DeepEqual(top, all[230:270]) {
+		t.Fatalf("top results differ from sorted results, expected %v, found %v", all[230:270], top)
+	}
+
+	ch := make(chan Result)
+	go func() {
+		defer close(ch)
+		for _, n := range []string{"5", "3", "9", "1", "7"} {
+			v := n
+			ch <- NewResult("t", minisql.Values{"n": &v})
+		}
+		e := "failed"
+		ch <- NewResult("t", minisql.Values{"ERROR": &e})
+	}()
+	sr := sortedResult{Columns: []string{"n"}, Types: map[string]minisql.ColumnType{"n": minisql.INTEGER}, Top: 2}
+	var found []string
+	for r := range sr.Sort(context.TODO(), ch) {
+		if e := minisql.ErrorOf(r); e != nil {
+			found = append(found, "ERROR")
+			continue
+		}
+		found = append(found, *r.Values()["n"])
+	}
+	if !reflect.DeepEqual(found, []string{"ERROR", "1", "3"}) {
+		t.Fatalf("unexpected top results, expected %v, found %v", []string{"ERROR", "1", "3"}, found)
+	}
+}
@@ -0,0 +1,46 @@
+package queries
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// parameterValue gets the value of a parameter, as it is held in a table.
+// Parameters may be nil, for NULL, strings, byte slices, times, booleans or numbers.
+func parameterValue(v interface{}) (*string, error) {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case *string:
+		return v, nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case bool:
+		s = strconv.FormatBool(v)
+	case int:
+		s = strconv.Itoa(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return parameterValue(i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return parameterValue(f)
+	default:
+		return nil, fmt.Errorf("values of type %T are not supported", v)
+	}
+	return &s, nil
+}
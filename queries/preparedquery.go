@@ -0,0 +1,195 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/queries/whereclause"
+	"fmt"
+)
+
+// PreparedQuery is a query parsed once, with placeholders for the values of its parameters, which may be run any number of times.
+// Each time it is run, the values are bound to a copy of the parsed query, so it may be run by any number of routines at once.
+// Placeholders are either '?', numbered in the order they appear, or '$n', and may be used in place of any value
+// of a WHERE or HAVING condition, an INSERT VALUES or UPDATE SET, or the counts of LIMIT and OFFSET.
+type PreparedQuery struct {
+	query      Query
+	parameters int
+}
+
+// parameterisedQuery is implemented by queries which may have parameter placeholders.
+type parameterisedQuery interface {
+	// withParameters returns a copy of the query, with its parameter placeholders replaced by the given values.
+	withParameters(params []*string) (Query, error)
+}
+
+// NumParameters gets the number of parameters the query must be given.
+func (pq PreparedQuery) NumParameters() int {
+	return pq.parameters
+}
+
+// Bind gets the query with its placeholders replaced by the given values, the first value for parameter 1.
+// Values may be nil, for NULL, strings, byte slices, times, booleans or numbers.
+func (pq PreparedQuery) Bind(args ...interface{}) (minisql.Statement, error) {
+	return pq.BindQuery(args...)
+}
+
+// BindQuery gets the query with its placeholders replaced by the given values, as Bind.
+func (pq PreparedQuery) BindQuery(args ...interface{}) (Query, error) {
+	if len(args) != pq.parameters {
+		return nil, fmt.Errorf("query has %d parameters, but %d values were given", pq.parameters, len(args))
+	}
+	if pq.parameters == 0 {
+		return pq.query, nil
+	}
+	params := make([]*string, len(args))
+	for i, a := range args {
+		v, err := parameterValue(a)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d  %w", i+1, err)
+		}
+		params[i] = v
+	}
+	q, ok := pq.query.(parameterisedQuery)
+	if !ok {
+		return pq.query, nil
+	}
+	return q.withParameters(params)
+}
+
+// Execute runs a query without any parameters.
+func (pq PreparedQuery) Execute(ctx context.Context, db minisql.Database) (<-chan Result, error) {
+	q, err := pq.BindQuery()
+	if err != nil {
+		return nil, err
+	}
+	return q.Execute(ctx, db)
+}
+
+// PrepareQuery parses the given string into a PreparedQuery.
+// String should contain a single statement, optionally ending in a semicolon.
+func PrepareQuery(q string) (*PreparedQuery, error) {
+	return prepareQuery(q, false)
+}
+
+// PrepareCompatQuery parses the given string into a PreparedQuery, as PrepareQuery, with its conditions
+// following the legacy rules of earlier versions.
+func PrepareCompatQuery(q string) (*PreparedQuery, error) {
+	return prepareQuery(q, true)
+}
+
+func prepareQuery(q string, compat bool) (*PreparedQuery, error) {
+	ts, err := lexer.NewTokenStream(q)
+	if err != nil {
+		return nil, err
+	}
+	query, err := ReadQuery(ts, compat)
+	if err != nil {
+		return nil, err
+	}
+	if err = ts.ExpectEnd(); err != nil {
+		return nil, err
+	}
+	return &PreparedQuery{query: query, parameters: ts.Parameters()}, nil
+}
+
+func (q SelectQuery) withParameters(params []*string) (Query, error) {
+	q.Expressions = expressionsWithParameters(q.Expressions, params)
+	if q.OrderBy != nil && q.OrderBy.Expressions != nil {
+		order := *q.OrderBy
+		order.Expressions = expressionsWithParameters(order.Expressions, params)
+		q.OrderBy = &order
+	}
+	q.Where = whereclause.WhereWithParameters(q.Where, params)
+	if q.Having != nil {
+		q.Having = whereclause.WithParameters(q.Having, params)
+	}
+	if q.LimitParameter > 0 {
+		limit, err := countParameter(params, q.LimitParameter, "LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		q.Limit = &limit
+		q.LimitParameter = 0
+	}
+	if q.OffsetParameter > 0 {
+		offset, err := countParameter(params, q.OffsetParameter, "OFFSET")
+		if err != nil {
+			return nil, err
+		}
+		q.Offset = offset
+		q.OffsetParameter = 0
+	}
+	return &q, nil
+}
+
+func (q InsertQuery) withParameters(params []*string) (Query, error) {
+	if q.Parameters != nil {
+		rows := make([]minisql.Values, len(q.Rows))
+		for i, vals := range q.Rows {
+			rows[i] = valuesWithParameters(vals, q.Parameters[i], params)
+		}
+		q.Rows = rows
+		q.Parameters = nil
+	}
+	if q.Select != nil {
+		sq, err := q.Select.withParameters(params)
+		if err != nil {
+			return nil, err
+		}
+		q.Select = sq.(*SelectQuery)
+	}
+	return &q, nil
+}
+
+func (q UpdateQuery) withParameters(params []*string) (Query, error) {
+	if q.Parameters != nil {
+		q.Values = valuesWithParameters(q.Values, q.Parameters, params)
+		q.Parameters = nil
+	}
+	q.Expressions = expressionsWithParameters(q.Expressions, params)
+	q.Where = whereclause.WhereWithParameters(q.Where, params)
+	return &q, nil
+}
+
+func (q DeleteQuery) withParameters(params []*string) (Query, error) {
+	q.Where = whereclause.WhereWithParameters(q.Where, params)
+	return &q, nil
+}
+
+// valuesWithParameters gets a copy of the values, with the values of the parameter columns replaced by their parameter.
+func valuesWithParameters(values minisql.Values, columns map[string]int, params []*string) minisql.Values {
+	vals := minisql.Values{}
+	for k, v := range values {
+		vals[k] = v
+	}
+	for c, p := range columns {
+		vals[c] = params[p-1]
+	}
+	return vals
+}
+
+// expressionsWithParameters gets a copy of the computed values, with their parameters replaced by the given values.
+func expressionsWithParameters(exs map[string]whereclause.Value, params []*string) map[string]whereclause.Value {
+	if exs == nil {
+		return nil
+	}
+	vals := map[string]whereclause.Value{}
+	for c, v := range exs {
+		vals[c] = whereclause.ValueWithParameters(v, params)
+	}
+	return vals
+}
+
+// countParameter gets the value of a parameter giving a number of rows.
+func countParameter(params []*string, param int, what string) (int, error) {
+	v := params[param-1]
+	if v == nil {
+		return 0, fmt.Errorf("%s parameter %d can not be NULL", what, param)
+	}
+	n, err := parseCount(*v)
+	if err != nil {
+		return 0, fmt.Errorf("%s parameter %d  %w", what, param, err)
+	}
+	return n, nil
+}
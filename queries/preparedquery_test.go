@@ -0,0 +1,169 @@
+package queries
+
+import (
+	"context"
+	"encoding/json"
+	"eurozulu/miniSQL/minisql"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// runPrepared runs the prepared query with the given parameters, returning its results as strings of the given columns values.
+func runPrepared(t *testing.T, db minisql.Database, pq *PreparedQuery, params []interface{}, columns ...string) []string {
+	rows, err := preparedResults(db, pq, params, columns...)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rows
+}
+
+func preparedResults(db minisql.Database, pq *PreparedQuery, params []interface{}, columns ...string) ([]string, error) {
+	q, err := pq.BindQuery(params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind %v  %w", params, err)
+	}
+	rCh, err := q.Execute(context.TODO(), db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query with %v  %w", params, err)
+	}
+	var rows []string
+	for r := range rCh {
+		if e := minisql.ErrorOf(r); e != nil {
+			return nil, fmt.Errorf("unexpected error with %v  %s", params, *e)
+		}
+		var vals []string
+		for _, c := range columns {
+			v := r.Values()[c]
+			if v == nil {
+				vals = append(vals, "NULL")
+				continue
+			}
+			vals = append(vals, *v)
+		}
+		rows = append(rows, strings.Join(vals, ","))
+	}
+	return rows, nil
+}
+
+func mustPrepare(t *testing.T, query string, parameters int) *PreparedQuery {
+	pq, err := PrepareQuery(query)
+	if err != nil {
+		t.Fatalf("failed to prepare %q  %s", query, err)
+	}
+	if pq.NumParameters() != parameters {
+		t.Fatalf("expected %d parameters in %q, found %d", parameters, query, pq.NumParameters())
+	}
+	return pq
+}
+
+func TestPrepareQuery(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{
+		"t": {"a": {Type: minisql.TEXT}, "b": {Type: minisql.INTEGER}, "c": {Type: minisql.REAL}},
+	})
+	insert := mustPrepare(t, "INSERT INTO t (a, b, c) VALUES (?, ?, ?)", 3)
+	for _, params := range [][]interface{}{
+		{"it's", 1, 1.5},
+		{"two", int64(2), nil},
+		{"?", json.Number("3"), json.Number("-0.5")},
+		{[]byte("four"), "4", float64(4)},
+	} {
+		runPrepared(t, tdb, insert, params)
+	}
+
+	sel := mustPrepare(t, "SELECT a FROM t WHERE b >= $1 AND (c = $2 OR a = '?') ORDER BY b LIMIT $3 OFFSET $4", 4)
+	tests := []struct {
+		params []interface{}
+		expect string
+	}{
+		{[]interface{}{1, nil, 10, 0}, "?"},
+		{[]interface{}{0, 1.5, 1, 0}, "it's"},
+		{[]interface{}{0, 1.5, 10, 1}, "?"},
+		{[]interface{}{3, 4, "10", "0"}, "? four"},
+	}
+	for _, test := range tests {
+		found := strings.Join(runPrepared(t, tdb, sel, test.params, "a"), " ")
+		if found != test.expect {
+			t.Fatalf("unexpected results with %v, expected %q, found %q", test.params, test.expect, found)
+		}
+	}
+
+	found := strings.Join(runPrepared(t, tdb, mustPrepare(t, "SELECT b * ? AS n FROM t WHERE b < 3 ORDER BY n", 1), []interface{}{10}, "n"), " ")
+	if found != "10 20" {
+		t.Fatalf("unexpected computed values, found %q", found)
+	}
+
+	update := mustPrepare(t, "UPDATE t SET a = ?, c = 9 WHERE b = ?", 2)
+	runPrepared(t, tdb, update, []interface{}{nil, 2})
+	found = strings.Join(runPrepared(t, tdb, mustPrepare(t, "SELECT a, c FROM t WHERE b = 2", 0), nil, "a", "c"), " ")
+	if found != "NULL,9" {
+		t.Fatalf("unexpected update, found %q", found)
+	}
+	runPrepared(t, tdb, mustPrepare(t, "DELETE FROM t WHERE a = ?", 1), []interface{}{"it's"})
+	found = strings.Join(runPrepared(t, tdb, mustPrepare(t, "SELECT a FROM t WHERE b < ?", 1), []interface{}{3}, "a"), " ")
+	if found != "NULL" {
+		t.Fatalf("unexpected rows after delete, found %q", found)
+	}
+}
+
+func TestPrepareQuery_Errors(t *testing.T) {
+	if _, err := ParseQuery("SELECT a FROM t WHERE a = ?"); err == nil {
+		t.Fatalf("expected error parsing a query with parameters")
+	}
+	sel := mustPrepare(t, "SELECT a FROM t WHERE a = ? LIMIT ?", 2)
+	for _, params := range [][]interface{}{
+		{1},
+		{1, 2, 3},
+		{struct{}{}, 1},
+		{1, -1},
+		{1, nil},
+		{1, "many"},
+	} {
+		if _, err := sel.BindQuery(params...); err == nil {
+			t.Fatalf("expected error binding %v", params)
+		}
+	}
+	for _, q := range []string{
+		"SELECT a FROM t WHERE a = ? AND b = $2",
+		"CREATE TABLE t (a, ?)",
+		"SELECT a AS ? FROM t",
+		"SELECT a FROM ?",
+	} {
+		if _, err := PrepareQuery(q); err == nil {
+			t.Fatalf("expected error preparing %q", q)
+		}
+	}
+}
+
+// TestPrepareQuery_Concurrent runs the same prepared queries, with different parameters, at once.
+// Run with 'go test -race' to detect unsafe sharing of the parsed query.
+func TestPrepareQuery_Concurrent(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{
+		"items": {"name": {Type: minisql.TEXT}, "qty": {Type: minisql.INTEGER}},
+	})
+	insert := mustPrepare(t, "INSERT INTO items (name, qty) VALUES (?, ?)", 2)
+	sel := mustPrepare(t, "SELECT name, qty FROM items WHERE name = ? ORDER BY qty LIMIT ?", 2)
+	const workers = 6
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			name := fmt.Sprintf("w%d", w)
+			for i := 0; i < 20; i++ {
+				if _, err := preparedResults(tdb, insert, []interface{}{name, i}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			found, err := preparedResults(tdb, sel, []interface{}{name, 3}, "name", "qty")
+			if err != nil {
+				t.Error(err)
+			} else if strings.Join(found, " ") != fmt.Sprintf("%s,0 %s,1 %s,2", name, name, name) {
+				t.Errorf("unexpected results for %s  %v", name, found)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
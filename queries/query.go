@@ -6,5 +6,5 @@ import (
 )
 
 type Query interface {
-	Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Result, error)
+	Execute(ctx context.Context, db minisql.Database) (<-chan Result, error)
 }
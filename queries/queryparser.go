@@ -1,30 +1,54 @@
 package queries
 
 import (
-	"eurozulu/miniSQL/stringutil"
-	"fmt"
-	"strings"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
 )
 
+func init() {
+	minisql.RegisterParser(func(query string, compat bool) (minisql.PreparedStatement, error) {
+		if compat {
+			return PrepareCompatQuery(query)
+		}
+		return PrepareQuery(query)
+	})
+}
+
+// ParseQuery parses the given string into a Query.
+// String should contain a single statement, optionally ending in a semicolon.
+// Errors in the statement are reported with their line and column in the string.
+// A statement with parameter placeholders must be parsed with PrepareQuery, to bind the values of its parameters.
 func ParseQuery(q string) (Query, error) {
-	cmd, rest := stringutil.FirstWord(q)
-	if cmd == "" {
-		return nil, fmt.Errorf("invalid query, missing query type SELECT, INSERT, DELETE or UPDATE")
-	}
-	if rest == "" {
-		return nil, fmt.Errorf("invalid query, missing values after %s", cmd)
+	pq, err := PrepareQuery(q)
+	if err != nil {
+		return nil, err
 	}
-	switch strings.ToUpper(cmd) {
-	case "SELECT":
-		return NewSelectQuery(rest)
-	case "INSERT":
-		return NewInsertQuery(rest)
-	case "UPDATE":
-		return NewUpdateQuery(rest)
-	case "DELETE":
-		return NewDeleteQuery(rest)
+	return pq.BindQuery()
+}
+
+// ReadQuery reads a single statement from the given tokens.
+// When compat is set, the conditions of the statement follow the legacy rules of earlier versions.
+func ReadQuery(ts *lexer.TokenStream, compat bool) (Query, error) {
+	t := ts.Next()
+	switch {
+	case t.Type == lexer.EOF:
+		return nil, ts.Errorf(t, "invalid query, missing query type SELECT, INSERT, DELETE, UPDATE, CREATE or DROP")
+	case t.IsKeyword("SELECT"):
+		return readSelectQuery(ts, compat)
+	case t.IsKeyword("INSERT"):
+		return readInsertQuery(ts, compat)
+	case t.IsKeyword("UPDATE"):
+		return readUpdateQuery(ts, compat)
+	case t.IsKeyword("DELETE"):
+		return readDeleteQuery(ts, compat)
+	case t.IsKeyword("CREATE"):
+		return readCreateQuery(ts)
+	case t.IsKeyword("DROP"):
+		return readDropQuery(ts)
+	case t.IsKeyword("BEGIN", "START", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE"):
+		return readTransactionQuery(t, ts)
 
 	default:
-		return nil, fmt.Errorf("unrecognised query")
+		return nil, ts.Errorf(t, "unrecognised query %s", t)
 	}
 }
@@ -3,25 +3,27 @@ package queries
 import (
 	"context"
 	"eurozulu/miniSQL/minisql"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 var testSchema = minisql.Schema{
 	"t1": {
-		"c1-1": true,
-		"c1-2": true,
-		"c1-3": true,
+		"c1-1": {Type: minisql.TEXT},
+		"c1-2": {Type: minisql.TEXT},
+		"c1-3": {Type: minisql.TEXT},
 	},
 	"t2": {
-		"c2-1": true,
-		"c2-2": true,
-		"c2-3": true,
+		"c2-1": {Type: minisql.TEXT},
+		"c2-2": {Type: minisql.TEXT},
+		"c2-3": {Type: minisql.TEXT},
 	},
 	"t3": {
-		"c3-1": true,
-		"c3-2": true,
-		"c3-3": true,
+		"c3-1": {Type: minisql.TEXT},
+		"c3-2": {Type: minisql.TEXT},
+		"c3-3": {Type: minisql.TEXT},
 	},
 }
 
@@ -59,6 +61,46 @@ func TestQueryParser_ParseInsertValues(t *testing.T) {
 	}
 }
 
+func TestQueryParser_ParseInsertRows(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{"t": {"a": {Type: minisql.INTEGER}, "b": {Type: minisql.TEXT}}})
+	insert := func(query string) []string {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse query %s", err)
+		}
+		rCh, err := q.Execute(context.TODO(), tdb)
+		if err != nil {
+			t.Fatalf("failed to execute query %s", err)
+		}
+		var ids []string
+		for r := range rCh {
+			if e := minisql.ErrorOf(r); e != nil {
+				ids = append(ids, "ERROR")
+				continue
+			}
+			ids = append(ids, *r.Values()["_id"])
+		}
+		return ids
+	}
+	if ids := strings.Join(insert("INSERT INTO t (a, b) VALUES (1, 'one'), (2, NULL), (3, 'three')"), ","); ids != "0,1,2" {
+		t.Fatalf("unexpected ids of inserted rows, expected %q, found %q", "0,1,2", ids)
+	}
+	tb, _ := tdb.Table("t")
+	if vals, _ := tb.Select(1, []string{"a", "b"}); *vals["a"] != "2" || vals["b"] != nil {
+		t.Fatalf("unexpected values of second row %v", vals)
+	}
+	// a failing row removes the rows already inserted by the query
+	if ids := strings.Join(insert("INSERT INTO t (a, b) VALUES (4, 'four'), ('five', 'five')"), ","); ids != "3,ERROR" {
+		t.Fatalf("unexpected results of failing insert %q", ids)
+	}
+	if tb.ContainsID(3) {
+		t.Fatalf("expected row inserted by failed query to be removed")
+	}
+	if _, err := ParseQuery("INSERT INTO t (a, b) VALUES (1, 'one'), (2)"); err == nil {
+		t.Fatalf("expected error parsing row with missing value")
+	}
+}
+
 func TestQueryParser_ParseSelect(t *testing.T) {
 	query := "SELECT * FROM t1"
 	q, err := ParseQuery(query)
@@ -74,3 +116,339 @@ func TestQueryParser_ParseSelect(t *testing.T) {
 		t.Fatalf("failed to execute query %s", err)
 	}
 }
+
+func TestQueryParser_SelectTypedWhere(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{
+		"people": {"name": {Type: minisql.TEXT}, "age": {Type: minisql.INTEGER}},
+	})
+	for _, ins := range []string{
+		"INSERT INTO people (name, age) VALUES ('one', 9)",
+		"INSERT INTO people (name, age) VALUES ('two', 10)",
+		"INSERT INTO people (name, age) VALUES ('three', 100)",
+	} {
+		q, err := ParseQuery(ins)
+		if err != nil {
+			t.Fatalf("Failed to parse query %s", err)
+		}
+		rCh, err := q.Execute(context.TODO(), tdb)
+		if err != nil {
+			t.Fatalf("failed to execute query %s", err)
+		}
+		for r := range rCh {
+			if _, ok := r.Values()["ERROR"]; ok {
+				t.Fatalf("unexpected error inserting %s", r.Values())
+			}
+		}
+	}
+
+	q, err := ParseQuery("SELECT name, age FROM people WHERE age > 9 ORDER BY age DESC")
+	if err != nil {
+		t.Fatalf("Failed to parse query %s", err)
+	}
+	rCh, err := q.Execute(context.TODO(), tdb)
+	if err != nil {
+		t.Fatalf("failed to execute query %s", err)
+	}
+	var names []string
+	for r := range rCh {
+		names = append(names, *r.Values()["name"])
+	}
+	if !reflect.DeepEqual(names, []string{"three", "two"}) {
+		t.Fatalf("unexpected result, expected %v, found %v", []string{"three", "two"}, names)
+	}
+}
+
+func TestQueryParser_ParseKeywordsInNamesAndValues(t *testing.T) {
+	q, err := ParseQuery("SELECT information, fromage AS orderly FROM t1 WHERE information = 'FROM here' ORDER BY orderly")
+	if err != nil {
+		t.Fatalf("Failed to parse query %s", err)
+	}
+	sq, ok := q.(*SelectQuery)
+	if !ok {
+		t.Fatalf("unexpected query type found.  Expected %s, found %s", "*SelectQuery", reflect.TypeOf(q).Elem().Name())
+	}
+	if !reflect.DeepEqual(sq.Columns, []string{"information", "fromage"}) {
+		t.Fatalf("unexpected columns %v", sq.Columns)
+	}
+	if !reflect.DeepEqual(sq.Names, []string{"information", "orderly"}) {
+		t.Fatalf("unexpected names %v", sq.Names)
+	}
+	if sq.TableName != "t1" {
+		t.Fatalf("unexpected table name, expected %q, found %q", "t1", sq.TableName)
+	}
+	if sq.OrderBy == nil || !reflect.DeepEqual(sq.OrderBy.Columns, []string{"orderly"}) {
+		t.Fatalf("unexpected order by %v", sq.OrderBy)
+	}
+}
+
+func TestQueryParser_ParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"SELECT a b FROM t1":                     "line 1, column 10: expected FROM",
+		"SELECT a FROM":                          "line 1, column 14: expected table name",
+		"INSERT INTO t1 (a, b) VALUES (1)":       "columns / values count mismatch",
+		"UPDATE t1 SET a = 1,\nWHERE a = 2":      "line 2, column 7: expected '='",
+		"DELETE t1":                              "line 1, column 8: expected FROM",
+		"CREATE TABLE t (a BLOB)":                "line 1, column 19: \"BLOB\" is not a known column type",
+		"DROP TRIGGER t":                         "line 1, column 6: DROP \"TRIGGER\", is not a known drop type",
+		"SELECT * FROM t1 WHERE a = 1 ORDER a":   "line 1, column 36: expected BY",
+		"SELECT * FROM t1 WHERE a = 1 LIMIT 2 3": "line 1, column 38: unexpected \"3\"",
+	}
+	for query, expect := range tests {
+		_, err := ParseQuery(query)
+		if err == nil {
+			t.Fatalf("expected error parsing %q", query)
+		}
+		if !strings.Contains(err.Error(), expect) {
+			t.Fatalf("unexpected error parsing %q.  Expected %q, found %q", query, expect, err)
+		}
+	}
+}
+
+func TestQueryParser_CreateDrop(t *testing.T) {
+	tdb := minisql.NewDatabase(nil)
+	for _, query := range []string{
+		"CREATE TABLE t4 (id INTEGER, name)",
+		"CREATE COLUMN t4 (price REAL)",
+		"DROP COLUMN t4 (name)",
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse query %q  %s", query, err)
+		}
+		if _, err = q.Execute(context.TODO(), tdb); err != nil {
+			t.Fatalf("failed to execute query %q  %s", query, err)
+		}
+	}
+	cols, err := tdb.Describe("t4")
+	if err != nil {
+		t.Fatalf("failed to describe table  %s", err)
+	}
+	if !reflect.DeepEqual(cols, []string{"id", "price"}) {
+		t.Fatalf("unexpected columns, expected %v, found %v", []string{"id", "price"}, cols)
+	}
+	q, _ := ParseQuery("DROP TABLE t4")
+	if _, err = q.Execute(context.TODO(), tdb); err != nil {
+		t.Fatalf("failed to drop table  %s", err)
+	}
+	if tdb.ContainsTable("t4") {
+		t.Fatalf("expected table to be dropped")
+	}
+}
+
+func TestQueryParser_Transactions(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{"t4": {"name": {Type: minisql.TEXT}}})
+	session := tdb.NewSession()
+	for _, query := range []string{
+		"BEGIN TRANSACTION",
+		"INSERT INTO t4 (name) VALUES ('one')",
+		"SAVEPOINT sp1",
+		"INSERT INTO t4 (name) VALUES ('two')",
+		"ROLLBACK TO SAVEPOINT sp1",
+		"COMMIT",
+		"START TRANSACTION",
+		"DELETE FROM t4",
+		"CREATE TABLE t5 (x)",
+		"ROLLBACK WORK;",
+	} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse query %q  %s", query, err)
+		}
+		rCh, err := q.Execute(context.TODO(), session)
+		if err != nil {
+			t.Fatalf("failed to execute query %q  %s", query, err)
+		}
+		for r := range rCh {
+			if e, ok := r.Values()["ERROR"]; ok {
+				t.Fatalf("query %q failed  %s", query, *e)
+			}
+		}
+	}
+	if session.InTransaction() {
+		t.Fatalf("expected transaction to have ended")
+	}
+	if tdb.ContainsTable("t5") {
+		t.Fatalf("expected created table to be rolled back")
+	}
+	tb, _ := tdb.Table("t4")
+	if !tb.ContainsID(0) || tb.ContainsID(1) {
+		t.Fatalf("expected only the first insert to remain")
+	}
+
+	q, _ := ParseQuery("COMMIT")
+	if _, err := q.Execute(context.TODO(), session); err == nil {
+		t.Fatalf("expected error committing without a transaction")
+	}
+	if _, err := ParseQuery("ROLLBACK TO"); err == nil {
+		t.Fatalf("expected error parsing rollback without a savepoint name")
+	}
+}
+
+func TestQueryParser_CreateDropIndex(t *testing.T) {
+	tdb := minisql.NewDatabase(minisql.Schema{"t4": {"name": {Type: minisql.TEXT}, "qty": {Type: minisql.INTEGER}}})
+	q, err := ParseQuery("CREATE UNIQUE INDEX byname ON t4 USING HASH (name)")
+	if err != nil {
+		t.Fatalf("Failed to parse query  %s", err)
+	}
+	ciq, ok := q.(*CreateIndexQuery)
+	if !ok {
+		t.Fatalf("expected CreateIndexQuery, found %T", q)
+	}
+	expect := minisql.IndexDef{Name: "byname", Columns: []string{"name"}, Unique: true, Type: minisql.HASH}
+	if ciq.TableName != "t4" || !reflect.DeepEqual(ciq.Index, expect) {
+		t.Fatalf("unexpected index, expected %v on t4, found %v on %s", expect, ciq.Index, ciq.TableName)
+	}
+	if _, err = q.Execute(context.TODO(), tdb); err != nil {
+		t.Fatalf("failed to create index  %s", err)
+	}
+	if _, err = q.Execute(context.TODO(), tdb); err == nil {
+		t.Fatalf("expected error creating an existing index")
+	}
+	q, _ = ParseQuery("CREATE INDEX byqty ON t4 (qty, name)")
+	if _, err = q.Execute(context.TODO(), tdb); err != nil {
+		t.Fatalf("failed to create index  %s", err)
+	}
+	tb, _ := tdb.Table("t4")
+	if defs := tb.(minisql.Indexer).Indexes(); len(defs) != 2 || defs[1].Type != minisql.ORDERED {
+		t.Fatalf("expected two indexes, found %v", defs)
+	}
+
+	for _, query := range []string{
+		"CREATE INDEX ON t4 (name)",
+		"CREATE INDEX i t4 (name)",
+		"CREATE INDEX i ON t4 USING LIST (name)",
+		"CREATE UNIQUE TABLE t5 (name)",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Fatalf("expected error parsing %q", query)
+		}
+	}
+
+	q, _ = ParseQuery("DROP INDEX byname ON t3")
+	if _, err = q.Execute(context.TODO(), tdb); err == nil {
+		t.Fatalf("expected error dropping an index from the wrong table")
+	}
+	q, _ = ParseQuery("DROP INDEX byname")
+	if _, err = q.Execute(context.TODO(), tdb); err != nil {
+		t.Fatalf("failed to drop index  %s", err)
+	}
+	if defs := tb.(minisql.Indexer).Indexes(); len(defs) != 1 || defs[0].Name != "byqty" {
+		t.Fatalf("expected only index byqty to remain, found %v", defs)
+	}
+}
+
+func TestQueryParser_CreateConstraints(t *testing.T) {
+	tdb := minisql.NewDatabase(nil)
+	runQuery(t, tdb, "CREATE TABLE t5 (email TEXT NOT NULL UNIQUE, status TEXT DEFAULT 'new', qty INTEGER CHECK (qty >= 0))")
+	tb, _ := tdb.Table("t5")
+	for cn, expect := range map[string]string{
+		"email":  "TEXT NOT NULL UNIQUE",
+		"status": "TEXT DEFAULT 'new'",
+		"qty":    "INTEGER CHECK (qty >= 0)",
+	} {
+		if cd, _ := tb.ColumnDef(cn); cd.String() != expect {
+			t.Fatalf("expected column %s to be %q, found %q", cn, expect, cd)
+		}
+	}
+	runQuery(t, tdb, "INSERT INTO t5 (email, qty) VALUES ('a', 1), ('b', NULL)")
+	if rows := runQuery(t, tdb, "SELECT email, status, qty FROM t5", "email", "status", "qty"); strings.Join(rows, " ") != "a,new,1 b,new,NULL" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	runQuery(t, tdb, "CREATE TABLE t6 (email, qty)")
+	runQuery(t, tdb, "INSERT INTO t6 (email, qty) VALUES ('c', '-1')")
+	runQuery(t, tdb, "CREATE TABLE t9 (a INTEGER CHECK (a + 1 > a), b INTEGER DEFAULT 2 CHECK (b * 2 > a))")
+
+	for query, expect := range map[string]string{
+		"INSERT INTO t5 (email, qty) VALUES ('c', -1)":          "CHECK constraint of column qty failed, (qty >= 0) is false",
+		"INSERT INTO t5 (qty) VALUES (2)":                       "NOT NULL constraint of column email failed, value is NULL",
+		"INSERT INTO t5 (email) VALUES ('a')":                   "UNIQUE constraint of column email failed, 'a' is already in another row",
+		"UPDATE t5 SET qty = -2 WHERE email = 'a'":              "CHECK constraint of column qty failed",
+		"UPDATE t5 SET email = NULL":                            "NOT NULL constraint of column email failed",
+		"INSERT INTO t5 (email, qty) SELECT email, qty FROM t6": "CHECK constraint of column qty failed",
+		"INSERT INTO t9 (a) VALUES (9223372036854775807)":       "CHECK constraint of column a  9223372036854775807 + 1 overflows an INTEGER",
+		"INSERT INTO t9 (a) VALUES (4)":                         "CHECK constraint of column b failed, (b * 2 > a) is false",
+	} {
+		if err := queryError(tdb, query); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("expected %q to fail with %q, found %v", query, expect, err)
+		}
+	}
+
+	runQuery(t, tdb, "CREATE TABLE t8 (a INTEGER DEFAULT 1 CHECK (a > 0), b INTEGER CHECK (b > a))")
+	runQuery(t, tdb, "CREATE COLUMN t5 (flag INTEGER NOT NULL DEFAULT 0)")
+	if rows := runQuery(t, tdb, "SELECT flag FROM t5", "flag"); strings.Join(rows, " ") != "0 0" {
+		t.Fatalf("expected existing rows to have the default flag, found %v", rows)
+	}
+	for query, expect := range map[string]string{
+		"CREATE COLUMN t5 (other NOT NULL)":                                         "needs a DEFAULT value",
+		"CREATE COLUMN t5 (other UNIQUE DEFAULT 'x')":                               "can not have a DEFAULT value",
+		"CREATE TABLE t7 (a CHECK (b > 1))":                                         "b, which is not a known column",
+		"CREATE TABLE t7 (a INTEGER DEFAULT 'x')":                                   "DEFAULT",
+		"CREATE TABLE t7 (a CHECK (a >))":                                           "missing condition value",
+		"CREATE TABLE t7 (a NOT UNIQUE)":                                            "expected NULL",
+		"CREATE TABLE t7 (a INTEGER DEFAULT -1 CHECK (a > 0))":                      "CHECK constraint of column a fails the DEFAULT values, (a > 0) is false",
+		"CREATE TABLE t7 (a INTEGER DEFAULT 1, b INTEGER DEFAULT 2 CHECK (a > b))":  "CHECK constraint of column b fails the DEFAULT values",
+		"CREATE COLUMN t5 (other INTEGER DEFAULT 0 CHECK (other <> 0))":             "CHECK constraint of column other fails the DEFAULT values",
+		"CREATE TABLE t7 (a INTEGER DEFAULT 2 CHECK (a - 3 > 0))":                   "CHECK constraint of column a fails the DEFAULT values, (a - 3 > 0) is false",
+		"CREATE TABLE t7 (a INTEGER DEFAULT 2 CHECK (a * 4611686018427387904 > 0))": "CHECK constraint of column a  2 * 4611686018427387904 overflows an INTEGER",
+	} {
+		if err := queryError(tdb, query); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("expected %q to fail with %q, found %v", query, expect, err)
+		}
+	}
+}
+
+func TestQueryParser_UpdateAtomic(t *testing.T) {
+	tdb := minisql.NewDatabase(nil)
+	runQuery(t, tdb, "CREATE TABLE stock (name TEXT UNIQUE, qty INTEGER CHECK (qty >= 0))")
+	runQuery(t, tdb, "INSERT INTO stock (name, qty) VALUES ('a', 10), ('b', 3), ('c', 8)")
+	for query, expect := range map[string]string{
+		"UPDATE stock SET qty = qty - 5": "CHECK constraint of column qty failed",
+		"UPDATE stock SET name = 'x'":    "UNIQUE constraint of column name failed",
+	} {
+		if err := queryError(tdb, query); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("expected %q to fail with %q, found %v", query, expect, err)
+		}
+		if rows := runQuery(t, tdb, "SELECT name, qty FROM stock", "name", "qty"); strings.Join(rows, " ") != "a,10 b,3 c,8" {
+			t.Fatalf("expected failed %q to leave every row unchanged, found %v", query, rows)
+		}
+	}
+}
+
+// queryError parses and runs the query, returning the first error it results in.
+func queryError(db minisql.Database, query string) error {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	rCh, err := q.Execute(context.TODO(), db)
+	if err != nil {
+		return err
+	}
+	var first error
+	for r := range rCh {
+		if e := minisql.ErrorOf(r); e != nil && first == nil {
+			first = fmt.Errorf("%s", *e)
+		}
+	}
+	return first
+}
+
+func TestQueryParser_ConstantWhere(t *testing.T) {
+	tdb := minisql.NewDatabase(nil)
+	runQuery(t, tdb, "CREATE TABLE t (a INTEGER)")
+	runQuery(t, tdb, "INSERT INTO t (a) VALUES (1), (2)")
+	for _, where := range []string{"1 = 2", "'x' = 'y'", "NULL = NULL", "NULL IS NOT NULL", "1 > 1 + 1"} {
+		if rows := runQuery(t, tdb, "SELECT a FROM t WHERE "+where, "a"); len(rows) != 0 {
+			t.Fatalf("expected no rows selected WHERE %s, found %v", where, rows)
+		}
+		if rows := runQuery(t, tdb, "UPDATE t SET a = 0 WHERE "+where, "_id"); len(rows) != 0 {
+			t.Fatalf("expected no rows updated WHERE %s, found %v", where, rows)
+		}
+		if rows := runQuery(t, tdb, "DELETE FROM t WHERE "+where, "deleted"); strings.Join(rows, " ") != "0" {
+			t.Fatalf("expected no rows deleted WHERE %s, found %v", where, rows)
+		}
+	}
+	if rows := runQuery(t, tdb, "SELECT a FROM t WHERE 1 = 1 ORDER BY a", "a"); strings.Join(rows, " ") != "1 2" {
+		t.Fatalf("expected every row selected WHERE 1 = 1, found %v", rows)
+	}
+}
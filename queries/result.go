@@ -1,14 +1,16 @@
 package queries
 
-import "eurozulu/miniSQL/minisql"
+import (
+	"eurozulu/miniSQL/minisql"
+	"sort"
+)
 
-type Result interface {
-	TableName() string
-	Values() minisql.Values
-}
+// Result is a single result of a query.
+type Result = minisql.Result
 
 type result struct {
 	tableName string
+	columns   []string
 	values    minisql.Values
 }
 
@@ -20,9 +22,32 @@ func (r result) Values() minisql.Values {
 	return r.values
 }
 
+// Columns gets the names of the values in the order they were selected.  Results created without an order
+// have their names in alphabetical order.
+func (r result) Columns() []string {
+	if r.columns != nil {
+		return r.columns
+	}
+	cols := make([]string, 0, len(r.values))
+	for c := range r.values {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
 func NewResult(tableName string, values minisql.Values) Result {
 	return &result{
 		tableName: tableName,
 		values:    values,
 	}
 }
+
+// NewResultOfColumns creates a result of the values of the given columns, which keeps the order of the columns.
+func NewResultOfColumns(tableName string, columns []string, values minisql.Values) Result {
+	return &result{
+		tableName: tableName,
+		columns:   columns,
+		values:    values,
+	}
+}
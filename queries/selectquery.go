@@ -2,53 +2,133 @@ package queries
 
 import (
 	"context"
+	"eurozulu/miniSQL/queries/lexer"
 	"eurozulu/miniSQL/queries/whereclause"
 	"eurozulu/miniSQL/stringutil"
 	"fmt"
 	"log"
 	"strings"
+	"unicode"
 
 	"eurozulu/miniSQL/minisql"
 )
 
 type SelectQuery struct {
 	TableName string
-	Columns   []string
-	Names     []string
-	Where     whereclause.WhereClause
-	Into      string
-	OrderBy   *sortedResult
+	// Alias is an optional name for the table, used to qualify its column names
+	Alias   string
+	Joins   []*Join
+	Columns []string
+	Names   []string
+	// Expressions are the values of the selected columns which are computed, rather than read from a column,
+	// keyed by their text in Columns.  e.g. price * qty
+	Expressions map[string]whereclause.Value
+	Where       whereclause.WhereClause
+	// GroupBy are the columns to group the rows by, aggregating each group into a single result
+	GroupBy []string
+	// Having filters the grouped results
+	Having whereclause.Expression
+	// Aggregates are the aggregate functions used in the columns, HAVING and ORDER BY of the query, keyed by their text.
+	// e.g. COUNT(*)  Columns of a table are never aggregates, whatever their names.
+	Aggregates map[string]*aggregate
+	Into       string
+	OrderBy    *sortedResult
+	// Limit, when not nil, is the most results to return, following the first Offset results.
+	Limit  *int
+	Offset int
+	// LimitParameter and OffsetParameter, when not zero, are the numbers of the parameters which give the Limit and Offset.
+	LimitParameter  int
+	OffsetParameter int
 }
 
-func (q SelectQuery) Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Result, error) {
-	t, err := db.Table(q.TableName)
+func (q SelectQuery) Execute(ctx context.Context, db minisql.Database) (<-chan Result, error) {
+	t, err := q.table(ctx, db)
 	if err != nil {
 		return nil, err
 	}
 
-	cols, err := expandColumnNames(t, q.Columns)
+	cols, names, err := expandColumnNames(t, q.Columns, q.Names, q.Expressions, q.Aggregates)
 	if err != nil {
 		return nil, fmt.Errorf("%w in table %s", err, q.TableName)
 	}
 	q.Columns = cols
+	q.Names = names
+	hidden := q.selectOrderExpressions()
+	if q.Expressions, err = q.bindExpressions(t); err != nil {
+		return nil, fmt.Errorf("%w in table %s", err, q.TableName)
+	}
+	if err = whereclause.CheckWhereTypes(q.Where, t); err != nil {
+		return nil, err
+	}
 
 	if q.Into != "" && db.ContainsTable(q.Into) {
 		return nil, fmt.Errorf("table %q already exists. Use INSERT INTO to insert into existing table", q.Into)
 	}
+	g, err := q.grouping(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var order *sortedResult
+	if q.OrderBy != nil && q.Into == "" {
+		order = &sortedResult{}
+		*order = *q.OrderBy
+		order.Hidden = hidden
+		// order by columns which are not selected are selected, to sort by, and then removed
+		for _, c := range order.Columns {
+			if stringutil.Contains(c, q.Names) {
+				continue
+			}
+			if g != nil && g.Aggregates[c] == nil && !stringutil.Contains(c, g.GroupBy) {
+				return nil, fmt.Errorf("%s is not a grouped column or aggregate to order by", c)
+			}
+			if g == nil && !hasColumn(t, c) {
+				return nil, fmt.Errorf("%s is an unknown column to order by", c)
+			}
+			q.Columns = append(q.Columns, c)
+			q.Names = append(q.Names, c)
+			order.Hidden = append(order.Hidden, c)
+		}
+		order.Types, err = q.columnTypes(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a limited query is cancelled once it has returned all its results
+	var limit *resultLimit
+	if q.Limit != nil && q.Into == "" {
+		limit = newResultLimit(ctx, q.Offset, *q.Limit)
+		ctx = limit.ctx
+		if order != nil {
+			order.Top = q.Offset + *q.Limit
+		}
+	}
 
 	ch := make(chan Result)
 	var chOut <-chan Result = ch
-	if q.OrderBy != nil {
-		chOut = q.OrderBy.Sort(ctx, ch)
+	if g != nil && q.Into == "" {
+		// the rows of the selected columns are aggregated into the grouped results
+		g.Columns = q.Columns
+		g.Names = q.Names
+		chOut = g.Aggregate(ctx, q.resultName(t), chOut)
+		q.Columns = g.Selected
+		q.Names = g.Selected
+	}
+	if order != nil {
+		chOut = order.Sort(ctx, chOut)
+	}
+	if limit != nil {
+		chOut = limit.Limit(chOut)
 	}
 	go func(sq *SelectQuery, results chan<- Result) {
 		defer close(results)
 
 		var err error
 		if sq.Into != "" {
-			err = sq.executeSelectINTO(ctx, db, results)
+			err = sq.executeSelectINTO(ctx, db, t, results)
 		} else {
-			err = sq.executeSelect(ctx, db, results)
+			err = sq.executeSelect(ctx, t, results)
 		}
 		if err != nil {
 			es := err.Error()
@@ -63,48 +143,125 @@ func (q SelectQuery) Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Re
 	return chOut, nil
 }
 
-func (q SelectQuery) executeSelect(ctx context.Context, db *minisql.MiniDB, results chan<- Result) error {
-	t, _ := db.Table(q.TableName)
-	keys := q.Where.Keys(ctx, t)
+// table gets the table the query selects from.  When the query joins tables, names its table with an alias
+// or qualifies its column names, the table is a view of the joined rows.
+func (q SelectQuery) table(ctx context.Context, db minisql.Database) (minisql.Table, error) {
+	if len(q.Joins) == 0 && q.Alias == "" && !q.hasQualifiedNames() {
+		return db.Table(q.TableName)
+	}
+	return newJoinView(ctx, db, q.TableName, q.Alias, q.Joins)
+}
+
+// hasQualifiedNames checks if any of the column names used by the query are qualified with a table name.
+func (q SelectQuery) hasQualifiedNames() bool {
+	names := q.sourceColumns()
+	names = append(names, whereclause.ColumnNames(q.Where)...)
+	if q.OrderBy != nil {
+		names = append(names, q.OrderBy.columnNames()...)
+	}
+	for _, n := range names {
+		if strings.Contains(n, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// resultName gets the table name given to the results.  Results of joined tables are named after all the tables.
+func (q SelectQuery) resultName(t minisql.Table) string {
+	if v, ok := t.(*joinView); ok {
+		return v.name
+	}
+	return q.TableName
+}
+
+func (q SelectQuery) executeSelect(ctx context.Context, t minisql.Table, results chan<- Result) error {
+	name := q.resultName(t)
+	keys, errs := q.Where.Keys(ctx, t)
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case id, ok := <-keys:
 			if !ok {
-				return nil
+				return <-errs
 			}
-			v, err := t.Select(id, q.Columns)
+			v, err := t.Select(id, q.sourceColumns())
 			if err != nil {
 				return err
 			}
+			if v, err = q.computeValues(v); err != nil {
+				return err
+			}
 			v = q.nameValues(v)
 			select {
 			case <-ctx.Done():
 				return nil
-			case results <- NewResult(q.TableName, v):
+			case results <- NewResultOfColumns(name, q.Names, v):
 			}
 		}
 	}
 }
 
-func (q SelectQuery) executeSelectINTO(ctx context.Context, db *minisql.MiniDB, results chan<- Result) error {
-	// create the new table based on the query columns
-	cols := removeIDColumn(q.Columns)
-	if err := createTable(q.Into, cols, db); err != nil {
+func (q SelectQuery) executeSelectINTO(ctx context.Context, db minisql.Database, t minisql.Table, results chan<- Result) error {
+	// create the new table based on the query columns, named with any aliases
+	types, err := q.columnTypes(t)
+	if err != nil {
 		return err
 	}
+	cols := map[string]*minisql.ColumnDef{}
+	var selected []string
+	var names []string
+	for i, n := range q.Names {
+		if isIDColumn(t, q.Columns[i]) {
+			continue
+		}
+		ct := types[n]
+		if n == q.Columns[i] {
+			switch {
+			case q.Expressions[n] != nil || q.Aggregates[n] != nil:
+				n = plainColumnName(n)
+			case strings.Contains(n, "."):
+				// qualified names of joined columns are named by their column
+				n = n[strings.Index(n, ".")+1:]
+			}
+		}
+		if _, ok := cols[n]; ok {
+			return fmt.Errorf("column %s appears more than once, use AS to name the column", n)
+		}
+		cols[n] = &minisql.ColumnDef{Type: ct}
+		selected = append(selected, q.Columns[i])
+		names = append(names, n)
+	}
+	into := q.Into
+	db.AlterDatabase(minisql.Schema{into: cols})
 
 	// flip SELECT INTO, into an INSERT SELECT, removing the SELECT INTO name
 	q.Into = ""
+	q.Columns = selected
+	q.Names = names
 	iq := InsertQuery{
-		TableName: q.Into,
-		Columns:   cols,
+		TableName: into,
+		Columns:   names,
 		Select:    &q,
 	}
 	return iq.insertSelect(ctx, db, results)
 }
 
+// plainColumnName gets the name of a new column, of a computed value or aggregate selected without an alias.
+// The name is the words of its text, in lower case, joined with underscores, so it can be selected like any other column.
+// e.g. UPPER(name) is upper_name, id + 1 is id_1 and COUNT(*) is count
+func plainColumnName(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	n := strings.ToLower(strings.Join(words, "_"))
+	if n == "" || unicode.IsDigit(rune(n[0])) {
+		n = strings.TrimSuffix("column_"+n, "_")
+	}
+	return n
+}
+
 func (q SelectQuery) nameValues(values minisql.Values) minisql.Values {
 	vals := minisql.Values{}
 	for i, col := range q.Columns {
@@ -113,131 +270,259 @@ func (q SelectQuery) nameValues(values minisql.Values) minisql.Values {
 	return vals
 }
 
-func createTable(table string, columns []string, db *minisql.MiniDB) error {
-	cols := map[string]bool{}
-	for _, c := range columns {
-		cols[c] = true
+// columnTypes maps the result names of the query to the types of the columns, or aggregates, they select.
+func (q SelectQuery) columnTypes(t minisql.Table) (map[string]minisql.ColumnType, error) {
+	types := map[string]minisql.ColumnType{}
+	for i, c := range q.Columns {
+		if v, ok := q.Expressions[c]; ok {
+			types[q.Names[i]] = valueType(v)
+			continue
+		}
+		if a := q.Aggregates[c]; a != nil {
+			ct, err := a.columnType(t)
+			if err != nil {
+				return nil, err
+			}
+			types[q.Names[i]] = ct
+			continue
+		}
+		cd, err := t.ColumnDef(c)
+		if err != nil {
+			return nil, err
+		}
+		types[q.Names[i]] = cd.Type
 	}
-	db.AlterDatabase(minisql.Schema{
-		table: cols,
-	})
-	return nil
+	return types, nil
 }
 
 // expandColumnNames expands the given list of column names and validates the given list as known names.
-// columns may contain "*" wild card to indicate all column names.
-func expandColumnNames(t minisql.Table, columns []string) ([]string, error) {
-	tcols := t.ColumnNames()
+// columns may contain "*" wild card to indicate all column names, including the _id column.
+// Columns of joined tables may use "<table>.*" to indicate all the columns of that table.
+// names are the result names (aliases) of the columns, and are expanded along with the columns.
+// When nil, the column names are used as their result names.
+// Columns which are computed, by the given expressions, are not columns of the table, so are kept as they are,
+// as are the given aggregates, once the columns they aggregate are found.
+func expandColumnNames(t minisql.Table, columns []string, names []string, expressions map[string]whereclause.Value,
+	aggregates map[string]*aggregate) ([]string, []string, error) {
+	tcols := allColumnNames(t)
 	if len(columns) == 0 {
-		return tcols, nil
+		return tcols, tcols, nil
+	}
+	if names == nil {
+		names = columns
 	}
 	var cols []string
-	for _, c := range columns {
-		if c == "*" {
+	var ns []string
+	for i, c := range columns {
+		switch {
+		case c == "*":
 			cols = append(cols, tcols...)
-		} else {
-			if !stringutil.Contains(c, tcols) {
-				return nil, fmt.Errorf("%s is an unknown column", c)
+			ns = append(ns, tcols...)
+		case strings.HasSuffix(c, ".*"):
+			v, ok := t.(*joinView)
+			si := -1
+			if ok {
+				si = v.sourceIndex(strings.TrimSuffix(c, ".*"))
+			}
+			if si < 0 {
+				return nil, nil, fmt.Errorf("%s is an unknown table", strings.TrimSuffix(c, ".*"))
 			}
+			cols = append(cols, v.sourceColumnNames(si)...)
+			ns = append(ns, v.sourceColumnNames(si)...)
+		case expressions[c] != nil:
 			cols = append(cols, c)
+			ns = append(ns, names[i])
+		default:
+			var err error
+			if a := aggregates[c]; a == nil {
+				err = validColumn(t, c)
+			} else if a.Column != "*" {
+				err = validColumn(t, a.Column)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			cols = append(cols, c)
+			ns = append(ns, names[i])
 		}
 	}
-	return cols, nil
+	return cols, ns, nil
+}
+
+// validColumn checks the table has the named column.
+func validColumn(t minisql.Table, name string) error {
+	if hasColumn(t, name) {
+		return nil
+	}
+	_, err := t.ColumnDef(name)
+	return err
 }
 
-func removeIDColumn(cols []string) []string {
-	i := stringutil.IndexOf("_id", cols)
-	if i < 0 {
-		return cols
+// allColumnNames gets all the column names of the table, including the _id column.
+// All the columns of joined tables are qualified with their table name.
+func allColumnNames(t minisql.Table) []string {
+	if v, ok := t.(*joinView); ok && len(v.sources) > 1 {
+		return v.allColumnNames()
 	}
-	if i == len(cols)-1 {
-		return cols[:i]
+	if v, ok := t.(*joinView); ok {
+		t = v.sources[0].table
 	}
-	c := make([]string, len(cols)-1)
-	copy(c, cols[:i])
-	copy(c[i:], cols[i+1:])
-	return c
+	return append([]string{minisql.IDColumn}, t.ColumnNames()...)
 }
 
-func parseColumnNames(q string) ([]string, []string, error) {
-	cols := stringutil.SplitTrim(q, ",")
-	names := make([]string, len(cols))
+// isIDColumn checks if the named column is an _id column, of the table or any of the joined tables.
+func isIDColumn(t minisql.Table, name string) bool {
+	if v, ok := t.(*joinView); ok {
+		ref, err := v.resolve(name)
+		return err == nil && ref.column == minisql.IDColumn
+	}
+	return name == minisql.IDColumn
+}
 
-	for i, c := range cols {
-		n := c
-		if strings.Contains(c, " ") {
-			col, rest := stringutil.FirstWord(c)
-			as, name := stringutil.FirstWord(rest)
-			if !strings.EqualFold(as, "AS") {
-				return nil, nil, fmt.Errorf("unexpected value found after column %q. Expected ',' or 'AS'", c)
+// readColumnNames reads a comma delimited list of column names, each optionally followed by AS and an alias name.
+// returns the column names and the result names. The result name of a column is its alias or the column name when no alias is given.
+// A column may also be a value computed from other columns and literals, whose name is its text, and whose value is
+// returned in the expressions, keyed by that name.  e.g. price * qty AS total
+func readColumnNames(ts *lexer.TokenStream) ([]string, []string, map[string]whereclause.Value, error) {
+	var cols []string
+	var names []string
+	var exs map[string]whereclause.Value
+	for {
+		t := ts.Peek()
+		var col string
+		if ts.AcceptSymbol("*") {
+			col = "*"
+		} else {
+			mark := ts.Mark()
+			var c string
+			var err error
+			if t.IsName() && !whereclause.IsFunctionCall(ts) {
+				if c, err = whereclause.ReadColumnName(ts, "column name"); err != nil {
+					return nil, nil, nil, err
+				}
 			}
-			if name == "" {
-				return nil, nil, fmt.Errorf("expected column alias name not found after AS: %q.", c)
+			col = c
+			switch {
+			// all the columns of one of the joined tables
+			case c != "" && ts.Peek().IsSymbol(".") && ts.PeekAt(1).IsSymbol("*"):
+				ts.Next()
+				ts.Next()
+				col = c + ".*"
+			case c == "" || ts.Peek().IsSymbol("+", "-", "*", "/", "%", "||"):
+				ts.Reset(mark)
+				v, err := whereclause.ReadValueExpression(ts)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				col = ts.Source(t)
+				if exs == nil {
+					exs = map[string]whereclause.Value{}
+				}
+				exs[col] = v
+			}
+		}
+		n := col
+		if ts.AcceptKeyword("AS") {
+			name, err := ts.ExpectName("column alias name after AS")
+			if err != nil {
+				return nil, nil, nil, err
 			}
 			n = name
-			cols[i] = col
 		}
 		if stringutil.Contains(n, names) {
-			return nil, nil, fmt.Errorf("column name %q appears more than once", n)
+			return nil, nil, nil, ts.Errorf(t, "column name %q appears more than once", n)
+		}
+		cols = append(cols, col)
+		names = append(names, n)
+		if !ts.AcceptSymbol(",") {
+			return cols, names, exs, nil
 		}
-		names[i] = n
 	}
-	return cols, names, nil
 }
 
-// NewSelectQuery creates a SelectQuery from the given string.
-// String should contain a valid SELECT query, without the preceeding SELECT statement.
-// i.e. it should begin with a comma delimited list of column names.
-// e.g. "col1, col2, col3 FROM mytable WHERE col3=NULL"
-func NewSelectQuery(query string) (*SelectQuery, error) {
+// readSelectQuery reads a SELECT query from the tokens, following the SELECT keyword.
+// When compat is set, its conditions follow the legacy rules of earlier versions.
+func readSelectQuery(ts *lexer.TokenStream, compat bool) (*SelectQuery, error) {
+	cols, names, exs, err := readColumnNames(ts)
+	if err != nil {
+		return nil, err
+	}
 	var into string
-	iti := strings.Index(strings.ToUpper(query), "INTO")
-	if iti > 0 {
-		q := strings.TrimSpace(query[iti+len("INTO"):])
-		into, q = stringutil.FirstWord(q)
-		if into == "" {
-			return nil, fmt.Errorf("missing table name after INTO")
+	if ts.AcceptKeyword("INTO") {
+		if into, err = ts.ExpectName("table name after INTO"); err != nil {
+			return nil, err
 		}
-		query = strings.Join([]string{query[:iti], q}, " ")
 	}
-	fi := strings.Index(strings.ToUpper(query), "FROM")
-	if fi < 0 {
-		return nil, fmt.Errorf("missing FROM in query")
+	if err = ts.ExpectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := ts.ExpectName("table name")
+	if err != nil {
+		return nil, err
+	}
+	alias, err := readTableAlias(ts)
+	if err != nil {
+		return nil, err
+	}
+	joins, err := readJoins(ts)
+	if err != nil {
+		return nil, err
 	}
 
-	cols, names, err := parseColumnNames(strings.TrimSpace(query[:fi]))
+	// Query always has a where, but can be 'empty' == ALL keys in the table
+	where, err := whereclause.ReadWhere(ts, compat)
 	if err != nil {
 		return nil, err
 	}
 
-	// trim off FROM and read table name
-	_, query = stringutil.FirstWord(query[fi:])
-	table, rest := stringutil.FirstWord(query)
-	if table == "" {
-		return nil, fmt.Errorf("no table name given")
+	groupBy, having, err := readGroupBy(ts, compat)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check if an ORDER BY is present
 	var order *sortedResult
-	if i := strings.Index(strings.ToUpper(rest), "ORDER"); i >= 0 {
-		order, err = newSortedResult(rest[i:])
-		if err != nil {
+	if ts.Peek().IsKeyword("ORDER") {
+		if order, err = readSortedResult(ts); err != nil {
 			return nil, err
 		}
-		rest = strings.TrimSpace(rest[:i])
 	}
+	q := &SelectQuery{
+		TableName:   table,
+		Alias:       alias,
+		Joins:       joins,
+		Columns:     cols,
+		Names:       names,
+		Expressions: exs,
+		Where:       where,
+		GroupBy:     groupBy,
+		Having:      having,
+		Into:        into,
+		OrderBy:     order,
+	}
+	if q.Aggregates, err = q.parseAggregates(); err != nil {
+		return nil, err
+	}
+	if err = q.readLimit(ts); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
 
-	// Check for a WHERE clause (Query always has a where, but can be 'empty' == ALL keys in the table)
-	where, err := whereclause.NewWhere(rest)
+// NewSelectQuery creates a SelectQuery from the given string.
+// String should contain a valid SELECT query, without the preceeding SELECT statement.
+// i.e. it should begin with a comma delimited list of column names.
+// e.g. "col1, col2, col3 FROM mytable WHERE col3=NULL"
+func NewSelectQuery(query string) (*SelectQuery, error) {
+	ts, err := lexer.NewTokenStream(query)
 	if err != nil {
 		return nil, err
 	}
-	return &SelectQuery{
-		TableName: table,
-		Columns:   cols,
-		Names:     names,
-		Where:     where,
-		Into:      into,
-		OrderBy:   order,
-	}, nil
+	q, err := readSelectQuery(ts, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = ts.ExpectEnd(); err != nil {
+		return nil, err
+	}
+	return q, nil
 }
@@ -1,27 +1,46 @@
 package queries
 
 import (
+	"container/heap"
 	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
 	"eurozulu/miniSQL/queries/whereclause"
 	"eurozulu/miniSQL/stringutil"
-	"fmt"
 	"sort"
-	"strings"
 )
 
 const DESC = "DESC"
 const ASC = "ASC"
 
 type sortedResult struct {
-	Columns    []string
-	Descending bool
+	Columns []string
+	// Expressions are the values of the columns which are computed, keyed by their text in Columns. e.g. LOWER(name)
+	Expressions map[string]whereclause.Value
+	Descending  bool
+	// Types are the column types of the result values, used to order them. Columns with no type are ordered as TEXT
+	Types map[string]minisql.ColumnType
+	// Hidden are the columns only selected to order by, which are removed from the sorted results.
+	Hidden []string
+	// Top, when more than zero, only keeps the first Top results, in order, discarding the rest as they are read.
+	Top int
 }
 
 func (sr sortedResult) Sort(ctx context.Context, results <-chan Result) <-chan Result {
 	chOut := make(chan Result)
 	go func(chIn <-chan Result, chOut chan<- Result) {
 		defer close(chOut)
+		var columns []string
 		for _, r := range sr.readAllResults(ctx, chIn) {
+			if len(sr.Hidden) > 0 && minisql.ErrorOf(r) == nil {
+				for _, c := range sr.Hidden {
+					delete(r.Values(), c)
+				}
+				if columns == nil {
+					columns = visibleColumns(r.Columns(), sr.Hidden)
+				}
+				r = NewResultOfColumns(r.TableName(), columns, r.Values())
+			}
 			select {
 			case <-ctx.Done():
 				return
@@ -33,8 +52,21 @@ func (sr sortedResult) Sort(ctx context.Context, results <-chan Result) <-chan R
 	return chOut
 }
 
+// readAllResults reads all the results, returning them in order.  Any errors are returned before the results.
+// visibleColumns gets the columns which are not hidden.
+func visibleColumns(columns []string, hidden []string) []string {
+	var cols []string
+	for _, c := range columns {
+		if !stringutil.Contains(c, hidden) {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
 func (sr sortedResult) readAllResults(ctx context.Context, results <-chan Result) []Result {
-	var rs []Result
+	var errs []Result
+	top := &topResults{sr: sr}
 outerLoop:
 	for {
 		select {
@@ -44,60 +76,136 @@ outerLoop:
 			if !ok {
 				break outerLoop
 			}
-			rs = append(rs, r)
+			if minisql.ErrorOf(r) != nil {
+				errs = append(errs, r)
+				continue
+			}
+			top.add(r)
 		}
 	}
+	rs := top.results
 	sort.Slice(rs, func(i, j int) bool {
 		return sr.sortResult(rs[i], rs[j])
 	})
-	return rs
+	return append(errs, rs...)
 }
 
 func (sr sortedResult) sortResult(r1, r2 Result) bool {
-	lt := whereclause.Operator("<")
-	eq := whereclause.Operator("=")
-
-	var v1 *string
-	var v2 *string
-	// find first column where values are not equal
-	var index int
-	for index < len(sr.Columns) {
-		v1 = r1.Values()[sr.Columns[index]]
-		v2 = r2.Values()[sr.Columns[index]]
-		if !eq.Compare(v1, v2) {
-			break
+	// find first column where values are not equal, NULL values are ordered before all others
+	for _, col := range sr.Columns {
+		c := sr.Types[col].CompareValues(r1.Values()[col], r2.Values()[col])
+		if c == 0 {
+			continue
 		}
-		index++
+		if sr.Descending {
+			return c > 0
+		}
+		return c < 0
 	}
-	if index >= len(sr.Columns) {
-		// all values in all columns are equal
-		return false
+	// all values in all columns are equal
+	return false
+}
+
+// topResults is a heap of the first results in order, with the last of those results at the top of the heap.
+// When the heap is limited to the Top results, any result after the last is discarded, and a result before it replaces it.
+type topResults struct {
+	sr      sortedResult
+	results []Result
+}
+
+func (tr *topResults) add(r Result) {
+	switch {
+	case tr.sr.Top <= 0:
+		tr.results = append(tr.results, r)
+	case len(tr.results) < tr.sr.Top:
+		heap.Push(tr, r)
+	case tr.sr.sortResult(r, tr.results[0]):
+		tr.results[0] = r
+		heap.Fix(tr, 0)
 	}
-	if sr.Descending {
-		return lt.Compare(v2, v1)
-	} else {
-		return lt.Compare(v1, v2)
+}
+
+func (tr topResults) Len() int {
+	return len(tr.results)
+}
+
+func (tr topResults) Less(i, j int) bool {
+	return tr.sr.sortResult(tr.results[j], tr.results[i])
+}
+
+func (tr topResults) Swap(i, j int) {
+	tr.results[i], tr.results[j] = tr.results[j], tr.results[i]
+}
+
+func (tr *topResults) Push(x interface{}) {
+	tr.results = append(tr.results, x.(Result))
+}
+
+func (tr *topResults) Pop() interface{} {
+	r := tr.results[len(tr.results)-1]
+	tr.results = tr.results[:len(tr.results)-1]
+	return r
+}
+
+// columnNames gets the names of the columns to order by, replacing computed columns with the columns they are read from.
+func (sr sortedResult) columnNames() []string {
+	var cols []string
+	for _, c := range sr.Columns {
+		if v, ok := sr.Expressions[c]; ok {
+			cols = append(cols, v.ColumnNames()...)
+			continue
+		}
+		cols = append(cols, c)
 	}
+	return cols
 }
 
-func newSortedResult(q string) (*sortedResult, error) {
-	if strings.HasPrefix(strings.ToUpper(q), "ORDER") {
-		_, q = stringutil.FirstWord(q)
+// readSortedResult reads an ORDER BY clause from the tokens.
+// The clause is a comma delimited list of column names, optionally followed by ASC or DESC
+// A column may also be a value computed from other columns, whose name is its text.  e.g. ORDER BY LOWER(name)
+func readSortedResult(ts *lexer.TokenStream) (*sortedResult, error) {
+	if err := ts.ExpectKeyword("ORDER"); err != nil {
+		return nil, err
 	}
-	if strings.HasPrefix(strings.ToUpper(q), "BY") {
-		_, q = stringutil.FirstWord(q)
+	if err := ts.ExpectKeyword("BY"); err != nil {
+		return nil, err
 	}
-	before, l := stringutil.LastWord(q)
-	desc := strings.EqualFold(l, DESC)
-	if desc || strings.EqualFold(l, ASC) {
-		q = before
+	var cols []string
+	var exs map[string]whereclause.Value
+	for {
+		t := ts.Peek()
+		mark := ts.Mark()
+		var c string
+		var err error
+		if t.IsName() && !whereclause.IsFunctionCall(ts) {
+			if c, err = whereclause.ReadColumnName(ts, "column name to order by"); err != nil {
+				return nil, err
+			}
+		}
+		if c == "" || ts.Peek().IsSymbol("+", "-", "*", "/", "%", "||") {
+			ts.Reset(mark)
+			v, err := whereclause.ReadValueExpression(ts)
+			if err != nil {
+				return nil, err
+			}
+			c = ts.Source(t)
+			if exs == nil {
+				exs = map[string]whereclause.Value{}
+			}
+			exs[c] = v
+		}
+		cols = append(cols, c)
+		if !ts.AcceptSymbol(",") {
+			break
+		}
 	}
-	cols := stringutil.SplitTrim(q, ",")
-	if len(cols) == 0 || cols[0] == "" {
-		return nil, fmt.Errorf("no column names found in sort")
+	desc := ts.AcceptKeyword(DESC)
+	if !desc {
+		ts.AcceptKeyword(ASC)
 	}
 	return &sortedResult{
-		Columns:    cols,
-		Descending: desc,
+		Columns:     cols,
+		Expressions: exs,
+		Descending:  desc,
 	}, nil
 }
@@ -0,0 +1,160 @@
+package queries
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"fmt"
+)
+
+// BeginQuery starts a new transaction.
+type BeginQuery struct{}
+
+func (q BeginQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	tx, err := transactional(db)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Begin(); err != nil {
+		return nil, err
+	}
+	return transactionResult("started"), nil
+}
+
+// CommitQuery ends the current transaction, keeping its changes.
+type CommitQuery struct{}
+
+func (q CommitQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	tx, err := transactional(db)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return transactionResult("committed"), nil
+}
+
+// RollbackQuery undoes the changes of the current transaction.
+// When a Savepoint is given, only the changes since that savepoint are undone and the transaction continues.
+type RollbackQuery struct {
+	Savepoint string
+}
+
+func (q RollbackQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	tx, err := transactional(db)
+	if err != nil {
+		return nil, err
+	}
+	if q.Savepoint != "" {
+		if err = tx.RollbackTo(q.Savepoint); err != nil {
+			return nil, err
+		}
+		return transactionResult(fmt.Sprintf("rolled back to %s", q.Savepoint)), nil
+	}
+	if err = tx.Rollback(); err != nil {
+		return nil, err
+	}
+	return transactionResult("rolled back"), nil
+}
+
+// SavepointQuery marks a point in the current transaction, which may later be rolled back to.
+type SavepointQuery struct {
+	Name string
+}
+
+func (q SavepointQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	tx, err := transactional(db)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Savepoint(q.Name); err != nil {
+		return nil, err
+	}
+	return transactionResult(fmt.Sprintf("savepoint %s", q.Name)), nil
+}
+
+// ReleaseQuery removes a savepoint from the current transaction.
+type ReleaseQuery struct {
+	Name string
+}
+
+func (q ReleaseQuery) Execute(_ context.Context, db minisql.Database) (<-chan Result, error) {
+	tx, err := transactional(db)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Release(q.Name); err != nil {
+		return nil, err
+	}
+	return transactionResult(fmt.Sprintf("released %s", q.Name)), nil
+}
+
+func transactional(db minisql.Database) (minisql.Transactional, error) {
+	tx, ok := db.(minisql.Transactional)
+	if !ok {
+		return nil, fmt.Errorf("transactions are not supported without a session")
+	}
+	return tx, nil
+}
+
+func transactionResult(status string) <-chan Result {
+	return resultsOf(NewResult("", minisql.Values{"transaction": &status}))
+}
+
+// readTransactionQuery reads a BEGIN, START, COMMIT, END, ROLLBACK, SAVEPOINT or RELEASE statement,
+// following its first keyword, t.
+func readTransactionQuery(t lexer.Token, ts *lexer.TokenStream) (Query, error) {
+	switch {
+	case t.IsKeyword("START"):
+		if err := ts.ExpectKeyword("TRANSACTION"); err != nil {
+			return nil, err
+		}
+		return &BeginQuery{}, nil
+
+	case t.IsKeyword("BEGIN"):
+		acceptTransactionKeyword(ts)
+		return &BeginQuery{}, nil
+
+	case t.IsKeyword("COMMIT", "END"):
+		acceptTransactionKeyword(ts)
+		return &CommitQuery{}, nil
+
+	case t.IsKeyword("ROLLBACK"):
+		acceptTransactionKeyword(ts)
+		if !ts.AcceptKeyword("TO") {
+			return &RollbackQuery{}, nil
+		}
+		ts.AcceptKeyword("SAVEPOINT")
+		name, err := ts.ExpectName("savepoint name")
+		if err != nil {
+			return nil, err
+		}
+		return &RollbackQuery{Savepoint: name}, nil
+
+	case t.IsKeyword("SAVEPOINT"):
+		name, err := ts.ExpectName("savepoint name")
+		if err != nil {
+			return nil, err
+		}
+		return &SavepointQuery{Name: name}, nil
+
+	case t.IsKeyword("RELEASE"):
+		ts.AcceptKeyword("SAVEPOINT")
+		name, err := ts.ExpectName("savepoint name")
+		if err != nil {
+			return nil, err
+		}
+		return &ReleaseQuery{Name: name}, nil
+
+	default:
+		return nil, ts.Errorf(t, "%s is not a transaction statement", t)
+	}
+}
+
+// acceptTransactionKeyword skips the optional TRANSACTION or WORK keyword.
+func acceptTransactionKeyword(ts *lexer.TokenStream) {
+	if !ts.AcceptKeyword("TRANSACTION") {
+		ts.AcceptKeyword("WORK")
+	}
+}
@@ -3,41 +3,84 @@ package queries
 import (
 	"context"
 	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
 	"eurozulu/miniSQL/queries/whereclause"
 	"eurozulu/miniSQL/stringutil"
 	"fmt"
+	"log"
 	"strconv"
-	"strings"
 )
 
 type UpdateQuery struct {
 	TableName string
 	Values    minisql.Values
-	Where     whereclause.WhereClause
+	// Parameters are the numbers of the parameters which give the values of the named columns.
+	Parameters map[string]int
+	// Expressions are the values of the named columns which are computed from each row.  e.g. SET qty = qty + 1
+	Expressions map[string]whereclause.Value
+	Where       whereclause.WhereClause
 }
 
-func (q UpdateQuery) Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Result, error) {
-	if !db.ContainsTable(q.TableName) {
-		return nil, fmt.Errorf("%q is not a known table", q.TableName)
+func (q UpdateQuery) Execute(ctx context.Context, db minisql.Database) (<-chan Result, error) {
+	t, err := db.Table(q.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err = minisql.CheckReferences(db, t, q.Values); err != nil {
+		return nil, err
+	}
+	if q.Expressions, err = q.bindExpressions(t); err != nil {
+		return nil, fmt.Errorf("%w in table %s", err, q.TableName)
+	}
+	if err = whereclause.CheckWhereTypes(q.Where, t); err != nil {
+		return nil, err
 	}
 	ch := make(chan Result)
 	go func(q *UpdateQuery, ch chan<- Result) {
 		defer close(ch)
-		t, _ := db.Table(q.TableName)
-		keys := q.Where.Keys(ctx, t)
+		// use sub context to stop reading the keys if an update fails
+		subCtx, cnl := context.WithCancel(ctx)
+		defer cnl()
+		keys, errs := q.Where.Keys(subCtx, t)
+		var updated []updatedRow
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case k, ok := <-keys:
 				if !ok {
+					if err := <-errs; err != nil {
+						undoUpdates(t, updated)
+						es := err.Error()
+						select {
+						case <-ctx.Done():
+						case ch <- NewResult(q.TableName, minisql.Values{"ERROR": &es}):
+						}
+					}
 					return
 				}
-				r := q.updateRow(k, t)
+				old, err := q.updateRow(db, k, t)
+				if err != nil && !t.ContainsID(k) {
+					// deleted since it was selected
+					continue
+				}
+				var v minisql.Values
+				if err != nil {
+					undoUpdates(t, updated)
+					errs := err.Error()
+					v = minisql.Values{"ERROR": &errs}
+				} else {
+					updated = append(updated, updatedRow{id: k, values: old})
+					id := strconv.Itoa(int(k))
+					v = minisql.Values{"_id": &id}
+				}
 				select {
 				case <-ctx.Done():
 					return
-				case ch <- r:
+				case ch <- NewResult(q.TableName, v):
+				}
+				if err != nil {
+					return
 				}
 			}
 		}
@@ -45,68 +88,171 @@ func (q UpdateQuery) Execute(ctx context.Context, db *minisql.MiniDB) (<-chan Re
 	return ch, nil
 }
 
-func (q UpdateQuery) updateRow(k minisql.Key, t minisql.Table) Result {
-	var v minisql.Values
-	err := t.Update(k, q.Values)
-	if err != nil {
-		errs := err.Error()
-		v = minisql.Values{"ERROR": &errs}
-	} else {
-		id := strconv.Itoa(int(k))
-		v = minisql.Values{"_id": &id}
-	}
-	return NewResult(q.TableName, v)
+// updatedRow is the key of a row changed by an update, with the values it had before.
+type updatedRow struct {
+	id     minisql.Key
+	values minisql.Values
 }
 
-// NewUpdateQuery creates a new update query from the given string
-// Query should be a valid update without the preceeding UPDATE.
-// i.e it should begin with the table name.
-// e.g. "mytable SET col1=bla, col3=haha WHERE col2=hoho"
-func NewUpdateQuery(q string) (*UpdateQuery, error) {
-	table, rest := stringutil.FirstWord(q)
-	if table == "" {
-		return nil, fmt.Errorf("missing table name")
+// undoUpdates returns the updated rows to their values before the update, in reverse order, so each row is restored
+// to a state of the table in which its values were valid.
+func undoUpdates(t minisql.Table, updated []updatedRow) {
+	for i := len(updated) - 1; i >= 0; i-- {
+		if err := t.Update(updated[i].id, updated[i].values); err != nil {
+			log.Println(err)
+		}
 	}
+}
 
-	if !strings.HasPrefix(strings.ToUpper(rest), "SET") {
-		return nil, fmt.Errorf("missing SET command")
+// bindExpressions binds the computed values to the types of the columns of the table.
+// Each column a value is computed from must be a column of the table.
+func (q UpdateQuery) bindExpressions(t minisql.Table) (map[string]whereclause.Value, error) {
+	if len(q.Expressions) == 0 {
+		return nil, nil
+	}
+	exs := map[string]whereclause.Value{}
+	for c, v := range q.Expressions {
+		v = whereclause.ValueWithTableTypes(v, t)
+		for _, n := range v.ColumnNames() {
+			if err := validColumn(t, n); err != nil {
+				return nil, fmt.Errorf("%w in %s", err, c)
+			}
+		}
+		if err := whereclause.CheckValueTypes(v); err != nil {
+			return nil, err
+		}
+		exs[c] = v
 	}
-	_, rest = stringutil.FirstWord(rest)
+	return exs, nil
+}
 
-	var where whereclause.WhereClause
-	wi := strings.Index(strings.ToUpper(rest), "WHERE")
-	if wi >= 0 {
-		w, err := whereclause.NewWhere(rest[wi:])
-		if err != nil {
+// rowValues gets the values to update the row with the given key, computing any values from the row.
+func (q UpdateQuery) rowValues(db minisql.Database, k minisql.Key, t minisql.Table) (minisql.Values, error) {
+	if len(q.Expressions) == 0 {
+		return q.Values, nil
+	}
+	var cols []string
+	for _, v := range q.Expressions {
+		cols = append(cols, v.ColumnNames()...)
+	}
+	row, err := t.Select(k, stringutil.UniqueStrings(cols))
+	if err != nil {
+		return nil, err
+	}
+	computed := minisql.Values{}
+	for c, v := range q.Expressions {
+		if computed[c], err = whereclause.Evaluate(v, row); err != nil {
 			return nil, err
 		}
-		where = w
-		rest = rest[:wi]
+	}
+	if err = minisql.CheckReferences(db, t, computed); err != nil {
+		return nil, err
+	}
+	for c, v := range q.Values {
+		computed[c] = v
+	}
+	return computed, nil
+}
+
+// updateRow updates the row with the given key, returning the values it had before.
+// A value referenced by the foreign keys of other rows is not changed.
+func (q UpdateQuery) updateRow(db minisql.Database, k minisql.Key, t minisql.Table) (minisql.Values, error) {
+	values, err := q.rowValues(db, k, t)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkReferenced(db, q.TableName, t, k, values); err != nil {
+		return nil, err
+	}
+	cols := make([]string, 0, len(values))
+	for c := range values {
+		cols = append(cols, c)
+	}
+	old, err := t.Select(k, cols)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.Update(k, values); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// readUpdateQuery reads an UPDATE query from the tokens, following the UPDATE keyword.
+func readUpdateQuery(ts *lexer.TokenStream, compat bool) (*UpdateQuery, error) {
+	table, err := ts.ExpectName("table name")
+	if err != nil {
+		return nil, err
+	}
+	if err = ts.ExpectKeyword("SET"); err != nil {
+		return nil, err
 	}
 	vals := minisql.Values{}
-	sets := strings.Split(rest, ",")
-	for _, s := range sets {
-		ss := strings.SplitN(s, "=", 2)
-		if len(ss) != 2 {
-			return nil, fmt.Errorf("missing value for %s", s)
+	var params map[string]int
+	var exs map[string]whereclause.Value
+	for {
+		col, err := ts.ExpectName("column name")
+		if err != nil {
+			return nil, err
 		}
-		col := strings.TrimSpace(ss[0])
-		if col == "" {
-			return nil, fmt.Errorf("missing column name before =")
+		if err = ts.ExpectSymbol("="); err != nil {
+			return nil, err
 		}
-		val := strings.TrimSpace(ss[1])
-		if val == "" {
-			return nil, fmt.Errorf("missing value after =")
+		mark := ts.Mark()
+		v, param, err := whereclause.ReadValueOrParameter(ts)
+		switch {
+		// a value which is not a single literal, or parameter, is computed from the row
+		case err != nil || !(ts.Peek().IsSymbol(",") || ts.Peek().IsKeyword("WHERE") || ts.AtEnd()):
+			ts.Reset(mark)
+			ex, err := whereclause.ReadValueExpression(ts)
+			if err != nil {
+				return nil, err
+			}
+			if exs == nil {
+				exs = map[string]whereclause.Value{}
+			}
+			exs[col] = ex
+		case param > 0:
+			if params == nil {
+				params = map[string]int{}
+			}
+			params[col] = param
+			vals[col] = v
+		default:
+			vals[col] = v
 		}
-		var v *string
-		if val != whereclause.NULL {
-			v = &ss[1]
+		if !ts.AcceptSymbol(",") {
+			break
 		}
-		vals[col] = v
+	}
+	where, err := whereclause.ReadWhere(ts, compat)
+	if err != nil {
+		return nil, err
 	}
 	return &UpdateQuery{
-		TableName: table,
-		Values:    vals,
-		Where:     where,
+		TableName:   table,
+		Values:      vals,
+		Parameters:  params,
+		Expressions: exs,
+		Where:       where,
 	}, nil
 }
+
+// NewUpdateQuery creates a new update query from the given string
+// Query should be a valid update without the preceeding UPDATE.
+// i.e it should begin with the table name.
+// e.g. "mytable SET col1=bla, col3=haha WHERE col2=hoho"
+func NewUpdateQuery(q string) (*UpdateQuery, error) {
+	ts, err := lexer.NewTokenStream(q)
+	if err != nil {
+		return nil, err
+	}
+	uq, err := readUpdateQuery(ts, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = ts.ExpectEnd(); err != nil {
+		return nil, err
+	}
+	return uq, nil
+}
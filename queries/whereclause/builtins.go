@@ -0,0 +1,488 @@
+package whereclause
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	for _, f := range builtinFunctions {
+		RegisterFunction(f)
+	}
+}
+
+// builtinFunctions are the functions which may always be called.
+// Arguments which are not the type a function reads them as, once bound to the types of a table, result in NULL.
+var builtinFunctions = []*Function{
+	// string functions
+	{Name: "UPPER", MinArgs: 1, MaxArgs: 1, Result: returns(minisql.TEXT), Call: textFunction(strings.ToUpper)},
+	{Name: "LOWER", MinArgs: 1, MaxArgs: 1, Result: returns(minisql.TEXT), Call: textFunction(strings.ToLower)},
+	{Name: "LENGTH", MinArgs: 1, MaxArgs: 1, Result: returns(minisql.INTEGER), Call: length},
+	{Name: "SUBSTR", MinArgs: 2, MaxArgs: 3, Args: []minisql.ColumnType{minisql.TEXT, minisql.INTEGER},
+		Result: returns(minisql.TEXT), Call: substr},
+	{Name: "TRIM", MinArgs: 1, MaxArgs: 2, Result: returns(minisql.TEXT), Call: trim},
+	{Name: "REPLACE", MinArgs: 3, MaxArgs: 3, Result: returns(minisql.TEXT), Call: replace},
+	{Name: "INSTR", MinArgs: 2, MaxArgs: 2, Result: returns(minisql.INTEGER), Call: instr},
+	{Name: "CONCAT", MinArgs: 1, MaxArgs: -1, Result: returns(minisql.TEXT), Call: concat, NullArgs: true},
+
+	// numeric functions
+	{Name: "ABS", MinArgs: 1, MaxArgs: 1, Args: []minisql.ColumnType{minisql.REAL}, Result: numericResult, Call: abs},
+	{Name: "ROUND", MinArgs: 1, MaxArgs: 2, Args: []minisql.ColumnType{minisql.REAL, minisql.INTEGER},
+		Result: roundResult, Call: round},
+	{Name: "FLOOR", MinArgs: 1, MaxArgs: 1, Args: []minisql.ColumnType{minisql.REAL}, Result: returns(minisql.INTEGER),
+		Call: wholeFunction("FLOOR", math.Floor)},
+	{Name: "CEIL", MinArgs: 1, MaxArgs: 1, Args: []minisql.ColumnType{minisql.REAL}, Result: returns(minisql.INTEGER),
+		Call: wholeFunction("CEIL", math.Ceil)},
+	{Name: "MOD", MinArgs: 2, MaxArgs: 2, Args: []minisql.ColumnType{minisql.REAL}, Result: numericResult, Call: mod},
+
+	// NULL functions
+	{Name: "COALESCE", MinArgs: 1, MaxArgs: -1, Result: firstType, Call: coalesce, NullArgs: true},
+	{Name: "IFNULL", MinArgs: 2, MaxArgs: 2, Result: firstType, Call: coalesce, NullArgs: true},
+	{Name: "NULLIF", MinArgs: 2, MaxArgs: 2, Result: firstType, Call: nullif, NullArgs: true},
+
+	// date and time functions
+	{Name: "NOW", MinArgs: 0, MaxArgs: 0, Result: returns(minisql.TIMESTAMP), Call: now},
+	{Name: "DATE", MinArgs: 1, MaxArgs: 1, Args: []minisql.ColumnType{minisql.TIMESTAMP}, Result: returns(minisql.TEXT),
+		Call: date},
+	{Name: "STRFTIME", MinArgs: 2, MaxArgs: 2, Args: []minisql.ColumnType{minisql.TEXT, minisql.TIMESTAMP},
+		Result: returns(minisql.TEXT), Call: strftime, Check: checkFormat},
+	{Name: "DATE_ADD", MinArgs: 3, MaxArgs: 3, Args: []minisql.ColumnType{minisql.TIMESTAMP, minisql.INTEGER, minisql.TEXT},
+		Result: returns(minisql.TIMESTAMP), Call: dateAdd, Check: checkUnit},
+
+	{Name: CAST, MinArgs: 2, MaxArgs: 2, Result: castType, Call: cast, Check: checkCast},
+}
+
+// returns gives the result type of functions whose type is always the same.
+func returns(ct minisql.ColumnType) func(args []Value) minisql.ColumnType {
+	return func(_ []Value) minisql.ColumnType {
+		return ct
+	}
+}
+
+// numericResult is INTEGER when all the arguments are INTEGER, otherwise REAL.
+func numericResult(args []Value) minisql.ColumnType {
+	for _, arg := range args {
+		if numericType(arg) != minisql.INTEGER {
+			return minisql.REAL
+		}
+	}
+	return minisql.INTEGER
+}
+
+// firstType is the type of the first argument with a type.
+func firstType(args []Value) minisql.ColumnType {
+	for _, arg := range args {
+		if ct := arg.Type(); ct != "" {
+			return ct
+		}
+	}
+	return ""
+}
+
+func textFunction(fn func(s string) string) func(args []*string) *string {
+	return func(args []*string) *string {
+		s := fn(*args[0])
+		return &s
+	}
+}
+
+func length(args []*string) *string {
+	return formatInt(int64(utf8.RuneCountInString(*args[0])))
+}
+
+// substr gets the characters from the start position, the first being 1, up to the optional length.
+// A negative start counts back from the end of the text, the last character being -1.
+func substr(args []*string) *string {
+	r := []rune(*args[0])
+	start, ok := intArg(args[1])
+	if !ok {
+		return nil
+	}
+	if start < 0 {
+		start += int64(len(r)) + 1
+	}
+	end := int64(len(r)) + 1
+	if len(args) > 2 {
+		n, ok := intArg(args[2])
+		if !ok || n < 0 {
+			return nil
+		}
+		if start < 1 {
+			// the positions before the first character count towards the length
+			n -= 1 - start
+			start = 1
+		}
+		if n < end-start {
+			end = start + n
+		}
+	}
+	if start < 1 {
+		start = 1
+	}
+	s := ""
+	if start < end {
+		s = string(r[start-1 : end-1])
+	}
+	return &s
+}
+
+// trim removes the spaces, or the given characters, from both ends.
+func trim(args []*string) *string {
+	cutset := " "
+	if len(args) > 1 {
+		cutset = *args[1]
+	}
+	s := strings.Trim(*args[0], cutset)
+	return &s
+}
+
+func replace(args []*string) *string {
+	if *args[1] == "" {
+		return args[0]
+	}
+	s := strings.ReplaceAll(*args[0], *args[1], *args[2])
+	return &s
+}
+
+// instr gets the position of the first character of the second value within the first, the first being 1, or 0 when it is not found.
+func instr(args []*string) *string {
+	i := strings.Index(*args[0], *args[1])
+	if i < 0 {
+		return formatInt(0)
+	}
+	return formatInt(int64(utf8.RuneCountInString((*args[0])[:i]) + 1))
+}
+
+// concat joins the values, ignoring NULL values.
+func concat(args []*string) *string {
+	var sb strings.Builder
+	for _, arg := range args {
+		if arg != nil {
+			sb.WriteString(*arg)
+		}
+	}
+	s := sb.String()
+	return &s
+}
+
+func intArg(s *string) (int64, bool) {
+	i, err := strconv.ParseInt(strings.TrimSpace(*s), 10, 64)
+	return i, err == nil
+}
+
+func realArg(s *string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(*s), 64)
+	return f, err == nil
+}
+
+func abs(args []*string) *string {
+	if i, ok := intArg(args[0]); ok {
+		if i == math.MinInt64 {
+			failEvaluation("ABS(%d) overflows an INTEGER", i)
+		}
+		if i < 0 {
+			i = -i
+		}
+		return formatInt(i)
+	}
+	if f, ok := realArg(args[0]); ok {
+		return formatReal(math.Abs(f))
+	}
+	return nil
+}
+
+func roundResult(args []Value) minisql.ColumnType {
+	return numericType(args[0])
+}
+
+// round rounds the value, half away from zero, to the optional number of decimal places.
+func round(args []*string) *string {
+	var places int64
+	if len(args) > 1 {
+		var ok bool
+		if places, ok = intArg(args[1]); !ok {
+			return nil
+		}
+	}
+	i, isInt := intArg(args[0])
+	if isInt && places >= 0 {
+		return formatInt(i)
+	}
+	f, ok := realArg(args[0])
+	if !ok {
+		return nil
+	}
+	f = roundDecimal(f, places)
+	if isInt {
+		return formatInt(wholeInt("ROUND", f))
+	}
+	return formatReal(f)
+}
+
+// roundDecimal rounds the number, half away from zero, on the digits of its shortest decimal form, rather than its binary value.
+// e.g. 1.005 rounds to 1.01, although its nearest binary value is a little below 1.005.
+func roundDecimal(f float64, places int64) float64 {
+	if f == 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return f
+	}
+	// mantissa digits d1.d2d3... and exponent, the value being 0.d1d2d3... * 10^(exp+1)
+	s := strconv.FormatFloat(math.Abs(f), 'e', -1, 64)
+	i := strings.IndexByte(s, 'e')
+	digits := strings.Replace(s[:i], ".", "", 1)
+	exp, _ := strconv.ParseInt(s[i+1:], 10, 64)
+	// a REAL has no digits beyond 10^-400 nor 10^400, so further places change nothing
+	if places > 400 {
+		places = 400
+	} else if places < -400 {
+		places = -400
+	}
+	keep := exp + 1 + places
+	if keep >= int64(len(digits)) {
+		return f
+	}
+	if keep < 0 {
+		return math.Copysign(0, f)
+	}
+	var n int64
+	if keep > 0 {
+		n, _ = strconv.ParseInt(digits[:keep], 10, 64)
+	}
+	if digits[keep] >= '5' {
+		n++
+	}
+	r, _ := strconv.ParseFloat(fmt.Sprintf("%de%d", n, exp+1-keep), 64)
+	return math.Copysign(r, f)
+}
+
+// wholeInt converts a whole REAL, given by the named function, to an INTEGER.
+// It fails the evaluation when the number is beyond the range of an INTEGER.
+func wholeInt(name string, f float64) int64 {
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		failEvaluation("%s(%v) overflows an INTEGER", name, f)
+	}
+	return int64(f)
+}
+
+// wholeFunction gives the INTEGER of a function rounding a number to a whole number.
+func wholeFunction(name string, fn func(f float64) float64) func(args []*string) *string {
+	return func(args []*string) *string {
+		if _, ok := intArg(args[0]); ok {
+			return args[0]
+		}
+		f, ok := realArg(args[0])
+		if !ok {
+			return nil
+		}
+		return formatInt(wholeInt(name, fn(f)))
+	}
+}
+
+func mod(args []*string) *string {
+	return arithmeticValue{Operator: OP_MODULO}.calculate(*args[0], *args[1])
+}
+
+func coalesce(args []*string) *string {
+	for _, arg := range args {
+		if arg != nil {
+			return arg
+		}
+	}
+	return nil
+}
+
+// nullif is NULL when both values are equal, otherwise the first value.
+func nullif(args []*string) *string {
+	if args[0] != nil && args[1] != nil && *args[0] == *args[1] {
+		return nil
+	}
+	return args[0]
+}
+
+// now gets the current time, as a UTC TIMESTAMP.
+func now(_ []*string) *string {
+	s := time.Now().UTC().Format(time.RFC3339Nano)
+	return &s
+}
+
+// date gets the date of a TIMESTAMP, as YYYY-MM-DD.
+func date(args []*string) *string {
+	t, err := minisql.ParseTimestamp(*args[0])
+	if err != nil {
+		return nil
+	}
+	s := t.Format("2006-01-02")
+	return &s
+}
+
+// strftime formats a TIMESTAMP with the given format, of % directives.
+// %Y year, %m month, %d day, %H hour, %M minute, %S second, %f seconds with milliseconds, %j day of the year,
+// %w day of the week, Sunday being 0, %s seconds since 1970-01-01 and %% a percent sign.
+func strftime(args []*string) *string {
+	t, err := minisql.ParseTimestamp(*args[1])
+	if err != nil {
+		return nil
+	}
+	s, err := formatTime(*args[0], t)
+	if err != nil {
+		return nil
+	}
+	return &s
+}
+
+func formatTime(format string, t time.Time) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("format %q ends with a single %%", format)
+		}
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&sb, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&sb, "%02d", t.Month())
+		case 'd':
+			fmt.Fprintf(&sb, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&sb, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&sb, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&sb, "%02d", t.Second())
+		case 'f':
+			fmt.Fprintf(&sb, "%06.3f", float64(t.Second())+float64(t.Nanosecond()/int(time.Millisecond))/1000)
+		case 'j':
+			fmt.Fprintf(&sb, "%03d", t.YearDay())
+		case 'w':
+			fmt.Fprintf(&sb, "%d", t.Weekday())
+		case 's':
+			fmt.Fprintf(&sb, "%d", t.Unix())
+		case '%':
+			sb.WriteByte('%')
+		default:
+			return "", fmt.Errorf("%%%c is not a known directive in format %q", format[i], format)
+		}
+	}
+	return sb.String(), nil
+}
+
+// checkFormat checks a constant format of STRFTIME.
+func checkFormat(args []Value) error {
+	if !isConstant(args[0]) {
+		return nil
+	}
+	if f := args[0].Evaluate(nil); f != nil {
+		_, err := formatTime(*f, time.Time{})
+		return err
+	}
+	return nil
+}
+
+// dateUnit is a unit of time DATE_ADD adds to a TIMESTAMP,
+// with the most of them spanning the 10000 years a TIMESTAMP may be in.
+type dateUnit struct {
+	add func(t time.Time, n int64) time.Time
+	max int64
+}
+
+// timeUnits are the units of time DATE_ADD adds to a TIMESTAMP
+var timeUnits = map[string]*dateUnit{
+	"YEAR":   {func(t time.Time, n int64) time.Time { return t.AddDate(int(n), 0, 0) }, 10000},
+	"MONTH":  {func(t time.Time, n int64) time.Time { return t.AddDate(0, int(n), 0) }, 10000 * 12},
+	"DAY":    {func(t time.Time, n int64) time.Time { return t.AddDate(0, 0, int(n)) }, 10000 * 366},
+	"HOUR":   {func(t time.Time, n int64) time.Time { return addSeconds(t, n*60*60) }, 10000 * 366 * 24},
+	"MINUTE": {func(t time.Time, n int64) time.Time { return addSeconds(t, n*60) }, 10000 * 366 * 24 * 60},
+	"SECOND": {func(t time.Time, n int64) time.Time { return addSeconds(t, n) }, 10000 * 366 * 24 * 60 * 60},
+}
+
+// addSeconds adds seconds to a time, beyond the 290 years a time.Duration is limited to.
+func addSeconds(t time.Time, s int64) time.Time {
+	return time.Unix(t.Unix()+s, int64(t.Nanosecond())).In(t.Location())
+}
+
+// timeUnit gets the unit of time with the given name, ignoring case and any plural.
+func timeUnit(name string) *dateUnit {
+	return timeUnits[strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(name)), "S")]
+}
+
+// dateAdd adds a number of units of time, to a TIMESTAMP.  e.g. DATE_ADD(created, 2, 'DAY')
+func dateAdd(args []*string) *string {
+	t, err := minisql.ParseTimestamp(*args[0])
+	if err != nil {
+		return nil
+	}
+	n, ok := intArg(args[1])
+	if !ok {
+		return nil
+	}
+	u := timeUnit(*args[2])
+	if u == nil {
+		return nil
+	}
+	if n >= -u.max && n <= u.max {
+		if r := u.add(t, n); r.Year() >= 0 && r.Year() <= 9999 {
+			s := r.Format(time.RFC3339Nano)
+			return &s
+		}
+	}
+	failEvaluation("DATE_ADD('%s', %d, '%s') overflows a TIMESTAMP", *args[0], n, *args[2])
+	return nil
+}
+
+// checkUnit checks a constant unit of DATE_ADD.
+func checkUnit(args []Value) error {
+	if !isConstant(args[2]) {
+		return nil
+	}
+	if u := args[2].Evaluate(nil); u != nil && timeUnit(*u) == nil {
+		return fmt.Errorf("%q is not a unit of time, expected YEAR, MONTH, DAY, HOUR, MINUTE or SECOND", *u)
+	}
+	return nil
+}
+
+// castType is the type named following the AS of a CAST.
+func castType(args []Value) minisql.ColumnType {
+	return minisql.ColumnType(*args[1].Evaluate(nil))
+}
+
+// cast converts a value to the type, or NULL when it is not a valid value of the type.
+// A REAL cast as an INTEGER is truncated.
+func cast(args []*string) *string {
+	s, err := castValue(*args[0], minisql.ColumnType(*args[1]))
+	if err != nil {
+		return nil
+	}
+	return &s
+}
+
+func castValue(v string, ct minisql.ColumnType) (string, error) {
+	if ct == minisql.INTEGER {
+		if _, ok := intArg(&v); !ok {
+			if f, ok := realArg(&v); ok {
+				return strconv.FormatInt(wholeInt("CAST", math.Trunc(f)), 10), nil
+			}
+		}
+	}
+	return ct.Convert(v)
+}
+
+// checkCast checks a constant value can be cast as the type.
+func checkCast(args []Value) error {
+	if !isConstant(args[0]) {
+		return nil
+	}
+	if v := args[0].Evaluate(nil); v != nil {
+		_, err := castValue(*v, castType(args))
+		return err
+	}
+	return nil
+}
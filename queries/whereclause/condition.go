@@ -2,6 +2,7 @@ package whereclause
 
 import (
 	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
 	"eurozulu/miniSQL/stringutil"
 	"fmt"
 	"strings"
@@ -13,11 +14,19 @@ type condition struct {
 	Column   string
 	Operator Operator
 	Value    *string
+	// Parameter, when not zero, is the number of the parameter whose value the condition compares, in place of Value.
+	Parameter int
+	// Type is the type of the column, used to compare its values. Conditions without a type compare as TEXT.
+	Type minisql.ColumnType
+	// Compat compares NULL values with the legacy rules, where NULL equals NULL and is less than every other value.
+	Compat bool
 }
 
 func (c condition) String() string {
 	v := NULL
-	if c.Value != nil {
+	if c.Parameter > 0 {
+		v = fmt.Sprintf("$%d", c.Parameter)
+	} else if c.Value != nil {
 		v = *c.Value
 	}
 	return fmt.Sprintf("%s %s %s", c.Column, c.Operator, v)
@@ -28,35 +37,296 @@ func (c condition) ColumnNames() []string {
 }
 
 func (c condition) Compare(values minisql.Values) bool {
+	return c.evaluate(values) == truthTrue
+}
+
+func (c condition) evaluate(values minisql.Values) truth {
 	v, ok := values[c.Column]
+	if c.Compat {
+		return truthOf(ok && c.Operator.compareLegacy(c.Type, v, c.Value))
+	}
+	return c.Operator.evaluate(c.Type, v, c.Value)
+}
+
+func (c condition) withTypes(types columnTypes) Expression {
+	c.Type = types(c.Column)
+	return &c
+}
+
+func (c condition) withParameters(params []*string) Expression {
+	if c.Parameter > 0 {
+		c.Value = params[c.Parameter-1]
+		c.Parameter = 0
+	}
+	return &c
+}
+
+func (c condition) withCompat() Expression {
+	c.Compat = true
+	return &c
+}
+
+// comparison compares two values with an operator, where either value may be a column, or an expression of columns.
+// e.g. updated > created  or  qty * price > 100
+type comparison struct {
+	Left     Value
+	Operator Operator
+	Right    Value
+	// Compat compares NULL values with the legacy rules, where NULL equals NULL and is less than every other value.
+	Compat bool
+}
+
+func (c comparison) String() string {
+	return fmt.Sprintf("%s %s %s", c.Left, c.Operator, c.Right)
+}
+
+func (c comparison) ColumnNames() []string {
+	return stringutil.UniqueStrings(append(c.Left.ColumnNames(), c.Right.ColumnNames()...))
+}
+
+func (c comparison) Compare(values minisql.Values) bool {
+	return c.evaluate(values) == truthTrue
+}
+
+func (c comparison) evaluate(values minisql.Values) truth {
+	// values are compared by the type of the first, unless it has no type
+	ct := c.Left.Type()
+	if ct == "" {
+		ct = c.Right.Type()
+	}
+	v1, v2 := c.Left.Evaluate(values), c.Right.Evaluate(values)
+	if c.Compat {
+		return truthOf(c.Operator.compareLegacy(ct, v1, v2))
+	}
+	return c.Operator.evaluate(ct, v1, v2)
+}
+
+// withTypes binds the types of both values.  A column compared with a literal word becomes a condition of the column.
+func (c comparison) withTypes(types columnTypes) Expression {
+	c.Left = valueWithTypes(c.Left, types)
+	c.Right = valueWithTypes(c.Right, types)
+	if cond, ok := c.condition(); ok {
+		cond.Type = types(cond.Column)
+		return cond
+	}
+	return &c
+}
+
+func (c comparison) withParameters(params []*string) Expression {
+	c.Left = valueWithParameters(c.Left, params)
+	c.Right = valueWithParameters(c.Right, params)
+	return &c
+}
+
+func (c comparison) withCompat() Expression {
+	c.Compat = true
+	return &c
+}
+
+// condition gets the comparison as a condition, when it compares a column with a literal value or a parameter.
+func (c comparison) condition() (*condition, bool) {
+	col, ok := c.Left.(*columnValue)
+	if !ok || col.Word {
+		return nil, false
+	}
+	lit, ok := c.Right.(*literalValue)
 	if !ok {
-		return false
+		return nil, false
 	}
-	return c.Operator.Compare(v, c.Value)
+	return &condition{
+		Column:    col.Name,
+		Operator:  c.Operator,
+		Value:     lit.Value,
+		Parameter: lit.Parameter,
+		Compat:    c.Compat,
+	}, true
 }
 
+// ParseCondition parses the first condition in the given string, returning the condition and any string following it.
 func ParseCondition(q string) (*condition, string, error) {
-	col, op, rest := SplitOperator(q)
+	ts, err := lexer.NewTokenStream(q)
+	if err != nil {
+		return nil, q, err
+	}
+	c, err := readCondition(ts)
+	if err != nil {
+		return nil, q, err
+	}
+	return c, ts.Rest(), nil
+}
+
+// readComparison reads two values, compared with an Operator, from the given tokens.
+// A column compared with a literal value or a parameter is read as a condition.
+func readComparison(ts *lexer.TokenStream) (Expression, error) {
+	t := ts.Peek()
+	if !isValueStart(t) {
+		if readOperator(ts) != OP_UNKNOWN {
+			return nil, ts.Errorf(t, "missing condition column name before '%s'", t.Text)
+		}
+		return nil, ts.Errorf(t, "expected condition column name, found %s", t)
+	}
+	left, err := ReadValueExpression(ts)
+	if err != nil {
+		return nil, err
+	}
+	// a single name, before the operator, is always a column
+	if col, ok := left.(*columnValue); ok {
+		col.Word = false
+	}
+
+	t = ts.Peek()
+	op := readOperator(ts)
 	if op == OP_UNKNOWN {
-		return nil, q, fmt.Errorf("no Operator found in condition %q", q)
+		return nil, ts.Errorf(t, "no Operator found in condition after %q, found %s", left.String(), t)
+	}
+	t = ts.Peek()
+	if !isValueStart(t) {
+		return nil, ts.Errorf(t, "missing condition value after '%s %s'  use 'NULL' to compare to empty value", left, op)
+	}
+	right, err := ReadValueExpression(ts)
+	if err != nil {
+		return nil, err
 	}
-	if col == "" {
-		return nil, q, fmt.Errorf("missing condition column name before '%s %s'", op, rest)
+	c := &comparison{Left: left, Operator: op, Right: right}
+	if cond, ok := c.condition(); ok {
+		return cond, nil
 	}
+	return c, nil
+}
 
-	vals := stringutil.SplitIgnoreQuoted(rest, " ")
-	val := stringutil.Unquote(vals[0])
-	if val == "" {
-		return nil, q, fmt.Errorf("missing condition value after '%s %s'  use 'NULL' to compare to empty value", col, op)
+// readCondition reads a condition, a column name, an Operator and a value, from the given tokens.
+func readCondition(ts *lexer.TokenStream) (*condition, error) {
+	t := ts.Peek()
+	if !t.IsName() {
+		if readOperator(ts) != OP_UNKNOWN {
+			return nil, ts.Errorf(t, "missing condition column name before '%s'", t.Text)
+		}
+		return nil, ts.Errorf(t, "expected condition column name, found %s", t)
 	}
-	var vp *string
-	if !strings.EqualFold(vals[0], NULL) {
-		vp = &val
+	col, err := ReadColumnName(ts, "condition column name")
+	if err != nil {
+		return nil, err
+	}
+
+	t = ts.Peek()
+	op := readOperator(ts)
+	if op == OP_UNKNOWN {
+		return nil, ts.Errorf(t, "no Operator found in condition after %q, found %s", col, t)
+	}
+	t = ts.Peek()
+	if !IsValue(t) && t.Type != lexer.PARAMETER {
+		return nil, ts.Errorf(t, "missing condition value after '%s %s'  use 'NULL' to compare to empty value", col, op)
+	}
+	vp, param, err := ReadValueOrParameter(ts)
+	if err != nil {
+		return nil, err
 	}
-	rest = strings.Join(vals[1:], " ")
 	return &condition{
-		Column:   col,
-		Operator: op,
-		Value:    vp,
-	}, rest, nil
+		Column:    col,
+		Operator:  op,
+		Value:     vp,
+		Parameter: param,
+	}, nil
+}
+
+// The aggregate functions, read as the names of the aggregated columns.
+const (
+	COUNT = "COUNT"
+	SUM   = "SUM"
+	AVG   = "AVG"
+	MIN   = "MIN"
+	MAX   = "MAX"
+)
+
+// IsAggregate checks if the given name is the name of an aggregate function.  Names are not case sensitive.
+func IsAggregate(name string) bool {
+	switch strings.ToUpper(name) {
+	case COUNT, SUM, AVG, MIN, MAX:
+		return true
+	}
+	return false
+}
+
+// ReadColumnName reads a column name, optionally qualified with its table name, from the tokens.
+// A name followed by brackets is an aggregate function of a column, e.g. COUNT(*), SUM(price) or COUNT(DISTINCT name),
+// which is read as a single name in a standard form, with the function name in upper case.
+// Any other name followed by brackets, which is not a registered function, is an unknown function.
+func ReadColumnName(ts *lexer.TokenStream, what string) (string, error) {
+	t := ts.Peek()
+	name, err := ts.ExpectQualifiedName(what)
+	if err != nil {
+		return "", err
+	}
+	if !ts.AcceptSymbol("(") {
+		return name, nil
+	}
+	if !IsAggregate(name) {
+		return "", ts.Errorf(t, "unknown function %s", name)
+	}
+	fn := strings.ToUpper(name)
+	arg := "*"
+	if !ts.AcceptSymbol("*") {
+		distinct := ts.AcceptKeyword("DISTINCT")
+		if arg, err = ts.ExpectQualifiedName(fmt.Sprintf("column name in %s", fn)); err != nil {
+			return "", err
+		}
+		if distinct {
+			arg = "DISTINCT " + arg
+		}
+	}
+	if err = ts.ExpectSymbol(")"); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s(%s)", fn, arg), nil
+}
+
+// IsValue checks if the given token can begin a literal value.
+func IsValue(t lexer.Token) bool {
+	switch t.Type {
+	case lexer.STRING, lexer.NUMBER, lexer.IDENT:
+		return true
+	case lexer.SYMBOL:
+		return t.IsSymbol("-", "+")
+	default:
+		return false
+	}
+}
+
+// ReadValue reads a single literal value from the tokens.
+// Values may be quoted strings, numbers or unquoted words.  The unquoted NULL keyword results in a nil value.
+func ReadValue(ts *lexer.TokenStream) (*string, error) {
+	t := ts.Peek()
+	if !IsValue(t) {
+		return nil, ts.Errorf(t, "expected a value, found %s", t)
+	}
+	ts.Next()
+	v := t.Text
+	switch {
+	case t.IsKeyword(NULL):
+		return nil, nil
+	case t.Type == lexer.SYMBOL:
+		// signed number
+		n := ts.Peek()
+		if n.Type != lexer.NUMBER || n.Offset != t.End {
+			return nil, ts.Errorf(n, "expected a number after '%s', found %s", t.Text, n)
+		}
+		ts.Next()
+		if t.Text == "-" {
+			v = t.Text + n.Text
+		} else {
+			v = n.Text
+		}
+	}
+	return &v, nil
+}
+
+// ReadValueOrParameter reads a literal value, or a parameter placeholder, from the tokens.
+// For a placeholder, the value is nil and the number of the parameter, from 1, is returned.  For a literal, the number is zero.
+func ReadValueOrParameter(ts *lexer.TokenStream) (*string, int, error) {
+	if ts.Peek().Type != lexer.PARAMETER {
+		v, err := ReadValue(ts)
+		return v, 0, err
+	}
+	n, err := ts.ExpectParameter()
+	return nil, n, err
 }
@@ -156,15 +156,20 @@ func TestCondition_Compare(t *testing.T) {
 		t.Fatalf("unexpected exception for condition  %v", err)
 	}
 	expected = minisql.Values{"A": nil, "B": nil}
-	if !c.Compare(expected) {
-		t.Fatalf("unexpected false when comapring with = NULL")
+	if c.Compare(expected) {
+		t.Fatalf("unexpected true when comapring NULL with = NULL")
 	}
 	c, _, err = whereclause.ParseCondition("A != NULL")
 	if err != nil {
 		t.Fatalf("unexpected exception for condition  %v", err)
 	}
 	if c.Compare(expected) {
-		t.Fatalf("unexpected true when comapring with != NuLL")
+		t.Fatalf("unexpected true when comapring NULL with != NuLL")
+	}
+	b := "B"
+	expected["A"] = &b
+	if c.Compare(expected) {
+		t.Fatalf("unexpected true when comapring a value with != NuLL")
 	}
 
 	c, _, err = whereclause.ParseCondition("C != 1")
@@ -175,8 +180,8 @@ func TestCondition_Compare(t *testing.T) {
 		t.Fatalf("unexpected true when comapring with missing value")
 	}
 	expected["C"] = nil
-	if !c.Compare(expected) {
-		t.Fatalf("unexpected false when comapring != 1 with null value")
+	if c.Compare(expected) {
+		t.Fatalf("unexpected true when comapring != 1 with null value")
 	}
 	a := "A"
 	expected["C"] = &a
@@ -189,3 +194,34 @@ func TestCondition_Compare(t *testing.T) {
 	}
 
 }
+
+func TestCondition_Parameter(t *testing.T) {
+	c, _, err := whereclause.ParseCondition("a = $2")
+	if err != nil {
+		t.Fatalf("unexpected exception for condition  %v", err)
+	}
+	if c.Parameter != 2 || c.Value != nil {
+		t.Fatalf("expected parameter 2 without a value, found %d %v", c.Parameter, c.Value)
+	}
+
+	ex, err := whereclause.ParseExpression("a = ? AND NOT (b > ? OR c = ?)")
+	if err != nil {
+		t.Fatalf("unexpected exception for expression  %v", err)
+	}
+	one, two, three := "1", "2", "3"
+	bound := whereclause.WithParameters(ex, []*string{&one, &two, &two})
+	if !bound.Compare(minisql.Values{"a": &one, "b": &two, "c": &three}) {
+		t.Fatalf("unexpected false compare with bound parameters")
+	}
+	if bound.Compare(minisql.Values{"a": &one, "b": &three, "c": &three}) {
+		t.Fatalf("unexpected true compare with bound parameters")
+	}
+	if bound.Compare(minisql.Values{"a": &one, "b": &two, "c": nil}) {
+		t.Fatalf("unexpected true compare with NULL value")
+	}
+	// binding leaves the expression unchanged, so it may be bound again
+	bound = whereclause.WithParameters(ex, []*string{&two, &two, &two})
+	if bound.Compare(minisql.Values{"a": &one, "b": &two, "c": &three}) {
+		t.Fatalf("unexpected true compare with parameters bound again")
+	}
+}
@@ -2,11 +2,16 @@ package whereclause
 
 import (
 	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
 	"eurozulu/miniSQL/stringutil"
 	"fmt"
 	"strings"
 )
 
+func init() {
+	minisql.RegisterPredicateParser(parsePredicate)
+}
+
 const (
 	AND = "AND"
 	OR  = "OR"
@@ -30,6 +35,48 @@ type OperatorExpression interface {
 	SetExpression(ex Expression)
 }
 
+// typedExpression is implemented by expressions which compare values according to the types of their columns.
+type typedExpression interface {
+	// withTypes returns a copy of the expression, which compares its values using the given column types.
+	withTypes(types columnTypes) Expression
+}
+
+// columnTypes resolves the type of the named column.  Names which are not a column have no type.
+type columnTypes func(column string) minisql.ColumnType
+
+// withTypes binds the given column types to the expression, if it compares typed values.
+func withTypes(ex Expression, types columnTypes) Expression {
+	te, ok := ex.(typedExpression)
+	if !ok {
+		return ex
+	}
+	return te.withTypes(types)
+}
+
+// WithTypes binds the given column types to the expression, so it compares the values of those columns by their type.
+// Columns without a type are compared as TEXT, and unquoted words which are not one of the columns are literal words.
+func WithTypes(ex Expression, types map[string]minisql.ColumnType) Expression {
+	return withTypes(ex, func(column string) minisql.ColumnType {
+		return types[column]
+	})
+}
+
+// parameterisedExpression is implemented by expressions which may compare the values of parameters.
+type parameterisedExpression interface {
+	// withParameters returns a copy of the expression, with its parameter placeholders replaced by the given values.
+	withParameters(params []*string) Expression
+}
+
+// WithParameters binds the given values to the parameter placeholders of the expression, the first value to parameter 1.
+// There must be a value for every parameter in the expression.
+func WithParameters(ex Expression, params []*string) Expression {
+	pe, ok := ex.(parameterisedExpression)
+	if !ok {
+		return ex
+	}
+	return pe.withParameters(params)
+}
+
 // NotExpression inverts the outcome of another expression.
 type NotExpression struct {
 	expression Expression
@@ -44,7 +91,23 @@ func (oe NotExpression) ColumnNames() []string {
 }
 
 func (oe NotExpression) Compare(values minisql.Values) bool {
-	return !oe.expression.Compare(values)
+	return oe.evaluate(values) == truthTrue
+}
+
+func (oe NotExpression) evaluate(values minisql.Values) truth {
+	return evaluate(oe.expression, values).not()
+}
+
+func (oe NotExpression) withTypes(types columnTypes) Expression {
+	return &NotExpression{expression: withTypes(oe.expression, types)}
+}
+
+func (oe NotExpression) withParameters(params []*string) Expression {
+	return &NotExpression{expression: WithParameters(oe.expression, params)}
+}
+
+func (oe NotExpression) withCompat() Expression {
+	return &NotExpression{expression: withCompat(oe.expression)}
 }
 
 // AndExpression performs an operation on two expressions, resulting in an AND of both results
@@ -62,7 +125,27 @@ func (oe AndExpression) ColumnNames() []string {
 }
 
 func (oe AndExpression) Compare(values minisql.Values) bool {
-	return oe.operand.Compare(values) && oe.expression.Compare(values)
+	return oe.evaluate(values) == truthTrue
+}
+
+func (oe AndExpression) evaluate(values minisql.Values) truth {
+	t := evaluate(oe.operand, values)
+	if t == truthFalse {
+		return t
+	}
+	return t.and(evaluate(oe.expression, values))
+}
+
+func (oe AndExpression) withTypes(types columnTypes) Expression {
+	return &AndExpression{operand: withTypes(oe.operand, types), expression: withTypes(oe.expression, types)}
+}
+
+func (oe AndExpression) withParameters(params []*string) Expression {
+	return &AndExpression{operand: WithParameters(oe.operand, params), expression: WithParameters(oe.expression, params)}
+}
+
+func (oe AndExpression) withCompat() Expression {
+	return &AndExpression{operand: withCompat(oe.operand), expression: withCompat(oe.expression)}
 }
 
 // OrExpression performs an operation on two expressions, resulting in an OR of both results
@@ -80,7 +163,27 @@ func (oe OrExpression) ColumnNames() []string {
 }
 
 func (oe OrExpression) Compare(values minisql.Values) bool {
-	return oe.operand.Compare(values) || oe.expression.Compare(values)
+	return oe.evaluate(values) == truthTrue
+}
+
+func (oe OrExpression) evaluate(values minisql.Values) truth {
+	t := evaluate(oe.operand, values)
+	if t == truthTrue {
+		return t
+	}
+	return t.or(evaluate(oe.expression, values))
+}
+
+func (oe OrExpression) withTypes(types columnTypes) Expression {
+	return &OrExpression{operand: withTypes(oe.operand, types), expression: withTypes(oe.expression, types)}
+}
+
+func (oe OrExpression) withParameters(params []*string) Expression {
+	return &OrExpression{operand: WithParameters(oe.operand, params), expression: WithParameters(oe.expression, params)}
+}
+
+func (oe OrExpression) withCompat() Expression {
+	return &OrExpression{operand: withCompat(oe.operand), expression: withCompat(oe.expression)}
 }
 
 func NewOperatorExpression(s string, operand Expression) OperatorExpression {
@@ -101,68 +204,206 @@ func NewNOTOperatorExpression(s string) OperatorExpression {
 	return nil
 }
 
-// parseNextExpression attempts to parse the first expression from the given string.
+// readNextExpression reads the first expression from the given tokens.
 // The first expression will be a condition or a bracketed expression
 // If expression is preceded with the NOT Operator, the expression will be returned wrapped in a NOT OperatorExpression
-// any string remaining after the expression is returned along with the parsed expression.
-func parseNextExpression(s string) (Expression, string, error) {
-	// bracketed string, treat its contents as a single expression
-	if strings.HasPrefix(s, "(") {
-		bs, rest := stringutil.BracketedString(s)
-		ex, err := ParseExpression(bs)
-		if err != nil {
-			return nil, rest, err
-		}
-		return ex, rest, nil
+func readNextExpression(ts *lexer.TokenStream, compat bool) (Expression, error) {
+	// bracketed expression, treat its contents as a single expression
+	if ts.Peek().IsSymbol("(") {
+		return readBracketed(ts, compat)
 	}
 
 	// check if it's a NOT op:
-	cmd, rest := stringutil.FirstWord(s)
-	not := NewNOTOperatorExpression(cmd)
-	if not != nil {
+	if ts.Peek().IsKeyword(NOT) {
+		not := NewNOTOperatorExpression(ts.Next().Text)
 		// parse following as an expression (may be complex, bracketed expression)
-		ex, r, err := parseNextExpression(rest)
+		ex, err := readNextExpression(ts, compat)
 		if err != nil {
-			return nil, rest, err
+			return nil, err
 		}
 		not.SetExpression(ex)
-		return not, r, nil
+		return not, nil
 	}
 
-	// not a bracket or NOT, treat as a condition <key=value>
-	var c Expression
-	c, rest, err := ParseCondition(s)
+	// not a bracket or NOT, treat as a condition <key=value>, or IS NULL, IN or BETWEEN
+	return readValueCondition(ts)
+}
+
+// readBracketed reads a bracketed expression, or a comparison beginning with a bracketed value.
+// e.g. (a = 1 OR b = 2) AND c = 3  or  (a + b) * 2 > 10
+// When neither can be read, the error found furthest into the tokens is returned.
+func readBracketed(ts *lexer.TokenStream, compat bool) (Expression, error) {
+	mark := ts.Mark()
+	ts.Next()
+	ex, err := readExpression(ts, compat)
+	if err == nil {
+		err = ts.ExpectSymbol(")")
+	}
+	if err == nil && !ts.Peek().IsSymbol(OP_ADD, OP_SUBTRACT, OP_MULTIPLY, OP_DIVIDE, OP_MODULO, OP_CONCAT) &&
+		readOperator(ts) == OP_UNKNOWN {
+		return ex, nil
+	}
+	exErr, exEnd := err, ts.Mark()
+	ts.Reset(mark)
+	cex, err := readValueCondition(ts)
+	if err != nil && exErr != nil && exEnd > ts.Mark() {
+		return nil, exErr
+	}
+	return cex, err
+}
+
+// readValueCondition reads a condition of a value, either an operator comparison, or an IS [NOT] NULL,
+// [NOT] IN or [NOT] BETWEEN condition.  The value may be a column, or any value expression.  e.g. id + 1 BETWEEN 1 AND 3
+func readValueCondition(ts *lexer.TokenStream) (Expression, error) {
+	mark := ts.Mark()
+	if isValueStart(ts.Peek()) {
+		if v, err := ReadValueExpression(ts); err == nil {
+			// a single name, before the predicate, is always a column
+			if col, ok := v.(*columnValue); ok {
+				col.Word = false
+			}
+			ex, ok, err := readPredicate(ts, v)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return ex, nil
+			}
+		}
+		ts.Reset(mark)
+	}
+	return readComparison(ts)
+}
+
+// ReadExpression reads an Expression from the given tokens.
+// Reading stops at the first token following an expression, which is not an AND or OR.
+// NOT is applied before AND, and AND before OR, and any comparison with NULL is unknown, matching no rows.
+// When compat is set, the expression follows the legacy rules of earlier versions, AND and OR are read strictly
+// from left to right, NULL equals NULL, and NULL is less than every other value.
+func ReadExpression(ts *lexer.TokenStream, compat bool) (Expression, error) {
+	ex, err := readExpression(ts, compat)
 	if err != nil {
-		return nil, rest, err
+		return nil, err
 	}
-	return c, rest, nil
+	if compat {
+		ex = withCompat(ex)
+	}
+	return ex, nil
 }
 
-// ParseExpression the given string into an Expression.
-func ParseExpression(s string) (Expression, error) {
+func readExpression(ts *lexer.TokenStream, compat bool) (Expression, error) {
+	if compat {
+		return readLeftToRight(ts)
+	}
+	return readOr(ts)
+}
+
+// readOr reads one or more AND expressions, delimited with OR.
+func readOr(ts *lexer.TokenStream) (Expression, error) {
+	ex, err := readAnd(ts)
+	if err != nil {
+		return nil, err
+	}
+	for ts.Peek().IsKeyword(OR) {
+		op := NewOperatorExpression(ts.Next().Text, ex)
+		e, err := readAnd(ts)
+		if err != nil {
+			return nil, err
+		}
+		op.SetExpression(e)
+		ex = op
+	}
+	return ex, nil
+}
+
+// readAnd reads one or more expressions, delimited with AND.
+func readAnd(ts *lexer.TokenStream) (Expression, error) {
+	ex, err := readNextExpression(ts, false)
+	if err != nil {
+		return nil, err
+	}
+	for ts.Peek().IsKeyword(AND) {
+		op := NewOperatorExpression(ts.Next().Text, ex)
+		e, err := readNextExpression(ts, false)
+		if err != nil {
+			return nil, err
+		}
+		op.SetExpression(e)
+		ex = op
+	}
+	return ex, nil
+}
+
+// readLeftToRight reads expressions delimited with AND or OR, each operator taking all the expressions before it
+// as its operand, as the legacy versions did.  e.g. a=1 OR b=2 AND c=3 is read as (a=1 OR b=2) AND c=3
+func readLeftToRight(ts *lexer.TokenStream) (Expression, error) {
 	// must have at least one expression
-	ex, rest, err := parseNextExpression(s)
+	ex, err := readNextExpression(ts, true)
 	if err != nil {
 		return nil, err
 	}
-	rest = strings.TrimSpace(rest)
 	// further expressions must be delimited with operators OR or AND
-	for len(rest) > 0 {
+	for ts.Peek().IsKeyword(AND, OR) {
 		// parse joining Operator (AND/OR), using previously parsed expression as its operand
-		cmd, r := stringutil.FirstWord(rest)
-		op := NewOperatorExpression(cmd, ex)
-		if op == nil {
-			return nil, fmt.Errorf("unexpected %q after expression. Expected 'OR' or 'AND'", cmd)
-		}
+		op := NewOperatorExpression(ts.Next().Text, ex)
 
 		// parse the following expression to add to the Operator
-		e, er, err := parseNextExpression(r)
+		e, err := readNextExpression(ts, true)
 		if err != nil {
 			return nil, err
 		}
 		op.SetExpression(e)
 		ex = op
-		rest = strings.TrimSpace(er)
 	}
 	return ex, nil
 }
+
+// ParseExpression the given string into an Expression.
+func ParseExpression(s string) (Expression, error) {
+	return parseExpression(s, false)
+}
+
+// ParseCompatExpression parses the given string into an Expression, following the legacy rules of earlier versions.
+func ParseCompatExpression(s string) (Expression, error) {
+	return parseExpression(s, true)
+}
+
+func parseExpression(s string, compat bool) (Expression, error) {
+	ts, err := lexer.NewTokenStream(s)
+	if err != nil {
+		return nil, err
+	}
+	ex, err := ReadExpression(ts, compat)
+	if err != nil {
+		return nil, err
+	}
+	if !ts.AtEnd() {
+		return nil, ts.Errorf(ts.Peek(), "unexpected %s after expression. Expected 'OR' or 'AND'", ts.Peek())
+	}
+	return ex, nil
+}
+
+// parsePredicate parses a condition, such as the CHECK constraint of a column, comparing its values by the given types.
+func parsePredicate(condition string, types func(column string) minisql.ColumnType) (minisql.Predicate, error) {
+	ex, err := ParseExpression(condition)
+	if err != nil {
+		return nil, err
+	}
+	if ts, _ := lexer.NewTokenStream(condition); ts.Parameters() > 0 {
+		return nil, fmt.Errorf("parameters can not be used in %q", condition)
+	}
+	ex = withTypes(ex, types)
+	if err = checkTypes(ex); err != nil {
+		return nil, err
+	}
+	return predicate{ex}, nil
+}
+
+// predicate is an expression used as the CHECK constraint of a column.
+type predicate struct {
+	Expression
+}
+
+func (p predicate) Compare(values minisql.Values) (bool, error) {
+	return Compare(p.Expression, values)
+}
@@ -0,0 +1,331 @@
+package whereclause
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/stringutil"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// CAST is the function converting a value to a type, read with the type following AS.  e.g. CAST(price AS INTEGER)
+const CAST = "CAST"
+
+// A Function is a scalar function, giving a single value from the values of its arguments.  e.g. UPPER(name)
+type Function struct {
+	Name string
+	// MinArgs and MaxArgs are the fewest and the most arguments the function is called with.  A negative MaxArgs has no limit.
+	MinArgs, MaxArgs int
+	// Args are the types each argument is read as.  Arguments following the last type are read as the last type.
+	// TEXT arguments may be any value.
+	Args []minisql.ColumnType
+	// Result gets the type of the functions value, from its arguments.
+	Result func(args []Value) minisql.ColumnType
+	// Call gets the value of the function from the values of its arguments.
+	// Unless NullArgs is set, the value is NULL when any argument is NULL, without calling the function.
+	Call func(args []*string) *string
+	// NullArgs is set on functions which are called with NULL arguments.  e.g. COALESCE
+	NullArgs bool
+	// Check, when not nil, checks the arguments when the function is parsed, once their number and types are checked.
+	Check func(args []Value) error
+}
+
+// argType gets the type the argument at the given index is read as.
+func (f Function) argType(i int) minisql.ColumnType {
+	if len(f.Args) == 0 {
+		return minisql.TEXT
+	}
+	if i >= len(f.Args) {
+		i = len(f.Args) - 1
+	}
+	return f.Args[i]
+}
+
+// checkArgs checks the number of the arguments, and the type of any whose type is known before they are bound to a table.
+// Constant arguments which can not be evaluated are an error.
+func (f Function) checkArgs(args []Value) (err error) {
+	defer recoverEvaluation(&err)
+	if len(args) < f.MinArgs || (f.MaxArgs >= 0 && len(args) > f.MaxArgs) {
+		return fmt.Errorf("%s expects %s, found %d", f.Name, f.arity(), len(args))
+	}
+	for i, arg := range args {
+		if err := checkArg(f.argType(i), arg); err != nil {
+			return fmt.Errorf("argument %d of %s, %w", i+1, f.Name, err)
+		}
+	}
+	if f.Check != nil {
+		return f.Check(args)
+	}
+	return nil
+}
+
+func (f Function) arity() string {
+	switch {
+	case f.MaxArgs < 0:
+		return fmt.Sprintf("at least %s", plural(f.MinArgs, "argument"))
+	case f.MinArgs == f.MaxArgs:
+		return plural(f.MinArgs, "argument")
+	default:
+		return fmt.Sprintf("%d to %s", f.MinArgs, plural(f.MaxArgs, "argument"))
+	}
+}
+
+func plural(n int, s string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, s)
+	}
+	return fmt.Sprintf("%d %ss", n, s)
+}
+
+// checkArg checks the argument can be read as the given type, by its type, when that is known, and by its value when it is constant.
+func checkArg(ct minisql.ColumnType, arg Value) error {
+	if ct == minisql.TEXT {
+		return nil
+	}
+	found := arg.Type()
+	if found != "" && found != minisql.TEXT && found != ct && !(isNumeric(found) && isNumeric(ct)) {
+		return fmt.Errorf("%s is a %s, not a %s", arg, found, ct)
+	}
+	if !isConstant(arg) {
+		return nil
+	}
+	if v := arg.Evaluate(nil); v != nil {
+		_, err := ct.Convert(*v)
+		return err
+	}
+	return nil
+}
+
+// checkBoundArg checks the argument can be read as the given type, once it is bound to the types of its columns.
+// A TEXT column is never read as a number, whatever its values.
+func checkBoundArg(ct minisql.ColumnType, arg Value) error {
+	if err := checkArg(ct, arg); err != nil {
+		return err
+	}
+	if c, ok := arg.(*columnValue); ok && isNumeric(ct) && c.ColumnType == minisql.TEXT {
+		return fmt.Errorf("%s is a %s, not a %s", arg, c.ColumnType, ct)
+	}
+	return nil
+}
+
+// CheckTypes checks the arguments of the functions in the expression, once it is bound to the types of its columns,
+// can be read as the types the functions expect.  e.g. ABS(name), of a TEXT column, is an error.
+func CheckTypes(ex Expression) error {
+	return checkTypes(ex)
+}
+
+// CheckValueTypes checks the arguments of the functions in the value, once it is bound, as CheckTypes.
+func CheckValueTypes(v Value) error {
+	return checkTypes(v)
+}
+
+// CheckWhereTypes checks the arguments of the functions in the where clause, bound to the types of the given table, as CheckTypes.
+func CheckWhereTypes(w WhereClause, t minisql.Table) error {
+	wc, ok := w.(*whereClause)
+	if !ok || !wc.HasExpression() {
+		return nil
+	}
+	return checkTypes(withTypes(wc.expression, tableTypes(t)))
+}
+
+// checkTypes checks the arguments of every function in the given expression, or value.
+func checkTypes(x interface{}) (err error) {
+	defer recoverEvaluation(&err)
+	var parts []interface{}
+	switch e := x.(type) {
+	case *functionValue:
+		for i, arg := range e.Args {
+			if err := checkTypes(arg); err != nil {
+				return err
+			}
+			if err := checkBoundArg(e.Function.argType(i), arg); err != nil {
+				return fmt.Errorf("argument %d of %s, %w", i+1, e.Function.Name, err)
+			}
+		}
+	case *negativeValue:
+		parts = append(parts, e.Operand)
+	case *arithmeticValue:
+		parts = append(parts, e.Left, e.Right)
+	case *comparison:
+		parts = append(parts, e.Left, e.Right)
+	case *valueBetween:
+		parts = append(parts, e.Value, e.Low, e.High)
+	case *valueIn:
+		parts = append(parts, e.Value)
+		for _, v := range e.List {
+			parts = append(parts, v)
+		}
+	case *valueNull:
+		parts = append(parts, e.Value)
+	case *NotExpression:
+		parts = append(parts, e.expression)
+	case *AndExpression:
+		parts = append(parts, e.operand, e.expression)
+	case *OrExpression:
+		parts = append(parts, e.operand, e.expression)
+	}
+	for _, p := range parts {
+		if err := checkTypes(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNumeric(ct minisql.ColumnType) bool {
+	return ct == minisql.INTEGER || ct == minisql.REAL
+}
+
+// isConstant checks if the value is the same for every row, having no columns or parameters.
+func isConstant(v Value) bool {
+	switch cv := v.(type) {
+	case *literalValue:
+		return cv.Parameter == 0
+	case *negativeValue:
+		return isConstant(cv.Operand)
+	case *arithmeticValue:
+		return isConstant(cv.Left) && isConstant(cv.Right)
+	case *functionValue:
+		for _, arg := range cv.Args {
+			if !isConstant(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+var functions = map[string]*Function{}
+var functionsLock sync.RWMutex
+
+// RegisterFunction adds the given function to the functions which may be called in values, replacing any of the same name.
+// Function names are not case sensitive.
+func RegisterFunction(f *Function) {
+	functionsLock.Lock()
+	defer functionsLock.Unlock()
+	functions[strings.ToUpper(f.Name)] = f
+}
+
+func lookupFunction(name string) *Function {
+	functionsLock.RLock()
+	defer functionsLock.RUnlock()
+	return functions[strings.ToUpper(name)]
+}
+
+// IsFunctionCall checks if the next tokens are a call of a registered function.
+func IsFunctionCall(ts *lexer.TokenStream) bool {
+	t := ts.Peek()
+	return t.Type == lexer.IDENT && ts.PeekAt(1).IsSymbol("(") && lookupFunction(t.Text) != nil
+}
+
+// functionValue is the value of a function, called with the values of its arguments.  e.g. SUBSTR(name, 1, 3)
+type functionValue struct {
+	Function *Function
+	Args     []Value
+}
+
+func (f functionValue) Evaluate(values minisql.Values) *string {
+	args := make([]*string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.Evaluate(values)
+		if args[i] == nil && !f.Function.NullArgs {
+			return nil
+		}
+	}
+	return f.Function.Call(args)
+}
+
+func (f functionValue) Type() minisql.ColumnType {
+	return f.Function.Result(f.Args)
+}
+
+func (f functionValue) ColumnNames() []string {
+	var names []string
+	for _, arg := range f.Args {
+		names = append(names, arg.ColumnNames()...)
+	}
+	return stringutil.UniqueStrings(names)
+}
+
+func (f functionValue) String() string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.String()
+	}
+	if f.Function.Name == CAST {
+		return fmt.Sprintf("%s(%s AS %s)", f.Function.Name, args[0], f.Type())
+	}
+	return fmt.Sprintf("%s(%s)", f.Function.Name, strings.Join(args, ", "))
+}
+
+func (f functionValue) withTypes(types columnTypes) Value {
+	args := make([]Value, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = valueWithTypes(arg, types)
+	}
+	return &functionValue{Function: f.Function, Args: args}
+}
+
+func (f functionValue) withParameters(params []*string) Value {
+	args := make([]Value, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = valueWithParameters(arg, params)
+	}
+	return &functionValue{Function: f.Function, Args: args}
+}
+
+// readFunction reads a function call, its name followed by its bracketed, comma delimited arguments.
+// The arguments of CAST are a value followed by AS and the name of a type.  e.g. CAST(price AS INTEGER)
+func readFunction(ts *lexer.TokenStream) (Value, error) {
+	t := ts.Next()
+	f := lookupFunction(t.Text)
+	if err := ts.ExpectSymbol("("); err != nil {
+		return nil, err
+	}
+	var args []Value
+	if !ts.AcceptSymbol(")") {
+		for {
+			arg, err := readConcat(ts)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if f.Name == CAST {
+				if arg, err = readCastType(ts); err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			if !ts.AcceptSymbol(",") {
+				break
+			}
+		}
+		if err := ts.ExpectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.checkArgs(args); err != nil {
+		return nil, ts.Errorf(t, "%v", err)
+	}
+	return &functionValue{Function: f, Args: args}, nil
+}
+
+// readCastType reads the AS and the type name of a CAST, as a literal of the type.
+func readCastType(ts *lexer.TokenStream) (Value, error) {
+	if err := ts.ExpectKeyword("AS"); err != nil {
+		return nil, err
+	}
+	t := ts.Peek()
+	name, err := ts.ExpectName("type name after AS")
+	if err != nil {
+		return nil, err
+	}
+	ct, err := minisql.ParseColumnType(name)
+	if err != nil {
+		return nil, ts.Errorf(t, "%v", err)
+	}
+	s := string(ct)
+	return &literalValue{Value: &s}, nil
+}
@@ -0,0 +1,250 @@
+package whereclause_test
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/whereclause"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFunction_Evaluate(t *testing.T) {
+	for _, test := range []struct {
+		value  string
+		expect string
+		ct     minisql.ColumnType
+	}{
+		{"UPPER(name)", "BOB", minisql.TEXT},
+		{"lower('AbC')", "abc", minisql.TEXT},
+		{"LENGTH(name || 'ér')", "5", minisql.INTEGER},
+		{"LENGTH(note)", "NULL", minisql.INTEGER},
+		{"SUBSTR('hello', 2)", "ello", minisql.TEXT},
+		{"SUBSTR('hello', 2, 3)", "ell", minisql.TEXT},
+		{"SUBSTR('hello', 0, 2)", "h", minisql.TEXT},
+		{"SUBSTR('hello', 9)", "", minisql.TEXT},
+		{"SUBSTR('hello', 1, -1)", "NULL", minisql.TEXT},
+		{"SUBSTR('hello', -2)", "lo", minisql.TEXT},
+		{"SUBSTR('hello', -3, 2)", "ll", minisql.TEXT},
+		{"SUBSTR('hello', -9, 5)", "h", minisql.TEXT},
+		{"SUBSTR('hello', 2, 9223372036854775807)", "ello", minisql.TEXT},
+		{"SUBSTR('hello', -9223372036854775807, 9223372036854775807)", "hello", minisql.TEXT},
+		{"SUBSTR('hello', 9223372036854775807, 9223372036854775807)", "", minisql.TEXT},
+		{"TRIM('  a b  ')", "a b", minisql.TEXT},
+		{"TRIM('xxaxx', 'x')", "a", minisql.TEXT},
+		{"REPLACE(name, 'o', '00')", "b00b", minisql.TEXT},
+		{"INSTR(name, 'b')", "1", minisql.INTEGER},
+		{"INSTR('héllo', 'l')", "3", minisql.INTEGER},
+		{"INSTR(name, 'x')", "0", minisql.INTEGER},
+		{"CONCAT(name, '-', qty, note)", "bob-4", minisql.TEXT},
+		{"ABS(-qty)", "4", minisql.INTEGER},
+		{"ABS(price - 3)", "0.5", minisql.REAL},
+		{"ROUND(2.5)", "3", minisql.REAL},
+		{"ROUND(price * 1.11, 1)", "2.8", minisql.REAL},
+		{"ROUND(qty)", "4", minisql.INTEGER},
+		{"ROUND(1234, -2)", "1200", minisql.INTEGER},
+		{"ROUND(1.005, 2)", "1.01", minisql.REAL},
+		{"ROUND(-1.005, 2)", "-1.01", minisql.REAL},
+		{"ROUND(2.675, 2)", "2.68", minisql.REAL},
+		{"ROUND(0.285, 2)", "0.29", minisql.REAL},
+		{"ROUND(9.995, 2)", "10", minisql.REAL},
+		{"ROUND(0.004, 2)", "0", minisql.REAL},
+		{"ROUND(1250, -2)", "1300", minisql.INTEGER},
+		{"ROUND(-1250, -2)", "-1300", minisql.INTEGER},
+		{"ROUND(49, -2)", "0", minisql.INTEGER},
+		{"ROUND(99, -3)", "0", minisql.INTEGER},
+		{"ROUND(1.5, 9223372036854775807)", "1.5", minisql.REAL},
+		{"ROUND(1.5, -9223372036854775807)", "0", minisql.REAL},
+		{"ROUND(1e300, -300)", "1e+300", minisql.REAL},
+		{"ROUND(5e-324, 324)", "5e-324", minisql.REAL},
+		{"FLOOR(price)", "2", minisql.INTEGER},
+		{"CEIL(price)", "3", minisql.INTEGER},
+		{"FLOOR(-price)", "-3", minisql.INTEGER},
+		{"MOD(qty, 3)", "1", minisql.INTEGER},
+		{"MOD(price, 2)", "0.5", minisql.REAL},
+		{"MOD(qty, 0)", "NULL", minisql.INTEGER},
+		{"COALESCE(note, NULL, name)", "bob", minisql.TEXT},
+		{"COALESCE(note)", "NULL", minisql.TEXT},
+		{"IFNULL(note, 'none')", "none", minisql.TEXT},
+		{"IFNULL(qty, 0)", "4", minisql.INTEGER},
+		{"NULLIF(qty, 4)", "NULL", minisql.INTEGER},
+		{"NULLIF(qty, 5)", "4", minisql.INTEGER},
+		{"DATE(updated)", "2021-06-01", minisql.TEXT},
+		{"DATE('2021-02-03 04:05')", "2021-02-03", minisql.TEXT},
+		{"STRFTIME('%Y/%m/%d %H:%M:%S %j %w %%', updated)", "2021/06/01 00:00:00 152 2 %", minisql.TEXT},
+		{"STRFTIME('%s', created)", "1609459200", minisql.TEXT},
+		{"STRFTIME('%f', '2021-01-01T00:00:05.25Z')", "05.250", minisql.TEXT},
+		{"DATE_ADD(created, 2, 'DAY')", "2021-01-03T00:00:00Z", minisql.TIMESTAMP},
+		{"DATE_ADD(created, -1, 'months')", "2020-12-01T00:00:00Z", minisql.TIMESTAMP},
+		{"DATE_ADD(created, qty, 'hour')", "2021-01-01T04:00:00Z", minisql.TIMESTAMP},
+		{"DATE_ADD(created, 3000000, 'hour')", "2363-03-30T00:00:00Z", minisql.TIMESTAMP},
+		{"CAST(price AS INTEGER)", "2", minisql.INTEGER},
+		{"CAST('1.50' AS REAL)", "1.5", minisql.REAL},
+		{"CAST(qty AS TEXT) || '!'", "4!", minisql.TEXT},
+		{"CAST('2021-01-01' AS DATE)", "2021-01-01T00:00:00Z", minisql.TIMESTAMP},
+		{"CAST(name AS INTEGER)", "NULL", minisql.INTEGER},
+		{"UPPER(SUBSTR(name, 1, 1)) || SUBSTR(name, 2)", "Bob", minisql.TEXT},
+		{"ABS(qty - 10) * 2", "12", minisql.INTEGER},
+	} {
+		v, err := whereclause.ParseValueExpression(test.value)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", test.value, err)
+		}
+		v = whereclause.ValueWithTypes(v, valueTypes)
+		found := valueOrNull(v.Evaluate(valueRow()))
+		if found != test.expect {
+			t.Errorf("unexpected value of %q, expected %q, found %q", test.value, test.expect, found)
+		}
+		if v.Type() != test.ct {
+			t.Errorf("unexpected type of %q, expected %q, found %q", test.value, test.ct, v.Type())
+		}
+	}
+}
+
+func TestFunction_Now(t *testing.T) {
+	v, err := whereclause.ParseValueExpression("NOW()")
+	if err != nil {
+		t.Fatalf("unexpected error parsing NOW()  %v", err)
+	}
+	before := time.Now().Add(-time.Second)
+	now, err := minisql.ParseTimestamp(valueOrNull(v.Evaluate(nil)))
+	if err != nil {
+		t.Fatalf("expected NOW() to be a timestamp  %v", err)
+	}
+	if now.Before(before) || now.After(time.Now()) {
+		t.Fatalf("unexpected time of NOW(), found %v", now)
+	}
+}
+
+func TestFunction_Compare(t *testing.T) {
+	for _, test := range []struct {
+		where  string
+		expect bool
+	}{
+		{"UPPER(name) = 'BOB'", true},
+		{"LENGTH(name) > 3", false},
+		{"COALESCE(note, 'x') = 'x'", true},
+		{"DATE(created) = '2021-01-01'", true},
+		{"DATE_ADD(created, 5, 'MONTH') > updated", false},
+		{"DATE_ADD(created, 5, 'MONTH') = updated", true},
+		{"NOT LOWER(name) LIKE 'b%'", false},
+		{"ROUND(price) = 3 AND MOD(qty, 2) = 0", true},
+		{"qty = LENGTH(name) + 1", true},
+	} {
+		ex, err := whereclause.ParseExpression(test.where)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", test.where, err)
+		}
+		ex = whereclause.WithTypes(ex, valueTypes)
+		if found := ex.Compare(valueRow()); found != test.expect {
+			t.Errorf("unexpected result of %q, expected %v, found %v", test.where, test.expect, found)
+		}
+	}
+}
+
+func TestFunction_ParseErrors(t *testing.T) {
+	for value, expect := range map[string]string{
+		"UPPER()":                      "UPPER expects 1 argument, found 0",
+		"UPPER(name, 1)":               "UPPER expects 1 argument, found 2",
+		"SUBSTR(name)":                 "SUBSTR expects 2 to 3 arguments, found 1",
+		"CONCAT()":                     "CONCAT expects at least 1 argument, found 0",
+		"NOW(1)":                       "NOW expects 0 arguments, found 1",
+		"ABS('x')":                     `argument 1 of ABS, "x" is not a valid REAL`,
+		"ROUND(price, 1.5)":            `argument 2 of ROUND, "1.5" is not a valid INTEGER`,
+		"SUBSTR(name, 'a')":            `argument 2 of SUBSTR, "a" is not a valid INTEGER`,
+		"DATE('yesterday')":            `argument 1 of DATE, "yesterday" is not a valid TIMESTAMP`,
+		"DATE(qty * 2)":                "argument 1 of DATE, qty * 2 is a REAL, not a TIMESTAMP",
+		"ABS(NOW())":                   "argument 1 of ABS, NOW() is a TIMESTAMP, not a REAL",
+		"ABS(UPPER('1'))":              "",
+		"DATE_ADD(created, 1, 'WEEK')": `"WEEK" is not a unit of time`,
+		"STRFTIME('%Q', created)":      "%Q is not a known directive",
+		"CAST('abc' AS INTEGER)":       `"abc" is not a valid INTEGER`,
+		"CAST(qty AS BLOB)":            `"BLOB" is not a known column type`,
+		"CAST(qty, INTEGER)":           "expected AS",
+		"UPPER(name":                   "expected ')'",
+		"LOWER(name,)":                 "expected a value",
+		"NOSUCH(name)":                 "unknown function NOSUCH",
+		"qty + nosuch(qty)":            "unknown function nosuch",
+	} {
+		_, err := whereclause.ParseValueExpression(value)
+		if expect == "" {
+			if err != nil {
+				t.Errorf("unexpected error parsing %q  %v", value, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected error parsing %q", value)
+			continue
+		}
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("unexpected error parsing %q, expected %q, found %q", value, expect, err)
+		}
+	}
+}
+
+func TestFunction_BoundTypes(t *testing.T) {
+	for value, expect := range map[string]string{
+		"ABS(name)":                "argument 1 of ABS, name is a TEXT, not a REAL",
+		"ROUND(price, note)":       "argument 2 of ROUND, note is a TEXT, not a INTEGER",
+		"UPPER(SUBSTR(name, qty))": "",
+		"DATE(qty)":                "argument 1 of DATE, qty is a INTEGER, not a TIMESTAMP",
+		"LENGTH(name) + ABS(qty)":  "",
+		"ABS(UPPER('1'))":          "",
+		"-ABS(note)":               "argument 1 of ABS, note is a TEXT, not a REAL",
+		"DATE(name)":               "",
+	} {
+		v, err := whereclause.ParseValueExpression(value)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", value, err)
+		}
+		err = whereclause.CheckValueTypes(whereclause.ValueWithTypes(v, valueTypes))
+		if expect == "" {
+			if err != nil {
+				t.Errorf("unexpected error checking %q  %v", value, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != expect {
+			t.Errorf("unexpected error checking %q, expected %q, found %v", value, expect, err)
+		}
+	}
+	ex, err := whereclause.ParseExpression("qty > 1 AND NOT (name = 'x' OR ABS(name) BETWEEN 1 AND 2)")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if err = whereclause.CheckTypes(whereclause.WithTypes(ex, valueTypes)); err == nil {
+		t.Fatalf("expected error checking the bound functions of an expression")
+	}
+}
+
+func TestFunction_Unknown(t *testing.T) {
+	for _, where := range []string{"NOSUCH(qty) = 1", "qty = NOSUCH(qty)", "NOSUCH(qty) IS NULL", "NOT NOSUCH(qty) IN (1)"} {
+		_, err := whereclause.ParseExpression(where)
+		if err == nil || !strings.Contains(err.Error(), "unknown function NOSUCH") {
+			t.Errorf("expected unknown function parsing %q, found %v", where, err)
+		}
+	}
+}
+
+func TestFunction_Register(t *testing.T) {
+	whereclause.RegisterFunction(&whereclause.Function{
+		Name: "Reverse", MinArgs: 1, MaxArgs: 1,
+		Result: func(_ []whereclause.Value) minisql.ColumnType { return minisql.TEXT },
+		Call: func(args []*string) *string {
+			r := []rune(*args[0])
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+			s := string(r)
+			return &s
+		},
+	})
+	v, err := whereclause.ParseValueExpression("reverse(name) || REVERSE('ab')")
+	if err != nil {
+		t.Fatalf("unexpected error parsing registered function  %v", err)
+	}
+	v = whereclause.ValueWithTypes(v, valueTypes)
+	if found := valueOrNull(v.Evaluate(valueRow())); found != "bobba" {
+		t.Fatalf("unexpected value of registered function, found %q", found)
+	}
+}
@@ -0,0 +1,146 @@
+package whereclause
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"strings"
+)
+
+// likeMetaCharacters end the literal prefix of a LIKE pattern.
+const likeMetaCharacters = "%_.^$*+?()[]{}|\\"
+
+// indexKeys finds the keys of the rows which may match the expression, using the indexes of the table.
+// The keys are only candidates, each must still be compared with the expression.
+// Returns false when the expression can not be found with the indexes, and every row must be compared.
+func indexKeys(ex Expression, ix minisql.Indexer) ([]minisql.Key, bool) {
+	switch e := ex.(type) {
+	case *condition:
+		r, ok := e.keyRange()
+		if !ok {
+			return nil, false
+		}
+		return ix.IndexKeys(r)
+
+	case *betweenCondition:
+		r, ok := e.keyRange()
+		if !ok {
+			return nil, false
+		}
+		return ix.IndexKeys(r)
+
+	case *inCondition:
+		rs, ok := e.keyRanges()
+		if !ok {
+			return nil, false
+		}
+		// rows may match any value, so each value must be found in the index
+		var keys []minisql.Key
+		for _, r := range rs {
+			k, ok := ix.IndexKeys(r)
+			if !ok {
+				return nil, false
+			}
+			keys = unionKeys(keys, k)
+		}
+		return keys, true
+
+	case *AndExpression:
+		// rows must match both, so either side can provide the candidates
+		k1, ok1 := indexKeys(e.operand, ix)
+		k2, ok2 := indexKeys(e.expression, ix)
+		switch {
+		case ok1 && ok2:
+			return intersectKeys(k1, k2), true
+		case ok1:
+			return k1, true
+		case ok2:
+			return k2, true
+		}
+		return nil, false
+
+	case *OrExpression:
+		// rows may match either, so both sides must provide their candidates
+		k1, ok := indexKeys(e.operand, ix)
+		if !ok {
+			return nil, false
+		}
+		k2, ok := indexKeys(e.expression, ix)
+		if !ok {
+			return nil, false
+		}
+		return unionKeys(k1, k2), true
+	}
+	return nil, false
+}
+
+// keyRange gets the range of column values the condition can match.
+func (c condition) keyRange() (minisql.KeyRange, bool) {
+	r := minisql.KeyRange{Column: c.Column}
+	if c.Value == nil {
+		return r, false
+	}
+	switch c.Operator {
+	case OP_EQUAL:
+		r.Lower, r.Upper = c.Value, c.Value
+		r.LowerInclusive, r.UpperInclusive = true, true
+	case OP_GREATER, OP_GREATER_OR_EQUAL:
+		r.Lower = c.Value
+		r.LowerInclusive = c.Operator == OP_GREATER_OR_EQUAL
+	case OP_LESS, OP_LESS_OR_EQUAL:
+		// in compat mode, NULL values are less than any value
+		r.Upper = c.Value
+		r.UpperInclusive = c.Operator == OP_LESS_OR_EQUAL
+		r.Nulls = c.Compat
+	case OP_LIKE:
+		i := strings.IndexAny(*c.Value, likeMetaCharacters)
+		if i < 0 {
+			i = len(*c.Value)
+		}
+		if i == 0 {
+			return r, false
+		}
+		r.Prefix = (*c.Value)[:i]
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+// intersectKeys gets the keys in both of the given, ordered, keys.
+func intersectKeys(k1, k2 []minisql.Key) []minisql.Key {
+	var keys []minisql.Key
+	var i, j int
+	for i < len(k1) && j < len(k2) {
+		switch {
+		case k1[i] < k2[j]:
+			i++
+		case k1[i] > k2[j]:
+			j++
+		default:
+			keys = append(keys, k1[i])
+			i++
+			j++
+		}
+	}
+	return keys
+}
+
+// unionKeys gets the keys in either of the given, ordered, keys, in order.
+func unionKeys(k1, k2 []minisql.Key) []minisql.Key {
+	keys := make([]minisql.Key, 0, len(k1)+len(k2))
+	var i, j int
+	for i < len(k1) || j < len(k2) {
+		switch {
+		case j >= len(k2) || (i < len(k1) && k1[i] < k2[j]):
+			keys = append(keys, k1[i])
+			i++
+		case i >= len(k1) || k2[j] < k1[i]:
+			keys = append(keys, k2[j])
+			j++
+		default:
+			keys = append(keys, k1[i])
+			i++
+			j++
+		}
+	}
+	return keys
+}
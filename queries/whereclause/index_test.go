@@ -0,0 +1,118 @@
+package whereclause_test
+
+import (
+	"context"
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/whereclause"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func whereKeys(t *testing.T, where string, tb minisql.Table) []minisql.Key {
+	w, err := whereclause.NewWhere(where)
+	if err != nil {
+		t.Fatalf("failed to parse %q  %v", where, err)
+	}
+	var keys []minisql.Key
+	found, errs := w.Keys(context.Background(), tb)
+	for k := range found {
+		keys = append(keys, k)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to get the keys of %q  %v", where, err)
+	}
+	return keys
+}
+
+// TestWhereClause_IndexedKeys checks the keys found using indexes match those found by comparing every row.
+func TestWhereClause_IndexedKeys(t *testing.T) {
+	db := minisql.NewDatabase(minisql.Schema{
+		"plain":   {"name": {Type: minisql.TEXT}, "qty": {Type: minisql.INTEGER}},
+		"indexed": {"name": {Type: minisql.TEXT}, "qty": {Type: minisql.INTEGER}},
+	})
+	plain, _ := db.Table("plain")
+	indexed, _ := db.Table("indexed")
+	ix, ok := indexed.(minisql.Indexer)
+	if !ok {
+		t.Fatalf("expected table to support indexes")
+	}
+	if err := ix.CreateIndex(minisql.IndexDef{Name: "byqty", Columns: []string{"qty"}}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if err := ix.CreateIndex(minisql.IndexDef{Name: "byname", Columns: []string{"name"}, Type: minisql.HASH}); err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	names := []string{"apple", "apricot", "banana", "cherry", "Apple"}
+	for i := 0; i < 300; i++ {
+		vals := minisql.Values{}
+		if rnd.Intn(10) > 0 {
+			n := names[rnd.Intn(len(names))]
+			vals["name"] = &n
+		}
+		if rnd.Intn(10) > 0 {
+			q := fmt.Sprintf("%d", rnd.Intn(50)-10)
+			vals["qty"] = &q
+		}
+		for _, tb := range []minisql.Table{plain, indexed} {
+			if _, err := tb.Insert(vals); err != nil {
+				t.Fatalf("unexpected error  %v", err)
+			}
+		}
+	}
+	// change some rows after the index was built
+	for i := 0; i < 50; i++ {
+		k := minisql.Key(rnd.Intn(300))
+		q := fmt.Sprintf("%d", rnd.Intn(50))
+		del := rnd.Intn(2) == 0
+		for _, tb := range []minisql.Table{plain, indexed} {
+			if del {
+				tb.Delete(k)
+			} else {
+				_ = tb.Update(k, minisql.Values{"qty": &q})
+			}
+		}
+	}
+
+	for _, where := range []string{
+		"qty = 5",
+		"qty = '05'",
+		"qty > 20",
+		"qty >= 20",
+		"qty < 0",
+		"qty <= 0",
+		"qty < -100",
+		"name = 'apple'",
+		"name LIKE 'ap%'",
+		"name LIKE 'a_p%'",
+		"name = 'apple' AND qty > 10",
+		"name = 'cherry' OR qty = 3",
+		"NOT qty = 5",
+		"qty = NULL",
+		"qty > 10 AND qty < 20",
+		"qty BETWEEN 10 AND 20",
+		"qty BETWEEN 20 AND 10",
+		"qty NOT BETWEEN 10 AND 20",
+		"qty IN (1, '02', 3, NULL)",
+		"name IN ('apple', 'cherry') AND qty BETWEEN 0 AND 30",
+		"qty IS NULL",
+		"qty NOT IN (1, 2)",
+	} {
+		expect := whereKeys(t, where, plain)
+		found := whereKeys(t, where, indexed)
+		if !reflect.DeepEqual(expect, found) {
+			t.Fatalf("unexpected keys for %q using indexes, expected %v, found %v", where, expect, found)
+		}
+	}
+
+	if _, ok := ix.IndexKeys(minisql.KeyRange{Column: "qty", Prefix: "1"}); ok {
+		t.Fatalf("expected prefix not to use index of INTEGER column")
+	}
+	five := "5"
+	if _, ok := ix.IndexKeys(minisql.KeyRange{Column: "name", Lower: &five}); ok {
+		t.Fatalf("expected range not to use HASH index")
+	}
+}
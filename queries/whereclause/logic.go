@@ -0,0 +1,71 @@
+package whereclause
+
+import (
+	"eurozulu/miniSQL/minisql"
+)
+
+// truth is the outcome of an expression in SQL's three valued logic.
+// Comparing a NULL value is neither true nor false, but unknown.  Only rows whose expression is true are matched.
+type truth int8
+
+const (
+	truthFalse truth = iota
+	truthUnknown
+	truthTrue
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+// not inverts the truth.  NOT unknown remains unknown.
+func (t truth) not() truth {
+	return truthTrue - t
+}
+
+// and is false when either is false, otherwise unknown when either is unknown.
+func (t truth) and(t2 truth) truth {
+	if t2 < t {
+		return t2
+	}
+	return t
+}
+
+// or is true when either is true, otherwise unknown when either is unknown.
+func (t truth) or(t2 truth) truth {
+	if t2 > t {
+		return t2
+	}
+	return t
+}
+
+// evaluator is implemented by expressions which may be unknown, when they compare NULL values.
+type evaluator interface {
+	evaluate(values minisql.Values) truth
+}
+
+// evaluate gets the truth of the expression with the given values.
+// Expressions which are not evaluators are only ever true or false.
+func evaluate(ex Expression, values minisql.Values) truth {
+	if e, ok := ex.(evaluator); ok {
+		return e.evaluate(values)
+	}
+	return truthOf(ex.Compare(values))
+}
+
+// compatibleExpression is implemented by expressions whose comparisons differ in compat mode.
+type compatibleExpression interface {
+	// withCompat returns a copy of the expression, which compares its values with the legacy rules.
+	withCompat() Expression
+}
+
+func withCompat(ex Expression) Expression {
+	ce, ok := ex.(compatibleExpression)
+	if !ok {
+		return ex
+	}
+	return ce.withCompat()
+}
@@ -0,0 +1,317 @@
+package whereclause_test
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/whereclause"
+	"testing"
+)
+
+// row builds the values of a row from pairs of column names and values, where the value NULL is a nil value.
+func row(kv ...string) minisql.Values {
+	vals := minisql.Values{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == whereclause.NULL {
+			vals[kv[i]] = nil
+			continue
+		}
+		v := kv[i+1]
+		vals[kv[i]] = &v
+	}
+	return vals
+}
+
+var logicTypes = map[string]minisql.ColumnType{"a": minisql.INTEGER, "b": minisql.INTEGER, "c": minisql.INTEGER, "s": minisql.TEXT}
+
+type logicTest struct {
+	expression string
+	values     minisql.Values
+	expect     bool
+}
+
+func testLogic(t *testing.T, compat bool, tests []logicTest) {
+	parse := whereclause.ParseExpression
+	if compat {
+		parse = whereclause.ParseCompatExpression
+	}
+	for _, test := range tests {
+		ex, err := parse(test.expression)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", test.expression, err)
+		}
+		ex = whereclause.WithTypes(ex, logicTypes)
+		if found := ex.Compare(test.values); found != test.expect {
+			t.Errorf("unexpected result of %q with %v, compat %v, expected %v, found %v",
+				test.expression, valuesString(test.values), compat, test.expect, found)
+		}
+	}
+}
+
+func valuesString(vals minisql.Values) map[string]string {
+	m := map[string]string{}
+	for k, v := range vals {
+		if v == nil {
+			m[k] = whereclause.NULL
+			continue
+		}
+		m[k] = *v
+	}
+	return m
+}
+
+func TestLogic_Precedence(t *testing.T) {
+	testLogic(t, false, []logicTest{
+		// AND before OR
+		{"a = 1 OR b = 2 AND c = 3", row("a", "1", "b", "0", "c", "0"), true},
+		{"a = 1 OR b = 2 AND c = 3", row("a", "0", "b", "2", "c", "3"), true},
+		{"a = 1 OR b = 2 AND c = 3", row("a", "0", "b", "2", "c", "0"), false},
+		{"a = 1 AND b = 2 OR c = 3", row("a", "0", "b", "0", "c", "3"), true},
+		{"a = 1 AND b = 2 OR c = 3", row("a", "1", "b", "0", "c", "0"), false},
+		{"a = 1 OR b = 2 AND c = 3 OR a = 4", row("a", "4", "b", "0", "c", "0"), true},
+		{"a = 1 AND b = 2 OR c = 3 AND a = 4", row("a", "1", "b", "2", "c", "0"), true},
+		{"a = 1 AND b = 2 OR c = 3 AND a = 4", row("a", "1", "b", "0", "c", "3"), false},
+		// brackets override the precedence
+		{"(a = 1 OR b = 2) AND c = 3", row("a", "1", "b", "0", "c", "0"), false},
+		{"(a = 1 OR b = 2) AND c = 3", row("a", "1", "b", "0", "c", "3"), true},
+		{"a = 1 AND (b = 2 OR c = 3)", row("a", "1", "b", "0", "c", "3"), true},
+		// NOT before AND
+		{"NOT a = 1 AND b = 2", row("a", "0", "b", "2"), true},
+		{"NOT a = 1 AND b = 2", row("a", "1", "b", "0"), false},
+		{"NOT a = 1 OR b = 2", row("a", "1", "b", "2"), true},
+		{"NOT (a = 1 OR b = 2) AND c = 3", row("a", "0", "b", "0", "c", "3"), true},
+		{"NOT NOT a = 1", row("a", "1"), true},
+		// BETWEEN's AND is part of its condition
+		{"a BETWEEN 1 AND 3 OR b = 2 AND c = 3", row("a", "2", "b", "0", "c", "0"), true},
+		{"a = 0 OR b BETWEEN 1 AND 3 AND c = 3", row("a", "0", "b", "9", "c", "0"), true},
+		{"a = 9 OR b BETWEEN 1 AND 3 AND c = 3", row("a", "0", "b", "2", "c", "0"), false},
+	})
+}
+
+func TestLogic_PrecedenceCompat(t *testing.T) {
+	testLogic(t, true, []logicTest{
+		// read from left to right
+		{"a = 1 OR b = 2 AND c = 3", row("a", "1", "b", "0", "c", "0"), false},
+		{"a = 1 OR b = 2 AND c = 3", row("a", "1", "b", "0", "c", "3"), true},
+		{"a = 1 AND b = 2 OR c = 3", row("a", "0", "b", "0", "c", "3"), true},
+		{"a = 1 OR b = 2 AND c = 3 OR a = 4", row("a", "4", "b", "0", "c", "0"), true},
+		{"a = 1 AND (b = 2 OR c = 3)", row("a", "1", "b", "0", "c", "3"), true},
+		{"NOT a = 1 AND b = 2", row("a", "0", "b", "2"), true},
+		{"a = 1 OR (b = 2 AND c = 3)", row("a", "1", "b", "0", "c", "0"), true},
+	})
+}
+
+func TestLogic_NullComparisons(t *testing.T) {
+	null := row("a", "NULL", "s", "NULL")
+	testLogic(t, false, []logicTest{
+		// any comparison with NULL is unknown, so is not matched
+		{"a = NULL", null, false},
+		{"a != NULL", null, false},
+		{"a <> NULL", null, false},
+		{"a = NULL", row("a", "1"), false},
+		{"a != NULL", row("a", "1"), false},
+		{"a = 1", null, false},
+		{"a != 1", null, false},
+		{"a > 1", null, false},
+		{"a >= 1", null, false},
+		{"a < 1", null, false},
+		{"a <= 1", null, false},
+		{"a < NULL", row("a", "1"), false},
+		{"a <= NULL", null, false},
+		{"a >= NULL", null, false},
+		{"s LIKE 'a%'", null, false},
+		{"s LIKE NULL", row("s", "abc"), false},
+		// inverting unknown remains unknown
+		{"NOT a = 1", null, false},
+		{"NOT a != 1", null, false},
+		{"NOT a = NULL", null, false},
+		{"NOT a = NULL", row("a", "1"), false},
+		{"NOT NOT a = 1", null, false},
+		// a missing column compares as NULL
+		{"b = 1", row("a", "1"), false},
+		{"NOT b = 1", row("a", "1"), false},
+		// IS NULL is never unknown
+		{"a IS NULL", null, true},
+		{"a IS NOT NULL", null, false},
+		{"NOT a IS NULL", null, false},
+		{"a IS NOT NULL", row("a", "1"), true},
+		// comparisons with values are unchanged
+		{"a = 1", row("a", "1"), true},
+		{"a != 1", row("a", "2"), true},
+		{"a < 1", row("a", "0"), true},
+		{"s LIKE 'a%'", row("s", "abc"), true},
+	})
+}
+
+func TestLogic_NullComparisonsCompat(t *testing.T) {
+	null := row("a", "NULL", "s", "NULL")
+	testLogic(t, true, []logicTest{
+		// NULL equals NULL, and is less than every other value
+		{"a = NULL", null, true},
+		{"a != NULL", null, false},
+		{"a <> NULL", row("a", "1"), true},
+		{"a = NULL", row("a", "1"), false},
+		{"a = 1", null, false},
+		{"a != 1", null, true},
+		{"a > 1", null, false},
+		{"a < 1", null, true},
+		{"a <= 1", null, true},
+		{"a >= NULL", null, true},
+		{"a > NULL", row("a", "1"), true},
+		{"s LIKE 'a%'", null, false},
+		// NOT inverts false
+		{"NOT a = 1", null, true},
+		{"NOT a = NULL", null, false},
+		{"NOT a = NULL", row("a", "1"), true},
+		// a missing column never matches
+		{"b = NULL", row("a", "1"), false},
+		{"NOT b = 1", row("a", "1"), true},
+		{"a IS NULL", null, true},
+	})
+}
+
+func TestLogic_ThreeValued(t *testing.T) {
+	// with a NULL, the conditions on c are unknown, a = 1 is true and a = 0 is false
+	vals := row("a", "1", "c", "NULL")
+	testLogic(t, false, []logicTest{
+		{"a = 1 AND c = 1", vals, false},
+		{"NOT (a = 1 AND c = 1)", vals, false},
+		{"a = 0 AND c = 1", vals, false},
+		{"NOT (a = 0 AND c = 1)", vals, true},
+		{"c = 1 AND a = 0", vals, false},
+		{"NOT (c = 1 AND a = 0)", vals, true},
+		{"c = 1 AND c = 2", vals, false},
+		{"NOT (c = 1 AND c = 2)", vals, false},
+
+		{"a = 1 OR c = 1", vals, true},
+		{"c = 1 OR a = 1", vals, true},
+		{"NOT (c = 1 OR a = 1)", vals, false},
+		{"a = 0 OR c = 1", vals, false},
+		{"NOT (a = 0 OR c = 1)", vals, false},
+		{"c = 1 OR c = 2", vals, false},
+		{"NOT (c = 1 OR c = 2)", vals, false},
+
+		{"c = 1 OR a = 1 AND c = 2", vals, false},
+		{"c = 1 OR a = 1 AND c IS NULL", vals, true},
+		{"NOT (c = 1 OR a = 0) OR a = 1", vals, true},
+		{"NOT (c = 1 OR a = 0) AND a = 1", vals, false},
+	})
+}
+
+func TestLogic_ThreeValuedCompat(t *testing.T) {
+	vals := row("a", "1", "c", "NULL")
+	testLogic(t, true, []logicTest{
+		{"a = 1 AND c = 1", vals, false},
+		{"NOT (a = 1 AND c = 1)", vals, true},
+		{"NOT (c = 1 OR c = 2)", vals, true},
+		{"a = 0 OR c = NULL", vals, true},
+	})
+}
+
+func TestLogic_Predicates(t *testing.T) {
+	null := row("a", "NULL")
+	testLogic(t, false, []logicTest{
+		{"a BETWEEN 1 AND 3", null, false},
+		{"a NOT BETWEEN 1 AND 3", null, false},
+		{"NOT a BETWEEN 1 AND 3", null, false},
+		{"NOT (a NOT BETWEEN 1 AND 3)", null, false},
+		// a NULL bound is unknown, unless the other bound is false
+		{"a BETWEEN NULL AND 3", row("a", "2"), false},
+		{"a NOT BETWEEN NULL AND 3", row("a", "2"), false},
+		{"a NOT BETWEEN NULL AND 3", row("a", "5"), true},
+		{"a NOT BETWEEN 3 AND NULL", row("a", "1"), true},
+		{"a NOT BETWEEN 1 AND 3", row("a", "5"), true},
+
+		{"a IN (1, 2)", null, false},
+		{"a NOT IN (1, 2)", null, false},
+		{"NOT a IN (1, 2)", null, false},
+		{"NOT (a NOT IN (1, 2))", null, false},
+		// a value not in a list with NULL is unknown
+		{"a IN (1, NULL)", row("a", "1"), true},
+		{"a IN (1, NULL)", row("a", "2"), false},
+		{"NOT a IN (1, NULL)", row("a", "2"), false},
+		{"a NOT IN (1, NULL)", row("a", "2"), false},
+		{"a NOT IN (1, NULL)", row("a", "1"), false},
+		{"a NOT IN (1, 2)", row("a", "3"), true},
+		{"a IN (1, NULL) OR a = 2", row("a", "2"), true},
+	})
+}
+
+func TestLogic_PredicatesCompat(t *testing.T) {
+	null := row("a", "NULL")
+	testLogic(t, true, []logicTest{
+		{"a BETWEEN 1 AND 3", null, false},
+		{"a NOT BETWEEN 1 AND 3", null, false},
+		{"NOT a BETWEEN 1 AND 3", null, true},
+		{"a NOT BETWEEN NULL AND 3", row("a", "5"), false},
+		{"a IN (1, 2)", null, false},
+		{"NOT a IN (1, 2)", null, true},
+		{"NOT a IN (1, NULL)", row("a", "2"), true},
+		{"a NOT IN (1, NULL)", row("a", "2"), false},
+	})
+}
+
+func TestLogic_CompatKept(t *testing.T) {
+	// expressions keep the rules they were parsed with
+	legacy, err := whereclause.ParseCompatExpression("a = NULL")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	standard, err := whereclause.ParseExpression("a = NULL")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	null := row("a", "NULL")
+	if !legacy.Compare(null) {
+		t.Fatalf("expected expression parsed in compat mode to match NULL = NULL")
+	}
+	if standard.Compare(null) {
+		t.Fatalf("expected expression parsed in standard mode to not match NULL = NULL")
+	}
+	// binding types and parameters keeps the mode
+	if !whereclause.WithTypes(legacy, logicTypes).Compare(null) {
+		t.Fatalf("expected typed expression parsed in compat mode to match NULL = NULL")
+	}
+	param, err := whereclause.ParseCompatExpression("a = ? OR b = 1")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if !whereclause.WithParameters(param, []*string{nil}).Compare(null) {
+		t.Fatalf("expected bound expression parsed in compat mode to match NULL = NULL")
+	}
+}
+
+func TestLogic_OperatorCompare(t *testing.T) {
+	one, two := "1", "2"
+	tests := []struct {
+		op     whereclause.Operator
+		v1, v2 *string
+		expect bool
+	}{
+		{whereclause.OP_EQUAL, nil, nil, false},
+		{whereclause.OP_EQUAL, &one, nil, false},
+		{whereclause.OP_NOT_EQUAL, nil, nil, false},
+		{whereclause.OP_NOT_EQUAL, &one, nil, false},
+		{whereclause.OP_NOT_EQUAL_ALT, nil, &one, false},
+		{whereclause.OP_LESS, nil, &one, false},
+		{whereclause.OP_LESS_OR_EQUAL, nil, nil, false},
+		{whereclause.OP_GREATER, &one, nil, false},
+		{whereclause.OP_GREATER_OR_EQUAL, nil, nil, false},
+		{whereclause.OP_LIKE, nil, &one, false},
+		{whereclause.OP_EQUAL, &one, &one, true},
+		{whereclause.OP_NOT_EQUAL, &one, &two, true},
+		{whereclause.OP_LESS, &one, &two, true},
+		{whereclause.OP_GREATER_OR_EQUAL, &two, &one, true},
+	}
+	for _, test := range tests {
+		if found := test.op.Compare(test.v1, test.v2); found != test.expect {
+			t.Errorf("unexpected result of %v %s %v, expected %v, found %v",
+				valueOrNull(test.v1), test.op, valueOrNull(test.v2), test.expect, found)
+		}
+	}
+}
+
+func valueOrNull(v *string) string {
+	if v == nil {
+		return whereclause.NULL
+	}
+	return *v
+}
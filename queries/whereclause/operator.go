@@ -1,6 +1,8 @@
 package whereclause
 
 import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
 	"log"
 	"math"
 	"regexp"
@@ -51,74 +53,75 @@ func SplitOperator(s string) (string, Operator, string) {
 	return b4, op, rest
 }
 
-func (op Operator) Compare(v1, v2 *string) bool {
-	bothNull := (v1 == nil && v2 == nil)
-	eitherNull := (v1 == nil || v2 == nil)
-	switch op {
-	case OP_EQUAL:
-		if bothNull {
-			return true
-		}
-		if eitherNull {
-			return false
-		}
-		return *v1 == *v2
-
-	case OP_GREATER:
-		if bothNull {
-			return false
-		}
-		if eitherNull {
-			return v2 == nil
-		}
-		return strings.Compare(*v1, *v2) > 0
-
-	case OP_GREATER_OR_EQUAL:
-		if bothNull {
-			return true
-		}
-		if eitherNull {
-			return v2 == nil
-		}
-		return *v1 == *v2 || strings.Compare(*v1, *v2) > 0
-
-	case OP_LESS:
-		if bothNull {
-			return false
-		}
-		if eitherNull {
-			return v1 == nil
+// readOperator reads a condition Operator from the tokens.  If the next token is not an Operator, OP_UNKNOWN is returned
+// and no tokens are consumed.
+func readOperator(ts *lexer.TokenStream) Operator {
+	t := ts.Peek()
+	if t.Type != lexer.SYMBOL && !t.IsKeyword(string(OP_LIKE)) {
+		return OP_UNKNOWN
+	}
+	for _, op := range operators {
+		if strings.EqualFold(t.Text, string(op)) {
+			ts.Next()
+			return op
 		}
-		return strings.Compare(*v1, *v2) < 0
+	}
+	return OP_UNKNOWN
+}
 
-	case OP_LESS_OR_EQUAL:
-		if bothNull {
-			return true
-		}
-		if eitherNull {
-			return v1 == nil
-		}
-		return *v1 == *v2 || strings.Compare(*v1, *v2) < 0
+// Compare compares the two values, as TEXT, using the operator.
+// A comparison with a NULL value is never true, as its outcome is unknown.
+func (op Operator) Compare(v1, v2 *string) bool {
+	return op.CompareType(minisql.TEXT, v1, v2)
+}
 
-	case OP_NOT_EQUAL, OP_NOT_EQUAL_ALT:
-		if bothNull {
-			return false
-		}
-		if eitherNull {
-			return true
-		}
-		return *v1 != *v2
+// CompareType compares the two values, according to the given column type, using the operator.
+// LIKE always compares the values as TEXT.  A comparison with a NULL value is never true, as its outcome is unknown.
+func (op Operator) CompareType(ct minisql.ColumnType, v1, v2 *string) bool {
+	return op.evaluate(ct, v1, v2) == truthTrue
+}
 
-	case OP_LIKE:
-		if eitherNull {
-			return false
-		}
+// evaluate compares the two values, according to the given column type, using the operator.
+// The outcome is unknown when either value is NULL.
+func (op Operator) evaluate(ct minisql.ColumnType, v1, v2 *string) truth {
+	if v1 == nil || v2 == nil {
+		return truthUnknown
+	}
+	if op == OP_LIKE {
 		regx, err := likeToRegex(*v2)
 		if err != nil {
 			log.Printf("Where LIKE %q invalid.", *v2)
-			return false
+			return truthFalse
 		}
-		return regx.MatchString(*v1)
+		return truthOf(regx.MatchString(*v1))
+	}
+	return truthOf(op.ordered(ct.Compare(*v1, *v2)))
+}
+
+// compareLegacy compares the two values with the legacy rules of compat mode,
+// where NULL equals NULL, and is less than every other value.  LIKE is false with a NULL value.
+func (op Operator) compareLegacy(ct minisql.ColumnType, v1, v2 *string) bool {
+	if op == OP_LIKE || (v1 != nil && v2 != nil) {
+		return op.evaluate(ct, v1, v2) == truthTrue
+	}
+	return op.ordered(ct.CompareValues(v1, v2))
+}
+
+// ordered checks if the order of two values, -1, 0 or 1, is true for the operator.
+func (op Operator) ordered(c int) bool {
+	switch op {
+	case OP_EQUAL:
+		return c == 0
+	case OP_GREATER:
+		return c > 0
+	case OP_GREATER_OR_EQUAL:
+		return c >= 0
+	case OP_LESS:
+		return c < 0
+	case OP_LESS_OR_EQUAL:
+		return c <= 0
+	case OP_NOT_EQUAL, OP_NOT_EQUAL_ALT:
+		return c != 0
 	default:
 		log.Printf("%q is not a known Operator\n", op)
 		return false
@@ -0,0 +1,633 @@
+package whereclause
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/stringutil"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	IS      = "IS"
+	IN      = "IN"
+	BETWEEN = "BETWEEN"
+)
+
+// betweenCondition compares a column with an inclusive range of values.
+// e.g. price BETWEEN 10 AND 20  or  created NOT BETWEEN '2021-01-01' AND '2021-12-31'
+// Comparing a NULL column value, or bound, is unknown, unless the other bound alone places the value outside the range.
+type betweenCondition struct {
+	Column    string
+	Low, High *string
+	Not       bool
+	// LowParameter and HighParameter, when not zero, are the numbers of the parameters giving the bounds.
+	LowParameter, HighParameter int
+	Type                        minisql.ColumnType
+	// Compat compares with the legacy rules, where a NULL value, or bound, is never within, or outside of, the range.
+	Compat bool
+}
+
+func (c betweenCondition) String() string {
+	return fmt.Sprintf("%s %s %s AND %s", c.Column, notKeyword(c.Not, BETWEEN),
+		valueString(c.Low, c.LowParameter), valueString(c.High, c.HighParameter))
+}
+
+func (c betweenCondition) ColumnNames() []string {
+	return []string{c.Column}
+}
+
+func (c betweenCondition) Compare(values minisql.Values) bool {
+	return c.evaluate(values) == truthTrue
+}
+
+func (c betweenCondition) evaluate(values minisql.Values) truth {
+	v := values[c.Column]
+	ct := c.Type
+	if ct == "" {
+		ct = minisql.TEXT
+	}
+	if c.Compat && (v == nil || c.Low == nil || c.High == nil) {
+		return truthFalse
+	}
+	within := OP_GREATER_OR_EQUAL.evaluate(ct, v, c.Low).and(OP_LESS_OR_EQUAL.evaluate(ct, v, c.High))
+	if c.Not {
+		return within.not()
+	}
+	return within
+}
+
+func (c betweenCondition) withTypes(types columnTypes) Expression {
+	c.Type = types(c.Column)
+	return &c
+}
+
+func (c betweenCondition) withParameters(params []*string) Expression {
+	if c.LowParameter > 0 {
+		c.Low = params[c.LowParameter-1]
+		c.LowParameter = 0
+	}
+	if c.HighParameter > 0 {
+		c.High = params[c.HighParameter-1]
+		c.HighParameter = 0
+	}
+	return &c
+}
+
+func (c betweenCondition) withCompat() Expression {
+	c.Compat = true
+	return &c
+}
+
+// keyRange gets the range of column values the condition can match.
+func (c betweenCondition) keyRange() (minisql.KeyRange, bool) {
+	if c.Not || c.Low == nil || c.High == nil {
+		return minisql.KeyRange{}, false
+	}
+	return minisql.KeyRange{Column: c.Column, Lower: c.Low, Upper: c.High, LowerInclusive: true, UpperInclusive: true}, true
+}
+
+// inCondition compares a column with a list of values, matching when the column equals any of them.
+// e.g. status IN ('new', 'open')  or  qty NOT IN (1, 2, 3)
+// The values are held in a set, keyed on the canonical form of the columns type, so each row is compared with a single lookup
+// of the values which may equal it.
+// Comparing a NULL column value is unknown, as is a value not in a list holding NULL, so NOT IN such a list matches nothing.
+type inCondition struct {
+	Column string
+	Values []*string
+	Not    bool
+	// Parameters are the numbers of the parameters giving each value, zero for a literal value.
+	Parameters []int
+	Type       minisql.ColumnType
+	// Compat compares with the legacy rules, where a NULL column value is never in, or not in, the list.
+	Compat  bool
+	set     map[string][]string
+	hasNull bool
+}
+
+func (c inCondition) String() string {
+	vs := make([]string, len(c.Values))
+	for i, v := range c.Values {
+		p := 0
+		if i < len(c.Parameters) {
+			p = c.Parameters[i]
+		}
+		vs[i] = valueString(v, p)
+	}
+	return fmt.Sprintf("%s %s (%s)", c.Column, notKeyword(c.Not, IN), strings.Join(vs, ", "))
+}
+
+func (c inCondition) ColumnNames() []string {
+	return []string{c.Column}
+}
+
+func (c inCondition) Compare(values minisql.Values) bool {
+	return c.evaluate(values) == truthTrue
+}
+
+func (c inCondition) evaluate(values minisql.Values) truth {
+	v := values[c.Column]
+	if v == nil {
+		if c.Compat {
+			return truthFalse
+		}
+		return truthUnknown
+	}
+	for _, sv := range c.set[c.setKey(*v)] {
+		if c.Type.Compare(*v, sv) == 0 {
+			return truthOf(!c.Not)
+		}
+	}
+	if c.hasNull && !c.Compat {
+		return truthUnknown
+	}
+	return truthOf(c.Not && !c.hasNull)
+}
+
+// buildSet builds the set of the non NULL values, by their keys.
+func (c *inCondition) buildSet() {
+	c.set = map[string][]string{}
+	c.hasNull = false
+	for _, v := range c.Values {
+		if v == nil {
+			c.hasNull = true
+			continue
+		}
+		k := c.setKey(*v)
+		c.set[k] = append(c.set[k], *v)
+	}
+}
+
+// setKey gets the key of a value, which all values equal to it, as the column type, share.
+// Numbers are keyed as a REAL, as an INTEGER equals a REAL of the same value, and a TIMESTAMP in UTC.
+// Values which are not of the type are keyed as they are.
+func (c inCondition) setKey(v string) string {
+	switch c.Type {
+	case "":
+		return v
+	case minisql.INTEGER, minisql.REAL:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			if f == 0 {
+				// -0 equals 0
+				f = 0
+			}
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case minisql.TIMESTAMP:
+		if t, err := minisql.ParseTimestamp(v); err == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	default:
+		if cv, err := c.Type.Convert(v); err == nil {
+			return cv
+		}
+	}
+	return v
+}
+
+func (c inCondition) withTypes(types columnTypes) Expression {
+	c.Type = types(c.Column)
+	c.buildSet()
+	return &c
+}
+
+func (c inCondition) withParameters(params []*string) Expression {
+	vals := make([]*string, len(c.Values))
+	for i, v := range c.Values {
+		if i < len(c.Parameters) && c.Parameters[i] > 0 {
+			v = params[c.Parameters[i]-1]
+		}
+		vals[i] = v
+	}
+	c.Values = vals
+	c.Parameters = nil
+	c.buildSet()
+	return &c
+}
+
+func (c inCondition) withCompat() Expression {
+	c.Compat = true
+	return &c
+}
+
+// keyRanges gets a range of each value in the list.
+func (c inCondition) keyRanges() ([]minisql.KeyRange, bool) {
+	if c.Not {
+		return nil, false
+	}
+	var rs []minisql.KeyRange
+	for _, v := range c.Values {
+		if v == nil {
+			continue
+		}
+		rs = append(rs, minisql.KeyRange{Column: c.Column, Lower: v, Upper: v, LowerInclusive: true, UpperInclusive: true})
+	}
+	return rs, true
+}
+
+// nullCondition checks if a column is, or is not, NULL.
+// e.g. name IS NULL  or  name IS NOT NULL
+type nullCondition struct {
+	Column string
+	Not    bool
+}
+
+func (c nullCondition) String() string {
+	if c.Not {
+		return fmt.Sprintf("%s %s %s %s", c.Column, IS, NOT, NULL)
+	}
+	return fmt.Sprintf("%s %s %s", c.Column, IS, NULL)
+}
+
+func (c nullCondition) ColumnNames() []string {
+	return []string{c.Column}
+}
+
+func (c nullCondition) Compare(values minisql.Values) bool {
+	v, ok := values[c.Column]
+	if !ok {
+		return false
+	}
+	return (v == nil) != c.Not
+}
+
+// valueBetween compares a value, which may be an expression of columns, with an inclusive range of values.
+// e.g. qty * price BETWEEN 10 AND 20  or  LENGTH(name) BETWEEN min_len AND max_len
+// Once bound, a column compared with literal bounds is a betweenCondition.
+type valueBetween struct {
+	Value, Low, High Value
+	Not              bool
+	// Compat compares with the legacy rules, where a NULL value, or bound, is never within, or outside of, the range.
+	Compat bool
+}
+
+func (c valueBetween) String() string {
+	return fmt.Sprintf("%s %s %s AND %s", c.Value, notKeyword(c.Not, BETWEEN), c.Low, c.High)
+}
+
+func (c valueBetween) ColumnNames() []string {
+	names := append(c.Value.ColumnNames(), c.Low.ColumnNames()...)
+	return stringutil.UniqueStrings(append(names, c.High.ColumnNames()...))
+}
+
+func (c valueBetween) Compare(values minisql.Values) bool {
+	return c.evaluate(values) == truthTrue
+}
+
+func (c valueBetween) evaluate(values minisql.Values) truth {
+	v, low, high := c.Value.Evaluate(values), c.Low.Evaluate(values), c.High.Evaluate(values)
+	if c.Compat && (v == nil || low == nil || high == nil) {
+		return truthFalse
+	}
+	ct := valuesType(c.Value, c.Low, c.High)
+	within := OP_GREATER_OR_EQUAL.evaluate(ct, v, low).and(OP_LESS_OR_EQUAL.evaluate(ct, v, high))
+	if c.Not {
+		return within.not()
+	}
+	return within
+}
+
+// withTypes binds the types of the values.  A column compared with literal bounds becomes a betweenCondition of the column.
+func (c valueBetween) withTypes(types columnTypes) Expression {
+	c.Value = valueWithTypes(c.Value, types)
+	c.Low = valueWithTypes(c.Low, types)
+	c.High = valueWithTypes(c.High, types)
+	if cond, ok := c.condition(); ok {
+		cond.Type = types(cond.Column)
+		return cond
+	}
+	return &c
+}
+
+func (c valueBetween) withParameters(params []*string) Expression {
+	c.Value = valueWithParameters(c.Value, params)
+	c.Low = valueWithParameters(c.Low, params)
+	c.High = valueWithParameters(c.High, params)
+	return &c
+}
+
+func (c valueBetween) withCompat() Expression {
+	c.Compat = true
+	return &c
+}
+
+// condition gets the range as a betweenCondition, when it compares a column with literal values or parameters.
+func (c valueBetween) condition() (*betweenCondition, bool) {
+	col, ok := columnOf(c.Value)
+	if !ok {
+		return nil, false
+	}
+	low, ok := c.Low.(*literalValue)
+	if !ok {
+		return nil, false
+	}
+	high, ok := c.High.(*literalValue)
+	if !ok {
+		return nil, false
+	}
+	return &betweenCondition{
+		Column:        col,
+		Low:           low.Value,
+		High:          high.Value,
+		Not:           c.Not,
+		LowParameter:  low.Parameter,
+		HighParameter: high.Parameter,
+		Compat:        c.Compat,
+	}, true
+}
+
+// valueIn compares a value, which may be an expression of columns, with a list of values, matching when it equals any of them.
+// e.g. UPPER(name) IN ('A', 'B')  or  'admin' IN (role, group_role)
+// Once bound, a column compared with a list of literal values is an inCondition.
+type valueIn struct {
+	Value Value
+	List  []Value
+	Not   bool
+	// Compat compares with the legacy rules, where a NULL value is never in, or not in, the list.
+	Compat bool
+}
+
+func (c valueIn) String() string {
+	vs := make([]string, len(c.List))
+	for i, v := range c.List {
+		vs[i] = v.String()
+	}
+	return fmt.Sprintf("%s %s (%s)", c.Value, notKeyword(c.Not, IN), strings.Join(vs, ", "))
+}
+
+func (c valueIn) ColumnNames() []string {
+	names := c.Value.ColumnNames()
+	for _, v := range c.List {
+		names = append(names, v.ColumnNames()...)
+	}
+	return stringutil.UniqueStrings(names)
+}
+
+func (c valueIn) Compare(values minisql.Values) bool {
+	return c.evaluate(values) == truthTrue
+}
+
+func (c valueIn) evaluate(values minisql.Values) truth {
+	v := c.Value.Evaluate(values)
+	if v == nil {
+		if c.Compat {
+			return truthFalse
+		}
+		return truthUnknown
+	}
+	ct := valuesType(append([]Value{c.Value}, c.List...)...)
+	var hasNull bool
+	for _, lv := range c.List {
+		iv := lv.Evaluate(values)
+		if iv == nil {
+			hasNull = true
+			continue
+		}
+		if OP_EQUAL.evaluate(ct, v, iv) == truthTrue {
+			return truthOf(!c.Not)
+		}
+	}
+	if hasNull && !c.Compat {
+		return truthUnknown
+	}
+	return truthOf(c.Not && !hasNull)
+}
+
+// withTypes binds the types of the values.  A column compared with literal values becomes an inCondition of the column.
+func (c valueIn) withTypes(types columnTypes) Expression {
+	c.Value = valueWithTypes(c.Value, types)
+	c.List = valuesWithTypes(c.List, types)
+	if cond, ok := c.condition(); ok {
+		return cond.withTypes(types)
+	}
+	return &c
+}
+
+func (c valueIn) withParameters(params []*string) Expression {
+	c.Value = valueWithParameters(c.Value, params)
+	list := make([]Value, len(c.List))
+	for i, v := range c.List {
+		list[i] = valueWithParameters(v, params)
+	}
+	c.List = list
+	return &c
+}
+
+func (c valueIn) withCompat() Expression {
+	c.Compat = true
+	return &c
+}
+
+// condition gets the list as an inCondition, when it compares a column with literal values or parameters.
+func (c valueIn) condition() (*inCondition, bool) {
+	col, ok := columnOf(c.Value)
+	if !ok {
+		return nil, false
+	}
+	cond := &inCondition{Column: col, Not: c.Not, Compat: c.Compat}
+	var hasParams bool
+	for _, v := range c.List {
+		lit, ok := v.(*literalValue)
+		if !ok {
+			return nil, false
+		}
+		cond.Values = append(cond.Values, lit.Value)
+		cond.Parameters = append(cond.Parameters, lit.Parameter)
+		hasParams = hasParams || lit.Parameter > 0
+	}
+	if !hasParams {
+		cond.Parameters = nil
+	}
+	cond.buildSet()
+	return cond, true
+}
+
+// valueNull checks if a value, which may be an expression of columns, is, or is not, NULL.
+// e.g. price * qty IS NULL  or  COALESCE(note, title) IS NOT NULL
+type valueNull struct {
+	Value Value
+	Not   bool
+}
+
+func (c valueNull) String() string {
+	if c.Not {
+		return fmt.Sprintf("%s %s %s %s", c.Value, IS, NOT, NULL)
+	}
+	return fmt.Sprintf("%s %s %s", c.Value, IS, NULL)
+}
+
+func (c valueNull) ColumnNames() []string {
+	return c.Value.ColumnNames()
+}
+
+func (c valueNull) Compare(values minisql.Values) bool {
+	return (c.Value.Evaluate(values) == nil) != c.Not
+}
+
+func (c valueNull) withTypes(types columnTypes) Expression {
+	c.Value = valueWithTypes(c.Value, types)
+	if cond, ok := c.condition(); ok {
+		return cond
+	}
+	return &c
+}
+
+func (c valueNull) withParameters(params []*string) Expression {
+	c.Value = valueWithParameters(c.Value, params)
+	return &c
+}
+
+// condition gets the check as a nullCondition, when it checks a column.
+func (c valueNull) condition() (*nullCondition, bool) {
+	col, ok := columnOf(c.Value)
+	if !ok {
+		return nil, false
+	}
+	return &nullCondition{Column: col, Not: c.Not}, true
+}
+
+// columnOf gets the name of the column, when the value is a column, and not a word which may be a literal.
+func columnOf(v Value) (string, bool) {
+	col, ok := v.(*columnValue)
+	if !ok || col.Word {
+		return "", false
+	}
+	return col.Name, true
+}
+
+// valuesType gets the type the values are compared by, the first known type of the values, or TEXT when none is known.
+func valuesType(vs ...Value) minisql.ColumnType {
+	for _, v := range vs {
+		if ct := v.Type(); ct != "" {
+			return ct
+		}
+	}
+	return minisql.TEXT
+}
+
+func valuesWithTypes(vs []Value, types columnTypes) []Value {
+	bound := make([]Value, len(vs))
+	for i, v := range vs {
+		bound[i] = valueWithTypes(v, types)
+	}
+	return bound
+}
+
+// readPredicate reads the IS [NOT] NULL, [NOT] IN or [NOT] BETWEEN condition following the given value.
+// Returns false, consuming no tokens, when the value is followed by none of them.
+// A column compared with literal values, or parameters, is read as a condition of the column.
+func readPredicate(ts *lexer.TokenStream, v Value) (Expression, bool, error) {
+	if ts.AcceptKeyword(IS) {
+		not := ts.AcceptKeyword(NOT)
+		if err := ts.ExpectKeyword(NULL); err != nil {
+			return nil, true, err
+		}
+		c := &valueNull{Value: v, Not: not}
+		if cond, ok := c.condition(); ok {
+			return cond, true, nil
+		}
+		return c, true, nil
+	}
+	t := ts.Peek()
+	not := t.IsKeyword(NOT)
+	if not {
+		t = ts.PeekAt(1)
+	}
+	if !t.IsKeyword(IN, BETWEEN) {
+		return nil, false, nil
+	}
+	if not {
+		ts.Next()
+	}
+	ts.Next()
+	if t.IsKeyword(BETWEEN) {
+		c, err := readBetween(ts, v)
+		if err != nil {
+			return nil, true, err
+		}
+		c.Not = not
+		if cond, ok := c.condition(); ok {
+			return cond, true, nil
+		}
+		return c, true, nil
+	}
+	c, err := readIn(ts, v)
+	if err != nil {
+		return nil, true, err
+	}
+	c.Not = not
+	if cond, ok := c.condition(); ok {
+		return cond, true, nil
+	}
+	return c, true, nil
+}
+
+// readBetween reads the bounds of a BETWEEN condition, following the BETWEEN keyword.
+// The AND between the bounds is part of the condition, rather than joining two conditions.
+func readBetween(ts *lexer.TokenStream, v Value) (*valueBetween, error) {
+	c := &valueBetween{Value: v}
+	var err error
+	if c.Low, err = readBound(ts, v, "lower"); err != nil {
+		return nil, err
+	}
+	if err = ts.ExpectKeyword(AND); err != nil {
+		return nil, err
+	}
+	if c.High, err = readBound(ts, v, "upper"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readBound(ts *lexer.TokenStream, v Value, which string) (Value, error) {
+	t := ts.Peek()
+	if !isValueStart(t) {
+		return nil, ts.Errorf(t, "missing %s bound after '%s BETWEEN', found %s", which, v, t)
+	}
+	return ReadValueExpression(ts)
+}
+
+// readIn reads the bracketed list of values of an IN condition, following the IN keyword.
+func readIn(ts *lexer.TokenStream, v Value) (*valueIn, error) {
+	if err := ts.ExpectSymbol("("); err != nil {
+		return nil, err
+	}
+	c := &valueIn{Value: v}
+	for {
+		t := ts.Peek()
+		if !isValueStart(t) {
+			return nil, ts.Errorf(t, "expected a value in the IN list of %s, found %s", v, t)
+		}
+		lv, err := ReadValueExpression(ts)
+		if err != nil {
+			return nil, err
+		}
+		c.List = append(c.List, lv)
+		if !ts.AcceptSymbol(",") {
+			break
+		}
+	}
+	if err := ts.ExpectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func notKeyword(not bool, keyword string) string {
+	if not {
+		return NOT + " " + keyword
+	}
+	return keyword
+}
+
+func valueString(v *string, param int) string {
+	if param > 0 {
+		return fmt.Sprintf("$%d", param)
+	}
+	if v == nil {
+		return NULL
+	}
+	return *v
+}
@@ -0,0 +1,116 @@
+package whereclause_test
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/whereclause"
+	"testing"
+)
+
+func TestPredicates_Compare(t *testing.T) {
+	s := func(v string) *string { return &v }
+	row := minisql.Values{"qty": s("5"), "name": s("bob"), "note": nil, "created": s("2021-01-01T00:00:00Z")}
+	types := map[string]minisql.ColumnType{"qty": minisql.INTEGER, "name": minisql.TEXT, "note": minisql.TEXT, "created": minisql.TIMESTAMP}
+	for where, expect := range map[string]bool{
+		"qty BETWEEN 1 AND 10":                            true,
+		"qty BETWEEN 5 AND 5":                             true,
+		"qty BETWEEN 10 AND 1":                            false,
+		"qty BETWEEN 1 AND 4":                             false,
+		"qty NOT BETWEEN 1 AND 4":                         true,
+		"qty BETWEEN 1 AND 10 AND name = 'bob'":           true,
+		"qty BETWEEN 1 AND 10 AND name = 'ann'":           false,
+		"qty BETWEEN 1 AND NULL":                          false,
+		"NOT qty BETWEEN 1 AND 4":                         true,
+		"(qty BETWEEN 6 AND 9 OR name IN ('ann', 'bob'))": true,
+		"qty IN (1, 05, 9)":                               true,
+		"qty IN (1, 9)":                                   false,
+		"qty = 5.0":                                       true,
+		"qty IN (5.0)":                                    true,
+		"qty IN (4.5, 5e0)":                               true,
+		"qty NOT IN (5.0)":                                false,
+		"created = '2021-01-01T01:00:00+01:00'":           true,
+		"created IN ('2021-01-01T01:00:00+01:00')":        true,
+		"qty NOT IN (1, 9)":                               true,
+		"qty NOT IN (1, NULL)":                            false,
+		"NOT qty IN (1, 9)":                               true,
+		"name IN ('BOB', 'ann')":                          false,
+		"note IN ('x', NULL)":                             false,
+		"note NOT IN ('x')":                               false,
+		"note IS NULL":                                    true,
+		"note IS NOT NULL":                                false,
+		"name IS NOT NULL":                                true,
+		"NOT (note IS NULL)":                              false,
+		"missing IS NULL":                                 false,
+		"name IS NULL OR qty IN (5)":                      true,
+		"NOT (qty IN (5) AND note IS NULL)":               false,
+		"qty + 1 BETWEEN 1 AND 6":                         true,
+		"qty + 1 BETWEEN 1 AND 5":                         false,
+		"(qty * 2) NOT BETWEEN 1 AND 9":                   true,
+		"qty BETWEEN 1 AND 1 + 4":                         true,
+		"qty BETWEEN qty - 1 AND LENGTH(name)":            false,
+		"qty BETWEEN LENGTH(name) AND qty * 2":            true,
+		"UPPER(name) IN ('A', 'BOB')":                     true,
+		"UPPER(name) NOT IN ('A', 'BOB')":                 false,
+		"'bob' IN (note, name)":                           true,
+		"'ann' IN (note, name)":                           false,
+		"'ann' NOT IN (note, name)":                       false,
+		"qty IN (1, LENGTH(name) + 2)":                    true,
+		"qty * 2 IS NULL":                                 false,
+		"qty * 2 IS NOT NULL":                             true,
+		"note || 'x' IS NULL":                             true,
+		"COALESCE(note, name) IS NULL":                    false,
+	} {
+		ex, err := whereclause.ParseExpression(where)
+		if err != nil {
+			t.Fatalf("failed to parse %q  %v", where, err)
+		}
+		if found := whereclause.WithTypes(ex, types).Compare(row); found != expect {
+			t.Fatalf("unexpected result of %q, expected %v, found %v", where, expect, found)
+		}
+	}
+}
+
+func TestPredicates_Parse(t *testing.T) {
+	for _, where := range []string{
+		"qty BETWEEN 1",
+		"qty BETWEEN 1 OR 2",
+		"qty BETWEEN AND 2",
+		"qty IN 1, 2",
+		"qty IN (1, 2",
+		"qty IN ()",
+		"qty IS 1",
+		"qty IS NOT 'x'",
+		"qty NOT = 1",
+		"qty + 1 BETWEEN 1",
+		"UPPER(name) IN ()",
+		"qty * 2 IS 1",
+	} {
+		if _, err := whereclause.ParseExpression(where); err == nil {
+			t.Fatalf("expected %q to fail to parse", where)
+		}
+	}
+
+	ex, err := whereclause.ParseExpression("a BETWEEN ? AND ? AND b IN (?, 'x', ?)")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	s := func(v string) *string { return &v }
+	bound := whereclause.WithParameters(ex, []*string{s("1"), s("3"), s("y"), s("z")})
+	if !bound.Compare(minisql.Values{"a": s("2"), "b": s("z")}) {
+		t.Fatalf("expected true compare with bound parameters")
+	}
+	if bound.Compare(minisql.Values{"a": s("2"), "b": s("w")}) {
+		t.Fatalf("expected false compare with bound parameters")
+	}
+
+	ex, err = whereclause.ParseExpression("a + ? BETWEEN ? AND ? + 1 AND UPPER(b) IN (?, 'X')")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	bound = whereclause.WithParameters(ex, []*string{s("1"), s("2"), s("2"), s("Z")})
+	if !bound.Compare(minisql.Values{"a": s("2"), "b": s("z")}) {
+		t.Fatalf("expected true compare of values with bound parameters")
+	}
+	if bound.Compare(minisql.Values{"a": s("3"), "b": s("z")}) {
+		t.Fatalf("expected false compare of values with bound parameters")
+	}
+}
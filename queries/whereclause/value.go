@@ -0,0 +1,535 @@
+package whereclause
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/lexer"
+	"eurozulu/miniSQL/stringutil"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The operators of values
+const (
+	OP_ADD      = "+"
+	OP_SUBTRACT = "-"
+	OP_MULTIPLY = "*"
+	OP_DIVIDE   = "/"
+	OP_MODULO   = "%"
+	OP_CONCAT   = "||"
+)
+
+// A Value is an expression resulting in a single value, from the values of a row.
+// Values are columns, literals, parameters, functions, or the arithmetic of other values.
+// e.g. qty * price  or  first_name || ' ' || last_name  or  UPPER(name)
+type Value interface {
+	// Evaluate gets the value with the given values of a row.  A NULL value is nil.
+	// A value which can not be evaluated, such as an INTEGER which overflows, panics with an evaluationError,
+	// so values are evaluated, outside of this package, with the Evaluate function.
+	Evaluate(values minisql.Values) *string
+	// Type gets the type of the value, or an empty type when it is not known, such as the type of a quoted literal.
+	Type() minisql.ColumnType
+	// ColumnNames gets the names of all the columns the value is read from.
+	ColumnNames() []string
+	String() string
+}
+
+// evaluationError is the panic of a value which can not be evaluated.  e.g. an INTEGER which overflows.
+type evaluationError struct {
+	error
+}
+
+// failEvaluation stops the evaluation of a value with an evaluationError of the given message.
+func failEvaluation(format string, args ...interface{}) {
+	panic(evaluationError{fmt.Errorf(format, args...)})
+}
+
+// recoverEvaluation recovers a failed evaluation into the given error.  Any other panic is not recovered.
+func recoverEvaluation(err *error) {
+	if r := recover(); r != nil {
+		ee, ok := r.(evaluationError)
+		if !ok {
+			panic(r)
+		}
+		*err = ee.error
+	}
+}
+
+// Evaluate gets the value with the given values of a row, or an error when it can not be evaluated.
+func Evaluate(v Value, values minisql.Values) (s *string, err error) {
+	defer recoverEvaluation(&err)
+	return v.Evaluate(values), nil
+}
+
+// Compare compares the expression with the given values of a row, or gives an error when one of its values can not be evaluated.
+func Compare(ex Expression, values minisql.Values) (ok bool, err error) {
+	defer recoverEvaluation(&err)
+	return ex.Compare(values), nil
+}
+
+// typedValue is implemented by values which are read according to the types of their columns.
+type typedValue interface {
+	// withTypes returns a copy of the value, using the given column types.
+	withTypes(types columnTypes) Value
+}
+
+func valueWithTypes(v Value, types columnTypes) Value {
+	tv, ok := v.(typedValue)
+	if !ok {
+		return v
+	}
+	return tv.withTypes(types)
+}
+
+// parameterisedValue is implemented by values which may be the values of parameters.
+type parameterisedValue interface {
+	// withParameters returns a copy of the value, with its parameter placeholders replaced by the given values.
+	withParameters(params []*string) Value
+}
+
+func valueWithParameters(v Value, params []*string) Value {
+	pv, ok := v.(parameterisedValue)
+	if !ok {
+		return v
+	}
+	return pv.withParameters(params)
+}
+
+// columnValue is the value of a named column.
+// An unquoted name may also be a literal word, as values were written before they could be columns.  e.g. active = true
+// Once bound to the types of a table, a word which is not a column of the table is the literal word.
+type columnValue struct {
+	Name       string
+	ColumnType minisql.ColumnType
+	// Word is set when the name may be a literal word, until it is found to be a column.
+	Word bool
+}
+
+func (c columnValue) Evaluate(values minisql.Values) *string {
+	v, ok := values[c.Name]
+	if ok || !c.Word {
+		return v
+	}
+	w := c.Name
+	return &w
+}
+
+func (c columnValue) Type() minisql.ColumnType {
+	return c.ColumnType
+}
+
+// ColumnNames gets the name of the column.  A word is not known to be a column until it is bound to the types of a table.
+func (c columnValue) ColumnNames() []string {
+	if c.Word {
+		return nil
+	}
+	return []string{c.Name}
+}
+
+func (c columnValue) String() string {
+	return c.Name
+}
+
+func (c columnValue) withTypes(types columnTypes) Value {
+	ct := types(c.Name)
+	if ct == "" && c.Word {
+		w := c.Name
+		return &literalValue{Value: &w}
+	}
+	c.ColumnType = ct
+	c.Word = false
+	return &c
+}
+
+// literalValue is a literal value, or the value of a parameter.
+type literalValue struct {
+	Value *string
+	// Parameter, when not zero, is the number of the parameter whose value this is, in place of Value.
+	Parameter int
+	// LiteralType is the type of a literal number.  Other literals have no type.
+	LiteralType minisql.ColumnType
+}
+
+func (l literalValue) Evaluate(_ minisql.Values) *string {
+	return l.Value
+}
+
+func (l literalValue) Type() minisql.ColumnType {
+	return l.LiteralType
+}
+
+func (l literalValue) ColumnNames() []string {
+	return nil
+}
+
+func (l literalValue) String() string {
+	if l.Parameter > 0 || l.Value == nil || l.LiteralType != "" {
+		return valueString(l.Value, l.Parameter)
+	}
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(*l.Value, "'", "''"))
+}
+
+func (l literalValue) withParameters(params []*string) Value {
+	if l.Parameter > 0 {
+		l.Value = params[l.Parameter-1]
+		l.Parameter = 0
+	}
+	return &l
+}
+
+// negativeValue is the negative of a numeric value.  e.g. -qty
+type negativeValue struct {
+	Operand Value
+}
+
+func (n negativeValue) Evaluate(values minisql.Values) *string {
+	v := n.Operand.Evaluate(values)
+	if v == nil {
+		return nil
+	}
+	if i, err := strconv.ParseInt(strings.TrimSpace(*v), 10, 64); err == nil {
+		if i == math.MinInt64 {
+			failEvaluation("-(%d) overflows an INTEGER", i)
+		}
+		return formatInt(-i)
+	}
+	if f, err := strconv.ParseFloat(strings.TrimSpace(*v), 64); err == nil {
+		return formatReal(-f)
+	}
+	return nil
+}
+
+func (n negativeValue) Type() minisql.ColumnType {
+	return numericType(n.Operand)
+}
+
+func (n negativeValue) ColumnNames() []string {
+	return n.Operand.ColumnNames()
+}
+
+func (n negativeValue) String() string {
+	return fmt.Sprintf("-%s", operandString(n.Operand))
+}
+
+func (n negativeValue) withTypes(types columnTypes) Value {
+	return &negativeValue{Operand: valueWithTypes(n.Operand, types)}
+}
+
+func (n negativeValue) withParameters(params []*string) Value {
+	return &negativeValue{Operand: valueWithParameters(n.Operand, params)}
+}
+
+// arithmeticValue is the outcome of an operator on two values.
+// e.g. qty * price  or  first_name || ' ' || last_name
+// Arithmetic of INTEGER values is an INTEGER, truncating any division, otherwise it is REAL.
+// The outcome is NULL when either value is NULL, is not a number, or is divided by zero.
+type arithmeticValue struct {
+	Operator    string
+	Left, Right Value
+}
+
+func (a arithmeticValue) Evaluate(values minisql.Values) *string {
+	l := a.Left.Evaluate(values)
+	if l == nil {
+		return nil
+	}
+	r := a.Right.Evaluate(values)
+	if r == nil {
+		return nil
+	}
+	return a.calculate(*l, *r)
+}
+
+// calculate applies the operator to the two values.
+func (a arithmeticValue) calculate(l, r string) *string {
+	if a.Operator == OP_CONCAT {
+		s := l + r
+		return &s
+	}
+	i1, err1 := strconv.ParseInt(strings.TrimSpace(l), 10, 64)
+	i2, err2 := strconv.ParseInt(strings.TrimSpace(r), 10, 64)
+	if err1 == nil && err2 == nil {
+		return a.integer(i1, i2)
+	}
+	f1, err1 := strconv.ParseFloat(strings.TrimSpace(l), 64)
+	f2, err2 := strconv.ParseFloat(strings.TrimSpace(r), 64)
+	if err1 == nil && err2 == nil {
+		return a.real(f1, f2)
+	}
+	return nil
+}
+
+// integer applies the operator to two integers.  An outcome which overflows an INTEGER fails the evaluation.
+func (a arithmeticValue) integer(i1, i2 int64) *string {
+	var i int64
+	switch a.Operator {
+	case OP_ADD:
+		i = i1 + i2
+		if (i2 > 0 && i < i1) || (i2 < 0 && i > i1) {
+			a.overflow(i1, i2)
+		}
+	case OP_SUBTRACT:
+		i = i1 - i2
+		if (i2 > 0 && i > i1) || (i2 < 0 && i < i1) {
+			a.overflow(i1, i2)
+		}
+	case OP_MULTIPLY:
+		i = i1 * i2
+		if i1 != 0 && (i/i1 != i2 || (i1 == -1 && i2 == math.MinInt64)) {
+			a.overflow(i1, i2)
+		}
+	case OP_DIVIDE:
+		if i2 == 0 {
+			return nil
+		}
+		if i1 == math.MinInt64 && i2 == -1 {
+			a.overflow(i1, i2)
+		}
+		i = i1 / i2
+	case OP_MODULO:
+		if i2 == 0 {
+			return nil
+		}
+		i = i1 % i2
+	default:
+		return nil
+	}
+	return formatInt(i)
+}
+
+func (a arithmeticValue) overflow(i1, i2 int64) {
+	failEvaluation("%d %s %d overflows an INTEGER", i1, a.Operator, i2)
+}
+
+func (a arithmeticValue) real(f1, f2 float64) *string {
+	switch a.Operator {
+	case OP_ADD:
+		return formatReal(f1 + f2)
+	case OP_SUBTRACT:
+		return formatReal(f1 - f2)
+	case OP_MULTIPLY:
+		return formatReal(f1 * f2)
+	case OP_DIVIDE:
+		if f2 == 0 {
+			return nil
+		}
+		return formatReal(f1 / f2)
+	case OP_MODULO:
+		if f2 == 0 {
+			return nil
+		}
+		return formatReal(math.Mod(f1, f2))
+	}
+	return nil
+}
+
+func (a arithmeticValue) Type() minisql.ColumnType {
+	if a.Operator == OP_CONCAT {
+		return minisql.TEXT
+	}
+	if numericType(a.Left) == minisql.INTEGER && numericType(a.Right) == minisql.INTEGER {
+		return minisql.INTEGER
+	}
+	return minisql.REAL
+}
+
+func (a arithmeticValue) ColumnNames() []string {
+	return stringutil.UniqueStrings(append(a.Left.ColumnNames(), a.Right.ColumnNames()...))
+}
+
+func (a arithmeticValue) String() string {
+	return fmt.Sprintf("%s %s %s", operandString(a.Left), a.Operator, operandString(a.Right))
+}
+
+func (a arithmeticValue) withTypes(types columnTypes) Value {
+	return &arithmeticValue{Operator: a.Operator, Left: valueWithTypes(a.Left, types), Right: valueWithTypes(a.Right, types)}
+}
+
+func (a arithmeticValue) withParameters(params []*string) Value {
+	return &arithmeticValue{Operator: a.Operator, Left: valueWithParameters(a.Left, params), Right: valueWithParameters(a.Right, params)}
+}
+
+// numericType gets the type of a value used as a number.  Values which are not INTEGER are used as REAL.
+func numericType(v Value) minisql.ColumnType {
+	if v.Type() == minisql.INTEGER {
+		return minisql.INTEGER
+	}
+	return minisql.REAL
+}
+
+// operandString gets the value, bracketed when it is the outcome of an operator.
+func operandString(v Value) string {
+	if _, ok := v.(*arithmeticValue); ok {
+		return fmt.Sprintf("(%s)", v)
+	}
+	return v.String()
+}
+
+func formatInt(i int64) *string {
+	s := strconv.FormatInt(i, 10)
+	return &s
+}
+
+func formatReal(f float64) *string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	return &s
+}
+
+// isValueStart checks if the given token can begin a value.
+func isValueStart(t lexer.Token) bool {
+	return IsValue(t) || t.IsName() || t.Type == lexer.PARAMETER || t.IsSymbol("(")
+}
+
+// ReadValueExpression reads a value from the tokens, which may be a column, a literal, a parameter, a function,
+// or the arithmetic of values.
+// * / and % are applied before + and -, and those before ||.  Brackets group the values within them.
+func ReadValueExpression(ts *lexer.TokenStream) (Value, error) {
+	return readConcat(ts)
+}
+
+// ParseValueExpression parses the given string into a Value.
+func ParseValueExpression(s string) (Value, error) {
+	ts, err := lexer.NewTokenStream(s)
+	if err != nil {
+		return nil, err
+	}
+	v, err := ReadValueExpression(ts)
+	if err != nil {
+		return nil, err
+	}
+	if err = ts.ExpectEnd(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ValueWithTypes binds the given column types to the value.
+// Columns without a type are read as TEXT, and unquoted words which are not a column are literal words.
+func ValueWithTypes(v Value, types map[string]minisql.ColumnType) Value {
+	return valueWithTypes(v, func(column string) minisql.ColumnType {
+		return types[column]
+	})
+}
+
+// ValueWithTableTypes binds the types of the columns of the given table to the value.
+// Unquoted words which are not a column of the table are literal words.
+func ValueWithTableTypes(v Value, t minisql.Table) Value {
+	return valueWithTypes(v, tableTypes(t))
+}
+
+// ValueWithParameters binds the given values to the parameter placeholders of the value, the first value to parameter 1.
+func ValueWithParameters(v Value, params []*string) Value {
+	return valueWithParameters(v, params)
+}
+
+func readConcat(ts *lexer.TokenStream) (Value, error) {
+	return readOperands(ts, readSum, OP_CONCAT)
+}
+
+func readSum(ts *lexer.TokenStream) (Value, error) {
+	return readOperands(ts, readProduct, OP_ADD, OP_SUBTRACT)
+}
+
+func readProduct(ts *lexer.TokenStream) (Value, error) {
+	return readOperands(ts, readUnary, OP_MULTIPLY, OP_DIVIDE, OP_MODULO)
+}
+
+// readOperands reads one or more values, each read with the given read function, delimited by any of the given operators.
+func readOperands(ts *lexer.TokenStream, read func(ts *lexer.TokenStream) (Value, error), operators ...string) (Value, error) {
+	v, err := read(ts)
+	if err != nil {
+		return nil, err
+	}
+	for ts.Peek().IsSymbol(operators...) {
+		op := ts.Next().Text
+		r, err := read(ts)
+		if err != nil {
+			return nil, err
+		}
+		v = &arithmeticValue{Operator: op, Left: v, Right: r}
+	}
+	return v, nil
+}
+
+// readUnary reads a value, optionally preceded by a sign.  A sign directly before a number is read as part of the number.
+func readUnary(ts *lexer.TokenStream) (Value, error) {
+	t := ts.Peek()
+	if !t.IsSymbol(OP_ADD, OP_SUBTRACT) {
+		return readPrimary(ts)
+	}
+	if n := ts.PeekAt(1); n.Type == lexer.NUMBER && n.Offset == t.End {
+		v, err := ReadValue(ts)
+		if err != nil {
+			return nil, err
+		}
+		return &literalValue{Value: v, LiteralType: numberType(*v)}, nil
+	}
+	ts.Next()
+	v, err := readUnary(ts)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsSymbol(OP_ADD) {
+		return v, nil
+	}
+	return &negativeValue{Operand: v}, nil
+}
+
+// readPrimary reads a bracketed value, a literal, a parameter, a function or a column name.
+func readPrimary(ts *lexer.TokenStream) (Value, error) {
+	t := ts.Peek()
+	switch {
+	case t.IsSymbol("("):
+		ts.Next()
+		v, err := readConcat(ts)
+		if err != nil {
+			return nil, err
+		}
+		if err = ts.ExpectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return v, nil
+
+	case t.Type == lexer.PARAMETER:
+		n, err := ts.ExpectParameter()
+		if err != nil {
+			return nil, err
+		}
+		return &literalValue{Parameter: n}, nil
+
+	case t.Type == lexer.STRING:
+		ts.Next()
+		v := t.Text
+		return &literalValue{Value: &v}, nil
+
+	case t.Type == lexer.NUMBER:
+		ts.Next()
+		v := t.Text
+		return &literalValue{Value: &v, LiteralType: numberType(v)}, nil
+
+	case t.IsKeyword(NULL):
+		ts.Next()
+		return &literalValue{}, nil
+
+	case IsFunctionCall(ts):
+		return readFunction(ts)
+
+	case t.IsName():
+		name, err := ReadColumnName(ts, "column name")
+		if err != nil {
+			return nil, err
+		}
+		word := t.Type == lexer.IDENT && !strings.ContainsAny(name, ".(")
+		return &columnValue{Name: name, Word: word}, nil
+	}
+	return nil, ts.Errorf(t, "expected a value, found %s", t)
+}
+
+// numberType gets the type of a literal number.
+func numberType(n string) minisql.ColumnType {
+	if _, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return minisql.INTEGER
+	}
+	return minisql.REAL
+}
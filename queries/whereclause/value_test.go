@@ -0,0 +1,274 @@
+package whereclause_test
+
+import (
+	"eurozulu/miniSQL/minisql"
+	"eurozulu/miniSQL/queries/whereclause"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var valueTypes = map[string]minisql.ColumnType{
+	"qty": minisql.INTEGER, "price": minisql.REAL, "name": minisql.TEXT, "note": minisql.TEXT,
+	"created": minisql.TIMESTAMP, "updated": minisql.TIMESTAMP,
+}
+
+func valueRow() minisql.Values {
+	return row("qty", "4", "price", "2.5", "name", "bob", "note", "NULL",
+		"created", "2021-01-01T00:00:00Z", "updated", "2021-06-01T00:00:00Z")
+}
+
+func TestValue_Evaluate(t *testing.T) {
+	for _, test := range []struct {
+		value  string
+		expect string
+		ct     minisql.ColumnType
+	}{
+		{"qty", "4", minisql.INTEGER},
+		{"qty + 1", "5", minisql.INTEGER},
+		{"qty - 6", "-2", minisql.INTEGER},
+		{"qty * price", "10", minisql.REAL},
+		{"qty / 3", "1", minisql.INTEGER},
+		{"qty / 8.0", "0.5", minisql.REAL},
+		{"qty % 3", "1", minisql.INTEGER},
+		{"price % 1", "0.5", minisql.REAL},
+		{"1 + 2 * 3", "7", minisql.INTEGER},
+		{"(1 + 2) * 3", "9", minisql.INTEGER},
+		{"10 - 4 - 3", "3", minisql.INTEGER},
+		{"24 / 4 / 2", "3", minisql.INTEGER},
+		{"-qty", "-4", minisql.INTEGER},
+		{"- (qty + 1)", "-5", minisql.INTEGER},
+		{"-qty * -2", "8", minisql.INTEGER},
+		{"+qty", "4", minisql.INTEGER},
+		{"- -qty", "4", minisql.INTEGER},
+		{"-1.5", "-1.5", minisql.REAL},
+		{"name || '!'", "bob!", minisql.TEXT},
+		{"name || ' has ' || qty", "bob has 4", minisql.TEXT},
+		{"'#' || qty + 1", "#5", minisql.TEXT},
+		{"name || note", "NULL", minisql.TEXT},
+		{"qty + note", "NULL", minisql.REAL},
+		{"qty + NULL", "NULL", minisql.REAL},
+		{"qty / 0", "NULL", minisql.INTEGER},
+		{"price / 0", "NULL", minisql.REAL},
+		{"qty % 0", "NULL", minisql.INTEGER},
+		{"name * 2", "NULL", minisql.REAL},
+		{"-name", "NULL", minisql.REAL},
+		{"missing", "missing", ""},
+		{"missing || 1", "missing1", minisql.TEXT},
+		{"`qty` + 1", "5", minisql.INTEGER},
+		{"'qty' || 1", "qty1", minisql.TEXT},
+	} {
+		v, err := whereclause.ParseValueExpression(test.value)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", test.value, err)
+		}
+		v = whereclause.ValueWithTypes(v, valueTypes)
+		found := valueOrNull(v.Evaluate(valueRow()))
+		if found != test.expect {
+			t.Errorf("unexpected value of %q, expected %q, found %q", test.value, test.expect, found)
+		}
+		if v.Type() != test.ct {
+			t.Errorf("unexpected type of %q, expected %q, found %q", test.value, test.ct, v.Type())
+		}
+	}
+}
+
+func TestValue_Overflow(t *testing.T) {
+	for value, expect := range map[string]string{
+		"9223372036854775807 + qty":                            "9223372036854775807 + 4 overflows an INTEGER",
+		"-9223372036854775807 - qty":                           "-9223372036854775807 - 4 overflows an INTEGER",
+		"qty - -9223372036854775807":                           "4 - -9223372036854775807 overflows an INTEGER",
+		"qty * 4611686018427387904":                            "4 * 4611686018427387904 overflows an INTEGER",
+		"-qty * 2305843009213693952 * 2":                       "-9223372036854775808 * 2 overflows an INTEGER",
+		"(-9223372036854775807 - 1) / -1":                      "-9223372036854775808 / -1 overflows an INTEGER",
+		"-(-9223372036854775807 - qty/4)":                      "-(-9223372036854775808) overflows an INTEGER",
+		"ABS(-9223372036854775807 - 1)":                        "ABS(-9223372036854775808) overflows an INTEGER",
+		"9223372036854775803 + qty":                            "",
+		"-9223372036854775807 - qty/4":                         "",
+		"qty * -2305843009213693952":                           "",
+		"(-9223372036854775807 - 1) % -1":                      "",
+		"qty * 4611686018427387904.0":                          "",
+		"CEIL(1e300 * qty)":                                    "CEIL(4e+300) overflows an INTEGER",
+		"FLOOR(-1e300 * qty)":                                  "FLOOR(-4e+300) overflows an INTEGER",
+		"FLOOR(9223372036854775807.0)":                         "FLOOR(9.223372036854776e+18) overflows an INTEGER",
+		"CAST(1e300 * qty AS INTEGER)":                         "CAST(4e+300) overflows an INTEGER",
+		"ROUND(9223372036854775807, -qty)":                     "ROUND(9.22337203685478e+18) overflows an INTEGER",
+		"DATE_ADD('2024-02-29', 9223372036854775807, 'day')":   "DATE_ADD('2024-02-29', 9223372036854775807, 'day') overflows a TIMESTAMP",
+		"DATE_ADD('2024-02-29', -9223372036854775807, 'hour')": "DATE_ADD('2024-02-29', -9223372036854775807, 'hour') overflows a TIMESTAMP",
+		"DATE_ADD('2024-02-29', qty * 2000, 'year')":           "DATE_ADD('2024-02-29', 8000, 'year') overflows a TIMESTAMP",
+		"FLOOR(-9223372036854775808.0)":                        "",
+		"CAST(-9.2e18 AS INTEGER)":                             "",
+		"DATE_ADD('2024-02-29', qty * 1000, 'year')":           "",
+	} {
+		v, err := whereclause.ParseValueExpression(value)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", value, err)
+		}
+		v = whereclause.ValueWithTypes(v, valueTypes)
+		_, err = whereclause.Evaluate(v, valueRow())
+		if expect == "" {
+			if err != nil {
+				t.Errorf("unexpected error evaluating %q  %v", value, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != expect {
+			t.Errorf("unexpected error evaluating %q, expected %q, found %v", value, expect, err)
+		}
+	}
+	ex, err := whereclause.ParseExpression("name = 'x' OR qty * 9223372036854775807 > 1")
+	if err != nil {
+		t.Fatalf("unexpected error  %v", err)
+	}
+	if _, err = whereclause.Compare(whereclause.WithTypes(ex, valueTypes), valueRow()); err == nil {
+		t.Fatalf("expected error comparing an overflowing value")
+	}
+	if _, err = whereclause.ParseValueExpression("ROUND(9223372036854775807 + 1)"); err == nil {
+		t.Fatalf("expected error parsing an overflowing constant argument")
+	}
+}
+
+func TestValue_ColumnNames(t *testing.T) {
+	for _, test := range []struct {
+		value  string
+		before []string
+		after  []string
+	}{
+		{"qty", nil, []string{"qty"}},
+		{"qty * price + qty", nil, []string{"price", "qty"}},
+		{"name || missing", nil, []string{"name"}},
+		{"t.qty + `price`", []string{"price", "t.qty"}, []string{"price", "t.qty"}},
+		{"COUNT(*) + 1", []string{"COUNT(*)"}, []string{"COUNT(*)"}},
+		{"'qty' || 1", nil, nil},
+	} {
+		v, err := whereclause.ParseValueExpression(test.value)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", test.value, err)
+		}
+		if found := sortedNames(v.ColumnNames()); !reflect.DeepEqual(found, test.before) {
+			t.Errorf("unexpected columns of %q, expected %v, found %v", test.value, test.before, found)
+		}
+		v = whereclause.ValueWithTypes(v, valueTypes)
+		if found := sortedNames(v.ColumnNames()); !reflect.DeepEqual(found, test.after) {
+			t.Errorf("unexpected bound columns of %q, expected %v, found %v", test.value, test.after, found)
+		}
+	}
+}
+
+func sortedNames(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestValue_Compare(t *testing.T) {
+	for _, test := range []struct {
+		where  string
+		expect bool
+	}{
+		{"updated > created", true},
+		{"created >= updated", false},
+		{"qty * price > 9", true},
+		{"qty * price > 10", false},
+		{"qty * price = 10", true},
+		{"price * 4 = qty * 2.5", true},
+		{"10 < qty * price + 1", true},
+		{"qty + 1 = 5", true},
+		{"qty = price + 1.5", true},
+		{"(qty + 1) * 2 > 9", true},
+		{"(qty + 1) * 2 > 9 AND name = 'bob'", true},
+		{"(qty + 1) * 2 > 10 OR name = bob", true},
+		{"((qty + 1) * 2 > 10 OR name = 'ann')", false},
+		{"(qty) = 4", true},
+		{"-qty < -3", true},
+		{"qty % 2 = 0", true},
+		{"name || '!' = 'bob!'", true},
+		{"name || note = 'bob'", false},
+		{"NOT name || note = 'bob'", false},
+		{"qty / 0 = 1", false},
+		{"name LIKE 'b' || '%'", true},
+		{"qty > price", true},
+		{"qty < price", false},
+		// values are compared by the type of their columns
+		{"qty * 3 > 9", true},
+		{"price + 0.5 = 3", true},
+		// unquoted words, which are not a column, are literal words
+		{"name = bob", true},
+		{"name = ann", false},
+		{"name != qty", true},
+	} {
+		ex, err := whereclause.ParseExpression(test.where)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q  %v", test.where, err)
+		}
+		ex = whereclause.WithTypes(ex, valueTypes)
+		if found := ex.Compare(valueRow()); found != test.expect {
+			t.Errorf("unexpected result of %q, expected %v, found %v", test.where, test.expect, found)
+		}
+	}
+}
+
+func TestValue_ParseErrors(t *testing.T) {
+	for where, expect := range map[string]string{
+		"qty + > 1":          "expected a value",
+		"qty * price":        "no Operator found",
+		"qty * price >":      "missing condition value",
+		"(qty + 1 > 2":       "expected ')'",
+		"(qty + 1) * > 2":    "expected a value",
+		"(qty = 1 OR":        "expected condition column name",
+		"qty * (price + 1 =": "expected ')'",
+		"= qty + 1":          "missing condition column name",
+	} {
+		_, err := whereclause.ParseExpression(where)
+		if err == nil {
+			t.Errorf("expected error parsing %q", where)
+			continue
+		}
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("unexpected error parsing %q, expected %q, found %q", where, expect, err)
+		}
+	}
+}
+
+// TestValue_Keys checks the keys of a where clause comparing columns, fetching all the columns it refers to.
+func TestValue_Keys(t *testing.T) {
+	db := minisql.NewDatabase(minisql.Schema{
+		"items": {"name": {Type: minisql.TEXT}, "qty": {Type: minisql.INTEGER}, "price": {Type: minisql.REAL}},
+	})
+	tb, _ := db.Table("items")
+	for _, r := range []minisql.Values{
+		row("name", "a", "qty", "2", "price", "10"),
+		row("name", "b", "qty", "20", "price", "10"),
+		row("name", "c", "qty", "NULL", "price", "100"),
+		row("name", "qty", "qty", "3", "price", "1"),
+	} {
+		if _, err := tb.Insert(r); err != nil {
+			t.Fatalf("unexpected error  %v", err)
+		}
+	}
+	for where, expect := range map[string][]minisql.Key{
+		"qty * price > 100":      {1},
+		"qty * price >= 20":      {0, 1},
+		"price > qty":            {0},
+		"name = qty":             nil,
+		"name = 'qty'":           {3},
+		"name || qty = 'a2'":     {0},
+		"NOT qty * price > 100":  {0, 3},
+		"price / qty = 5":        {0},
+		"price - qty * 5 = 0":    {0},
+		"qty = 2 OR price > qty": {0},
+		// conditions of no columns are the same for every row
+		"1 = 2":            nil,
+		"'x' = 'y'":        nil,
+		"NULL = NULL":      nil,
+		"1 + 1 = 2":        {0, 1, 2, 3},
+		"1 = 2 OR qty = 3": {3},
+	} {
+		if found := whereKeys(t, where, tb); !reflect.DeepEqual(found, expect) {
+			t.Errorf("unexpected keys of %q, expected %v, found %v", where, expect, found)
+		}
+	}
+}
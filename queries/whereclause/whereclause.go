@@ -3,10 +3,8 @@ package whereclause
 import (
 	"context"
 	"eurozulu/miniSQL/minisql"
-	"eurozulu/miniSQL/stringutil"
+	"eurozulu/miniSQL/queries/lexer"
 	"fmt"
-	"log"
-	"strings"
 )
 
 const (
@@ -23,34 +21,67 @@ const keyBuffer = 255
 // e.g. mycolumn != 'some value' AND _id <= 22
 type WhereClause interface {
 	// Keys returns a channel of all the keys in the given table, which match the where clause.
-	Keys(ctx context.Context, t minisql.Table) <-chan minisql.Key
+	// The error channel gives any error stopping the keys, once the keys channel is closed, and is then closed.
+	Keys(ctx context.Context, t minisql.Table) (<-chan minisql.Key, <-chan error)
 }
 
 type whereClause struct {
 	expression Expression
 }
 
-func (wc whereClause) Keys(ctx context.Context, t minisql.Table) <-chan minisql.Key {
+func (wc whereClause) Keys(ctx context.Context, t minisql.Table) (<-chan minisql.Key, <-chan error) {
 	ch := make(chan minisql.Key, keyBuffer)
-	go func(ch chan<- minisql.Key) {
+	errs := make(chan error, 1)
+	go func(ch chan<- minisql.Key, errs chan<- error) {
+		defer close(errs)
 		defer close(ch)
 		last := t.NextID()
 		var cols []string
+		var ex Expression
 		if wc.HasExpression() {
-			cols = wc.expression.ColumnNames()
+			// the names of the columns are only known once bound to the table
+			ex = withTypes(wc.expression, tableTypes(t))
+			cols = ex.ColumnNames()
 		}
-		for k := minisql.Key(0); k < last; k++ {
+		// use the tables indexes, when possible, to only compare the rows which may match
+		var candidates []minisql.Key
+		var indexed bool
+		if ix, ok := t.(minisql.Indexer); ok && ex != nil {
+			candidates, indexed = indexKeys(ex, ix)
+		}
+		for i := 0; ; i++ {
+			var k minisql.Key
+			if indexed {
+				if i >= len(candidates) {
+					break
+				}
+				k = candidates[i]
+			} else {
+				if minisql.Key(i) >= last {
+					break
+				}
+				k = minisql.Key(i)
+			}
 			if !t.ContainsID(k) {
 				continue
 			}
-			// If expression present, collect values for key and compare with expression
-			if len(cols) > 0 {
-				v, err := t.Select(k, cols)
+			// If expression present, collect values for key and compare with expression.
+			// An expression of no columns, such as 1 = 2, is compared without any values.
+			if ex != nil {
+				v := minisql.Values{}
+				if len(cols) > 0 {
+					var err error
+					if v, err = t.Select(k, cols); err != nil {
+						errs <- err
+						return
+					}
+				}
+				ok, err := Compare(ex, v)
 				if err != nil {
-					log.Println(err)
+					errs <- err
 					return
 				}
-				if !wc.expression.Compare(v) {
+				if !ok {
 					continue
 				}
 			}
@@ -60,29 +91,63 @@ func (wc whereClause) Keys(ctx context.Context, t minisql.Table) <-chan minisql.
 			case ch <- k:
 			}
 		}
-	}(ch)
-	return ch
+	}(ch, errs)
+	return ch, errs
+}
+
+// tableTypes resolves column types from the given table. Unknown columns have no type, and are compared as TEXT.
+func tableTypes(t minisql.Table) columnTypes {
+	return func(column string) minisql.ColumnType {
+		cd, err := t.ColumnDef(column)
+		if err != nil {
+			return ""
+		}
+		return cd.Type
+	}
+}
+
+// ColumnNames gets the names of the columns the given where clause compares.
+func ColumnNames(w WhereClause) []string {
+	wc, ok := w.(*whereClause)
+	if !ok || !wc.HasExpression() {
+		return nil
+	}
+	return wc.expression.ColumnNames()
+}
+
+// WhereWithParameters binds the given values to the parameter placeholders of the where clause, the first value to parameter 1.
+// There must be a value for every parameter in the clause.
+func WhereWithParameters(w WhereClause, params []*string) WhereClause {
+	wc, ok := w.(*whereClause)
+	if !ok || !wc.HasExpression() {
+		return w
+	}
+	return &whereClause{expression: WithParameters(wc.expression, params)}
 }
 
 func (wc whereClause) HasExpression() bool {
 	return wc.expression != nil
 }
 
-//  NewWhere creates a new Where clause
+//	NewWhere creates a new Where clause
+//
 // query is optional, when provided, can, optionally begin with the preceeding keyword "WHERE", begining with an expression
 // expressions can be conditions (x = y), or complex (bracketed) conditions e.g. '(a = true AND b=true) OR c=false'
 // conditions may be linked using the AND, OR or inverted with the NOT keyword.
 // use the NULL keyword to search for nil values (or NOT NULL for non nil)
 // If query is empty, where will generate aLL keys in the given table
 func NewWhere(q string) (WhereClause, error) {
+	ts, err := lexer.NewTokenStream(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WHERE  %w", err)
+	}
+	ts.AcceptKeyword("WHERE")
 	w := &whereClause{}
-	if q != "" {
-		// trim off WHERE, if present
-		ws, rest := stringutil.FirstWord(q)
-		if strings.EqualFold(ws, "WHERE") {
-			q = rest
+	if !ts.AtEnd() {
+		ex, err := ReadExpression(ts, false)
+		if err == nil {
+			err = ts.ExpectEnd()
 		}
-		ex, err := ParseExpression(q)
 		if err != nil {
 			return nil, fmt.Errorf("invalid WHERE  %w", err)
 		}
@@ -90,3 +155,19 @@ func NewWhere(q string) (WhereClause, error) {
 	}
 	return w, nil
 }
+
+// ReadWhere reads an optional WHERE clause from the given tokens.
+// If the next token is not the WHERE keyword, no tokens are read and the clause will generate all the keys in a table.
+// When compat is set, the conditions follow the legacy rules, as ReadExpression.
+func ReadWhere(ts *lexer.TokenStream, compat bool) (WhereClause, error) {
+	w := &whereClause{}
+	if !ts.AcceptKeyword("WHERE") {
+		return w, nil
+	}
+	ex, err := ReadExpression(ts, compat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WHERE  %w", err)
+	}
+	w.expression = ex
+	return w, nil
+}